@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// minContrast is the WCAG AA contrast ratio for body text
+	minContrast = 4.5
+	// auditMaxPages stops a crawl of a running site from going on forever
+	auditMaxPages = 1000
+	// auditMaxPageSize is the largest page read while crawling
+	auditMaxPageSize = 5 << 20
+)
+
+// AccessibilityOptions controls devdaze audit
+type AccessibilityOptions struct {
+	// Dir audits the HTML files of a static build instead of rendering the
+	// site
+	Dir string
+	// URL crawls a running site from this page instead
+	URL string
+	// MaxIssues is how many issues are tolerated before the audit fails
+	MaxIssues int
+}
+
+// auditPage is a rendered page and where it came from
+type auditPage struct {
+	Name string
+	Body []byte
+}
+
+// auditSite checks every page of the site for missing alt text, skipped
+// heading levels, links without text and inline colors with too little
+// contrast. It returns an error when there are more issues than allowed, so
+// it can fail CI runs.
+func auditSite(out io.Writer, cfg *Config, opts AccessibilityOptions) error {
+	var pages []auditPage
+	var err error
+	switch {
+	case opts.URL != "":
+		pages, err = crawlPages(opts.URL)
+	case opts.Dir != "":
+		pages, err = readBuiltPages(opts.Dir)
+	default:
+		pages, err = renderSitePages(cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	var issues []ValidationIssue
+	for _, page := range pages {
+		doc, err := html.Parse(bytes.NewReader(page.Body))
+		if err != nil {
+			issues = append(issues, ValidationIssue{File: page.Name, Message: fmt.Sprintf("unparseable HTML: %v", err)})
+			continue
+		}
+		issues = append(issues, checkAccessibility(page.Name, doc)...)
+	}
+	for _, issue := range issues {
+		fmt.Fprintln(out, issue)
+	}
+
+	if len(issues) > opts.MaxIssues {
+		return fmt.Errorf("%d accessibility issue(s) found in %d page(s), more than the %d allowed",
+			len(issues), len(pages), opts.MaxIssues)
+	}
+	fmt.Fprintf(out, "Audited %d pages, %d accessibility issue(s)\n", len(pages), len(issues))
+	return nil
+}
+
+// renderSitePages renders every route devdaze build would export
+func renderSitePages(cfg *Config) ([]auditPage, error) {
+	// Creating the server syncs the content source first
+	buildCfg := *cfg
+	buildCfg.Env = EnvProduction
+	app := newServer(&buildCfg, nopReporter{}).app
+	posts, translations, err := publishedPosts(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []auditPage
+	for _, page := range sitePages(posts, translations, cfg.I18n, cfg.PostsPerPage, cfg.Location()) {
+		if filepath.Ext(page.Route) != "" || page.Redirect != "" {
+			continue
+		}
+		body, err := renderRoute(app, page.Route)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, auditPage{Name: page.Route, Body: body})
+	}
+	return pages, nil
+}
+
+// readBuiltPages reads the HTML files of a static build
+func readBuiltPages(dir string) ([]auditPage, error) {
+	var pages []auditPage
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(p) != ".html" {
+			return err
+		}
+		body, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		pages = append(pages, auditPage{Name: p, Body: body})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", dir, err)
+	}
+	return pages, nil
+}
+
+// crawlPages fetches start and every page of the same site it links to
+func crawlPages(start string) ([]auditPage, error) {
+	base, err := url.Parse(start)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid --url %q", start)
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+
+	queue := []string{base.String()}
+	seen := map[string]bool{base.String(): true}
+	var pages []auditPage
+	for len(queue) > 0 && len(pages) < auditMaxPages {
+		u := queue[0]
+		queue = queue[1:]
+
+		resp, err := client.Get(u)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching %s: %v", u, err)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, auditMaxPageSize))
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error fetching %s: %v", u, err)
+		}
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+			continue
+		}
+		pages = append(pages, auditPage{Name: u, Body: body})
+
+		doc, err := html.Parse(bytes.NewReader(body))
+		if err != nil {
+			continue
+		}
+		for _, link := range pageLinks(doc, resp.Request.URL) {
+			if link.Host == base.Host && !seen[link.String()] {
+				seen[link.String()] = true
+				queue = append(queue, link.String())
+			}
+		}
+	}
+	return pages, nil
+}
+
+// pageLinks returns the pages a document links to, without their query and
+// fragment, skipping links to files other than HTML
+func pageLinks(doc *html.Node, base *url.URL) []*url.URL {
+	var links []*url.URL
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			if href, ok := attribute(n, "href"); ok {
+				if ref, err := base.Parse(strings.TrimSpace(href)); err == nil && (ref.Scheme == "http" || ref.Scheme == "https") {
+					if ext := path.Ext(ref.Path); ext == "" || ext == ".html" {
+						ref.RawQuery, ref.Fragment = "", ""
+						links = append(links, ref)
+					}
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return links
+}
+
+// checkAccessibility reports the accessibility issues of a page
+func checkAccessibility(name string, doc *html.Node) []ValidationIssue {
+	var issues []ValidationIssue
+	report := func(format string, args ...interface{}) {
+		issues = append(issues, ValidationIssue{File: name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	lastHeading := 0
+	var walk func(n *html.Node, background rgb)
+	walk = func(n *html.Node, background rgb) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "img":
+				// Markdown images without a description get an empty alt,
+				// so only images marked as decorative may have one
+				if alt, _ := attribute(n, "alt"); strings.TrimSpace(alt) == "" && !decorative(n) {
+					src, _ := attribute(n, "src")
+					report("image without alt text: %s", src)
+				}
+			case "a":
+				if href, ok := attribute(n, "href"); ok && accessibleName(n) == "" {
+					report("link without text: %s", href)
+				}
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				level := int(n.Data[1] - '0')
+				if lastHeading > 0 && level > lastHeading+1 {
+					report("heading skips from h%d to h%d: %q", lastHeading, level, shorten(textContent(n), 60))
+				}
+				lastHeading = level
+			}
+
+			if style, ok := attribute(n, "style"); ok {
+				decls := parseInlineStyle(style)
+				for _, key := range []string{"background", "background-color"} {
+					for _, value := range strings.Fields(decls[key]) {
+						if c, ok := parseColor(value); ok {
+							background = c
+						}
+					}
+				}
+				if fg, ok := parseColor(decls["color"]); ok {
+					if ratio := contrastRatio(fg, background); ratio < minContrast {
+						report("low contrast %.1f:1 of %s on %s in <%s>: %q", ratio, decls["color"], background, n.Data, shorten(textContent(n), 60))
+					}
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child, background)
+		}
+	}
+	walk(doc, rgb{255, 255, 255})
+	return issues
+}
+
+// attribute returns the value of an element's attribute
+func attribute(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// decorative reports whether an image is hidden from screen readers
+func decorative(n *html.Node) bool {
+	role, _ := attribute(n, "role")
+	hidden, _ := attribute(n, "aria-hidden")
+	return role == "presentation" || role == "none" || hidden == "true"
+}
+
+// textContent returns the text inside a node with its whitespace collapsed
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			b.WriteByte(' ')
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// accessibleName returns what a screen reader announces for a link: its
+// label, text or the alt text of the images inside it
+func accessibleName(n *html.Node) string {
+	for _, key := range []string{"aria-label", "aria-labelledby", "title"} {
+		if v, _ := attribute(n, key); strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	if text := textContent(n); text != "" {
+		return text
+	}
+	var alt string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "img" || n.Data == "svg") {
+			for _, key := range []string{"alt", "aria-label"} {
+				if v, _ := attribute(n, key); strings.TrimSpace(v) != "" {
+					alt = v
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil && alt == ""; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return alt
+}
+
+// shorten cuts s to at most n characters
+func shorten(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
+
+// parseInlineStyle splits a style attribute into its declarations
+func parseInlineStyle(style string) map[string]string {
+	decls := make(map[string]string)
+	for _, decl := range strings.Split(style, ";") {
+		key, value, ok := strings.Cut(decl, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "!important"))
+		decls[strings.ToLower(strings.TrimSpace(key))] = strings.ToLower(value)
+	}
+	return decls
+}
+
+// rgb is an opaque color
+type rgb [3]uint8
+
+func (c rgb) String() string {
+	return fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])
+}
+
+// namedColors are the CSS color keywords common in inline styles
+var namedColors = map[string]rgb{
+	"black": {0, 0, 0}, "white": {255, 255, 255}, "gray": {128, 128, 128}, "grey": {128, 128, 128},
+	"silver": {192, 192, 192}, "lightgray": {211, 211, 211}, "lightgrey": {211, 211, 211},
+	"red": {255, 0, 0}, "green": {0, 128, 0}, "blue": {0, 0, 255}, "yellow": {255, 255, 0},
+	"orange": {255, 165, 0}, "purple": {128, 0, 128}, "navy": {0, 0, 128}, "maroon": {128, 0, 0},
+}
+
+// parseColor parses a hex, rgb() or named CSS color. Translucent and other
+// colors are not understood.
+func parseColor(value string) (rgb, bool) {
+	if c, ok := namedColors[value]; ok {
+		return c, true
+	}
+	if hex, ok := strings.CutPrefix(value, "#"); ok {
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) != 6 {
+			return rgb{}, false
+		}
+		n, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return rgb{}, false
+		}
+		return rgb{uint8(n >> 16), uint8(n >> 8), uint8(n)}, true
+	}
+	if args, ok := strings.CutPrefix(value, "rgb("); ok {
+		parts := strings.Split(strings.TrimSuffix(args, ")"), ",")
+		if len(parts) != 3 {
+			return rgb{}, false
+		}
+		var c rgb
+		for i, part := range parts {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || n < 0 || n > 255 {
+				return rgb{}, false
+			}
+			c[i] = uint8(n)
+		}
+		return c, true
+	}
+	return rgb{}, false
+}
+
+// contrastRatio is the WCAG contrast ratio of two colors, from 1 to 21
+func contrastRatio(a, b rgb) float64 {
+	la, lb := luminance(a), luminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
+
+// luminance is the WCAG relative luminance of a color
+func luminance(c rgb) float64 {
+	var channels [3]float64
+	for i, v := range c {
+		s := float64(v) / 255
+		if s <= 0.03928 {
+			channels[i] = s / 12.92
+		} else {
+			channels[i] = math.Pow((s+0.055)/1.055, 2.4)
+		}
+	}
+	return 0.2126*channels[0] + 0.7152*channels[1] + 0.0722*channels[2]
+}
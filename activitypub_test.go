@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestVerifyActivity(t *testing.T) {
+	actorKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&actorKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	// The remote server has /actor, and /impostor, which claims the key of
+	// /actor as its own
+	var remote *httptest.Server
+	remote = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var actor remoteActor
+		switch r.URL.Path {
+		case "/actor":
+			actor.ID = remote.URL + "/actor"
+		case "/impostor":
+			actor.ID = remote.URL + "/impostor"
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		actor.PublicKey.ID = actor.ID + "#main-key"
+		actor.PublicKey.Owner = remote.URL + "/actor"
+		actor.PublicKey.PEM = publicPEM
+		json.NewEncoder(w).Encode(actor)
+	}))
+	defer remote.Close()
+
+	localKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.Env = EnvDevelopment
+	cfg.BaseURL = "https://blog.example.com"
+	s := &Server{cfg: cfg, remote: newRemoteClient(true), activityPub: &activityPub{key: localKey}}
+	app := fiber.New()
+	app.Post("/inbox", func(c *fiber.Ctx) error {
+		actor, err := s.verifyActivity(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).SendString(err.Error())
+		}
+		return c.SendString(actor.ID)
+	})
+
+	const body = `{"type":"Follow"}`
+	tests := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		keyID   string
+		headers string
+		date    time.Time
+		// tamper changes the request after it was signed
+		tamper func(req *http.Request)
+		// want is the actor verified, wantErr part of the error otherwise
+		want    string
+		wantErr string
+	}{
+		{name: "valid", want: remote.URL + "/actor"},
+		{name: "without host", headers: "(request-target) date digest", want: remote.URL + "/actor"},
+		{name: "no signature", tamper: func(req *http.Request) { req.Header.Del("Signature") }, wantErr: "missing signature"},
+		{name: "unsupported algorithm", tamper: func(req *http.Request) {
+			req.Header.Set("Signature", strings.Replace(req.Header.Get("Signature"), "rsa-sha256", "ed25519", 1))
+		}, wantErr: "unsupported algorithm"},
+		{name: "digest not signed", headers: "(request-target) host date", wantErr: "does not cover"},
+		{name: "date not signed", headers: "(request-target) host digest", wantErr: "does not cover"},
+		{name: "old date", date: time.Now().Add(-maxSignatureAge - time.Hour), wantErr: "out of range"},
+		{name: "body changed", tamper: func(req *http.Request) { setBody(req, `{"type":"Delete"}`, false) }, wantErr: "digest does not match"},
+		{name: "body and digest changed", tamper: func(req *http.Request) { setBody(req, `{"type":"Delete"}`, true) }, wantErr: "does not verify"},
+		{name: "signed with another key", key: otherKey, wantErr: "does not verify"},
+		{name: "key of another actor", keyID: remote.URL + "/impostor#main-key", wantErr: "does not belong"},
+		{name: "unknown actor", keyID: remote.URL + "/missing#main-key", wantErr: "404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.key == nil {
+				tt.key = actorKey
+			}
+			if tt.keyID == "" {
+				tt.keyID = remote.URL + "/actor#main-key"
+			}
+			if tt.headers == "" {
+				tt.headers = "(request-target) host date digest"
+			}
+			if tt.date.IsZero() {
+				tt.date = time.Now()
+			}
+			req := httptest.NewRequest(fiber.MethodPost, "/inbox", nil)
+			req.Header.Set("Content-Type", activityContentType)
+			req.Header.Set("Date", tt.date.UTC().Format(http.TimeFormat))
+			setBody(req, body, true)
+			signTestRequest(t, req, tt.key, tt.keyID, tt.headers)
+			if tt.tamper != nil {
+				tt.tamper(req)
+			}
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			got, _ := io.ReadAll(resp.Body)
+			if tt.wantErr != "" {
+				if resp.StatusCode == fiber.StatusOK || !strings.Contains(string(got), tt.wantErr) {
+					t.Errorf("got %d %s, want an error with %q", resp.StatusCode, got, tt.wantErr)
+				}
+				return
+			}
+			if resp.StatusCode != fiber.StatusOK || string(got) != tt.want {
+				t.Errorf("got %d %s, want the actor %s", resp.StatusCode, got, tt.want)
+			}
+		})
+	}
+}
+
+// setBody replaces the body of req, and its digest when digest is set
+func setBody(req *http.Request, body string, digest bool) {
+	req.Body = io.NopCloser(strings.NewReader(body))
+	req.ContentLength = int64(len(body))
+	if digest {
+		sum := sha256.Sum256([]byte(body))
+		req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+	}
+}
+
+// signTestRequest signs headers of req like a fediverse server would
+func signTestRequest(t *testing.T, req *http.Request, key *rsa.PrivateKey, keyID, headers string) {
+	t.Helper()
+	var lines []string
+	for _, h := range strings.Fields(headers) {
+		switch h {
+		case "(request-target)":
+			lines = append(lines, h+": "+strings.ToLower(req.Method)+" "+req.URL.RequestURI())
+		case "host":
+			lines = append(lines, h+": "+req.Host)
+		default:
+			lines = append(lines, h+": "+req.Header.Get(h))
+		}
+	}
+	hash := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, headers, base64.StdEncoding.EncodeToString(sig)))
+}
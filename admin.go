@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminConfig controls access to the /admin area
+type AdminConfig struct {
+	// Token authorizes automated admin calls such as POST /admin/reload.
+	// The endpoints are disabled while it is empty.
+	Token string `yaml:"token"`
+	// Username and Password protect the admin dashboard. The dashboard is
+	// disabled while either is empty.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Users lists further accounts and their roles
+	Users []AdminUser `yaml:"users"`
+	// OAuth lets allowlisted GitHub and Google accounts sign in instead
+	OAuth OAuthConfig `yaml:"oauth"`
+	// SessionSecret signs login cookies. Sessions do not survive a restart
+	// while it is empty.
+	SessionSecret string `yaml:"session_secret"`
+}
+
+// validate checks that every admin.users entry can sign in somehow
+func (c AdminConfig) validate() error {
+	for _, u := range c.Users {
+		provider, _, _ := strings.Cut(u.Account, ":")
+		if u.Password == "" && provider != "github" && provider != "google" {
+			return fmt.Errorf("admin.users %q needs a password, or an OAuth account like github:%s or google:%s", u.Account, u.Account, u.Account)
+		}
+	}
+	return nil
+}
+
+// registerAdminRoutes wires up the token API and the dashboard under /admin
+func (s *Server) registerAdminRoutes() {
+	admin := s.app.Group("/admin")
+	admin.Post("/reload", s.requireAdminToken, s.handleAdminReload)
+
+	// Everything below uses a session cookie, so unsafe requests need a CSRF token
+	admin.Use(s.sessionMiddleware, s.csrfProtect)
+	admin.Get("/login", s.handleAdminLogin)
+	admin.Post("/logout", s.handleAdminLogout)
+	admin.Get("/oauth/:provider", s.handleOAuthStart)
+	admin.Get("/oauth/:provider/callback", s.handleOAuthCallback)
+
+	admin.Use(s.requireAdminLogin())
+	admin.Get("/", s.handleAdminDashboard)
+	admin.Post("/actions/reload", requireRole(RoleAdmin), s.handleAdminAction(s.reloadContent, "Content re-scanned"))
+	admin.Post("/actions/purge", requireRole(RoleAdmin), s.handleAdminAction(s.purgeTemplates, "Template cache purged"))
+	admin.Post("/actions/publish/:slug", requireRole(RoleAdmin), s.handleAdminPublish)
+	admin.Post("/actions/schedule/:slug", requireRole(RoleAdmin), s.handleAdminSchedule)
+
+	admin.Get("/drafts", s.handleDraftList)
+
+	admin.Get("/editor", s.handleEditorList)
+	admin.Post("/editor", s.handleEditorCreate)
+	admin.Get("/editor/*", s.handleEditorEdit)
+	admin.Post("/editor/*", s.handleEditorSave)
+	admin.Post("/delete/*", s.handleEditorDelete)
+	admin.Get("/revisions/*", s.handleRevisions)
+	admin.Post("/revisions/*", s.handleRevisionRestore)
+	admin.Get("/trash", requireRole(RoleAdmin), s.handleTrashList)
+	admin.Post("/trash/restore/*", requireRole(RoleAdmin), s.handleTrashRestore)
+	admin.Post("/trash/delete/*", requireRole(RoleAdmin), s.handleTrashDelete)
+
+	admin.Get("/audit", requireRole(RoleAdmin), s.handleAuditLog)
+	admin.Get("/analytics", requireRole(RoleAdmin), s.handleAnalytics)
+	admin.Get("/newsletter", requireRole(RoleAdmin), s.handleNewsletterAdmin)
+	admin.Get("/emails", requireRole(RoleAdmin), s.handleEmailPreviews)
+	admin.Get("/emails/:name", requireRole(RoleAdmin), s.handleEmailPreview)
+	admin.Get("/newsletter/export", requireRole(RoleAdmin), s.handleSubscriberExport)
+	admin.Post("/newsletter/:id/delete", requireRole(RoleAdmin), s.handleSubscriberDelete)
+	admin.Get("/comments", requireRole(RoleAdmin), s.handleCommentQueue)
+	admin.Get("/comments/export", requireRole(RoleAdmin), s.handleCommentExport)
+	admin.Post("/comments/import", requireRole(RoleAdmin), s.handleCommentImport)
+	admin.Post("/comments/block", requireRole(RoleAdmin), s.handleCommentBlock)
+	admin.Post("/comments/unblock", requireRole(RoleAdmin), s.handleCommentUnblock)
+	admin.Post("/comments/:id/:action", requireRole(RoleAdmin), s.handleCommentModerate)
+
+	if s.cfg.IndieAuth.Enabled {
+		admin.Get("/indieauth", requireRole(RoleAdmin), s.handleIndieAuthConsent)
+		admin.Post("/indieauth", requireRole(RoleAdmin), s.handleIndieAuthApprove)
+	}
+
+	admin.Get("/media", s.handleMediaLibrary)
+	admin.Post("/media", s.handleMediaUpload)
+
+	s.app.Post("/api/preview", s.requireAdminLogin(), s.csrfProtect, s.handlePreview)
+	s.app.Get("/preview/:slug", s.requirePreviewAccess(), s.handleDraftPreview)
+}
+
+// requireAdminToken only lets requests through that carry the admin token as
+// a bearer token. Without a configured token the endpoints do not exist.
+func (s *Server) requireAdminToken(c *fiber.Ctx) error {
+	if s.cfg.Admin.Token == "" {
+		return fiber.ErrNotFound
+	}
+
+	token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Admin.Token)) != 1 {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="devdaze"`)
+		return fiber.ErrUnauthorized
+	}
+	return c.Next()
+}
+
+// requireAdminLogin protects the dashboard and draft previews. Users sign
+// in with OAuth when a provider is configured, and with HTTP basic auth
+// otherwise or when the request carries credentials. The signed-in user and
+// their role are available as the "adminUser" local.
+func (s *Server) requireAdminLogin() fiber.Handler {
+	users := s.basicUsers()
+	oauth := s.oauthEnabled()
+	if !oauth && !hasBasicUsers(users) {
+		return func(c *fiber.Ctx) error {
+			return fiber.ErrNotFound
+		}
+	}
+
+	return func(c *fiber.Ctx) error {
+		// A session whose account may no longer sign in counts as none
+		account, _ := s.adminSession(c)
+		user, ok := s.sessionUser(account)
+		if ok {
+			c.Locals("AdminAccount", account)
+		} else if c.Get(fiber.HeaderAuthorization) != "" || !oauth {
+			if account, ok = basicAuthUser(c, users); !ok {
+				return basicAuthChallenge(c, "DevDaze Admin")
+			}
+			if user, ok = s.basicUser(account); !ok {
+				return fiber.ErrForbidden
+			}
+		} else if c.Method() == fiber.MethodGet {
+			return c.Redirect("/admin/login?next="+url.QueryEscape(c.OriginalURL()), fiber.StatusFound)
+		} else {
+			return fiber.ErrUnauthorized
+		}
+
+		c.Locals("username", account)
+		c.Locals("adminUser", user)
+		c.Locals("AdminRole", user.Role)
+		return c.Next()
+	}
+}
+
+// reloadContent re-scans the content directory
+func (s *Server) reloadContent() error {
+	return s.content.Load()
+}
+
+// purgeTemplates drops the compiled templates so they are rebuilt from disk
+func (s *Server) purgeTemplates() error {
+	s.engine.Loaded = false
+	s.templatesErr = s.engine.Load()
+	return s.templatesErr
+}
+
+// handleAdminReload re-indexes content and purges the template cache after
+// content was changed by an external process such as rsync or a CI job
+func (s *Server) handleAdminReload(c *fiber.Ctx) error {
+	if err := s.reloadContent(); err != nil {
+		return s.internalError(c, "Reload failed", err)
+	}
+	if err := s.purgeTemplates(); err != nil {
+		return s.internalError(c, "Reload failed", err)
+	}
+
+	_, count, _ := s.content.Status()
+	requestLogger(c).Info("Content reloaded", "posts", count)
+	s.auditAs(c, "admin token", "Reload content and templates")
+	return c.JSON(fiber.Map{"status": "reloaded", "posts": count})
+}
+
+// handleAdminDashboard shows the state of the site and quick actions
+func (s *Server) handleAdminDashboard(c *fiber.Ctx) error {
+	posts, err := s.content.AllPosts()
+	if err != nil {
+		return s.internalError(c, "Error loading blog posts", err)
+	}
+
+	user := currentUser(c)
+	var drafts []*BlogPost
+	for _, post := range posts {
+		if post.Draft && user.canEdit(post) {
+			drafts = append(drafts, post)
+		}
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	views, err := s.dashboardViews(posts)
+	if err != nil {
+		requestLogger(c).Error("Error loading page views", "error", err)
+	}
+
+	return c.Render("admin", fiber.Map{
+		"Views":       views,
+		"Title":       "Admin",
+		"Notice":      c.Query("notice"),
+		"Stats":       s.content.Stats(),
+		"Drafts":      drafts,
+		"Errors":      s.errors.Recent(),
+		"Audit":       s.recentAudit(c, 10),
+		"TemplatesOK": s.templatesErr == nil,
+		"Development": s.cfg.Development(),
+		"Uptime":      time.Since(s.startedAt).Round(time.Second),
+		"MemoryMB":    mem.Alloc / 1024 / 1024,
+		"Goroutines":  runtime.NumGoroutine(),
+	})
+}
+
+// handleAdminAction runs a quick action from the dashboard and redirects back
+func (s *Server) handleAdminAction(action func() error, notice string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := action(); err != nil {
+			return s.internalError(c, "Action failed", err)
+		}
+		requestLogger(c).Info("Admin action", "action", c.Path())
+		s.audit(c, "%s", notice)
+		return c.Redirect("/admin?notice="+url.QueryEscape(notice), fiber.StatusSeeOther)
+	}
+}
+
+// handleAdminPublish publishes a post right away: it clears the draft flag
+// and moves a future date to now. The form's next field picks the page to
+// return to.
+func (s *Server) handleAdminPublish(c *fiber.Ctx) error {
+	post := s.findPost(c.Params("slug"))
+	if post == nil {
+		return fiber.ErrNotFound
+	}
+
+	err := s.revise(post.FilePath, func() error {
+		if post.Date.After(time.Now()) {
+			if err := setFrontmatterField(post.FilePath, "date", time.Now().In(s.cfg.Location()).Format(time.RFC3339)); err != nil {
+				return err
+			}
+		}
+		return setFrontmatterField(post.FilePath, "draft", "false")
+	})
+	if err != nil {
+		return s.internalError(c, "Publish failed", err)
+	}
+	if err := s.content.Load(); err != nil {
+		return s.internalError(c, "Publish failed", err)
+	}
+
+	requestLogger(c).Info("Published post", "slug", post.Slug)
+	s.recordEdit(c, "Publish %s", post.Slug)
+	s.postChanged(post, s.findPost(post.Slug))
+	return c.Redirect(withNotice(safeRedirect(c.FormValue("next")), "Published "+post.Title), fiber.StatusSeeOther)
+}
+
+// withNotice adds a notice to the query of a local URL, keeping the query it
+// already has
+func withNotice(target, notice string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "/admin?notice=" + url.QueryEscape(notice)
+	}
+	q := u.Query()
+	q.Set("notice", notice)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// handleDraftPreview renders any post, including drafts, so it can be
+// reviewed on the live site before publishing
+func (s *Server) handleDraftPreview(c *fiber.Ctx) error {
+	post := s.findPost(c.Params("slug"))
+	if post == nil {
+		return fiber.NewError(fiber.StatusNotFound, "Blog post not found")
+	}
+	c.Set("X-Robots-Tag", "noindex")
+	return s.renderPost(c, post)
+}
+
+// findPostFile looks up any post, including drafts, by the path of its file
+func (s *Server) findPostFile(path string) *BlogPost {
+	posts, err := s.content.AllPosts()
+	if err != nil {
+		return nil
+	}
+	for _, post := range posts {
+		if post.FilePath == path {
+			return post
+		}
+	}
+	return nil
+}
+
+// findPost looks up any post, including drafts, by slug
+func (s *Server) findPost(slug string) *BlogPost {
+	posts, err := s.content.AllPosts()
+	if err != nil {
+		return nil
+	}
+	for _, post := range posts {
+		if post.Slug == slug {
+			return post
+		}
+	}
+	return nil
+}
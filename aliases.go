@@ -0,0 +1,59 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// aliasPath normalizes an alias so /old/post/ and /old/post match
+func aliasPath(alias string) string {
+	return "/" + strings.Trim(strings.TrimSpace(alias), "/")
+}
+
+// addAliases maps the aliases of post to its path
+func addAliases(aliases map[string]string, post *BlogPost) {
+	for _, alias := range post.Aliases {
+		aliases[aliasPath(alias)] = postPath(post)
+	}
+}
+
+// postPath returns the path of a post or translation
+func postPath(post *BlogPost) string {
+	return languagePrefix(post.Lang) + "/blog/" + post.Slug
+}
+
+// handleAlias redirects the aliases of posts to them and leaves every other
+// unknown path to the not found handler
+func (s *Server) handleAlias(c *fiber.Ctx) error {
+	if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead {
+		return c.Next()
+	}
+	target, ok := s.content.Redirect(c.Path())
+	if !ok {
+		return c.Next()
+	}
+	return c.Redirect(target, fiber.StatusMovedPermanently)
+}
+
+// redirectPageTemplate stands in for an alias in static builds, where there
+// is no server to answer with a redirect
+var redirectPageTemplate = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="UTF-8">
+<title>Redirecting</title>
+<link rel="canonical" href="{{ . }}">
+<meta http-equiv="refresh" content="0; url={{ . }}">
+</head>
+<body><a href="{{ . }}">{{ . }}</a></body>
+</html>
+`))
+
+// redirectPage renders the page that sends browsers from an alias to target
+func redirectPage(target string) []byte {
+	var b strings.Builder
+	redirectPageTemplate.Execute(&b, target)
+	return []byte(b.String())
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AnalyticsConfig counts page views on the server, so no tracker runs in
+// readers' browsers
+type AnalyticsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Database is the SQLite file the view log is kept in
+	Database string `yaml:"database"`
+
+	// PublicCounts shows the view count of each post in the listings of the
+	// built-in templates
+	PublicCounts bool `yaml:"public_counts"`
+	// IgnoreReferrers are domains left out of the referrer log, in addition
+	// to a built-in list of referrer spam
+	IgnoreReferrers []string `yaml:"ignore_referrers"`
+
+	// Plausible, GoatCounter and Google add the script of a hosted analytics
+	// service to every page, with or without server-side counting
+	Plausible   PlausibleConfig       `yaml:"plausible"`
+	GoatCounter GoatCounterConfig     `yaml:"goatcounter"`
+	Google      GoogleAnalyticsConfig `yaml:"google"`
+}
+
+const (
+	// viewQueueSize is how many views wait for the writer before new ones
+	// are dropped
+	viewQueueSize = 1024
+	// viewBatchSize is how many views the writer stores in one transaction
+	viewBatchSize = 100
+	// viewFlushInterval is how long a view waits for its batch to fill up
+	viewFlushInterval = 5 * time.Second
+)
+
+// viewedPostLocal is the local handlePost sets to the slug of the post it
+// shows, so countViews can attribute the view
+const viewedPostLocal = "viewedPost"
+
+// botAgents matches the user agents of crawlers, feed fetchers, link
+// previews, monitors and HTTP libraries
+var botAgents = regexp.MustCompile(`(?i)bot|crawl|spider|slurp|archiver|fetch|scan|preview|monitor|uptime|pingdom|lighthouse|headless|phantom|curl|wget|python|go-http-client|java/|okhttp|axios|node|ruby|libwww|httpclient|facebookexternalhit|embedly|whatsapp|feed|rss`)
+
+var viewMigrations = []string{
+	`CREATE TABLE views (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		path TEXT NOT NULL,
+		post TEXT NOT NULL DEFAULT '',
+		visitor TEXT NOT NULL,
+		viewed_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX views_post ON views (post, viewed_at);
+	CREATE INDEX views_viewed_at ON views (viewed_at);`,
+	`ALTER TABLE views ADD COLUMN referrer TEXT NOT NULL DEFAULT '';
+	CREATE TABLE feed_hits (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		path TEXT NOT NULL,
+		reader TEXT NOT NULL,
+		subscribers INTEGER NOT NULL DEFAULT 0,
+		visitor TEXT NOT NULL,
+		fetched_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX feed_hits_fetched_at ON feed_hits (fetched_at);`,
+}
+
+// pageView is one counted request for a page
+type pageView struct {
+	Path string
+	// Post is the slug of the post viewed, "" for other pages
+	Post string
+	// Visitor is a hash that tells apart readers within a day
+	Visitor string
+	// Referrer is the host and path of the page that linked to this one,
+	// when it is on another site
+	Referrer string
+	At       time.Time
+
+	// Feed is set for fetches of a feed, with the feed reader's name and
+	// the number of subscribers it reports
+	Feed        bool
+	Reader      string
+	Subscribers int
+}
+
+// viewStore keeps the page view log. Views are queued and written in
+// batches by run, so counting never slows down a request.
+type viewStore struct {
+	db    *sql.DB
+	queue chan pageView
+}
+
+// visitorSalt is the salt of today's visitor hashes. It outlives reloads, so
+// readers are not counted twice when the sites are rebuilt.
+var visitorSalt struct {
+	mu  sync.Mutex
+	day string
+	key []byte
+}
+
+// newViewStore opens the analytics database of cfg, or returns nil when
+// analytics are disabled
+func newViewStore(cfg *Config) (*viewStore, error) {
+	if !cfg.Analytics.Enabled {
+		return nil, nil
+	}
+	db, err := openDatabase(cfg.Analytics.Database, viewMigrations)
+	if err != nil {
+		return nil, err
+	}
+	return &viewStore{db: db, queue: make(chan pageView, viewQueueSize)}, nil
+}
+
+// Record queues a view for the writer, dropping it when the queue is full
+func (s *viewStore) Record(v pageView) {
+	// Strings from fiber point into buffers reused by the next request
+	v.Path, v.Post, v.Referrer, v.Reader = strings.Clone(v.Path), strings.Clone(v.Post), strings.Clone(v.Referrer), strings.Clone(v.Reader)
+	select {
+	case s.queue <- v:
+	default:
+		slog.Debug("Dropped page view, the queue is full", "path", v.Path)
+	}
+}
+
+// run writes queued views until ctx is done, then writes what is left
+func (s *viewStore) run(ctx context.Context) {
+	ticker := time.NewTicker(viewFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]pageView, 0, viewBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.write(batch); err != nil {
+			slog.Error("Error saving page views", "count", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case v := <-s.queue:
+					batch = append(batch, v)
+				default:
+					flush()
+					return
+				}
+			}
+		case v := <-s.queue:
+			if batch = append(batch, v); len(batch) >= viewBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// write stores a batch of views in one transaction
+func (s *viewStore) write(views []pageView) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("INSERT INTO views (path, post, visitor, referrer, viewed_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	feedStmt, err := tx.Prepare("INSERT INTO feed_hits (path, reader, subscribers, visitor, fetched_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer feedStmt.Close()
+
+	for _, v := range views {
+		if v.Feed {
+			_, err = feedStmt.Exec(v.Path, v.Reader, v.Subscribers, v.Visitor, v.At.UTC())
+		} else {
+			_, err = stmt.Exec(v.Path, v.Post, v.Visitor, v.Referrer, v.At.UTC())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// viewVisitor hashes a reader's IP and user agent with a random salt that is
+// replaced every day and never stored, so readers can be counted once per
+// day but not recognized afterwards or traced back to their address
+func viewVisitor(ip, userAgent string) string {
+	visitorSalt.mu.Lock()
+	day := time.Now().UTC().Format("2006-01-02")
+	if day != visitorSalt.day {
+		visitorSalt.key = make([]byte, 32)
+		rand.Read(visitorSalt.key)
+		visitorSalt.day = day
+	}
+	salt := visitorSalt.key
+	visitorSalt.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(string(salt) + "|" + ip + "|" + userAgent))
+	return hex.EncodeToString(sum[:16])
+}
+
+// PostViews returns the number of views of post
+func (s *viewStore) PostViews(post string) (int, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM views WHERE post = ?", post).Scan(&n)
+	return n, err
+}
+
+// PostCounts returns the number of views of every viewed post
+func (s *viewStore) PostCounts() (map[string]int, error) {
+	rows, err := s.db.Query("SELECT post, COUNT(*) FROM views WHERE post != '' GROUP BY post")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var post string
+		var n int
+		if err := rows.Scan(&post, &n); err != nil {
+			return nil, err
+		}
+		counts[post] = n
+	}
+	return counts, rows.Err()
+}
+
+// Since returns the number of views and distinct visitors since t
+func (s *viewStore) Since(t time.Time) (views, visitors int, err error) {
+	err = s.db.QueryRow("SELECT COUNT(*), COUNT(DISTINCT visitor) FROM views WHERE viewed_at >= ?", t.UTC()).Scan(&views, &visitors)
+	return views, visitors, err
+}
+
+// startAnalytics runs the view writers of every site until ctx is done
+func (r *siteRouter) startAnalytics(ctx context.Context) {
+	for _, s := range append([]*Server{r.main}, r.sites...) {
+		if s.views != nil {
+			go s.views.run(ctx)
+		}
+	}
+}
+
+// countViews records successful page requests after they are served.
+// Crawlers and other tools, prefetches, the dashboard, the APIs and signed-in
+// admins are not counted. Fetches of feeds are logged apart, for estimating
+// the number of subscribers.
+func (s *Server) countViews(c *fiber.Ctx) error {
+	if err := c.Next(); err != nil {
+		return err
+	}
+	if c.Method() != fiber.MethodGet || c.Response().StatusCode() != fiber.StatusOK {
+		return nil
+	}
+	contentType := string(c.Response().Header.ContentType())
+	if isFeedType(contentType) {
+		ua := c.Get(fiber.HeaderUserAgent)
+		reader, subscribers := feedReader(ua)
+		s.views.Record(pageView{Path: c.Path(), Visitor: viewVisitor(c.IP(), ua), At: time.Now(),
+			Feed: true, Reader: reader, Subscribers: subscribers})
+		return nil
+	}
+	if !strings.HasPrefix(contentType, fiber.MIMETextHTML) {
+		return nil
+	}
+	path := c.Path()
+	for _, prefix := range []string{"/admin", "/api/", "/preview/", "/indieauth", "/comments/", "/micropub"} {
+		if strings.HasPrefix(path, prefix) {
+			return nil
+		}
+	}
+	ua := c.Get(fiber.HeaderUserAgent)
+	if ua == "" || botAgents.MatchString(ua) || c.Get("Sec-Purpose") != "" || c.Get("Purpose") == "prefetch" || s.signedInAdmin(c) {
+		return nil
+	}
+
+	// Referrer spam is sent by bots faking visits, so its views are not real
+	referrer := s.viewReferrer(c)
+	if s.isReferrerSpam(referrerHostOf(referrer)) {
+		return nil
+	}
+	post, _ := c.Locals(viewedPostLocal).(string)
+	s.views.Record(pageView{Path: path, Post: post, Visitor: viewVisitor(c.IP(), ua), Referrer: referrer, At: time.Now()})
+	return nil
+}
+
+// viewReferrer returns the host and path of the Referer header when it
+// points to another site. The query is left out, it can hold search terms
+// or tokens.
+func (s *Server) viewReferrer(c *fiber.Ctx) string {
+	ref, err := url.Parse(c.Get(fiber.HeaderReferer))
+	if err != nil || (ref.Scheme != "http" && ref.Scheme != "https") || ref.Host == "" {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(ref.Hostname()), "www.")
+	if host == strings.TrimPrefix(hostWithoutPort(c.Hostname()), "www.") {
+		return ""
+	}
+	if base, err := url.Parse(s.cfg.BaseURL); err == nil && host == strings.TrimPrefix(strings.ToLower(base.Hostname()), "www.") {
+		return ""
+	}
+	return host + strings.TrimSuffix(ref.EscapedPath(), "/")
+}
+
+// isFeedType reports whether a response content type is RSS, Atom or JSON
+// Feed
+func isFeedType(contentType string) bool {
+	for _, t := range []string{"application/rss+xml", "application/atom+xml", "application/feed+json"} {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// feedSubscribers finds the subscriber count hosted feed readers like
+// Feedly, Inoreader or NewsBlur put in their user agent
+var feedSubscribers = regexp.MustCompile(`(?i)(\d+)\s+(?:subscribers?|readers?)`)
+
+// feedReader returns the name of the feed reader of a user agent and the
+// number of subscribers it reports, 0 when it does not say
+func feedReader(userAgent string) (string, int) {
+	var subscribers int
+	if m := feedSubscribers.FindStringSubmatch(userAgent); m != nil {
+		subscribers, _ = strconv.Atoi(m[1])
+	}
+	name := userAgent
+	for _, sep := range []string{"/", "(", ";", " - ", " feed-id", " http"} {
+		if i := strings.Index(name, sep); i >= 0 {
+			name = name[:i]
+		}
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		name = "unknown"
+	}
+	return name, subscribers
+}
+
+// signedInAdmin reports whether the request carries a dashboard session.
+// Unlike session, it never starts one.
+func (s *Server) signedInAdmin(c *fiber.Ctx) bool {
+	value, ok := s.signer.Verify(c.Cookies(sessionCookie))
+	var sess Session
+	return ok && json.Unmarshal([]byte(value), &sess) == nil && sess.Account != ""
+}
+
+// postViews returns the view count of a post for templates, or nil when
+// analytics are disabled
+func (s *Server) postViews(c *fiber.Ctx, post *BlogPost) *int {
+	if s.views == nil || !apiVisible(post) {
+		return nil
+	}
+	n, err := s.views.PostViews(post.Slug)
+	if err != nil {
+		requestLogger(c).Error("Error counting views", "slug", post.Slug, "error", err)
+		return nil
+	}
+	return &n
+}
+
+// listingViews returns the all-time view counts of posts by slug for the
+// listing templates, or nil when analytics are disabled
+func (s *Server) listingViews(c *fiber.Ctx, posts []*BlogPost) map[string]int {
+	if s.views == nil {
+		return nil
+	}
+	// The ranking of every post is cached like other popular posts lists
+	ranked, err := s.popularPosts(0, len(posts))
+	if err != nil {
+		requestLogger(c).Error("Error counting views", "error", err)
+		return nil
+	}
+	counts := make(map[string]int, len(ranked))
+	for _, p := range ranked {
+		counts[p.Post.Slug] = p.Views
+	}
+	return counts
+}
+
+// dashboardViews summarizes the view log for the admin dashboard
+type dashboardViews struct {
+	Today, Month  int
+	VisitorsToday int
+	VisitorsMonth int
+	MostViewed    []postViewCount
+}
+
+// postViewCount is a post with its number of views
+type postViewCount struct {
+	Post  *BlogPost
+	Views int
+}
+
+// dashboardViews returns the view counts the admin dashboard shows, or nil
+// when analytics are disabled
+func (s *Server) dashboardViews(posts []*BlogPost) (*dashboardViews, error) {
+	if s.views == nil {
+		return nil, nil
+	}
+	var d dashboardViews
+	var err error
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if d.Today, d.VisitorsToday, err = s.views.Since(midnight); err != nil {
+		return nil, err
+	}
+	if d.Month, d.VisitorsMonth, err = s.views.Since(now.AddDate(0, 0, -30)); err != nil {
+		return nil, err
+	}
+
+	counts, err := s.views.PostCounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, post := range posts {
+		if n := counts[post.Slug]; n > 0 {
+			d.MostViewed = append(d.MostViewed, postViewCount{Post: post, Views: n})
+		}
+	}
+	sort.SliceStable(d.MostViewed, func(i, j int) bool { return d.MostViewed[i].Views > d.MostViewed[j].Views })
+	if len(d.MostViewed) > 10 {
+		d.MostViewed = d.MostViewed[:10]
+	}
+	return &d, nil
+}
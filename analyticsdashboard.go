@@ -0,0 +1,232 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// analyticsRanges are the periods the analytics dashboard can show, in days
+var analyticsRanges = []int{7, 30, 90}
+
+// dailyViews is a day of the analytics chart
+type dailyViews struct {
+	Day      time.Time
+	Views    int
+	Visitors int
+	// Height is the bar's share of the busiest day, in percent
+	Height int
+}
+
+// referrerCount is a site that sent readers, with its number of views
+type referrerCount struct {
+	Host  string
+	Views int
+}
+
+// feedSubscriberCount is the estimated number of subscribers of a feed
+// reader. Hosted readers report it in their user agent, other readers are
+// counted by distinct visitors.
+type feedSubscriberCount struct {
+	Reader      string
+	Subscribers int
+	Reported    bool
+}
+
+// Daily returns the views and visitors of each UTC day since, oldest first,
+// including days without views
+func (s *viewStore) Daily(since time.Time) ([]dailyViews, error) {
+	rows, err := s.db.Query("SELECT substr(viewed_at, 1, 10) AS day, COUNT(*), COUNT(DISTINCT visitor) FROM views WHERE viewed_at >= ? GROUP BY day",
+		since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string][2]int{}
+	for rows.Next() {
+		var day string
+		var views, visitors int
+		if err := rows.Scan(&day, &views, &visitors); err != nil {
+			return nil, err
+		}
+		counts[day] = [2]int{views, visitors}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var days []dailyViews
+	busiest := 0
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for day := since.UTC().Truncate(24 * time.Hour); !day.After(today); day = day.AddDate(0, 0, 1) {
+		n := counts[day.Format(time.DateOnly)]
+		days = append(days, dailyViews{Day: day, Views: n[0], Visitors: n[1]})
+		busiest = max(busiest, n[0])
+	}
+	for i := range days {
+		if busiest > 0 {
+			days[i].Height = days[i].Views * 100 / busiest
+		}
+	}
+	return days, nil
+}
+
+// referrerHost is the SQL expression for the host part of a logged referrer
+const referrerHost = "CASE WHEN instr(referrer, '/') > 0 THEN substr(referrer, 1, instr(referrer, '/') - 1) ELSE referrer END"
+
+// TopReferrers returns the sites that sent views since, most views first
+func (s *viewStore) TopReferrers(since time.Time) ([]referrerCount, error) {
+	rows, err := s.db.Query("SELECT "+referrerHost+" AS host, COUNT(*) AS n FROM views WHERE referrer != '' AND viewed_at >= ? GROUP BY host ORDER BY n DESC, host",
+		since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var referrers []referrerCount
+	for rows.Next() {
+		var r referrerCount
+		if err := rows.Scan(&r.Host, &r.Views); err != nil {
+			return nil, err
+		}
+		referrers = append(referrers, r)
+	}
+	return referrers, rows.Err()
+}
+
+// PostReferrers returns the sites that sent views to each post since, most
+// views first
+func (s *viewStore) PostReferrers(since time.Time) (map[string][]referrerCount, error) {
+	rows, err := s.db.Query("SELECT post, "+referrerHost+" AS host, COUNT(*) AS n FROM views WHERE post != '' AND referrer != '' AND viewed_at >= ? GROUP BY post, host ORDER BY n DESC, host",
+		since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referrers := map[string][]referrerCount{}
+	for rows.Next() {
+		var post string
+		var r referrerCount
+		if err := rows.Scan(&post, &r.Host, &r.Views); err != nil {
+			return nil, err
+		}
+		referrers[post] = append(referrers[post], r)
+	}
+	return referrers, rows.Err()
+}
+
+// FeedSubscribers estimates the subscribers of each feed reader from the feed
+// fetches since: the highest count a hosted reader reported for a feed, or the
+// distinct visitors of readers that do not report one
+func (s *viewStore) FeedSubscribers(since time.Time) ([]feedSubscriberCount, error) {
+	rows, err := s.db.Query("SELECT reader, MAX(subscribers), COUNT(DISTINCT visitor) FROM feed_hits WHERE fetched_at >= ? GROUP BY reader, path",
+		since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byReader := map[string]*feedSubscriberCount{}
+	for rows.Next() {
+		var reader string
+		var reported, visitors int
+		if err := rows.Scan(&reader, &reported, &visitors); err != nil {
+			return nil, err
+		}
+		f := byReader[reader]
+		if f == nil {
+			f = &feedSubscriberCount{Reader: reader}
+			byReader[reader] = f
+		}
+		if reported > 0 {
+			f.Subscribers += reported
+			f.Reported = true
+		} else {
+			f.Subscribers += visitors
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	readers := make([]feedSubscriberCount, 0, len(byReader))
+	for _, f := range byReader {
+		readers = append(readers, *f)
+	}
+	sort.Slice(readers, func(i, j int) bool {
+		if readers[i].Subscribers != readers[j].Subscribers {
+			return readers[i].Subscribers > readers[j].Subscribers
+		}
+		return readers[i].Reader < readers[j].Reader
+	})
+	return readers, nil
+}
+
+// handleAnalytics shows the analytics dashboard: daily views, top posts, top
+// referrers and feed subscribers
+func (s *Server) handleAnalytics(c *fiber.Ctx) error {
+	if s.views == nil {
+		return c.Render("admin_analytics", fiber.Map{"Title": "Analytics", "Enabled": false})
+	}
+	days := c.QueryInt("days", 30)
+	valid := false
+	for _, d := range analyticsRanges {
+		valid = valid || d == days
+	}
+	if !valid {
+		days = 30
+	}
+	window := time.Duration(days) * 24 * time.Hour
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-days)
+
+	views, visitors, err := s.views.Since(since)
+	if err != nil {
+		return s.internalError(c, "Error loading page views", err)
+	}
+	daily, err := s.views.Daily(since)
+	if err != nil {
+		return s.internalError(c, "Error loading page views", err)
+	}
+	posts, err := s.popularPosts(window, 10)
+	if err != nil {
+		return s.internalError(c, "Error loading page views", err)
+	}
+	referrers, err := s.views.TopReferrers(since)
+	if err != nil {
+		return s.internalError(c, "Error loading page views", err)
+	}
+	postReferrers, err := s.views.PostReferrers(since)
+	if err != nil {
+		return s.internalError(c, "Error loading page views", err)
+	}
+	for post, r := range postReferrers {
+		postReferrers[post] = s.withoutReferrerSpam(r, 3)
+	}
+	// Feed readers poll at least daily, so a day of fetches covers them
+	subscribers, err := s.views.FeedSubscribers(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		return s.internalError(c, "Error loading feed fetches", err)
+	}
+	total := 0
+	for _, f := range subscribers {
+		total += f.Subscribers
+	}
+
+	return c.Render("admin_analytics", fiber.Map{
+		"Title":            "Analytics",
+		"Enabled":          true,
+		"Days":             days,
+		"Ranges":           analyticsRanges,
+		"Views":            views,
+		"Visitors":         visitors,
+		"Daily":            daily,
+		"Posts":            posts,
+		"Referrers":        s.withoutReferrerSpam(referrers, 10),
+		"PostReferrers":    postReferrers,
+		"Subscribers":      subscribers,
+		"TotalSubscribers": total,
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PlausibleConfig adds Plausible's script to every page when Domain is set
+type PlausibleConfig struct {
+	// Domain is the site's domain as registered in Plausible
+	Domain string `yaml:"domain"`
+	// Script is the script URL, to use a self-hosted instance or one of
+	// Plausible's script extensions
+	Script string `yaml:"script"`
+}
+
+// GoatCounterConfig adds GoatCounter's script to every page when Code or
+// Endpoint is set
+type GoatCounterConfig struct {
+	// Code is the site code of https://CODE.goatcounter.com
+	Code string `yaml:"code"`
+	// Endpoint is the count URL of a self-hosted instance, instead of Code
+	Endpoint string `yaml:"endpoint"`
+	// Script is the URL of count.js
+	Script string `yaml:"script"`
+}
+
+// GoogleAnalyticsConfig adds the Google Analytics tag to every page when
+// MeasurementID is set
+type GoogleAnalyticsConfig struct {
+	// MeasurementID is the G-XXXXXXXXXX ID of the property's web stream
+	MeasurementID string `yaml:"measurement_id"`
+}
+
+var (
+	goatCounterCode  = regexp.MustCompile(`^[a-z0-9-]+$`)
+	gaMeasurementID  = regexp.MustCompile(`^G-[A-Z0-9]+$`)
+	plausibleDomains = regexp.MustCompile(`(?i)^[a-z0-9.-]+(,[a-z0-9.-]+)*$`)
+)
+
+// analyticsScripts are the script tags of the hosted analytics services
+var analyticsScripts = template.Must(template.New("analytics").Parse(`
+{{- with .Plausible.Domain }}
+    <script defer data-domain="{{ . }}" src="{{ $.Plausible.Script }}"></script>
+{{- end }}
+{{- with .GoatCounterEndpoint }}
+    <script data-goatcounter="{{ . }}" async src="{{ $.GoatCounter.Script }}"></script>
+{{- end }}
+{{- with .Google.MeasurementID }}
+    <script async src="https://www.googletagmanager.com/gtag/js?id={{ . }}"></script>
+    <script>
+        window.dataLayer = window.dataLayer || [];
+        function gtag(){dataLayer.push(arguments);}
+        gtag('js', new Date());
+        gtag('config', {{ . }});
+    </script>
+{{- end }}`))
+
+// validate checks the IDs of the hosted analytics services
+func (c *AnalyticsConfig) validate() error {
+	if d := c.Plausible.Domain; d != "" && !plausibleDomains.MatchString(d) {
+		return fmt.Errorf("analytics.plausible.domain %q is not a domain name", d)
+	}
+	if code := c.GoatCounter.Code; code != "" && !goatCounterCode.MatchString(code) {
+		return fmt.Errorf("analytics.goatcounter.code %q is not a GoatCounter site code", code)
+	}
+	for name, value := range map[string]string{
+		"analytics.plausible.script":     c.Plausible.Script,
+		"analytics.goatcounter.endpoint": c.GoatCounter.Endpoint,
+		"analytics.goatcounter.script":   c.GoatCounter.Script,
+	} {
+		if u, err := url.Parse(value); value != "" && (err != nil || u.Host == "") {
+			return fmt.Errorf("%s %q is not an absolute URL", name, value)
+		}
+	}
+	if id := c.Google.MeasurementID; id != "" && !gaMeasurementID.MatchString(id) {
+		return fmt.Errorf("analytics.google.measurement_id %q is not a measurement ID like G-XXXXXXXXXX", id)
+	}
+	return nil
+}
+
+// registerAnalyticsScripts passes the script tags of the configured hosted
+// analytics services to every page as AnalyticsScripts, which layout.html
+// puts in the head
+func (s *Server) registerAnalyticsScripts() {
+	cfg := s.cfg.Analytics
+	endpoint := cfg.GoatCounter.Endpoint
+	if endpoint == "" && cfg.GoatCounter.Code != "" {
+		endpoint = "https://" + cfg.GoatCounter.Code + ".goatcounter.com/count"
+	}
+	data := struct {
+		AnalyticsConfig
+		GoatCounterEndpoint string
+	}{cfg, endpoint}
+
+	var buf bytes.Buffer
+	if err := analyticsScripts.Execute(&buf, data); err != nil {
+		slog.Error("Error rendering analytics scripts", "error", err)
+		return
+	}
+	if strings.TrimSpace(buf.String()) == "" {
+		return
+	}
+	scripts := template.HTML(buf.String())
+	s.app.Use(func(c *fiber.Ctx) error {
+		c.Locals("AnalyticsScripts", withNonce(scripts, cspNonce(c)))
+		return c.Next()
+	})
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// APIPost is the JSON representation of a post in the content API
+type APIPost struct {
+	Title       string    `json:"title"`
+	Slug        string    `json:"slug"`
+	Date        time.Time `json:"date"`
+	Author      string    `json:"author"`
+	Description string    `json:"description"`
+	Tags        []string  `json:"tags"`
+	Draft       bool      `json:"draft"`
+	Series      string    `json:"series,omitempty"`
+	Comments    *bool     `json:"comments,omitempty"`
+	Dir         string    `json:"dir,omitempty"`
+	Aliases     []string  `json:"aliases,omitempty"`
+	Canonical   string    `json:"canonical,omitempty"`
+	Syndication []string  `json:"syndication,omitempty"`
+	Scheduled   bool      `json:"scheduled"`
+	URL         string    `json:"url"`
+	// Content and HTML are left out of listings
+	Content string `json:"content,omitempty"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// apiPost converts a post for the API, with or without its body
+func apiPost(post *BlogPost, withContent bool) APIPost {
+	p := APIPost{
+		Title:       post.Title,
+		Slug:        post.Slug,
+		Date:        post.Date,
+		Author:      post.Author,
+		Description: post.Description,
+		Tags:        post.Tags,
+		Draft:       post.Draft,
+		Series:      post.Series,
+		Comments:    post.Comments,
+		Dir:         post.Dir,
+		Aliases:     post.Aliases,
+		Canonical:   post.Canonical,
+		Syndication: post.Syndication,
+		Scheduled:   post.Scheduled(),
+		URL:         "/blog/" + post.Slug,
+	}
+	if p.Tags == nil {
+		p.Tags = []string{}
+	}
+	if withContent {
+		p.Content = post.Content
+		p.HTML = post.HTMLContent
+	}
+	return p
+}
+
+// registerAPIRoutes wires up the content API under /api
+func (s *Server) registerAPIRoutes() {
+	api := s.app.Group("/api")
+	api.Get("/whoami", s.requireAPIToken(ScopeRead), s.handleAPIWhoami)
+
+	api.Get("/posts", s.optionalAPIToken, s.handleAPIListPosts)
+	api.Get("/posts/:slug", s.optionalAPIToken, s.handleAPIGetPost)
+	api.Post("/posts", s.requireAPIToken(ScopeWrite), s.handleAPICreatePost)
+	api.Put("/posts/:slug", s.requireAPIToken(ScopeWrite), s.handleAPIUpdatePost)
+	api.Delete("/posts/:slug", s.requireAPIToken(ScopeWrite), s.handleAPIDeletePost)
+
+	api.Post("/posts/:slug/like", s.handleLike)
+
+	api.Get("/popular", s.handleAPIPopular)
+	api.Get("/campaign.rss", s.requireCampaignToken, s.handleCampaignFeed)
+	api.Get("/search", s.handleAPISearch)
+	api.Get("/tags", s.handleAPITags)
+	api.Get("/media", s.requireAPIToken(ScopeRead), s.handleAPIListMedia)
+	api.Post("/media", s.requireAPIToken(ScopeWrite), s.handleAPIUploadMedia)
+
+	api.Get("/graphql", s.optionalAPIToken, s.handleGraphQL)
+	api.Post("/graphql", s.optionalAPIToken, s.handleGraphQL)
+
+	api.Get("/openapi.json", s.handleOpenAPI)
+}
+
+// optionalAPIToken checks a bearer token when one is sent, so read
+// endpoints can show more to authenticated clients
+func (s *Server) optionalAPIToken(c *fiber.Ctx) error {
+	if c.Get(fiber.HeaderAuthorization) == "" {
+		return c.Next()
+	}
+	return s.requireAPIToken(ScopeRead)(c)
+}
+
+// apiTokenAllows reports whether the request's token grants scope
+func apiTokenAllows(c *fiber.Ctx, scope string) bool {
+	token, ok := c.Locals("apiToken").(APIToken)
+	return ok && token.Allows(scope)
+}
+
+// apiError responds with a JSON error body
+func apiError(c *fiber.Ctx, code int, format string, args ...interface{}) error {
+	return c.Status(code).JSON(fiber.Map{
+		"error":      fmt.Sprintf(format, args...),
+		"request_id": requestID(c),
+	})
+}
+
+// apiVisible reports whether a post can be read without a token
+func apiVisible(post *BlogPost) bool {
+	return !post.Draft && !post.Scheduled()
+}
+
+// handleAPIListPosts lists posts, filtered by status, tag, author and a
+// title search, with limit/offset pagination
+func (s *Server) handleAPIListPosts(c *fiber.Ctx) error {
+	status := c.Query("status", "published")
+	switch status {
+	case "published":
+	case "draft", "scheduled", "all":
+		if !apiTokenAllows(c, ScopeRead) {
+			return apiError(c, fiber.StatusUnauthorized, "status=%s needs a token with the read scope", status)
+		}
+	default:
+		return apiError(c, fiber.StatusBadRequest, "unknown status %q (want published, draft, scheduled or all)", status)
+	}
+
+	limit := c.QueryInt("limit", 20)
+	offset := c.QueryInt("offset", 0)
+	if limit < 1 || limit > 100 || offset < 0 {
+		return apiError(c, fiber.StatusBadRequest, "limit must be between 1 and 100 and offset not negative")
+	}
+
+	posts, err := s.content.AllPosts()
+	if err != nil {
+		return s.internalError(c, "Error loading blog posts", err)
+	}
+
+	tag, author := c.Query("tag"), c.Query("author")
+	q := strings.ToLower(c.Query("q"))
+	var matched []APIPost
+	for _, post := range posts {
+		switch {
+		case status == "published" && !apiVisible(post),
+			status == "draft" && !post.Draft,
+			status == "scheduled" && !post.Scheduled(),
+			tag != "" && !containsFold(post.Tags, tag),
+			author != "" && !strings.EqualFold(post.Author, author),
+			q != "" && !strings.Contains(strings.ToLower(post.Title), q):
+			continue
+		}
+		matched = append(matched, apiPost(post, false))
+	}
+
+	page := []APIPost{}
+	if offset < len(matched) {
+		page = matched[offset:min(offset+limit, len(matched))]
+	}
+	return c.JSON(fiber.Map{"posts": page, "total": len(matched), "limit": limit, "offset": offset})
+}
+
+// handleAPIGetPost returns a single post with its markdown and HTML
+func (s *Server) handleAPIGetPost(c *fiber.Ctx) error {
+	post := s.findPost(c.Params("slug"))
+	if post == nil || (!apiVisible(post) && !apiTokenAllows(c, ScopeRead)) {
+		return apiError(c, fiber.StatusNotFound, "post %q not found", c.Params("slug"))
+	}
+	return c.JSON(apiPost(post, true))
+}
+
+// handleAPICreatePost writes a new markdown file from a JSON post
+func (s *Server) handleAPICreatePost(c *fiber.Ctx) error {
+	input := APIPost{Date: time.Now().Truncate(time.Second), Author: s.cfg.Author}
+	if err := json.Unmarshal(c.Body(), &input); err != nil {
+		return apiError(c, fiber.StatusBadRequest, "invalid JSON: %v", err)
+	}
+	if input.Slug == "" {
+		input.Slug = slugify(input.Title)
+	}
+	if err := validateAPIPost(input); err != nil {
+		return apiError(c, fiber.StatusUnprocessableEntity, "%v", err)
+	}
+	if !input.Draft && !apiTokenAllows(c, ScopePublish) {
+		return apiError(c, fiber.StatusForbidden, "creating a published post needs the publish scope; set draft to true")
+	}
+
+	path := filepath.Join(s.cfg.ContentDir, input.Slug+".md")
+	if s.findPost(input.Slug) != nil || fileExists(path) {
+		return apiError(c, fiber.StatusConflict, "post %q already exists", input.Slug)
+	}
+
+	post, err := s.writeAPIPost(path, input)
+	if err != nil {
+		return s.internalError(c, "Error saving post", err)
+	}
+
+	requestLogger(c).Info("Created post via API", "slug", post.Slug)
+	s.recordEdit(c, "Create %s\n\nCreated through the content API.", post.Slug)
+	s.postChanged(nil, post)
+	c.Location("/api/posts/" + post.Slug)
+	return c.Status(fiber.StatusCreated).JSON(apiPost(post, true))
+}
+
+// handleAPIUpdatePost updates the fields sent in the JSON body; fields that
+// are left out keep their current value
+func (s *Server) handleAPIUpdatePost(c *fiber.Ctx) error {
+	existing := s.findPost(c.Params("slug"))
+	if existing == nil {
+		return apiError(c, fiber.StatusNotFound, "post %q not found", c.Params("slug"))
+	}
+
+	input := apiPost(existing, true)
+	if err := json.Unmarshal(c.Body(), &input); err != nil {
+		return apiError(c, fiber.StatusBadRequest, "invalid JSON: %v", err)
+	}
+	if input.Slug != existing.Slug {
+		return apiError(c, fiber.StatusUnprocessableEntity, "the slug of a post cannot be changed")
+	}
+	if err := validateAPIPost(input); err != nil {
+		return apiError(c, fiber.StatusUnprocessableEntity, "%v", err)
+	}
+	// Changing a live post, or making one live, counts as publishing
+	if (!existing.Draft || !input.Draft) && !apiTokenAllows(c, ScopePublish) {
+		return apiError(c, fiber.StatusForbidden, "changing a published post needs the publish scope")
+	}
+
+	post, err := s.writeAPIPost(existing.FilePath, input)
+	if err != nil {
+		return s.internalError(c, "Error saving post", err)
+	}
+
+	requestLogger(c).Info("Updated post via API", "slug", post.Slug)
+	s.recordEdit(c, "Update %s\n\nUpdated through the content API.", post.Slug)
+	s.postChanged(existing, post)
+	return c.JSON(apiPost(post, true))
+}
+
+// handleAPIDeletePost moves the markdown file of a post to the trash
+func (s *Server) handleAPIDeletePost(c *fiber.Ctx) error {
+	post := s.findPost(c.Params("slug"))
+	if post == nil {
+		return apiError(c, fiber.StatusNotFound, "post %q not found", c.Params("slug"))
+	}
+	if !post.Draft && !apiTokenAllows(c, ScopePublish) {
+		return apiError(c, fiber.StatusForbidden, "deleting a published post needs the publish scope")
+	}
+
+	if err := s.trashPost(post.FilePath); err != nil {
+		return s.internalError(c, "Error deleting post", err)
+	}
+
+	requestLogger(c).Info("Deleted post via API", "slug", post.Slug)
+	s.recordEdit(c, "Delete %s\n\nDeleted through the content API.", post.Slug)
+	s.postChanged(post, nil)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleAPISearch finds published posts whose title, description, tags or
+// body contain every word of the query, title matches first
+func (s *Server) handleAPISearch(c *fiber.Ctx) error {
+	words := strings.Fields(strings.ToLower(c.Query("q")))
+	if len(words) == 0 {
+		return apiError(c, fiber.StatusBadRequest, "the q parameter is required")
+	}
+
+	posts, err := s.content.AllPosts()
+	if err != nil {
+		return s.internalError(c, "Error loading blog posts", err)
+	}
+
+	var inTitle, inBody []APIPost
+	for _, post := range posts {
+		if !apiVisible(post) {
+			continue
+		}
+		title := strings.ToLower(post.Title)
+		text := strings.ToLower(strings.Join([]string{post.Title, post.Description, strings.Join(post.Tags, " "), post.Content}, " "))
+
+		titleMatch, match := true, true
+		for _, w := range words {
+			titleMatch = titleMatch && strings.Contains(title, w)
+			match = match && strings.Contains(text, w)
+		}
+		switch {
+		case titleMatch:
+			inTitle = append(inTitle, apiPost(post, false))
+		case match:
+			inBody = append(inBody, apiPost(post, false))
+		}
+	}
+
+	results := append(append([]APIPost{}, inTitle...), inBody...)
+	return c.JSON(fiber.Map{"query": c.Query("q"), "posts": results, "total": len(results)})
+}
+
+// APITag is a tag with the number of published posts using it
+type APITag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// handleAPITags lists the tags of published posts, most used first
+func (s *Server) handleAPITags(c *fiber.Ctx) error {
+	posts, err := s.content.AllPosts()
+	if err != nil {
+		return s.internalError(c, "Error loading blog posts", err)
+	}
+
+	counts := map[string]int{}
+	for _, post := range posts {
+		if apiVisible(post) {
+			for _, tag := range post.Tags {
+				counts[tag]++
+			}
+		}
+	}
+
+	tags := make([]APITag, 0, len(counts))
+	for name, count := range counts {
+		tags = append(tags, APITag{Name: name, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Name < tags[j].Name
+	})
+	return c.JSON(fiber.Map{"tags": tags})
+}
+
+// APIMedia is an uploaded media file in the content API
+type APIMedia struct {
+	Name     string    `json:"name"`
+	URL      string    `json:"url"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Image    bool      `json:"image"`
+	Markdown string    `json:"markdown"`
+}
+
+// apiMedia converts a media file for the API
+func apiMedia(f MediaFile) APIMedia {
+	return APIMedia{Name: f.Name, URL: f.URL, Size: f.Size, Modified: f.ModTime, Image: f.IsImage, Markdown: f.Markdown()}
+}
+
+// handleAPIListMedia lists the files in the media library
+func (s *Server) handleAPIListMedia(c *fiber.Ctx) error {
+	files, err := listMedia(s.cfg)
+	if err != nil {
+		return s.internalError(c, "Error listing media", err)
+	}
+
+	media := make([]APIMedia, 0, len(files))
+	for _, f := range files {
+		media = append(media, apiMedia(f))
+	}
+	return c.JSON(fiber.Map{"media": media})
+}
+
+// handleAPIUploadMedia stores the files of a multipart upload
+func (s *Server) handleAPIUploadMedia(c *fiber.Ctx) error {
+	saved, err := s.saveUploads(c)
+	if err != nil {
+		return err
+	}
+
+	files, err := listMedia(s.cfg)
+	if err != nil {
+		return s.internalError(c, "Error listing media", err)
+	}
+	media := []APIMedia{}
+	for _, f := range files {
+		if slices.Contains(saved, f.Name) {
+			media = append(media, apiMedia(f))
+		}
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"media": media})
+}
+
+// validateAPIPost checks the fields a post needs before it is written
+func validateAPIPost(p APIPost) error {
+	switch {
+	case strings.TrimSpace(p.Title) == "":
+		return fmt.Errorf("title is required")
+	case p.Slug == "" || p.Slug != slugify(p.Slug):
+		return fmt.Errorf("slug %q is not URL safe", p.Slug)
+	case p.Date.IsZero():
+		return fmt.Errorf("date is required")
+	case p.Dir != "" && p.Dir != "ltr" && p.Dir != "rtl":
+		return fmt.Errorf("dir %q is neither rtl nor ltr", p.Dir)
+	case p.Canonical != "" && !absoluteHTTPURL(p.Canonical):
+		return fmt.Errorf("canonical %q is not an absolute http or https URL", p.Canonical)
+	}
+	for _, link := range p.Syndication {
+		if !absoluteHTTPURL(link) {
+			return fmt.Errorf("syndication %q is not an absolute http or https URL", link)
+		}
+	}
+	for _, alias := range p.Aliases {
+		if !strings.HasPrefix(alias, "/") {
+			return fmt.Errorf("alias %q is not a path starting with /", alias)
+		}
+	}
+	return nil
+}
+
+// writeAPIPost writes a post to path and re-indexes the content
+func (s *Server) writeAPIPost(path string, p APIPost) (*BlogPost, error) {
+	err := s.writePost(path, &BlogPost{
+		Title:       p.Title,
+		Date:        p.Date,
+		Author:      p.Author,
+		Description: p.Description,
+		Tags:        p.Tags,
+		Slug:        p.Slug,
+		Draft:       p.Draft,
+		Series:      p.Series,
+		Comments:    p.Comments,
+		Dir:         p.Dir,
+		Aliases:     p.Aliases,
+		Canonical:   p.Canonical,
+		Syndication: p.Syndication,
+		Content:     p.Content,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	post := s.findPost(p.Slug)
+	if post == nil {
+		return nil, fmt.Errorf("post %q was written but is missing from the index", p.Slug)
+	}
+	return post, nil
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// API token scopes
+const (
+	ScopeRead    = "read"
+	ScopeWrite   = "write"
+	ScopePublish = "publish"
+)
+
+// apiScopes lists every scope a token can be granted
+var apiScopes = []string{ScopeRead, ScopeWrite, ScopePublish}
+
+// APIConfig holds the bearer tokens accepted by the content API
+type APIConfig struct {
+	Tokens []APIToken `yaml:"tokens"`
+}
+
+// APIToken is one bearer token with the scopes it grants. The token is
+// configured either in plain text or, preferably, as its SHA-256 hash.
+type APIToken struct {
+	Name   string   `yaml:"name"`
+	Token  string   `yaml:"token,omitempty"`
+	Hash   string   `yaml:"hash,omitempty"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// hashAPIToken returns the value stored in the hash field for a token
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// digest returns the SHA-256 of the configured token
+func (t APIToken) digest() []byte {
+	if t.Token != "" {
+		sum := sha256.Sum256([]byte(t.Token))
+		return sum[:]
+	}
+	sum, err := hex.DecodeString(strings.TrimPrefix(t.Hash, "sha256:"))
+	if err != nil {
+		return nil
+	}
+	return sum
+}
+
+// Allows reports whether the token grants scope
+func (t APIToken) Allows(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// validate checks that a token is usable
+func (t APIToken) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("api token without a name")
+	}
+	if (t.Token == "") == (t.Hash == "") {
+		return fmt.Errorf("api token %q needs exactly one of token or hash", t.Name)
+	}
+	if t.Hash != "" && len(t.digest()) != sha256.Size {
+		return fmt.Errorf("api token %q has an invalid hash, want sha256:<64 hex digits>", t.Name)
+	}
+	return validateScopes(t.Name, t.Scopes)
+}
+
+// validateScopes checks that every scope granted to a token exists
+func validateScopes(name string, scopes []string) error {
+	for _, scope := range scopes {
+		if !slices.Contains(apiScopes, scope) {
+			return fmt.Errorf("api token %q has unknown scope %q (want one of %s)", name, scope, strings.Join(apiScopes, ", "))
+		}
+	}
+	return nil
+}
+
+// lookupAPIToken finds the configured token matching a presented one. Every
+// token is compared so the time taken does not reveal which one matched.
+func (s *Server) lookupAPIToken(presented string) (APIToken, bool) {
+	sum := sha256.Sum256([]byte(presented))
+
+	var found APIToken
+	ok := false
+	for _, t := range s.cfg.API.Tokens {
+		if subtle.ConstantTimeCompare(sum[:], t.digest()) == 1 {
+			found, ok = t, true
+		}
+	}
+	return found, ok
+}
+
+// requireAPIToken only lets requests through that carry a bearer token
+// granting scope. The matched token is available as the "apiToken" local.
+func (s *Server) requireAPIToken(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		presented, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok || presented == "" {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="devdaze"`)
+			return fiber.ErrUnauthorized
+		}
+
+		token, ok := s.lookupAPIToken(presented)
+		if !ok {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="devdaze", error="invalid_token"`)
+			return fiber.ErrUnauthorized
+		}
+		if !token.Allows(scope) {
+			c.Set(fiber.HeaderWWWAuthenticate, fmt.Sprintf(`Bearer realm="devdaze", error="insufficient_scope", scope=%q`, scope))
+			return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("Token %q lacks the %s scope", token.Name, scope))
+		}
+
+		c.Locals("apiToken", token)
+		return c.Next()
+	}
+}
+
+// handleAPIWhoami describes the token used for the request
+func (s *Server) handleAPIWhoami(c *fiber.Ctx) error {
+	token := c.Locals("apiToken").(APIToken)
+	return c.JSON(fiber.Map{"name": token.Name, "scopes": token.Scopes})
+}
+
+// newAPIToken generates a token and prints the config entry that accepts it
+func newAPIToken(out io.Writer, name string, scopes []string) error {
+	if err := validateScopes(name, scopes); err != nil {
+		return err
+	}
+
+	token := randomToken()
+	fmt.Fprintf(out, "Token: %s\n\n", token)
+	fmt.Fprintf(out, "Add it to devdaze.yaml; the token itself is not shown again:\n\n")
+	fmt.Fprintf(out, "api:\n  tokens:\n    - name: %s\n      hash: %s\n      scopes: [%s]\n",
+		name, hashAPIToken(token), strings.Join(scopes, ", "))
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAPITokenAllows(t *testing.T) {
+	token := APIToken{Name: "deploy", Token: "t", Scopes: []string{ScopeRead, ScopeWrite}}
+	tests := []struct {
+		scope string
+		want  bool
+	}{
+		{ScopeRead, true},
+		{ScopeWrite, true},
+		{ScopePublish, false},
+		{"", false},
+		{"READ", false},
+	}
+	for _, tt := range tests {
+		if got := token.Allows(tt.scope); got != tt.want {
+			t.Errorf("Allows(%q) = %v, want %v", tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestRequireAPIToken(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.API.Tokens = []APIToken{
+		{Name: "reader", Token: "read-token", Scopes: []string{ScopeRead}},
+		{Name: "writer", Hash: hashAPIToken("write-token"), Scopes: []string{ScopeRead, ScopeWrite}},
+	}
+	s := &Server{cfg: cfg}
+	app := fiber.New()
+	scopes := func(c *fiber.Ctx) error {
+		var granted []string
+		for _, scope := range apiScopes {
+			if apiTokenAllows(c, scope) {
+				granted = append(granted, scope)
+			}
+		}
+		return c.SendString(strings.Join(granted, " "))
+	}
+	app.Get("/read", s.requireAPIToken(ScopeRead), scopes)
+	app.Get("/write", s.requireAPIToken(ScopeWrite), scopes)
+	app.Get("/optional", s.optionalAPIToken, scopes)
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		wantStatus    int
+		wantScopes    string
+	}{
+		{"no token", "/read", "", fiber.StatusUnauthorized, ""},
+		{"not a bearer token", "/read", "Basic cmVhZC10b2tlbg==", fiber.StatusUnauthorized, ""},
+		{"empty bearer token", "/read", "Bearer ", fiber.StatusUnauthorized, ""},
+		{"unknown token", "/read", "Bearer nope", fiber.StatusUnauthorized, ""},
+		{"plain token", "/read", "Bearer read-token", fiber.StatusOK, "read"},
+		{"hashed token", "/write", "Bearer write-token", fiber.StatusOK, "read write"},
+		{"missing scope", "/write", "Bearer read-token", fiber.StatusForbidden, ""},
+		{"the hash is not a token", "/read", "Bearer " + hashAPIToken("write-token"), fiber.StatusUnauthorized, ""},
+		{"optional without a token", "/optional", "", fiber.StatusOK, ""},
+		{"optional with a token", "/optional", "Bearer write-token", fiber.StatusOK, "read write"},
+		{"optional with an unknown token", "/optional", "Bearer nope", fiber.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(fiber.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.authorization)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != fiber.StatusOK {
+				if resp.Header.Get(fiber.HeaderWWWAuthenticate) == "" {
+					t.Errorf("no %s header", fiber.HeaderWWWAuthenticate)
+				}
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.wantScopes {
+				t.Errorf("scopes = %q, want %q", body, tt.wantScopes)
+			}
+		})
+	}
+}
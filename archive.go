@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// archiveYear is a year of /archive with the months it has posts in, newest
+// first
+type archiveYear struct {
+	Year   int
+	URL    string
+	Count  int
+	Months []archiveMonth
+}
+
+// archiveMonth is a month of /archive
+type archiveMonth struct {
+	// Date is the first day of the month, for templates to name it
+	Date  time.Time
+	URL   string
+	Count int
+}
+
+// registerArchiveRoutes serves /archive, the years and months with posts,
+// and the listing of each, like /archive/2024 and /archive/2024/03
+func (s *Server) registerArchiveRoutes() {
+	s.app.Get("/archive", s.handleArchive)
+	s.paginationRoutes("/archive/:year", s.handleArchivePeriod)
+	s.paginationRoutes("/archive/:year/:month", s.handleArchivePeriod)
+}
+
+// archivePath returns the listing of a year, or of a month of it when month
+// is not 0
+func archivePath(year, month int) string {
+	if month == 0 {
+		return fmt.Sprintf("/archive/%04d", year)
+	}
+	return fmt.Sprintf("/archive/%04d/%02d", year, month)
+}
+
+// archiveYears groups posts, newest first, by the year and month they were
+// published in loc
+func archiveYears(posts []*BlogPost, loc *time.Location) []archiveYear {
+	var years []archiveYear
+	for _, post := range posts {
+		date := post.Date.In(loc)
+		if len(years) == 0 || years[len(years)-1].Year != date.Year() {
+			years = append(years, archiveYear{Year: date.Year(), URL: archivePath(date.Year(), 0)})
+		}
+		year := &years[len(years)-1]
+		year.Count++
+		if n := len(year.Months); n == 0 || year.Months[n-1].Date.Month() != date.Month() {
+			year.Months = append(year.Months, archiveMonth{
+				Date: time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, loc),
+				URL:  archivePath(date.Year(), int(date.Month())),
+			})
+		}
+		year.Months[len(year.Months)-1].Count++
+	}
+	return years
+}
+
+// postsFrom returns the posts published in loc in year, or in a month of it
+// when month is not 0
+func postsFrom(posts []*BlogPost, loc *time.Location, year, month int) []*BlogPost {
+	var found []*BlogPost
+	for _, post := range posts {
+		date := post.Date.In(loc)
+		if date.Year() == year && (month == 0 || int(date.Month()) == month) {
+			found = append(found, post)
+		}
+	}
+	return found
+}
+
+// handleArchive lists the years and months with posts
+func (s *Server) handleArchive(c *fiber.Ctx) error {
+	posts, err := s.content.Posts()
+	if err != nil {
+		return s.internalError(c, "Error loading blog posts", err)
+	}
+	return renderView(c, "archive", ArchiveView{
+		PageView: s.pageView(c, s.t(c, "Archive")),
+		Years:    archiveYears(posts, s.cfg.Location()),
+	})
+}
+
+// handleArchivePeriod lists the posts of a year or a month with blog.html.
+// Only the zero-padded paths archivePath returns are served.
+func (s *Server) handleArchivePeriod(c *fiber.Ctx) error {
+	year, err := strconv.Atoi(c.Params("year"))
+	if err != nil {
+		return fiber.ErrNotFound
+	}
+	month := 0
+	if param := c.Params("month"); param != "" {
+		if month, err = strconv.Atoi(param); err != nil || month < 1 || month > 12 {
+			return fiber.ErrNotFound
+		}
+	}
+	base := archivePath(year, month)
+	requested := "/archive/" + c.Params("year")
+	if month != 0 {
+		requested += "/" + c.Params("month")
+	}
+	if requested != base {
+		return fiber.ErrNotFound
+	}
+
+	posts, err := s.content.Posts()
+	if err != nil {
+		return s.internalError(c, "Error loading blog posts", err)
+	}
+	loc := s.cfg.Location()
+	found := postsFrom(posts, loc, year, month)
+	if len(found) == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "No posts from then")
+	}
+	period := strconv.Itoa(year)
+	if month != 0 {
+		locale, _ := c.Locals("Locale").(string)
+		period = s.messages.FormatDate(locale, time.Date(year, time.Month(month), 1, 0, 0, 0, 0, loc), "January 2006")
+	}
+	list, err := s.listView(c, s.t(c, "Posts from %s", period), base, found)
+	if err != nil {
+		return err
+	}
+	return renderView(c, "blog", list)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestArchiveYears(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	post := func(slug, date string) *BlogPost {
+		d, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &BlogPost{Slug: slug, Date: d}
+	}
+	// Newest first, as the content index lists them
+	posts := []*BlogPost{
+		post("new-year", "2024-12-31T23:30:00Z"),
+		post("december", "2024-12-02T10:00:00Z"),
+		post("march", "2024-03-14T10:00:00Z"),
+		post("old", "2022-07-01T10:00:00Z"),
+	}
+
+	type month struct {
+		url   string
+		count int
+	}
+	type year struct {
+		year   int
+		count  int
+		months []month
+	}
+	tests := []struct {
+		name  string
+		posts []*BlogPost
+		loc   *time.Location
+		want  []year
+	}{
+		{"no posts", nil, time.UTC, nil},
+		{"UTC", posts, time.UTC, []year{
+			{2024, 3, []month{{"/archive/2024/12", 2}, {"/archive/2024/03", 1}}},
+			{2022, 1, []month{{"/archive/2022/07", 1}}},
+		}},
+		{"the site's time zone", posts, berlin, []year{
+			{2025, 1, []month{{"/archive/2025/01", 1}}},
+			{2024, 2, []month{{"/archive/2024/12", 1}, {"/archive/2024/03", 1}}},
+			{2022, 1, []month{{"/archive/2022/07", 1}}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []year
+			for _, y := range archiveYears(tt.posts, tt.loc) {
+				if y.URL != archivePath(y.Year, 0) {
+					t.Errorf("year %d links to %s", y.Year, y.URL)
+				}
+				entry := year{year: y.Year, count: y.Count}
+				for _, m := range y.Months {
+					entry.months = append(entry.months, month{m.URL, m.Count})
+				}
+				got = append(got, entry)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("archiveYears() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/js"
+)
+
+// AssetsConfig builds stylesheets and scripts from the sources in Dir into
+// bundles, compiling SCSS and minifying them on the way
+type AssetsConfig struct {
+	// Dir holds the sources, like scss/main.scss and js/menu.js
+	Dir string `yaml:"dir"`
+	// URL is the path the bundles are served under
+	URL string `yaml:"url"`
+	// Bundles maps each bundle, like site.css or site.js, to the sources it
+	// is made of, joined in order. A CSS bundle takes .css, .scss and .sass
+	// files and a JavaScript bundle .js files.
+	Bundles map[string][]string `yaml:"bundles"`
+	// Sass is the Dart Sass command SCSS is compiled with
+	Sass string `yaml:"sass"`
+	// Minify minifies the bundles outside development
+	Minify bool `yaml:"minify"`
+}
+
+func (c AssetsConfig) validate() error {
+	if len(c.Bundles) > 0 && !strings.HasPrefix(c.URL, "/") {
+		return fmt.Errorf("assets.url %q must be a path starting with /", c.URL)
+	}
+	for name, sources := range c.Bundles {
+		if path.Base(name) != name {
+			return fmt.Errorf("assets bundle %q must be a file name", name)
+		}
+		kinds := bundleSources[path.Ext(name)]
+		if kinds == nil {
+			return fmt.Errorf("assets bundle %q must be a .css or .js file", name)
+		}
+		if len(sources) == 0 {
+			return fmt.Errorf("assets bundle %q has no sources", name)
+		}
+		for _, src := range sources {
+			if !kinds[strings.ToLower(path.Ext(src))] {
+				return fmt.Errorf("assets bundle %q can't include %s", name, src)
+			}
+		}
+	}
+	return nil
+}
+
+// bundleSources are the source files each kind of bundle is made of
+var bundleSources = map[string]map[string]bool{
+	".css": {".css": true, ".scss": true, ".sass": true},
+	".js":  {".js": true},
+}
+
+// bundleTypes are the content types the bundles are served and minified as
+var bundleTypes = map[string]string{
+	".css": "text/css",
+	".js":  "application/javascript",
+}
+
+// bundleTimeout limits how long Sass may take to compile one source
+const bundleTimeout = time.Minute
+
+// assetBundle is a built bundle
+type assetBundle struct {
+	Name string
+	// File is the name the bundle is served as, with a hash of its content
+	// in production so that it can be cached forever
+	File    string
+	Content []byte
+}
+
+// buildBundles builds every bundle of the config
+func buildBundles(cfg *Config) (map[string]*assetBundle, error) {
+	bundles := make(map[string]*assetBundle, len(cfg.Assets.Bundles))
+	for name := range cfg.Assets.Bundles {
+		b, err := buildBundle(cfg, name)
+		if err != nil {
+			return nil, err
+		}
+		bundles[name] = b
+	}
+	return bundles, nil
+}
+
+// buildBundle compiles and joins the sources of a bundle. Outside
+// development it is minified and named with a fingerprint of its content,
+// like site.3f2a9c1d.css.
+func buildBundle(cfg *Config, name string) (*assetBundle, error) {
+	var out bytes.Buffer
+	for _, src := range cfg.Assets.Bundles[name] {
+		data, err := compileAsset(cfg.Assets, src)
+		if err != nil {
+			return nil, fmt.Errorf("error building %s: %v", name, err)
+		}
+		out.Write(data)
+		if !bytes.HasSuffix(data, []byte("\n")) {
+			out.WriteByte('\n')
+		}
+	}
+
+	b := &assetBundle{Name: name, File: name, Content: out.Bytes()}
+	if cfg.Development() {
+		return b, nil
+	}
+	if cfg.Assets.Minify {
+		m := minify.New()
+		m.AddFunc(bundleTypes[".css"], css.Minify)
+		m.AddFunc(bundleTypes[".js"], js.Minify)
+		minified, err := m.Bytes(bundleTypes[path.Ext(name)], b.Content)
+		if err != nil {
+			return nil, fmt.Errorf("error minifying %s: %v", name, err)
+		}
+		b.Content = minified
+	}
+	sum := sha256.Sum256(b.Content)
+	ext := path.Ext(name)
+	b.File = strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+	return b, nil
+}
+
+// compileAsset reads a source of a bundle, compiling SCSS and Sass to CSS
+func compileAsset(cfg AssetsConfig, src string) ([]byte, error) {
+	file := filepath.Join(cfg.Dir, filepath.FromSlash(src))
+	switch strings.ToLower(path.Ext(src)) {
+	case ".scss", ".sass":
+	default:
+		return os.ReadFile(file)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), bundleTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, cfg.Sass, "--no-source-map", "--load-path="+cfg.Dir, file)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", src, msg)
+		}
+		return nil, fmt.Errorf("%s: %s: %v", src, cfg.Sass, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// bundleURL returns the URL pages link a bundle with. This is the bundle
+// template function.
+func (s *Server) bundleURL(name string) (string, error) {
+	if _, ok := s.cfg.Assets.Bundles[name]; !ok {
+		return "", fmt.Errorf("bundle %q is not in assets.bundles", name)
+	}
+	file := name
+	if b := s.bundles[name]; b != nil {
+		file = b.File
+	}
+	return strings.TrimSuffix(s.cfg.Assets.URL, "/") + "/" + file, nil
+}
+
+// handleBundle serves a bundle. In development it is built again on every
+// request, so changed sources show up on reload; otherwise the bundles built
+// at startup are served with a year's max-age, as their name changes along
+// with their content.
+func (s *Server) handleBundle(c *fiber.Ctx) error {
+	file := c.Params("file")
+	var b *assetBundle
+	if s.cfg.Development() {
+		if _, ok := s.cfg.Assets.Bundles[file]; !ok {
+			return c.Next()
+		}
+		var err error
+		if b, err = buildBundle(s.cfg, file); err != nil {
+			return s.internalError(c, "Error building bundle", err)
+		}
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+	} else {
+		for _, built := range s.bundles {
+			if built.File == file {
+				b = built
+			}
+		}
+		if b == nil {
+			return c.Next()
+		}
+		c.Set(fiber.HeaderCacheControl, "public, max-age=31536000, immutable")
+	}
+	c.Set(fiber.HeaderContentType, bundleTypes[path.Ext(b.Name)]+"; charset=utf-8")
+	return c.Send(b.Content)
+}
+
+// writeBundles writes the bundles of a static build into the output
+// directory, unless the previous build already did, and records them in the
+// manifest so that old fingerprints are removed. It returns how many it
+// wrote.
+func writeBundles(cfg *Config, dir string, previous, current *buildManifest) (int, error) {
+	bundles, err := buildBundles(cfg)
+	if err != nil {
+		return 0, err
+	}
+	names := make([]string, 0, len(bundles))
+	for name := range bundles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	prefix := strings.Trim(cfg.Assets.URL, "/")
+	written := 0
+	for _, name := range names {
+		b := bundles[name]
+		rel := filepath.Join(prefix, b.File)
+		sum := sha256.Sum256(b.Content)
+		h := hex.EncodeToString(sum[:])
+		current.Assets[rel] = h
+		if previous.Assets[rel] == h && fileExists(filepath.Join(dir, rel)) {
+			continue
+		}
+		if err := writeOutputFile(dir, rel, b.Content); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AuditConfig sets where admin and API changes are recorded
+type AuditConfig struct {
+	// File is an append-only log with one JSON entry per line; empty
+	// disables the audit log
+	File string `yaml:"file"`
+}
+
+// AuditEntry records who changed what, when and from where
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	IP        string    `json:"ip"`
+	Host      string    `json:"host"`
+	Action    string    `json:"action"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	RequestID string    `json:"request_id"`
+}
+
+// auditLog appends entries to the audit file. Entries are never changed or
+// removed by DevDaze.
+type auditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// newAuditLog returns the audit log of cfg, or nil when it is disabled
+func newAuditLog(cfg *Config) *auditLog {
+	if cfg.Audit.File == "" {
+		return nil
+	}
+	return &auditLog{path: cfg.Audit.File}
+}
+
+// Append writes entry to the end of the log
+func (l *auditLog) Append(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Recent returns up to n of the latest entries, newest first
+func (l *auditLog) Recent(n int) ([]AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		entries = append(entries, entry)
+		if len(entries) > n {
+			entries = entries[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// audit records a change made by the request's editor. The change itself
+// has succeeded, so failures are only logged.
+func (s *Server) audit(c *fiber.Ctx, format string, args ...interface{}) {
+	s.auditAs(c, editor(c), format, args...)
+}
+
+// auditAs records a change made by actor, for requests where the editor is
+// not signed in yet or is not a user
+func (s *Server) auditAs(c *fiber.Ctx, actor, format string, args ...interface{}) {
+	if s.auditLog == nil {
+		return
+	}
+	entry := AuditEntry{
+		Time:      time.Now(),
+		Actor:     actor,
+		IP:        c.IP(),
+		Host:      c.Hostname(),
+		Action:    fmt.Sprintf(format, args...),
+		Method:    c.Method(),
+		Path:      c.Path(),
+		RequestID: requestID(c),
+	}
+	if err := s.auditLog.Append(entry); err != nil {
+		requestLogger(c).Error("Error writing audit log", "file", s.auditLog.path, "action", entry.Action, "error", err)
+	}
+}
+
+// recentAudit returns the latest audit entries for the admin pages
+func (s *Server) recentAudit(c *fiber.Ctx, n int) []AuditEntry {
+	if s.auditLog == nil {
+		return nil
+	}
+	entries, err := s.auditLog.Recent(n)
+	if err != nil {
+		requestLogger(c).Error("Error reading audit log", "file", s.auditLog.path, "error", err)
+	}
+	return entries
+}
+
+// handleAuditLog shows the latest entries of the audit log
+func (s *Server) handleAuditLog(c *fiber.Ctx) error {
+	return c.Render("admin_audit", fiber.Map{
+		"Title":   "Audit log",
+		"Enabled": s.auditLog != nil,
+		"Entries": s.recentAudit(c, 500),
+	})
+}
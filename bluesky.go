@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// BlueskyConfig announces published posts on a Bluesky account
+type BlueskyConfig struct {
+	// Handle is the account, like me.bsky.social
+	Handle string `yaml:"handle"`
+	// Password is an app password from Settings → Privacy and security → App
+	// passwords, never the account's own password
+	Password string `yaml:"password"`
+	// Server is the account's PDS, https://bsky.social by default
+	Server string `yaml:"server"`
+	// Message is the text/template of the post, with the same fields as
+	// Mastodon's. The link to the blog post is its card.
+	Message string `yaml:"message"`
+}
+
+func (c BlueskyConfig) validate() error {
+	if c.Password == "" {
+		return nil
+	}
+	if c.Handle == "" {
+		return fmt.Errorf("syndication.bluesky needs handle, the account to post as")
+	}
+	if !absoluteHTTPURL(c.Server) {
+		return fmt.Errorf("syndication.bluesky.server %q is not an absolute http or https URL", c.Server)
+	}
+	if _, err := template.New("bluesky").Parse(c.Message); err != nil {
+		return fmt.Errorf("syndication.bluesky.message: %v", err)
+	}
+	return nil
+}
+
+const (
+	// blueskyMaxLength is the most characters a Bluesky post may have
+	blueskyMaxLength = 300
+	// blueskyMaxThumb is the largest image a link card may have
+	blueskyMaxThumb = 1000000
+)
+
+// blueskySession is the part of a session the blog needs to post
+type blueskySession struct {
+	AccessJwt string `json:"accessJwt"`
+	DID       string `json:"did"`
+	Handle    string `json:"handle"`
+}
+
+// publish posts the message with a link card of the post, made from its Open
+// Graph data, and returns the post's URL on bsky.app
+func (c BlueskyConfig) publish(ctx context.Context, article syndicatedArticle) (string, error) {
+	server := strings.TrimSuffix(c.Server, "/")
+	var session blueskySession
+	err := postJSON(ctx, server+"/xrpc/com.atproto.server.createSession", nil,
+		map[string]string{"identifier": c.Handle, "password": c.Password}, &session)
+	if err != nil {
+		return "", err
+	}
+
+	text, err := renderStatus(c.Message, article)
+	if err != nil {
+		return "", err
+	}
+	if over := utf8.RuneCountInString(text) - blueskyMaxLength; over > 0 && article.Excerpt != "" {
+		// Shorten the excerpt before cutting the end of the post, which is
+		// where the hashtags usually are
+		article.Excerpt = truncateWords(article.Excerpt, utf8.RuneCountInString(article.Excerpt)-over)
+		if text, err = renderStatus(c.Message, article); err != nil {
+			return "", err
+		}
+	}
+	text = truncateWords(text, blueskyMaxLength)
+
+	external := map[string]interface{}{
+		"uri":         article.URL,
+		"title":       article.Title,
+		"description": article.Excerpt,
+	}
+	if article.Image != "" {
+		thumb, err := c.uploadThumb(ctx, session, article)
+		if err != nil {
+			slog.Warn("Error uploading link card image", "image", article.Image, "error", err)
+		} else if thumb != nil {
+			external["thumb"] = thumb
+		}
+	}
+	record := map[string]interface{}{
+		"$type":     "app.bsky.feed.post",
+		"text":      text,
+		"createdAt": time.Now().UTC().Format(time.RFC3339),
+		"embed":     map[string]interface{}{"$type": "app.bsky.embed.external", "external": external},
+	}
+	if facets := blueskyFacets(text); len(facets) > 0 {
+		record["facets"] = facets
+	}
+
+	var created struct {
+		URI string `json:"uri"`
+	}
+	err = postJSON(ctx, server+"/xrpc/com.atproto.repo.createRecord",
+		map[string]string{"Authorization": "Bearer " + session.AccessJwt},
+		map[string]interface{}{"repo": session.DID, "collection": "app.bsky.feed.post", "record": record}, &created)
+	if err != nil {
+		return "", err
+	}
+	if created.URI == "" {
+		return "", fmt.Errorf("bluesky returned no URI")
+	}
+	// at://did:plc:…/app.bsky.feed.post/<rkey>
+	return "https://bsky.app/profile/" + session.Handle + "/post/" + path.Base(created.URI), nil
+}
+
+// uploadThumb uploads the post's image for its link card and returns the
+// blob, or nil when the image is too large for a card
+func (c BlueskyConfig) uploadThumb(ctx context.Context, session blueskySession, article syndicatedArticle) (json.RawMessage, error) {
+	var data []byte
+	var err error
+	if article.ImageFile != "" {
+		data, err = os.ReadFile(article.ImageFile)
+	} else {
+		data, err = downloadImage(ctx, article.Image)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > blueskyMaxThumb {
+		return nil, nil
+	}
+	kind := http.DetectContentType(data)
+	if !strings.HasPrefix(kind, "image/") {
+		return nil, fmt.Errorf("%s is %s, not an image", article.Image, kind)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.Server, "/")+"/xrpc/com.atproto.repo.uploadBlob", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", kind)
+	req.Header.Set("Authorization", "Bearer "+session.AccessJwt)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("uploadBlob returned %s", resp.Status)
+	}
+	var uploaded struct {
+		Blob json.RawMessage `json:"blob"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&uploaded); err != nil {
+		return nil, err
+	}
+	return uploaded.Blob, nil
+}
+
+// downloadImage fetches an image, reading at most a byte more than a link
+// card may have
+func downloadImage(ctx context.Context, src string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", src, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, blueskyMaxThumb+1))
+}
+
+var (
+	// blueskyLink matches the URLs in a post
+	blueskyLink = regexp.MustCompile(`https?://[^\s]+[^\s.,;:!?)]`)
+	// blueskyHashtag matches the hashtags in a post
+	blueskyHashtag = regexp.MustCompile(`(?:^|\s)(#[\p{L}\p{N}_]+)`)
+)
+
+// blueskyFacets marks the links and hashtags in text, which Bluesky doesn't
+// find on its own. Facets are indexed by bytes of UTF-8.
+func blueskyFacets(text string) []map[string]interface{} {
+	facet := func(start, end int, feature map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"index":    map[string]int{"byteStart": start, "byteEnd": end},
+			"features": []map[string]interface{}{feature},
+		}
+	}
+	var facets []map[string]interface{}
+	for _, m := range blueskyLink.FindAllStringIndex(text, -1) {
+		facets = append(facets, facet(m[0], m[1], map[string]interface{}{"$type": "app.bsky.richtext.facet#link", "uri": text[m[0]:m[1]]}))
+	}
+	for _, m := range blueskyHashtag.FindAllStringSubmatchIndex(text, -1) {
+		facets = append(facets, facet(m[2], m[3], map[string]interface{}{"$type": "app.bsky.richtext.facet#tag", "tag": text[m[2]+1 : m[3]]}))
+	}
+	return facets
+}
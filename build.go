@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// manifestFile records the inputs of the last build inside the output directory
+const manifestFile = ".devdaze-manifest.json"
+
+// BuildOptions controls a static export of the site
+type BuildOptions struct {
+	OutputDir string
+	// Clean discards the manifest and re-renders every page
+	Clean bool
+}
+
+// buildManifest maps each output file to a hash of the inputs it was built from
+type buildManifest struct {
+	Pages  map[string]string `json:"pages"`
+	Assets map[string]string `json:"assets"`
+	// Fingerprints maps the public files pages link with asset to the
+	// fingerprinted copies written for them
+	Fingerprints map[string]string `json:"fingerprints"`
+}
+
+// sitePage is a single route of the static site and the posts it depends on
+type sitePage struct {
+	Route string
+	Posts []*BlogPost
+	// Redirect is the path an alias page sends browsers to
+	Redirect string
+	// Data is written as JSON instead of rendering the route
+	Data interface{}
+}
+
+// buildSite renders every route of the site into static files under the
+// output directory and copies the public assets alongside them. Pages whose
+// post files, templates and config are unchanged since the last build are
+// skipped, using the hashes stored in the build manifest.
+func buildSite(out io.Writer, cfg *Config, opts BuildOptions) error {
+	if err := checkOutputDir(cfg, opts.OutputDir); err != nil {
+		return err
+	}
+
+	if opts.Clean {
+		if err := os.RemoveAll(opts.OutputDir); err != nil {
+			return err
+		}
+	}
+
+	if _, err := syncContentSource(context.Background(), cfg); err != nil {
+		return err
+	}
+
+	previous := loadManifest(opts.OutputDir)
+	current := &buildManifest{Pages: map[string]string{}, Assets: map[string]string{}, Fingerprints: map[string]string{}}
+
+	// Generate image variants first so they are exported with the media
+	generated, err := newImagePipeline(cfg).ProcessAll()
+	if err != nil {
+		return fmt.Errorf("error processing media: %v", err)
+	}
+	if generated > 0 {
+		fmt.Fprintf(out, "Generated %d image variants\n", generated)
+	}
+
+	copied := 0
+	for _, dir := range cfg.PublicDirs() {
+		n, err := copyAssets(dir, opts.OutputDir, "", previous, current)
+		if err != nil {
+			return fmt.Errorf("error copying public assets: %v", err)
+		}
+		copied += n
+	}
+	// Pages link public files by a hash of their content
+	publicHash := hashManifestAssets(current)
+	if !mediaInsidePublic(cfg) {
+		n, err := copyAssets(cfg.Media.Dir, opts.OutputDir, strings.Trim(cfg.Media.URL, "/"), previous, current)
+		if err != nil {
+			return fmt.Errorf("error copying media: %v", err)
+		}
+		copied += n
+	}
+	if cfg.Obsidian.Enabled {
+		n, err := copyVaultFiles(cfg, opts.OutputDir, previous, current)
+		if err != nil {
+			return err
+		}
+		copied += n
+	}
+
+	// Static exports never include development behaviour, and the content
+	// was synced above
+	buildCfg := *cfg
+	buildCfg.Env = EnvProduction
+	buildCfg.Source = ContentSourceConfig{}
+	// Static hosts send their own headers, so pages carry no nonce a
+	// policy of theirs would not know
+	buildCfg.SecurityHeaders.ContentSecurityPolicy = ""
+	n, err := writeBundles(&buildCfg, opts.OutputDir, previous, current)
+	if err != nil {
+		return err
+	}
+	copied += n
+	if n, err = writeIcons(&buildCfg, opts.OutputDir, previous, current); err != nil {
+		return err
+	}
+	copied += n
+
+	posts, translations, err := publishedPosts(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Every page depends on the templates and the config
+	sharedHash, err := hashSharedInputs(cfg)
+	if err != nil {
+		return err
+	}
+	sharedHash += publicHash
+
+	postHashes := make(map[*BlogPost]string, len(posts)+len(translations))
+	for _, post := range append(posts[:len(posts):len(posts)], translations...) {
+		h, err := hashFile(post.FilePath)
+		if err != nil {
+			return err
+		}
+		postHashes[post] = h
+	}
+
+	// The server is only created once a page actually needs rendering
+	var srv *Server
+	rendered := 0
+	for _, page := range sitePages(posts, translations, cfg.I18n, cfg.PostsPerPage, cfg.Location()) {
+		name := outputPath(page.Route)
+
+		h := sha256.New()
+		io.WriteString(h, sharedHash)
+		for _, post := range page.Posts {
+			io.WriteString(h, postHashes[post])
+		}
+		inputHash := hex.EncodeToString(h.Sum(nil))
+		current.Pages[name] = inputHash
+
+		if previous.Pages[name] == inputHash && fileExists(filepath.Join(opts.OutputDir, name)) {
+			continue
+		}
+
+		if page.Redirect != "" {
+			if err := writeOutputFile(opts.OutputDir, name, redirectPage(page.Redirect)); err != nil {
+				return err
+			}
+			rendered++
+			continue
+		}
+		if page.Data != nil {
+			body, err := json.Marshal(page.Data)
+			if err != nil {
+				return fmt.Errorf("error encoding %s: %v", page.Route, err)
+			}
+			if err := writeOutputFile(opts.OutputDir, name, body); err != nil {
+				return err
+			}
+			rendered++
+			continue
+		}
+		if srv == nil {
+			srv = newServer(&buildCfg, nopReporter{})
+		}
+		body, err := renderRoute(srv.app, page.Route)
+		if err != nil {
+			return err
+		}
+		if err := writeOutputFile(opts.OutputDir, name, body); err != nil {
+			return err
+		}
+		rendered++
+	}
+
+	linked := map[string]string{}
+	if srv != nil {
+		linked = srv.assets.Linked()
+	}
+	n, err = writeFingerprinted(opts.OutputDir, linked, previous, current)
+	if err != nil {
+		return err
+	}
+	copied += n
+
+	// Drop pages and assets that no longer exist
+	for name := range previous.Pages {
+		if _, ok := current.Pages[name]; !ok {
+			os.Remove(filepath.Join(opts.OutputDir, name))
+		}
+	}
+	for name := range previous.Assets {
+		if _, ok := current.Assets[name]; !ok {
+			os.Remove(filepath.Join(opts.OutputDir, name))
+		}
+	}
+
+	if err := saveManifest(opts.OutputDir, current); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Built %d posts into %s (%d pages rendered, %d unchanged, %d assets copied)\n",
+		len(posts), opts.OutputDir, rendered, len(current.Pages)-rendered, copied)
+	return nil
+}
+
+// publishedPosts loads the posts and translations that are part of the
+// published site, leaving out drafts and scheduled posts
+func publishedPosts(cfg *Config) ([]*BlogPost, []*BlogPost, error) {
+	all, err := readPosts(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading blog posts: %v", err)
+	}
+
+	allTranslations, err := getTranslatedPosts(cfg.ContentDir, cfg.I18n.Languages, cfg.Location())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading translations: %v", err)
+	}
+
+	var posts []*BlogPost
+	published := make(map[string]bool)
+	for _, post := range all {
+		if !post.Draft && !post.Scheduled() {
+			posts = append(posts, post)
+			published[post.Slug] = true
+		}
+	}
+	var translations []*BlogPost
+	for _, t := range linkTranslations(all, allTranslations, cfg.I18n.Languages) {
+		if published[t.Slug] && !t.Draft && !t.Scheduled() {
+			translations = append(translations, t)
+		}
+	}
+	if cfg.Obsidian.Enabled {
+		newVaultIndex(cfg.ContentDir, cfg.Obsidian.URL, posts).render(append(posts[:len(posts):len(posts)], translations...))
+	}
+	return posts, translations, nil
+}
+
+// sitePages lists every route that makes up the static site. The page of a
+// post lists its translations too, as it links to them, and every language
+// has every post, in the default language where it is not translated.
+// Listings split into pages of perPage posts get a route for each page, and
+// the archive has a listing for every year and month with posts in loc.
+// Aliases become pages that redirect to their post, and the content API's
+// listing and posts become JSON files under /api/posts.
+func sitePages(posts, translations []*BlogPost, i18n I18nConfig, perPage int, loc *time.Location) []sitePage {
+	languages := i18n.Languages
+	everything := append(posts[:len(posts):len(posts)], translations...)
+	pages := append(listingPages("/", len(posts), perPage, posts), listingPages("/blog", len(posts), perPage, posts)...)
+	pages = append(pages, []sitePage{
+		{Route: "/feed.rss", Posts: posts},
+		{Route: "/feed.atom", Posts: posts},
+		{Route: "/sitemap.xml"},
+		{Route: "/sitemap-" + i18n.DefaultLanguage + ".xml", Posts: everything},
+	}...)
+	versions := make(map[string][]*BlogPost)
+	byLang := make(map[string][]*BlogPost)
+	for _, t := range translations {
+		versions[t.Slug] = append(versions[t.Slug], t)
+		byLang[t.Lang] = append(byLang[t.Lang], t)
+	}
+	for _, post := range posts {
+		group := append([]*BlogPost{post}, versions[post.Slug]...)
+		pages = append(pages, sitePage{Route: "/blog/" + post.Slug, Posts: group})
+		for _, lang := range languages {
+			pages = append(pages, sitePage{Route: languagePrefix(lang) + "/blog/" + post.Slug, Posts: group})
+		}
+	}
+	for _, lang := range languages {
+		list := append(posts[:len(posts):len(posts)], byLang[lang]...)
+		pages = append(pages, listingPages(languagePrefix(lang)+"/blog", len(posts), perPage, list)...)
+		pages = append(pages,
+			sitePage{Route: languagePrefix(lang) + "/feed.rss", Posts: byLang[lang]},
+			sitePage{Route: languagePrefix(lang) + "/feed.atom", Posts: byLang[lang]},
+			sitePage{Route: "/sitemap-" + lang + ".xml", Posts: everything})
+	}
+	for _, slug := range tagSlugs(posts) {
+		_, tagged := postsTagged(posts, slug)
+		pages = append(pages, listingPages("/tags/"+slug, len(tagged), perPage, tagged)...)
+	}
+	pages = append(pages, sitePage{Route: "/archive", Posts: posts})
+	for _, year := range archiveYears(posts, loc) {
+		inYear := postsFrom(posts, loc, year.Year, 0)
+		pages = append(pages, listingPages(year.URL, len(inYear), perPage, inYear)...)
+		for _, month := range year.Months {
+			inMonth := postsFrom(posts, loc, year.Year, int(month.Date.Month()))
+			pages = append(pages, listingPages(month.URL, len(inMonth), perPage, inMonth)...)
+		}
+	}
+	pages = append(pages, apiPages(posts)...)
+	routes := make(map[string]bool, len(pages))
+	for _, page := range pages {
+		routes[page.Route] = true
+	}
+	for _, post := range everything {
+		for _, alias := range post.Aliases {
+			if route := aliasPath(alias); !routes[route] {
+				routes[route] = true
+				pages = append(pages, sitePage{Route: route, Posts: []*BlogPost{post}, Redirect: postPath(post)})
+			}
+		}
+	}
+	return pages
+}
+
+// listingPages returns every page of a listing of total posts. Each depends
+// on all of posts, as a change to one moves the others between pages.
+func listingPages(base string, total, perPage int, posts []*BlogPost) []sitePage {
+	p := newPaginator(base, total, perPage, 1)
+	pages := make([]sitePage, 0, p.Pages)
+	for n := 1; n <= p.Pages; n++ {
+		pages = append(pages, sitePage{Route: p.URL(n), Posts: posts})
+	}
+	return pages
+}
+
+// apiPages exports the published posts as the content API serves them:
+// /api/posts/index.json like GET /api/posts without paging, and
+// /api/posts/<slug>.json like GET /api/posts/<slug>
+func apiPages(posts []*BlogPost) []sitePage {
+	list := make([]APIPost, 0, len(posts))
+	pages := []sitePage{{Route: "/api/posts/index.json", Posts: posts}}
+	for _, post := range posts {
+		list = append(list, apiPost(post, false))
+		pages = append(pages, sitePage{Route: "/api/posts/" + post.Slug + ".json", Posts: []*BlogPost{post}, Data: apiPost(post, true)})
+	}
+	pages[0].Data = fiber.Map{"posts": list, "total": len(list)}
+	return pages
+}
+
+// renderRoute runs a GET request for route through the app and returns the body
+func renderRoute(app *fiber.App, route string) ([]byte, error) {
+	req := httptest.NewRequest("GET", route, nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering %s: %v", route, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering %s: %v", route, err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("error rendering %s: status %d: %s", route, resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// outputPath maps a route to the file it is written to, so that /blog/foo
+// becomes blog/foo/index.html and is served by any static host
+func outputPath(route string) string {
+	if filepath.Ext(route) != "" {
+		return strings.TrimPrefix(route, "/")
+	}
+	return filepath.Join(strings.TrimPrefix(route, "/"), "index.html")
+}
+
+// writeOutputFile writes data to name inside dir, creating parent directories
+func writeOutputFile(dir, name string, data []byte) error {
+	target := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(target, data, 0644)
+}
+
+// checkOutputDir refuses output directories that would overwrite the project
+func checkOutputDir(cfg *Config, dir string) error {
+	target, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	protected := []string{cwd, cfg.ContentDir, cfg.TemplateDir, cfg.PublicDir, cfg.Theme.Dir, cfg.Theme.Override, cfg.Assets.Dir}
+	for _, p := range protected {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(target, abs); err == nil && !strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("refusing to build into %s: it contains %s", dir, p)
+		}
+	}
+	return nil
+}
+
+// copyAssets copies an asset directory into prefix inside the output
+// directory, skipping files whose hash matches the previous build and files
+// a theme layer copied before, and returns how many it copied
+func copyAssets(src, dst, prefix string, previous, current *buildManifest) (int, error) {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	copied := 0
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.Join(prefix, rel)
+		if _, ok := current.Assets[rel]; ok {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		h := hex.EncodeToString(sum[:])
+		current.Assets[rel] = h
+		if previous.Assets[rel] == h && fileExists(filepath.Join(dst, rel)) {
+			return nil
+		}
+
+		copied++
+		return writeOutputFile(dst, rel, data)
+	})
+	return copied, err
+}
+
+// hashSharedInputs hashes the inputs every page depends on: the templates,
+// the asset sources whose bundles pages link by fingerprint, the config and
+// the build info shown in the footer
+func hashSharedInputs(cfg *Config) (string, error) {
+	h := sha256.New()
+
+	info := currentBuildInfo()
+	io.WriteString(h, info.Version+info.Commit)
+
+	configData, err := yaml.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	h.Write(configData)
+
+	var files []string
+	dirs := cfg.TemplateDirs()
+	if len(cfg.Assets.Bundles) > 0 {
+		dirs = append(dirs, cfg.Assets.Dir)
+	}
+	for _, dir := range dirs {
+		err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			files = append(files, path)
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("error reading templates: %v", err)
+		}
+	}
+
+	sort.Strings(files)
+	for _, file := range files {
+		fh, err := hashFile(file)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, file+fh)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashManifestAssets hashes the assets recorded in a manifest so far
+func hashManifestAssets(m *buildManifest) string {
+	names := make([]string, 0, len(m.Assets))
+	for name := range m.Assets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		io.WriteString(h, name+m.Assets[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFile returns the hex encoded SHA-256 of a file's contents
+func hashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// loadManifest reads the manifest of the previous build, returning an empty
+// one if there is none
+func loadManifest(dir string) *buildManifest {
+	m := &buildManifest{Pages: map[string]string{}, Assets: map[string]string{}}
+
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return m
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return &buildManifest{Pages: map[string]string{}, Assets: map[string]string{}}
+	}
+	if m.Pages == nil {
+		m.Pages = map[string]string{}
+	}
+	if m.Assets == nil {
+		m.Assets = map[string]string{}
+	}
+	return m
+}
+
+// saveManifest writes the manifest for the current build
+func saveManifest(dir string, m *buildManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeOutputFile(dir, manifestFile, data)
+}
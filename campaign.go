@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/net/html"
+)
+
+// campaignFeedSize is the number of latest posts the campaign feed lists
+const campaignFeedSize = 20
+
+// requireCampaignToken checks for a token with the read scope, which may
+// also come as ?token= since RSS campaign services only take a feed URL
+func (s *Server) requireCampaignToken(c *fiber.Ctx) error {
+	if token := c.Query("token"); token != "" && c.Get(fiber.HeaderAuthorization) == "" {
+		c.Request().Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+	return s.requireAPIToken(ScopeRead)(c)
+}
+
+// handleCampaignFeed serves the latest posts as an RSS feed for the RSS
+// campaigns of Buttondown, Mailchimp and similar services: every item has
+// the full content with absolute links and images that display in email
+func (s *Server) handleCampaignFeed(c *fiber.Ctx) error {
+	posts, err := s.content.Posts()
+	if err != nil {
+		return s.internalError(c, "Error loading blog posts", err)
+	}
+	var visible []*BlogPost
+	for _, post := range posts {
+		if apiVisible(post) {
+			visible = append(visible, post)
+		}
+	}
+	sort.Slice(visible, func(i, j int) bool { return visible[i].Date.After(visible[j].Date) })
+	if len(visible) > campaignFeedSize {
+		visible = visible[:campaignFeedSize]
+	}
+
+	channel := rssChannel{
+		Title:       s.cfg.Title,
+		Link:        s.absoluteURL(c, "/"),
+		Description: "The latest posts of " + s.cfg.Title,
+	}
+	for _, post := range visible {
+		link := s.absoluteURL(c, "/blog/"+post.Slug)
+		base, err := url.Parse(link)
+		if err != nil {
+			return s.internalError(c, "Error building feed", err)
+		}
+		channel.Items = append(channel.Items, rssItem{
+			Title:       post.Title,
+			Link:        link,
+			GUID:        link,
+			Creator:     post.Author,
+			PubDate:     post.Date.In(s.cfg.Location()).Format(time.RFC1123Z),
+			Description: post.Description,
+			Content:     emailHTML(s.images.Rewrite(post.HTMLContent), base),
+		})
+	}
+
+	body, err := xml.MarshalIndent(rssFeed{
+		Version: "2.0",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Content: "http://purl.org/rss/1.0/modules/content/",
+		Channel: channel,
+	}, "", "  ")
+	if err != nil {
+		return s.internalError(c, "Error encoding feed", err)
+	}
+	c.Set(fiber.HeaderContentType, "application/rss+xml; charset=utf-8")
+	// The feed carries a secret in its URL, caches should not keep it
+	c.Set(fiber.HeaderCacheControl, "private, no-store")
+	return c.Send(append([]byte(xml.Header), body...))
+}
+
+// emailHTML prepares post content for email: links and images are made
+// absolute, and responsive images become a single image scaled to fit,
+// since mail clients ignore srcset, sizes and picture sources
+func emailHTML(content string, base *url.URL) string {
+	z := html.NewTokenizer(strings.NewReader(absolutizeHTML(content, base)))
+	var b strings.Builder
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return b.String()
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken && tt != html.EndTagToken {
+			b.Write(z.Raw())
+			continue
+		}
+		tok := z.Token()
+		switch tok.Data {
+		case "picture", "source":
+			continue
+		case "img":
+			if tt == html.EndTagToken {
+				continue
+			}
+			attrs := tok.Attr[:0]
+			for _, a := range tok.Attr {
+				switch a.Key {
+				case "srcset", "sizes", "loading", "decoding", "style":
+				default:
+					attrs = append(attrs, a)
+				}
+			}
+			tok.Attr = append(attrs, html.Attribute{Key: "style", Val: "max-width: 100%; height: auto;"})
+		}
+		b.WriteString(tok.String())
+	}
+}
+
+// handleSubscriberExport downloads the confirmed subscribers as CSV, to
+// import them into an external newsletter service
+func (s *Server) handleSubscriberExport(c *fiber.Ctx) error {
+	if s.newsletter == nil {
+		return fiber.ErrNotFound
+	}
+	subs, err := s.newsletter.Subscribers()
+	if err != nil {
+		return s.internalError(c, "Error loading subscribers", err)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="subscribers.csv"`)
+	w := csv.NewWriter(c)
+	w.Write([]string{"email", "subscribed_at"})
+	n := 0
+	for _, sub := range subs {
+		if sub.Status == SubscriberConfirmed {
+			w.Write([]string{sub.Email, sub.CreatedAt.UTC().Format(time.RFC3339)})
+			n++
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return s.internalError(c, "Error exporting subscribers", err)
+	}
+	s.audit(c, "Export %d newsletter subscribers", n)
+	return nil
+}
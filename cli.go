@@ -0,0 +1,355 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// newRootCmd builds the devdaze command tree
+func newRootCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:          "devdaze",
+		Short:        "A small markdown blog engine",
+		Version:      currentBuildInfo().Version,
+		SilenceUsage: true,
+	}
+
+	root.PersistentFlags().String("config", "", "path to the config file (default devdaze.yaml)")
+	root.PersistentFlags().String("content-dir", "", "directory containing markdown posts")
+	root.PersistentFlags().String("env", "", "runtime mode: development or production")
+	root.PersistentFlags().String("log-level", "", "log level: debug, info, warn or error")
+	root.PersistentFlags().String("log-format", "", "log format: text or json")
+
+	root.AddCommand(
+		newServeCmd(),
+		newBuildCmd(),
+		newDeployCmd(),
+		newNewCmd(),
+		newValidateCmd(),
+		newAuditCmd(),
+		newImportCmd(),
+		newExportCmd(),
+		newNotionCmd(),
+		newTokenCmd(),
+		newCommentsCmd(),
+		newThemeCmd(),
+	)
+
+	return root
+}
+
+// newServeCmd runs the blog as an HTTP server
+func newServeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the blog over HTTP",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serve(func() (*Config, error) {
+				return loadCommandConfig(cmd)
+			})
+		},
+	}
+
+	cmd.Flags().String("port", "", "port to listen on")
+	cmd.Flags().String("listen", "", "listen on host:port, unix:/path/to.sock, fd:N or systemd instead of --port")
+	cmd.Flags().String("template-dir", "", "directory containing HTML templates")
+	cmd.Flags().String("public-dir", "", "directory containing static assets")
+	cmd.Flags().Bool("headless", false, "serve only the APIs, feeds and media, without HTML pages")
+
+	return cmd
+}
+
+// newBuildCmd exports the blog as static files
+func newBuildCmd() *cobra.Command {
+	var opts BuildOptions
+
+	cmd := &cobra.Command{
+		Use:   "build",
+		Short: "Render the blog to static files",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCommandConfig(cmd)
+			if err != nil {
+				return err
+			}
+			return buildSite(cmd.OutOrStdout(), cfg, opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.OutputDir, "output", "o", "./dist", "directory to write the static site to")
+	cmd.Flags().BoolVar(&opts.Clean, "clean", false, "clear the output directory and re-render every page")
+	cmd.Flags().String("template-dir", "", "directory containing HTML templates")
+	cmd.Flags().String("public-dir", "", "directory containing static assets")
+
+	return cmd
+}
+
+// newDeployCmd publishes a static build to a hosting provider
+func newDeployCmd() *cobra.Command {
+	var opts DeployOptions
+
+	cmd := &cobra.Command{
+		Use:   "deploy",
+		Short: "Publish the static build to S3, Netlify or GitHub Pages",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCommandConfig(cmd)
+			if err != nil {
+				return err
+			}
+			return deploySite(cmd.Context(), cmd.OutOrStdout(), cfg, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Target, "target", "", "deploy target: s3, netlify or github (default from config)")
+	cmd.Flags().StringVar(&opts.Dir, "dir", "", "directory containing the built site (default ./dist)")
+	cmd.Flags().BoolVar(&opts.Invalidate, "invalidate", false, "invalidate the CloudFront distribution after an S3 deploy")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "show what would be deployed without uploading")
+
+	return cmd
+}
+
+// newNewCmd groups the content scaffolding commands
+func newNewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "new",
+		Short: "Create new content",
+	}
+
+	cmd.AddCommand(newNewPostCmd())
+
+	return cmd
+}
+
+// newNewPostCmd scaffolds a markdown file with pre-filled frontmatter
+func newNewPostCmd() *cobra.Command {
+	var opts NewPostOptions
+	var date string
+
+	cmd := &cobra.Command{
+		Use:   "post <title>",
+		Short: "Create a new blog post",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCommandConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			opts.Title = args[0]
+			if date != "" {
+				opts.Date, err = parseDateFlag(date, cfg.Location())
+				if err != nil {
+					return fmt.Errorf("invalid --date %q: %v", date, err)
+				}
+			}
+
+			return newPost(cmd.OutOrStdout(), cfg, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Slug, "slug", "", "slug and filename (default derived from the title)")
+	cmd.Flags().StringVar(&date, "date", "", "publish date as YYYY-MM-DD or RFC 3339 (default now)")
+	cmd.Flags().StringVar(&opts.Author, "author", "", "post author (default from config)")
+	cmd.Flags().StringVar(&opts.Description, "description", "", "short description of the post")
+	cmd.Flags().StringSliceVar(&opts.Tags, "tags", nil, "comma separated list of tags")
+	cmd.Flags().BoolVar(&opts.Draft, "draft", false, "mark the post as a draft, hidden outside development")
+	cmd.Flags().StringVar(&opts.Template, "template", "", "text/template file used for the new post (default from config)")
+
+	return cmd
+}
+
+// newValidateCmd checks the content tree for errors
+func newValidateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate",
+		Short: "Lint all posts for frontmatter errors and duplicate slugs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCommandConfig(cmd)
+			if err != nil {
+				return err
+			}
+			return validateContent(cmd.OutOrStdout(), cfg)
+		},
+	}
+}
+
+// newAuditCmd checks the rendered site for accessibility issues
+func newAuditCmd() *cobra.Command {
+	var opts AccessibilityOptions
+
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Check the rendered site for accessibility issues",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCommandConfig(cmd)
+			if err != nil {
+				return err
+			}
+			return auditSite(cmd.OutOrStdout(), cfg, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Dir, "dir", "", "audit the HTML files of a static build, like ./dist")
+	cmd.Flags().StringVar(&opts.URL, "url", "", "crawl a running site from this URL")
+	cmd.Flags().IntVar(&opts.MaxIssues, "max-issues", 0, "number of issues tolerated before the audit fails")
+	cmd.Flags().String("template-dir", "", "directory containing HTML templates")
+	cmd.Flags().String("public-dir", "", "directory containing static assets")
+
+	return cmd
+}
+
+// newImportCmd converts the posts of another blog engine
+func newImportCmd() *cobra.Command {
+	var opts ImportOptions
+
+	cmd := &cobra.Command{
+		Use:       "import <jekyll|hugo|wordpress|ghost|medium> <dir or export file>",
+		Short:     "Import the posts of a Jekyll, Hugo, WordPress, Ghost or Medium site",
+		Args:      cobra.ExactArgs(2),
+		ValidArgs: []string{"jekyll", "hugo", "wordpress", "ghost", "medium"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCommandConfig(cmd)
+			if err != nil {
+				return err
+			}
+			opts.Source, opts.Path = args[0], args[1]
+			return importSite(cmd.OutOrStdout(), cfg, opts)
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&opts.Sections, "section", nil, "Hugo sections holding posts (default posts, post and blog)")
+	cmd.Flags().StringVar(&opts.Redirects, "redirects", "", "write the old URLs of the posts to this file in the _redirects format")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "list the posts that would be imported without writing them")
+
+	return cmd
+}
+
+// newExportCmd writes the posts as the source of another static site
+// generator
+func newExportCmd() *cobra.Command {
+	var opts ExportOptions
+
+	cmd := &cobra.Command{
+		Use:   "export --format <hugo|jekyll>",
+		Short: "Export the posts as a Hugo or Jekyll site",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCommandConfig(cmd)
+			if err != nil {
+				return err
+			}
+			return exportSite(cmd.OutOrStdout(), cfg, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Format, "format", "", "generator to export for: hugo or jekyll")
+	cmd.Flags().StringVarP(&opts.OutputDir, "output", "o", "./export", "directory to write the site to")
+	cmd.MarkFlagRequired("format")
+
+	return cmd
+}
+
+// newNotionCmd groups the Notion commands
+func newNotionCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "notion",
+		Short: "Sync posts from a Notion database",
+	}
+	cmd.AddCommand(newNotionSyncCmd())
+	return cmd
+}
+
+// newNotionSyncCmd pulls the pages of the Notion database once
+func newNotionSyncCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "sync",
+		Short: "Write the pages of the Notion database into the content directory",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCommandConfig(cmd)
+			if err != nil {
+				return err
+			}
+			result, err := syncNotion(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Synced Notion into %s (%d updated, %d unchanged, %d removed, %d skipped)\n",
+				cfg.ContentDir, result.Updated, result.Unchanged, result.Removed, result.Skipped)
+			return nil
+		},
+	}
+}
+
+// loadCommandConfig loads the config and applies any flags set on the command,
+// which take precedence over both the config file and environment variables
+func loadCommandConfig(cmd *cobra.Command) (*Config, error) {
+	path, _ := cmd.Flags().GetString("config")
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := []struct {
+		flag string
+		dst  *string
+	}{
+		{"port", &cfg.Port},
+		{"listen", &cfg.Listen},
+		{"content-dir", &cfg.ContentDir},
+		{"template-dir", &cfg.TemplateDir},
+		{"public-dir", &cfg.PublicDir},
+		{"env", &cfg.Env},
+		{"log-level", &cfg.Log.Level},
+		{"log-format", &cfg.Log.Format},
+	}
+
+	for _, o := range overrides {
+		if f := cmd.Flags().Lookup(o.flag); f != nil && f.Changed {
+			*o.dst = f.Value.String()
+		}
+	}
+	if f := cmd.Flags().Lookup("headless"); f != nil && f.Changed {
+		cfg.Headless, _ = cmd.Flags().GetBool("headless")
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if err := setupLogging(os.Stderr, cfg.Log); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func newTokenCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "Manage content API tokens",
+	}
+	cmd.AddCommand(newTokenNewCmd())
+	return cmd
+}
+
+func newTokenNewCmd() *cobra.Command {
+	var scopes []string
+
+	cmd := &cobra.Command{
+		Use:   "new <name>",
+		Short: "Generate an API token and print its hashed config entry",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return newAPIToken(cmd.OutOrStdout(), args[0], scopes)
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&scopes, "scope", []string{ScopeRead}, "scopes to grant: read, write, publish (repeatable)")
+	return cmd
+}
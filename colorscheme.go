@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// colorSchemeCookie holds the scheme a visitor picked with the toggle, dark or
+// light. Without it pages follow the system setting.
+const colorSchemeCookie = "color_scheme"
+
+// colorSchemeTTL is how long the picked scheme is remembered
+const colorSchemeTTL = 365 * 24 * time.Hour
+
+// colorSchemeMiddleware exposes the picked scheme to templates as
+// .ColorScheme, so pages are rendered in it from the start instead of
+// flashing the system one first
+func colorSchemeMiddleware(c *fiber.Ctx) error {
+	switch scheme := c.Cookies(colorSchemeCookie); scheme {
+	case "dark", "light":
+		c.Locals("ColorScheme", scheme)
+	}
+	return c.Next()
+}
+
+// handleColorScheme saves the scheme picked with the toggle by visitors
+// without JavaScript, and goes back to the page they were on
+func (s *Server) handleColorScheme(c *fiber.Ctx) error {
+	cookie := &fiber.Cookie{
+		Name:     colorSchemeCookie,
+		Path:     "/",
+		Secure:   !s.cfg.Development(),
+		SameSite: fiber.CookieSameSiteLaxMode,
+	}
+	switch scheme := c.FormValue("scheme"); scheme {
+	case "dark", "light":
+		cookie.Value = scheme
+		cookie.Expires = time.Now().Add(colorSchemeTTL)
+	default:
+		cookie.Expires = time.Unix(0, 0)
+	}
+	c.Cookie(cookie)
+	return c.Redirect(refererPath(c), fiber.StatusSeeOther)
+}
+
+// refererPath returns the path of the page a form was sent from, never
+// another site
+func refererPath(c *fiber.Ctx) string {
+	ref, err := url.Parse(c.Get(fiber.HeaderReferer))
+	if err != nil || !strings.HasPrefix(ref.Path, "/") || strings.HasPrefix(ref.Path, "//") {
+		return "/"
+	}
+	return (&url.URL{Path: ref.Path, RawQuery: ref.RawQuery}).String()
+}
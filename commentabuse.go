@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Moderation modes of comments.moderation
+const (
+	// CommentModerationAll queues every comment for moderation
+	CommentModerationAll = "all"
+	// CommentModerationFirstTime only queues comments from people without
+	// an approved comment yet
+	CommentModerationFirstTime = "first_time"
+)
+
+// BlockedCommenter is an entry of the comment blocklist: an IP address, a
+// CIDR range, an email address or a whole email domain written as
+// @example.com
+type BlockedCommenter struct {
+	Value     string
+	Note      string
+	CreatedAt time.Time
+}
+
+// parseBlockEntry normalizes a blocklist entry and checks it is one of the
+// supported kinds
+func parseBlockEntry(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch {
+	case value == "":
+		return "", fmt.Errorf("enter an IP address, a CIDR range or an email address")
+	case net.ParseIP(value) != nil:
+		return net.ParseIP(value).String(), nil
+	case strings.Contains(value, "/"):
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a valid CIDR range", value)
+		}
+		return network.String(), nil
+	case strings.Contains(value, "@"):
+		local, domain, _ := strings.Cut(value, "@")
+		if domain == "" || strings.ContainsAny(local, " <>") || strings.ContainsAny(domain, " <>@") {
+			return "", fmt.Errorf("%q is not a valid email address or @domain", value)
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("%q is not an IP address, a CIDR range or an email address", value)
+}
+
+// blockMatches reports whether a blocklist entry covers a commenter
+func blockMatches(entry, ip, email string) bool {
+	email = strings.ToLower(email)
+	switch {
+	case strings.HasPrefix(entry, "@"):
+		return email != "" && strings.HasSuffix(email, entry)
+	case strings.Contains(entry, "@"):
+		return email == entry
+	case strings.Contains(entry, "/"):
+		_, network, err := net.ParseCIDR(entry)
+		addr := net.ParseIP(ip)
+		return err == nil && addr != nil && network.Contains(addr)
+	}
+	addr := net.ParseIP(ip)
+	return addr != nil && addr.String() == entry
+}
+
+// Blocklist returns the blocked commenters, newest first
+func (s *commentStore) Blocklist() ([]BlockedCommenter, error) {
+	rows, err := s.db.Query("SELECT value, note, created_at FROM blocked_commenters ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []BlockedCommenter
+	for rows.Next() {
+		var e BlockedCommenter
+		if err := rows.Scan(&e.Value, &e.Note, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Block adds an entry to the blocklist, updating the note of an existing one
+func (s *commentStore) Block(value, note string) error {
+	_, err := s.db.Exec("INSERT INTO blocked_commenters (value, note, created_at) VALUES (?, ?, ?) ON CONFLICT (value) DO UPDATE SET note = excluded.note",
+		value, note, time.Now().UTC())
+	return err
+}
+
+// Unblock removes an entry from the blocklist
+func (s *commentStore) Unblock(value string) error {
+	res, err := s.db.Exec("DELETE FROM blocked_commenters WHERE value = ?", value)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(res)
+}
+
+// BlockedBy returns the blocklist entry that covers a commenter, or ""
+func (s *commentStore) BlockedBy(ip, email string) (string, error) {
+	entries, err := s.Blocklist()
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if blockMatches(e.Value, ip, email) {
+			return e.Value, nil
+		}
+	}
+	return "", nil
+}
+
+// KnownCommenter reports whether the author of c had a comment approved
+// before: under the same website when c is verified with IndieAuth, or under
+// the same name and email otherwise
+func (s *commentStore) KnownCommenter(c *Comment) (bool, error) {
+	var n int
+	var err error
+	if c.Verified {
+		err = s.db.QueryRow("SELECT COUNT(*) FROM comments WHERE status = ? AND verified = 1 AND url = ?",
+			CommentApproved, c.URL).Scan(&n)
+	} else if c.Email != "" {
+		err = s.db.QueryRow("SELECT COUNT(*) FROM comments WHERE status = ? AND author = ? AND LOWER(email) = LOWER(?)",
+			CommentApproved, c.Author, c.Email).Scan(&n)
+	}
+	return n > 0, err
+}
+
+// autoApprove reports whether a new comment skips the moderation queue,
+// which with comments.moderation set to first_time is the case for
+// commenters with an approved comment
+func (s *Server) autoApprove(comment *Comment) (bool, error) {
+	if s.cfg.Comments.Moderation != CommentModerationFirstTime || comment.Status != CommentPending {
+		return false, nil
+	}
+	return s.comments.KnownCommenter(comment)
+}
+
+// handleCommentBlock adds an IP address, range or email to the blocklist
+func (s *Server) handleCommentBlock(c *fiber.Ctx) error {
+	if s.comments == nil {
+		return fiber.ErrNotFound
+	}
+	value, err := parseBlockEntry(c.FormValue("value"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+	}
+	note := strings.TrimSpace(c.FormValue("note"))
+	if err := s.comments.Block(value, note); err != nil {
+		return s.internalError(c, "Error saving blocklist", err)
+	}
+
+	requestLogger(c).Info("Blocked commenter", "value", value)
+	s.audit(c, "Block commenter %s", value)
+	return c.Redirect("/admin/comments?status="+url.QueryEscape(c.FormValue("status", CommentPending))+
+		"&notice="+url.QueryEscape("Blocked "+value), fiber.StatusSeeOther)
+}
+
+// handleCommentUnblock removes an entry from the blocklist
+func (s *Server) handleCommentUnblock(c *fiber.Ctx) error {
+	if s.comments == nil {
+		return fiber.ErrNotFound
+	}
+	value := c.FormValue("value")
+	err := s.comments.Unblock(value)
+	if err == sql.ErrNoRows {
+		return fiber.ErrNotFound
+	}
+	if err != nil {
+		return s.internalError(c, "Error saving blocklist", err)
+	}
+
+	requestLogger(c).Info("Unblocked commenter", "value", value)
+	s.audit(c, "Unblock commenter %s", value)
+	return c.Redirect("/admin/comments?notice="+url.QueryEscape("Unblocked "+value), fiber.StatusSeeOther)
+}
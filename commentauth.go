@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"willnorris.com/go/microformats"
+)
+
+const (
+	// commenterCookie holds the website a commenter signed in with
+	commenterCookie = "devdaze_commenter"
+	// commenterTTL is how long commenters stay signed in
+	commenterTTL = 30 * 24 * time.Hour
+	// signInCookie carries the state of a sign-in in progress
+	signInCookie = "devdaze_signin"
+)
+
+// commenterSignIn is the state of a commenter's IndieAuth sign-in
+type commenterSignIn struct {
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	Me       string `json:"me"`
+	Endpoint string `json:"endpoint"`
+	Post     string `json:"post"`
+}
+
+// commenter returns the website the visitor signed in with, if any
+func (s *Server) commenter(c *fiber.Ctx) string {
+	if !s.cfg.Comments.IndieAuth {
+		return ""
+	}
+	me, _ := s.signer.Verify(c.Cookies(commenterCookie))
+	return me
+}
+
+// setCookie writes a signed cookie, or deletes it when value is empty
+func (s *Server) setCookie(c *fiber.Ctx, name, value string, ttl time.Duration) {
+	cookie := &fiber.Cookie{
+		Name:     name,
+		Path:     "/",
+		HTTPOnly: true,
+		Secure:   !s.cfg.Development(),
+		SameSite: fiber.CookieSameSiteLaxMode,
+	}
+	if value == "" {
+		cookie.Expires = time.Unix(0, 0)
+	} else {
+		cookie.Value = s.signer.Sign(value, ttl)
+		cookie.Expires = time.Now().Add(ttl)
+	}
+	c.Cookie(cookie)
+}
+
+// handleCommenterSignIn starts signing a commenter in with their website:
+// it finds the site's authorization endpoint and sends the browser there
+func (s *Server) handleCommenterSignIn(c *fiber.Ctx) error {
+	post := s.findPost(c.FormValue("post"))
+	if post == nil {
+		return fiber.NewError(fiber.StatusNotFound, "Blog post not found")
+	}
+	me := strings.TrimSpace(c.FormValue("me"))
+	if me != "" && !strings.Contains(me, "://") {
+		me = "https://" + me
+	}
+	u, err := url.Parse(me)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "Enter the address of your website, like example.com")
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+	endpoint, err := s.discoverAuthorizationEndpoint(ctx, u.String())
+	if err != nil {
+		requestLogger(c).Info("IndieAuth discovery failed", "me", u.String(), "error", err)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "Could not find an IndieAuth server for "+u.String())
+	}
+
+	flow := commenterSignIn{State: randomToken(), Verifier: randomToken() + randomToken(), Me: u.String(), Endpoint: endpoint, Post: post.Slug}
+	value, _ := json.Marshal(flow)
+	s.setCookie(c, signInCookie, string(value), 10*time.Minute)
+
+	sum := sha256.Sum256([]byte(flow.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {s.absoluteURL(c, "/")},
+		"redirect_uri":          {s.absoluteURL(c, "/comments/signin/callback")},
+		"state":                 {flow.State},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+		"me":                    {flow.Me},
+	}
+	sep := "?"
+	if strings.Contains(endpoint, "?") {
+		sep = "&"
+	}
+	return c.Redirect(endpoint+sep+q.Encode(), fiber.StatusSeeOther)
+}
+
+// handleCommenterCallback finishes a sign-in: it redeems the code at the
+// commenter's authorization endpoint and remembers the website it confirms
+func (s *Server) handleCommenterCallback(c *fiber.Ctx) error {
+	value, ok := s.signer.Verify(c.Cookies(signInCookie))
+	var flow commenterSignIn
+	if !ok || json.Unmarshal([]byte(value), &flow) != nil || c.Query("state") != flow.State {
+		return fiber.NewError(fiber.StatusBadRequest, "The sign-in expired, please try again")
+	}
+	s.setCookie(c, signInCookie, "", 0)
+	if c.Query("error") != "" {
+		return c.Redirect("/blog/"+flow.Post+"#comment-form", fiber.StatusSeeOther)
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+	me, err := s.redeemCommenterCode(ctx, c, flow)
+	if err != nil {
+		requestLogger(c).Info("IndieAuth sign-in failed", "me", flow.Me, "error", err)
+		return fiber.NewError(fiber.StatusForbidden, "Your website did not confirm the sign-in")
+	}
+
+	requestLogger(c).Info("Commenter signed in", "me", me)
+	s.setCookie(c, commenterCookie, me, commenterTTL)
+	return c.Redirect("/blog/"+flow.Post+"#comment-form", fiber.StatusSeeOther)
+}
+
+// handleCommenterSignOut forgets the commenter's website
+func (s *Server) handleCommenterSignOut(c *fiber.Ctx) error {
+	s.setCookie(c, commenterCookie, "", 0)
+	return c.Redirect("/blog/"+url.PathEscape(c.FormValue("post"))+"#comment-form", fiber.StatusSeeOther)
+}
+
+// redeemCommenterCode exchanges the callback's code for the profile URL it
+// was issued for, which must be on the host the commenter entered
+func (s *Server) redeemCommenterCode(ctx context.Context, c *fiber.Ctx, flow commenterSignIn) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {c.Query("code")},
+		"client_id":     {s.absoluteURL(c, "/")},
+		"redirect_uri":  {s.absoluteURL(c, "/comments/signin/callback")},
+		"code_verifier": {flow.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, flow.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.remote.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", flow.Endpoint, resp.Status)
+	}
+	var profile struct {
+		Me string `json:"me"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&profile); err != nil {
+		return "", err
+	}
+
+	got, err := url.Parse(profile.Me)
+	want, _ := url.Parse(flow.Me)
+	if err != nil || !strings.EqualFold(got.Host, want.Host) {
+		return "", fmt.Errorf("the server confirmed %q instead of %s", profile.Me, flow.Me)
+	}
+	return profile.Me, nil
+}
+
+// discoverAuthorizationEndpoint finds the IndieAuth server a website uses,
+// through its metadata document or the older authorization_endpoint link,
+// in the Link header or the page's head
+func (s *Server) discoverAuthorizationEndpoint(ctx context.Context, me string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, me, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.remote.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMentionSource))
+	if err != nil {
+		return "", err
+	}
+
+	rels := linkHeaderRels(resp.Header.Values("Link"), resp.Request.URL)
+	for rel, urls := range microformats.Parse(bytes.NewReader(body), resp.Request.URL).Rels {
+		if _, ok := rels[rel]; !ok && len(urls) > 0 {
+			rels[rel] = urls[0]
+		}
+	}
+
+	if metadata := rels["indieauth-metadata"]; metadata != "" {
+		var doc struct {
+			AuthorizationEndpoint string `json:"authorization_endpoint"`
+		}
+		if err := getJSON(ctx, s.remote, metadata, &doc); err == nil && doc.AuthorizationEndpoint != "" {
+			return doc.AuthorizationEndpoint, nil
+		}
+	}
+	if endpoint := rels["authorization_endpoint"]; endpoint != "" {
+		return endpoint, nil
+	}
+	return "", fmt.Errorf("no indieauth-metadata or authorization_endpoint link")
+}
+
+// linkHeaderRels returns the first URL of each rel in Link headers
+func linkHeaderRels(headers []string, base *url.URL) map[string]string {
+	rels := map[string]string{}
+	for _, header := range headers {
+		for _, link := range strings.Split(header, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+			target = strings.Trim(strings.TrimSpace(target), "<>")
+			if !ok || target == "" {
+				continue
+			}
+			ref, err := base.Parse(target)
+			if err != nil {
+				continue
+			}
+			for _, param := range strings.Split(params, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if !strings.EqualFold(key, "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+					if _, seen := rels[rel]; !seen {
+						rels[rel] = ref.String()
+					}
+				}
+			}
+		}
+	}
+	return rels
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"strings"
+)
+
+// Comment providers for comments.provider. The built-in system is used when
+// none is set.
+const (
+	CommentsBuiltin    = "builtin"
+	CommentsGiscus     = "giscus"
+	CommentsUtterances = "utterances"
+	CommentsDisqus     = "disqus"
+)
+
+// GiscusConfig embeds GitHub Discussions through giscus.app. The IDs are
+// shown by the configurator on https://giscus.app.
+type GiscusConfig struct {
+	Repo       string `yaml:"repo"`
+	RepoID     string `yaml:"repo_id"`
+	Category   string `yaml:"category"`
+	CategoryID string `yaml:"category_id"`
+	// Mapping links posts to discussions, pathname by default
+	Mapping string `yaml:"mapping"`
+	Theme   string `yaml:"theme"`
+	Lang    string `yaml:"lang"`
+}
+
+// UtterancesConfig embeds GitHub issues through utteranc.es
+type UtterancesConfig struct {
+	Repo string `yaml:"repo"`
+	// IssueTerm links posts to issues, pathname by default
+	IssueTerm string `yaml:"issue_term"`
+	Label     string `yaml:"label"`
+	Theme     string `yaml:"theme"`
+}
+
+// DisqusConfig embeds Disqus threads
+type DisqusConfig struct {
+	Shortname string `yaml:"shortname"`
+}
+
+// commentEmbeds renders the snippet of each third-party provider. html/template
+// escapes the settings for the attribute and script contexts they land in.
+var commentEmbeds = template.Must(template.New("embeds").Parse(`
+{{- define "giscus" -}}
+<script{{ with .Nonce }} nonce="{{ . }}"{{ end }} src="https://giscus.app/client.js"
+        data-repo="{{ .Giscus.Repo }}"
+        data-repo-id="{{ .Giscus.RepoID }}"
+        data-category="{{ .Giscus.Category }}"
+        data-category-id="{{ .Giscus.CategoryID }}"
+        data-mapping="{{ or .Giscus.Mapping "pathname" }}"
+        data-strict="1"
+        data-reactions-enabled="1"
+        data-emit-metadata="0"
+        data-input-position="bottom"
+        data-theme="{{ or .Giscus.Theme "preferred_color_scheme" }}"
+        data-lang="{{ or .Giscus.Lang "en" }}"
+        crossorigin="anonymous"
+        async></script>
+{{- end -}}
+{{- define "utterances" -}}
+<script{{ with .Nonce }} nonce="{{ . }}"{{ end }} src="https://utteranc.es/client.js"
+        repo="{{ .Utterances.Repo }}"
+        issue-term="{{ or .Utterances.IssueTerm "pathname" }}"
+        {{ with .Utterances.Label }}label="{{ . }}"{{ end }}
+        theme="{{ or .Utterances.Theme "github-light" }}"
+        crossorigin="anonymous"
+        async></script>
+{{- end -}}
+{{- define "disqus" -}}
+<div id="disqus_thread"></div>
+<script{{ with .Nonce }} nonce="{{ . }}"{{ end }}>
+var disqus_config = function () {
+    this.page.url = {{ .URL }};
+    this.page.identifier = {{ .Slug }};
+};
+(function () {
+    var d = document, s = d.createElement("script");
+    s.src = "https://" + {{ .Disqus.Shortname }} + ".disqus.com/embed.js";
+    s.setAttribute("data-timestamp", +new Date());
+    (d.head || d.body).appendChild(s);
+})();
+</script>
+{{- end -}}
+`))
+
+// commentEmbed returns the third-party comment snippet for a post, or
+// nothing when the built-in system is used. Its scripts carry the nonce of
+// the response.
+func (s *Server) commentEmbed(url string, post *BlogPost, nonce string) template.HTML {
+	cfg := s.cfg.Comments
+	if !cfg.Enabled || cfg.Provider == "" || cfg.Provider == CommentsBuiltin {
+		return ""
+	}
+
+	var b strings.Builder
+	err := commentEmbeds.ExecuteTemplate(&b, cfg.Provider, struct {
+		CommentsConfig
+		URL   string
+		Slug  string
+		Nonce string
+	}{cfg, url, post.Slug, nonce})
+	if err != nil {
+		slog.Error("Error rendering comment embed", "provider", cfg.Provider, "error", err)
+		return ""
+	}
+	return template.HTML(b.String())
+}
+
+// validate checks that the chosen provider has the settings it needs
+func (c CommentsConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Moderation != CommentModerationAll && c.Moderation != CommentModerationFirstTime {
+		return fmt.Errorf("unknown comments.moderation %q (want %s or %s)", c.Moderation, CommentModerationAll, CommentModerationFirstTime)
+	}
+	switch c.Provider {
+	case "", CommentsBuiltin:
+	case CommentsGiscus:
+		if c.Giscus.Repo == "" || c.Giscus.RepoID == "" || c.Giscus.CategoryID == "" {
+			return fmt.Errorf("comments.giscus needs repo, repo_id and category_id")
+		}
+	case CommentsUtterances:
+		if c.Utterances.Repo == "" {
+			return fmt.Errorf("comments.utterances needs repo")
+		}
+	case CommentsDisqus:
+		if c.Disqus.Shortname == "" {
+			return fmt.Errorf("comments.disqus needs shortname")
+		}
+	default:
+		return fmt.Errorf("unknown comments.provider %q (want %s, %s, %s or %s)",
+			c.Provider, CommentsBuiltin, CommentsGiscus, CommentsUtterances, CommentsDisqus)
+	}
+	return nil
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/html"
+)
+
+// commentsExport is the JSON document comments are exported as
+type commentsExport struct {
+	Site       string            `json:"site"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Comments   []exportedComment `json:"comments"`
+}
+
+// exportedComment is one comment of an export, replies point to their
+// parent by ID
+type exportedComment struct {
+	ID        int64     `json:"id"`
+	Post      string    `json:"post"`
+	Parent    int64     `json:"parent,omitempty"`
+	Author    string    `json:"author"`
+	Email     string    `json:"email,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	Verified  bool      `json:"verified,omitempty"`
+	Body      string    `json:"body"`
+	Status    string    `json:"status"`
+	IP        string    `json:"ip,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// All returns every comment, oldest first
+func (s *commentStore) All() ([]*Comment, error) {
+	return s.query("ORDER BY created_at, id")
+}
+
+// Import stores a comment copied from another system unless one with the
+// same source ID was imported before. It sets the comment's ID either way
+// and reports whether the comment is new.
+func (s *commentStore) Import(c *Comment, source string) (bool, error) {
+	res, err := s.db.Exec("INSERT INTO comments (post, author, email, url, body, status, ip, created_at, parent_id, verified, source) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING",
+		c.Post, c.Author, c.Email, c.URL, c.Body, c.Status, c.IP, c.CreatedAt.UTC(), c.Parent, c.Verified, source)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, s.db.QueryRow("SELECT id FROM comments WHERE source = ?", source).Scan(&c.ID)
+	}
+	c.ID, err = res.LastInsertId()
+	return true, err
+}
+
+// exportComments writes every comment in the store as JSON
+func exportComments(w io.Writer, store *commentStore, site string) error {
+	comments, err := store.All()
+	if err != nil {
+		return err
+	}
+
+	export := commentsExport{Site: site, ExportedAt: time.Now().UTC(), Comments: []exportedComment{}}
+	for _, c := range comments {
+		export.Comments = append(export.Comments, exportedComment{
+			ID:        c.ID,
+			Post:      c.Post,
+			Parent:    c.Parent,
+			Author:    c.Author,
+			Email:     c.Email,
+			URL:       c.URL,
+			Verified:  c.Verified,
+			Body:      c.Body,
+			Status:    c.Status,
+			IP:        c.IP,
+			CreatedAt: c.CreatedAt.UTC(),
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(export)
+}
+
+// disqusExport is the XML file Disqus exports a forum as
+type disqusExport struct {
+	Threads []disqusThread `xml:"thread"`
+	Posts   []disqusPost   `xml:"post"`
+}
+
+// disqusThread is the discussion of one page
+type disqusThread struct {
+	ID         string `xml:"http://disqus.com/disqus-internals id,attr"`
+	Identifier string `xml:"id"`
+	Link       string `xml:"link"`
+}
+
+// disqusRef points to a thread or post by its Disqus ID
+type disqusRef struct {
+	ID string `xml:"http://disqus.com/disqus-internals id,attr"`
+}
+
+// disqusPost is one comment, its message is HTML
+type disqusPost struct {
+	ID        string    `xml:"http://disqus.com/disqus-internals id,attr"`
+	Message   string    `xml:"message"`
+	CreatedAt time.Time `xml:"createdAt"`
+	IsDeleted bool      `xml:"isDeleted"`
+	IsSpam    bool      `xml:"isSpam"`
+	Author    struct {
+		Name  string `xml:"name"`
+		Email string `xml:"email"`
+	} `xml:"author"`
+	IP     string    `xml:"ipAddress"`
+	Thread disqusRef `xml:"thread"`
+	Parent disqusRef `xml:"parent"`
+}
+
+// disqusImport counts what importDisqus did
+type disqusImport struct {
+	Imported int
+	// Existing comments were imported before
+	Existing int
+	// Skipped comments were deleted on Disqus or belong to pages that are
+	// not posts
+	Skipped int
+}
+
+// String summarizes the import for notices and the command line
+func (r disqusImport) String() string {
+	return fmt.Sprintf("Imported %d comments from Disqus, %d were imported before, %d skipped as deleted or not on a post",
+		r.Imported, r.Existing, r.Skipped)
+}
+
+// importDisqus copies the comments of a Disqus export into store. Threads are
+// matched to posts by their identifier, which the Disqus embed sets to the
+// slug, or by the last part of their link. Comments keep their Disqus ID, so
+// importing the same export again adds nothing.
+func importDisqus(store *commentStore, r io.Reader, slugs map[string]bool) (disqusImport, error) {
+	var export disqusExport
+	if err := xml.NewDecoder(r).Decode(&export); err != nil {
+		return disqusImport{}, fmt.Errorf("error reading Disqus export: %v", err)
+	}
+
+	threads := make(map[string]string, len(export.Threads))
+	for _, t := range export.Threads {
+		if slug := disqusSlug(t, slugs); slug != "" {
+			threads[t.ID] = slug
+		}
+	}
+
+	// Parents are older than their replies, so they are stored first
+	sort.SliceStable(export.Posts, func(i, j int) bool {
+		return export.Posts[i].CreatedAt.Before(export.Posts[j].CreatedAt)
+	})
+
+	type imported struct {
+		id    int64
+		depth int
+		up    string
+	}
+	seen := map[string]imported{}
+
+	var result disqusImport
+	for _, p := range export.Posts {
+		slug, ok := threads[p.Thread.ID]
+		if !ok || p.IsDeleted {
+			result.Skipped++
+			continue
+		}
+
+		comment := &Comment{
+			Post:      slug,
+			Author:    strings.TrimSpace(p.Author.Name),
+			Email:     strings.TrimSpace(p.Author.Email),
+			Body:      disqusText(p.Message),
+			Status:    CommentApproved,
+			IP:        p.IP,
+			CreatedAt: p.CreatedAt,
+		}
+		if comment.Author == "" {
+			comment.Author = "Anonymous"
+		}
+		if p.IsSpam {
+			comment.Status = CommentSpam
+		}
+
+		// Threads deeper than maxCommentDepth are flattened like new replies
+		depth, up := 0, p.Parent.ID
+		for up != "" {
+			parent, ok := seen[up]
+			if !ok {
+				break
+			}
+			if parent.depth < maxCommentDepth {
+				comment.Parent, depth = parent.id, parent.depth+1
+				break
+			}
+			up = parent.up
+		}
+
+		added, err := store.Import(comment, "disqus:"+p.ID)
+		if err != nil {
+			return result, fmt.Errorf("error importing Disqus comment %s: %v", p.ID, err)
+		}
+		seen[p.ID] = imported{id: comment.ID, depth: depth, up: p.Parent.ID}
+		if added {
+			result.Imported++
+		} else {
+			result.Existing++
+		}
+	}
+	return result, nil
+}
+
+// disqusSlug returns the post a Disqus thread belongs to, or "" for none
+func disqusSlug(t disqusThread, slugs map[string]bool) string {
+	if slugs[t.Identifier] {
+		return t.Identifier
+	}
+	if u, err := url.Parse(strings.TrimSpace(t.Link)); err == nil {
+		if slug := path.Base(strings.TrimSuffix(u.Path, "/")); slugs[slug] {
+			return slug
+		}
+	}
+	return ""
+}
+
+// disqusText turns the HTML of a Disqus message into the plain text
+// comments are stored as, keeping paragraphs, line breaks and link targets
+func disqusText(message string) string {
+	var b strings.Builder
+	var href string
+	z := html.NewTokenizer(strings.NewReader(message))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			text := strings.ReplaceAll(b.String(), "\u00a0", " ")
+			for strings.Contains(text, "\n\n\n") {
+				text = strings.ReplaceAll(text, "\n\n\n", "\n\n")
+			}
+			return strings.TrimSpace(text)
+		case html.TextToken:
+			b.Write(z.Text())
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "br":
+				b.WriteString("\n")
+			case "p", "blockquote", "pre", "ul", "ol":
+				b.WriteString("\n\n")
+			case "li":
+				b.WriteString("\n- ")
+			case "a":
+				href = ""
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					if string(key) == "href" {
+						href = string(val)
+					}
+				}
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "p", "blockquote", "pre", "ul", "ol":
+				b.WriteString("\n\n")
+			case "a":
+				if href != "" && !strings.HasSuffix(b.String(), href) {
+					fmt.Fprintf(&b, " (%s)", href)
+				}
+				href = ""
+			}
+		}
+	}
+}
+
+// handleCommentExport downloads every comment as JSON
+func (s *Server) handleCommentExport(c *fiber.Ctx) error {
+	if s.comments == nil {
+		return fiber.ErrNotFound
+	}
+	var b strings.Builder
+	if err := exportComments(&b, s.comments, s.cfg.Title); err != nil {
+		return s.internalError(c, "Error exporting comments", err)
+	}
+	s.audit(c, "Export comments")
+	c.Attachment("comments-" + time.Now().Format("2006-01-02") + ".json")
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	return c.SendString(b.String())
+}
+
+// handleCommentImport imports the comments of an uploaded Disqus export
+func (s *Server) handleCommentImport(c *fiber.Ctx) error {
+	if s.comments == nil {
+		return fiber.ErrNotFound
+	}
+	upload, err := c.FormFile("file")
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "No file selected")
+	}
+	f, err := upload.Open()
+	if err != nil {
+		return s.internalError(c, "Error reading upload", err)
+	}
+	defer f.Close()
+
+	slugs, err := s.postSlugs()
+	if err != nil {
+		return s.internalError(c, "Error loading posts", err)
+	}
+	result, err := importDisqus(s.comments, f, slugs)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+	}
+
+	requestLogger(c).Info("Imported Disqus comments", "imported", result.Imported, "existing", result.Existing, "skipped", result.Skipped)
+	s.audit(c, "Import %d comments from Disqus", result.Imported)
+	return c.Redirect("/admin/comments?status=approved&notice="+url.QueryEscape(result.String()), fiber.StatusSeeOther)
+}
+
+// postSlugs returns the slugs of all posts, drafts included
+func (s *Server) postSlugs() (map[string]bool, error) {
+	posts, err := s.content.AllPosts()
+	if err != nil {
+		return nil, err
+	}
+	return slugSet(posts), nil
+}
+
+// slugSet returns the slugs of posts as a set
+func slugSet(posts []*BlogPost) map[string]bool {
+	slugs := make(map[string]bool, len(posts))
+	for _, post := range posts {
+		slugs[post.Slug] = true
+	}
+	return slugs
+}
+
+func newCommentsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "comments",
+		Short: "Export and import built-in comments",
+	}
+	cmd.AddCommand(newCommentsExportCmd(), newCommentsImportCmd())
+	return cmd
+}
+
+func newCommentsExportCmd() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write every comment as JSON",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCommandConfig(cmd)
+			if err != nil {
+				return err
+			}
+			store, err := openCommentStore(cfg)
+			if err != nil {
+				return err
+			}
+			defer store.db.Close()
+
+			if output == "" || output == "-" {
+				return exportComments(cmd.OutOrStdout(), store, cfg.Title)
+			}
+			f, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			if err := exportComments(f, store, cfg.Title); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write the export to (default stdout)")
+	return cmd
+}
+
+func newCommentsImportCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "import <disqus.xml>",
+		Short: "Import the comments of a Disqus XML export",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCommandConfig(cmd)
+			if err != nil {
+				return err
+			}
+			store, err := openCommentStore(cfg)
+			if err != nil {
+				return err
+			}
+			defer store.db.Close()
+
+			posts, err := readPosts(cfg)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			result, err := importDisqus(store, f, slugSet(posts))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), result)
+			return nil
+		},
+	}
+}
+
+// openCommentStore opens the comment database for the commands, which only
+// work with built-in comments
+func openCommentStore(cfg *Config) (*commentStore, error) {
+	store, err := newCommentStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return nil, fmt.Errorf("built-in comments are disabled, set comments.enabled")
+	}
+	return store, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// commentFeedSize is the number of latest comments a feed lists
+const commentFeedSize = 50
+
+// rssFeed is an RSS 2.0 document
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Content string     `xml:"xmlns:content,attr,omitempty"`
+	Atom    string     `xml:"xmlns:atom,attr,omitempty"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	Language    string `xml:"language,omitempty"`
+	// Links point at the feed itself and its versions in other languages,
+	// in feeds declaring the Atom namespace
+	Links []atomLink `xml:"atom:link,omitempty"`
+	Items []rssItem  `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	Creator     string `xml:"dc:creator"`
+	PubDate     string `xml:"pubDate"`
+	Description string `xml:"description"`
+	// Content is the full HTML of the item, in feeds declaring the content
+	// module
+	Content string `xml:"content:encoded,omitempty"`
+}
+
+// Recent returns the latest approved comments on any post, newest first
+func (s *commentStore) Recent(n int) ([]*Comment, error) {
+	return s.query("WHERE status = ? ORDER BY created_at DESC, id DESC LIMIT ?", CommentApproved, n)
+}
+
+// handleCommentsFeed serves the latest approved comments of the whole site
+func (s *Server) handleCommentsFeed(c *fiber.Ctx) error {
+	comments, err := s.comments.Recent(commentFeedSize)
+	if err != nil {
+		return s.internalError(c, "Error loading comments", err)
+	}
+
+	posts := map[string]*BlogPost{}
+	var visible []*Comment
+	for _, comment := range comments {
+		if _, ok := posts[comment.Post]; !ok {
+			posts[comment.Post] = s.findPost(comment.Post)
+		}
+		// Comments on posts that were unpublished or deleted stay out
+		if post := posts[comment.Post]; post != nil && apiVisible(post) {
+			visible = append(visible, comment)
+		}
+	}
+
+	return s.sendCommentFeed(c, rssChannel{
+		Title:       "Comments on " + s.cfg.Title,
+		Link:        s.absoluteURL(c, "/"),
+		Description: "The latest comments on " + s.cfg.Title,
+	}, visible, posts)
+}
+
+// handlePostCommentsFeed serves the latest approved comments of one post
+func (s *Server) handlePostCommentsFeed(c *fiber.Ctx) error {
+	post := s.findPost(c.Params("slug"))
+	if post == nil || !apiVisible(post) {
+		return errorResponse(c, fiber.StatusNotFound, "Blog post not found")
+	}
+	comments, err := s.comments.Approved(post.Slug)
+	if err != nil {
+		return s.internalError(c, "Error loading comments", err)
+	}
+
+	// Approved lists the oldest first, feeds start with the newest
+	latest := make([]*Comment, 0, commentFeedSize)
+	for i := len(comments) - 1; i >= 0 && len(latest) < commentFeedSize; i-- {
+		latest = append(latest, comments[i])
+	}
+	return s.sendCommentFeed(c, rssChannel{
+		Title:       "Comments on " + post.Title,
+		Link:        s.absoluteURL(c, "/blog/"+post.Slug),
+		Description: fmt.Sprintf("Comments on %q from %s", post.Title, s.cfg.Title),
+	}, latest, map[string]*BlogPost{post.Slug: post})
+}
+
+// sendCommentFeed renders comments as the items of channel. posts holds the
+// post of every comment.
+func (s *Server) sendCommentFeed(c *fiber.Ctx, channel rssChannel, comments []*Comment, posts map[string]*BlogPost) error {
+	for _, comment := range comments {
+		post := posts[comment.Post]
+		link := s.absoluteURL(c, "/blog/"+post.Slug+"#comment-"+strconv.FormatInt(comment.ID, 10))
+		channel.Items = append(channel.Items, rssItem{
+			Title:       fmt.Sprintf("%s on %s", comment.Author, post.Title),
+			Link:        link,
+			GUID:        link,
+			Creator:     comment.Author,
+			PubDate:     comment.CreatedAt.In(s.cfg.Location()).Format(time.RFC1123Z),
+			Description: string(comment.BodyHTML()),
+		})
+	}
+
+	body, err := xml.MarshalIndent(rssFeed{Version: "2.0", DC: "http://purl.org/dc/elements/1.1/", Channel: channel}, "", "  ")
+	if err != nil {
+		return s.internalError(c, "Error encoding feed", err)
+	}
+	c.Set(fiber.HeaderContentType, "application/rss+xml; charset=utf-8")
+	return c.Send(append([]byte(xml.Header), body...))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// commentLinkTTL is how long the approve and reject links in notification
+// emails work
+const commentLinkTTL = 7 * 24 * time.Hour
+
+// commentActions maps the moderation actions to the words notices use
+var commentActions = map[string]string{"approve": "Approved", "reject": "Rejected", "delete": "Deleted"}
+
+// moderateComment approves, rejects or deletes a comment
+func (s *Server) moderateComment(c *fiber.Ctx, comment *Comment, action string) error {
+	switch action {
+	case "approve":
+		if err := s.comments.SetStatus(comment.ID, CommentApproved); err != nil {
+			return err
+		}
+		if comment.Status != CommentApproved {
+			s.notifyReply(c, comment)
+		}
+		return nil
+	case "reject":
+		return s.comments.SetStatus(comment.ID, CommentRejected)
+	case "delete":
+		return s.comments.Delete(comment.ID)
+	}
+	return fmt.Errorf("unknown action %q", action)
+}
+
+// commentLink returns a signed link that approves or rejects a comment
+// without signing in to the dashboard
+func (s *Server) commentLink(c *fiber.Ctx, comment *Comment, action string) string {
+	token := s.signer.Sign("comment|"+strconv.FormatInt(comment.ID, 10)+"|"+action, commentLinkTTL)
+	return s.absoluteURL(c, "/comments/moderate/"+token)
+}
+
+// notifyComment emails comments.notify about a comment waiting for
+// moderation, with links to approve or reject it
+func (s *Server) notifyComment(c *fiber.Ctx, post *BlogPost, comment *Comment) {
+	to := s.cfg.Comments.Notify
+	if len(to) == 0 || comment.Status != CommentPending {
+		return
+	}
+	s.notify(s.commentMail(c, post, comment, to))
+}
+
+// commentMail is the email about a comment waiting for moderation
+func (s *Server) commentMail(c *fiber.Ctx, post *BlogPost, comment *Comment, to []string) outgoingMail {
+	postURL := s.absoluteURL(c, "/blog/"+post.Slug)
+	approve, reject := s.commentLink(c, comment, "approve"), s.commentLink(c, comment, "reject")
+	queue := s.absoluteURL(c, "/admin/comments")
+	days := int(commentLinkTTL.Hours() / 24)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s commented on %q:\n\n", comment.Author, post.Title)
+	fmt.Fprintf(&b, "%s\n\n", comment.Body)
+	if comment.Email != "" {
+		fmt.Fprintf(&b, "Email: %s\n", comment.Email)
+	}
+	if comment.URL != "" {
+		fmt.Fprintf(&b, "Website: %s\n", comment.URL)
+	}
+	fmt.Fprintf(&b, "IP: %s\n", comment.IP)
+	fmt.Fprintf(&b, "Post: %s\n\n", postURL)
+	fmt.Fprintf(&b, "Approve: %s\n", approve)
+	fmt.Fprintf(&b, "Reject: %s\n\n", reject)
+	fmt.Fprintf(&b, "The links work for %d days. All comments waiting for moderation: %s\n", days, queue)
+
+	m := outgoingMail{
+		To:      to,
+		Subject: fmt.Sprintf("[%s] New comment on %s", s.cfg.Title, post.Title),
+		Text:    b.String(),
+	}
+	return s.withHTML(m, "comment", fiber.Map{
+		"Post":    post,
+		"Comment": comment,
+		"PostURL": postURL,
+		"Approve": approve,
+		"Reject":  reject,
+		"Queue":   queue,
+		"Days":    days,
+	})
+}
+
+// commentFromLink checks a moderation link and returns its comment and action
+func (s *Server) commentFromLink(c *fiber.Ctx) (*Comment, string, error) {
+	value, ok := s.signer.Verify(c.Params("token"))
+	parts := strings.Split(value, "|")
+	if !ok || len(parts) != 3 || parts[0] != "comment" || (parts[2] != "approve" && parts[2] != "reject") {
+		return nil, "", fiber.NewError(fiber.StatusNotFound, "This link is invalid or has expired")
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, "", fiber.NewError(fiber.StatusNotFound, "This link is invalid or has expired")
+	}
+	comment, err := s.comments.Get(id)
+	if err == sql.ErrNoRows {
+		return nil, "", fiber.NewError(fiber.StatusNotFound, "The comment no longer exists")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return comment, parts[2], nil
+}
+
+// handleCommentLink shows the comment of a moderation link with a button to
+// confirm the action. Opening the link changes nothing, so mail scanners
+// that follow links do not moderate comments.
+func (s *Server) handleCommentLink(c *fiber.Ctx) error {
+	comment, action, err := s.commentFromLink(c)
+	if err != nil {
+		return err
+	}
+	return c.Render("comment_moderate", fiber.Map{
+		"Title":   strings.ToUpper(action[:1]) + action[1:] + " comment",
+		"Comment": comment,
+		"Action":  action,
+	})
+}
+
+// handleCommentLinkConfirm approves or rejects the comment of a moderation
+// link
+func (s *Server) handleCommentLinkConfirm(c *fiber.Ctx) error {
+	comment, action, err := s.commentFromLink(c)
+	if err != nil {
+		return err
+	}
+	if err := s.moderateComment(c, comment, action); err != nil {
+		return s.internalError(c, "Error moderating comment", err)
+	}
+
+	done := commentActions[action]
+	requestLogger(c).Info("Moderated comment", "comment", comment.ID, "action", action, "via", "email link")
+	s.auditAs(c, "email link", "%s comment %d by %s on %s", done, comment.ID, comment.Author, comment.Post)
+	return c.Render("comment_moderate", fiber.Map{
+		"Title":   done + " comment",
+		"Comment": comment,
+		"Notice":  done + " the comment by " + comment.Author + ".",
+	})
+}
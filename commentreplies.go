@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// threadComments nests replies under the comments they answer, keeping
+// the order of comments. Replies whose parent is not among comments, such as
+// replies to rejected comments, stay at the top level.
+func threadComments(comments []*Comment) []*Comment {
+	byID := make(map[int64]*Comment, len(comments))
+	for _, c := range comments {
+		c.Replies = nil
+		byID[c.ID] = c
+	}
+
+	var top []*Comment
+	for _, c := range comments {
+		if parent, ok := byID[c.Parent]; ok && c.Parent != c.ID {
+			parent.Replies = append(parent.Replies, c)
+		} else {
+			top = append(top, c)
+		}
+	}
+	return top
+}
+
+// replyParent returns the approved comment on post a new comment replies
+// to, or nil when there is none. Replies to comments at maxCommentDepth go
+// to the comment above them, so threads never nest deeper.
+func (s *Server) replyParent(post *BlogPost, id int64) (*Comment, error) {
+	parent, err := s.comments.Get(id)
+	if err == sql.ErrNoRows || (err == nil && (parent.Post != post.Slug || parent.Status != CommentApproved)) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ancestors := []*Comment{parent}
+	for c := parent; c.Parent != 0 && len(ancestors) <= maxCommentDepth; {
+		if c, err = s.comments.Get(c.Parent); err != nil {
+			break
+		}
+		ancestors = append(ancestors, c)
+	}
+	// ancestors holds the parent's own depth plus one
+	if len(ancestors) > maxCommentDepth {
+		return ancestors[len(ancestors)-maxCommentDepth], nil
+	}
+	return parent, nil
+}
+
+// commentReplyTo returns the comment a reader picked to reply to with
+// ?reply=, if it can be replied to
+func (s *Server) commentReplyTo(c *fiber.Ctx, post *BlogPost) *Comment {
+	id, err := strconv.ParseInt(c.Query("reply"), 10, 64)
+	if err != nil {
+		return nil
+	}
+	parent, err := s.comments.Get(id)
+	if err != nil || parent.Post != post.Slug || parent.Status != CommentApproved {
+		return nil
+	}
+	return parent
+}
+
+// notifyReply tells the author of the comment a newly approved reply
+// answers, when comments.notify_replies is on and they left an address
+func (s *Server) notifyReply(c *fiber.Ctx, reply *Comment) {
+	if !s.cfg.Comments.NotifyReplies || reply.Parent == 0 {
+		return
+	}
+	parent, err := s.comments.Get(reply.Parent)
+	if err != nil || parent.Email == "" || strings.EqualFold(parent.Email, reply.Email) {
+		return
+	}
+	post := s.findPost(reply.Post)
+	if post == nil {
+		return
+	}
+
+	s.notify(s.replyMail(c, post, reply, parent.Email))
+}
+
+// replyMail is the email telling the author of a comment about a reply
+func (s *Server) replyMail(c *fiber.Ctx, post *BlogPost, reply *Comment, to string) outgoingMail {
+	link := s.absoluteURL(c, "/blog/"+post.Slug+"#comment-"+strconv.FormatInt(reply.ID, 10))
+	m := outgoingMail{
+		To:      []string{to},
+		Subject: fmt.Sprintf("[%s] %s replied to your comment", s.cfg.Title, reply.Author),
+		Text: fmt.Sprintf("%s replied to your comment on %q:\n\n%s\n\nRead the discussion: %s\n",
+			reply.Author, post.Title, reply.Body, link),
+	}
+	return s.withHTML(m, "reply", fiber.Map{"Post": post, "Reply": reply, "URL": link})
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"html/template"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CommentsConfig controls the comments under posts
+type CommentsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Provider picks the built-in comments or a third-party embed
+	Provider   string           `yaml:"provider"`
+	Giscus     GiscusConfig     `yaml:"giscus"`
+	Utterances UtterancesConfig `yaml:"utterances"`
+	Disqus     DisqusConfig     `yaml:"disqus"`
+
+	// Database is the SQLite file comments are stored in
+	Database string `yaml:"database"`
+	// MinSubmitTime rejects forms sent back faster than a person could
+	// write a comment
+	MinSubmitTime time.Duration `yaml:"min_submit_time"`
+	// PerIPPerHour limits how many comments one address can post, 0 for no
+	// limit
+	PerIPPerHour int `yaml:"per_ip_per_hour"`
+	// PerPostPerHour limits how many comments one post accepts, 0 for no
+	// limit
+	PerPostPerHour int           `yaml:"per_post_per_hour"`
+	Akismet        AkismetConfig `yaml:"akismet"`
+	// Moderation is all to queue every comment, or first_time to publish
+	// comments of people with an approved comment straight away
+	Moderation string `yaml:"moderation"`
+	// Notify lists the addresses emailed about each comment waiting for
+	// moderation, through the smtp settings
+	Notify []string `yaml:"notify"`
+	// NotifyReplies emails commenters who left an address when a reply to
+	// their comment is approved
+	NotifyReplies bool `yaml:"notify_replies"`
+	// IndieAuth lets commenters sign in with their own website
+	IndieAuth bool `yaml:"indieauth"`
+}
+
+// Comment statuses. New comments wait in the moderation queue until an
+// admin approves them.
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentRejected = "rejected"
+)
+
+// Comment limits, in characters
+const (
+	maxCommentAuthor = 100
+	maxCommentBody   = 5000
+)
+
+// maxCommentDepth is how deep replies nest. Replies to comments at this
+// depth join their parent's thread instead.
+const maxCommentDepth = 2
+
+// Comment is a reader's comment on a post
+type Comment struct {
+	ID        int64
+	Post      string
+	Author    string
+	Email     string
+	URL       string
+	Body      string
+	Status    string
+	IP        string
+	CreatedAt time.Time
+	// Parent is the ID of the comment this one replies to, 0 for none
+	Parent int64
+	// Verified is set when the commenter signed in with IndieAuth as URL
+	Verified bool
+
+	// Replies are the approved replies, filled in by threadComments
+	Replies []*Comment
+}
+
+// BodyHTML renders the comment's plain text as escaped paragraphs
+func (c *Comment) BodyHTML() template.HTML {
+	var b strings.Builder
+	for _, para := range strings.Split(strings.ReplaceAll(c.Body, "\r\n", "\n"), "\n\n") {
+		if para = strings.TrimSpace(para); para == "" {
+			continue
+		}
+		b.WriteString("<p>")
+		b.WriteString(strings.ReplaceAll(html.EscapeString(para), "\n", "<br>"))
+		b.WriteString("</p>\n")
+	}
+	return template.HTML(b.String())
+}
+
+// commentMigrations create and upgrade the comments database
+var commentMigrations = []string{
+	`CREATE TABLE comments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post TEXT NOT NULL,
+		author TEXT NOT NULL,
+		email TEXT NOT NULL DEFAULT '',
+		url TEXT NOT NULL DEFAULT '',
+		body TEXT NOT NULL,
+		status TEXT NOT NULL,
+		ip TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX comments_post_status ON comments (post, status);`,
+	`ALTER TABLE comments ADD COLUMN parent_id INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE comments ADD COLUMN verified INTEGER NOT NULL DEFAULT 0;`,
+	// source is the ID of comments imported from elsewhere, see importDisqus
+	`ALTER TABLE comments ADD COLUMN source TEXT NOT NULL DEFAULT '';
+	CREATE UNIQUE INDEX comments_source ON comments (source) WHERE source != '';`,
+	`CREATE TABLE blocked_commenters (
+		value TEXT PRIMARY KEY,
+		note TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL
+	);`,
+}
+
+// commentStore keeps comments in SQLite
+type commentStore struct {
+	db *sql.DB
+}
+
+// newCommentStore returns the comment store of cfg, or nil when built-in
+// comments are disabled
+func newCommentStore(cfg *Config) (*commentStore, error) {
+	if !cfg.Comments.Enabled || (cfg.Comments.Provider != "" && cfg.Comments.Provider != CommentsBuiltin) {
+		return nil, nil
+	}
+	db, err := openDatabase(cfg.Comments.Database, commentMigrations)
+	if err != nil {
+		return nil, err
+	}
+	return &commentStore{db: db}, nil
+}
+
+// commentColumns lists the columns scanComment reads, in order
+const commentColumns = "id, post, author, email, url, body, status, ip, created_at, parent_id, verified"
+
+// scanComment reads a row selected with commentColumns
+func scanComment(row interface{ Scan(...any) error }) (*Comment, error) {
+	var c Comment
+	err := row.Scan(&c.ID, &c.Post, &c.Author, &c.Email, &c.URL, &c.Body, &c.Status, &c.IP, &c.CreatedAt, &c.Parent, &c.Verified)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// query returns the comments selected by a query on commentColumns
+func (s *commentStore) query(query string, args ...any) ([]*Comment, error) {
+	rows, err := s.db.Query("SELECT "+commentColumns+" FROM comments "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*Comment
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// Add stores a new comment and sets its ID
+func (s *commentStore) Add(c *Comment) error {
+	res, err := s.db.Exec("INSERT INTO comments (post, author, email, url, body, status, ip, created_at, parent_id, verified) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		c.Post, c.Author, c.Email, c.URL, c.Body, c.Status, c.IP, c.CreatedAt.UTC(), c.Parent, c.Verified)
+	if err != nil {
+		return err
+	}
+	c.ID, err = res.LastInsertId()
+	return err
+}
+
+// Get returns a comment by ID
+func (s *commentStore) Get(id int64) (*Comment, error) {
+	return scanComment(s.db.QueryRow("SELECT "+commentColumns+" FROM comments WHERE id = ?", id))
+}
+
+// Approved returns the approved comments on a post, oldest first
+func (s *commentStore) Approved(post string) ([]*Comment, error) {
+	return s.query("WHERE post = ? AND status = ? ORDER BY created_at, id", post, CommentApproved)
+}
+
+// WithStatus returns the comments with a status, newest first
+func (s *commentStore) WithStatus(status string) ([]*Comment, error) {
+	return s.query("WHERE status = ? ORDER BY created_at DESC, id DESC", status)
+}
+
+// Counts returns the number of comments per status
+func (s *commentStore) Counts() (map[string]int, error) {
+	rows, err := s.db.Query("SELECT status, COUNT(*) FROM comments GROUP BY status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+// SetStatus moves a comment into or out of the moderation queue
+func (s *commentStore) SetStatus(id int64, status string) error {
+	res, err := s.db.Exec("UPDATE comments SET status = ? WHERE id = ?", status, id)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(res)
+}
+
+// Delete removes a comment for good
+func (s *commentStore) Delete(id int64) error {
+	res, err := s.db.Exec("DELETE FROM comments WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(res)
+}
+
+// expectOneRow turns an update of no rows into sql.ErrNoRows
+func expectOneRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// registerCommentRoutes wires up the public comment form. Moderation lives
+// with the other admin routes. The forms are checked for the session's CSRF
+// token like those of the admin.
+func (s *Server) registerCommentRoutes() {
+	if s.comments == nil {
+		return
+	}
+	s.app.Post("/blog/:slug/comments", s.sessionMiddleware, s.csrfProtect, s.handleCommentSubmit)
+	s.app.Get("/blog/:slug/comments.rss", s.handlePostCommentsFeed)
+	s.app.Get("/comments.rss", s.handleCommentsFeed)
+	if s.cfg.Comments.IndieAuth {
+		s.app.Post("/comments/signin", s.sessionMiddleware, s.csrfProtect, s.handleCommenterSignIn)
+		s.app.Get("/comments/signin/callback", s.handleCommenterCallback)
+		s.app.Post("/comments/signout", s.sessionMiddleware, s.csrfProtect, s.handleCommenterSignOut)
+	}
+	s.app.Get("/comments/moderate/:token", s.handleCommentLink)
+	s.app.Post("/comments/moderate/:token", s.handleCommentLinkConfirm)
+}
+
+// postComments returns the approved comments shown under a post
+func (s *Server) postComments(c *fiber.Ctx, post *BlogPost) []*Comment {
+	if s.comments == nil {
+		return nil
+	}
+	comments, err := s.comments.Approved(post.Slug)
+	if err != nil {
+		requestLogger(c).Error("Error loading comments", "slug", post.Slug, "error", err)
+	}
+	return threadComments(comments)
+}
+
+// handleCommentSubmit adds a reader's comment to the moderation queue
+func (s *Server) handleCommentSubmit(c *fiber.Ctx) error {
+	post, ok := s.content.Post(c.Params("slug"))
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Blog post not found")
+	}
+	if !post.CommentsAllowed() {
+		return fiber.NewError(fiber.StatusForbidden, "Comments are closed on this post")
+	}
+
+	if reason, ok := s.checkCommentForm(c, post); !ok {
+		// Look like a success, so bots do not learn to get around the check
+		requestLogger(c).Info("Dropped comment", "slug", post.Slug, "ip", c.IP(), "reason", reason)
+		return c.Redirect("/blog/"+post.Slug+"?comment=pending#comments", fiber.StatusSeeOther)
+	}
+
+	comment := &Comment{
+		Post:      post.Slug,
+		Author:    strings.TrimSpace(c.FormValue("author")),
+		Email:     strings.TrimSpace(c.FormValue("email")),
+		URL:       strings.TrimSpace(c.FormValue("url")),
+		Body:      strings.TrimSpace(c.FormValue("body")),
+		Status:    CommentPending,
+		IP:        c.IP(),
+		CreatedAt: time.Now(),
+	}
+	if me := s.commenter(c); me != "" {
+		comment.URL = me
+		comment.Verified = true
+	}
+	if err := validateComment(comment); err != nil {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "Comment not posted: "+err.Error())
+	}
+	blocked, err := s.comments.BlockedBy(comment.IP, comment.Email)
+	if err != nil {
+		return s.internalError(c, "Error loading blocklist", err)
+	}
+	if blocked != "" {
+		requestLogger(c).Info("Dropped comment", "slug", post.Slug, "ip", c.IP(), "reason", "blocked as "+blocked)
+		return c.Redirect("/blog/"+post.Slug+"?comment=pending#comments", fiber.StatusSeeOther)
+	}
+	if !s.commentLimit.Allow(c.IP()) {
+		return fiber.NewError(fiber.StatusTooManyRequests, "Too many comments, please try again later")
+	}
+	if !s.postCommentLimit.Allow(post.Slug) {
+		return fiber.NewError(fiber.StatusTooManyRequests, "This post is getting too many comments, please try again later")
+	}
+	if id, err := strconv.ParseInt(c.FormValue("parent"), 10, 64); err == nil && id > 0 {
+		parent, err := s.replyParent(post, id)
+		if err != nil {
+			return s.internalError(c, "Error loading comment", err)
+		}
+		if parent == nil {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "Comment not posted: the comment you replied to does not exist")
+		}
+		comment.Parent = parent.ID
+	}
+
+	spam, err := s.akismetSpam(c, comment)
+	if err != nil {
+		requestLogger(c).Warn("Akismet check failed, queueing the comment", "slug", post.Slug, "error", err)
+	}
+	if spam {
+		comment.Status = CommentSpam
+	}
+	known, err := s.autoApprove(comment)
+	if err != nil {
+		return s.internalError(c, "Error loading comments", err)
+	}
+	if known {
+		comment.Status = CommentApproved
+	}
+
+	if err := s.comments.Add(comment); err != nil {
+		return s.internalError(c, "Error saving comment", err)
+	}
+	requestLogger(c).Info("Comment received", "slug", post.Slug, "comment", comment.ID, "status", comment.Status)
+	if comment.Status == CommentApproved {
+		s.notifyReply(c, comment)
+		return c.Redirect("/blog/"+post.Slug+"#comment-"+strconv.FormatInt(comment.ID, 10), fiber.StatusSeeOther)
+	}
+	s.notifyComment(c, post, comment)
+	return c.Redirect("/blog/"+post.Slug+"?comment=pending#comments", fiber.StatusSeeOther)
+}
+
+// validateComment checks the submitted fields of a comment
+func validateComment(c *Comment) error {
+	switch {
+	case c.Author == "":
+		return fmt.Errorf("a name is required")
+	case c.Body == "":
+		return fmt.Errorf("the comment is empty")
+	case len([]rune(c.Author)) > maxCommentAuthor:
+		return fmt.Errorf("the name is longer than %d characters", maxCommentAuthor)
+	case len([]rune(c.Body)) > maxCommentBody:
+		return fmt.Errorf("the comment is longer than %d characters", maxCommentBody)
+	case c.Email != "" && !strings.Contains(c.Email, "@"):
+		return fmt.Errorf("%q is not an email address", c.Email)
+	}
+	if c.URL != "" {
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%q is not a web address", c.URL)
+		}
+	}
+	return nil
+}
+
+// handleCommentQueue lists the comments with the requested status, the
+// moderation queue by default
+func (s *Server) handleCommentQueue(c *fiber.Ctx) error {
+	if s.comments == nil {
+		return c.Render("admin_comments", fiber.Map{"Title": "Comments"})
+	}
+
+	status := c.Query("status", CommentPending)
+	if status != CommentPending && status != CommentApproved && status != CommentRejected && status != CommentSpam {
+		return fiber.NewError(fiber.StatusBadRequest, "Unknown comment status "+status)
+	}
+	comments, err := s.comments.WithStatus(status)
+	if err != nil {
+		return s.internalError(c, "Error loading comments", err)
+	}
+	counts, err := s.comments.Counts()
+	if err != nil {
+		return s.internalError(c, "Error loading comments", err)
+	}
+	blocklist, err := s.comments.Blocklist()
+	if err != nil {
+		return s.internalError(c, "Error loading blocklist", err)
+	}
+
+	return c.Render("admin_comments", fiber.Map{
+		"Title":    "Comments",
+		"Enabled":  true,
+		"Notice":   c.Query("notice"),
+		"Status":   status,
+		"Counts":   counts,
+		"Comments": comments,
+		"Statuses": []string{CommentPending, CommentApproved, CommentRejected, CommentSpam},
+		"Blocked":  blocklist,
+	})
+}
+
+// handleCommentModerate approves, rejects or deletes a comment
+func (s *Server) handleCommentModerate(c *fiber.Ctx) error {
+	if s.comments == nil {
+		return fiber.ErrNotFound
+	}
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return fiber.ErrNotFound
+	}
+	comment, err := s.comments.Get(id)
+	if err == sql.ErrNoRows {
+		return fiber.ErrNotFound
+	}
+	if err != nil {
+		return s.internalError(c, "Error loading comment", err)
+	}
+
+	action := c.Params("action")
+	done, ok := commentActions[action]
+	if !ok {
+		return fiber.ErrNotFound
+	}
+	if err := s.moderateComment(c, comment, action); err != nil {
+		return s.internalError(c, "Error moderating comment", err)
+	}
+
+	requestLogger(c).Info("Moderated comment", "comment", id, "action", action)
+	s.audit(c, "%s comment %d by %s on %s", done, id, comment.Author, comment.Post)
+	notice := done + " the comment by " + comment.Author
+	return c.Redirect("/admin/comments?status="+comment.Status+"&notice="+url.QueryEscape(notice), fiber.StatusSeeOther)
+}
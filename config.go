@@ -0,0 +1,477 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	// Embedded so timezone works without the system's zoneinfo
+	_ "time/tzdata"
+
+	"gopkg.in/yaml.v2"
+)
+
+// defaultConfigFile is read when no explicit config path is given
+const defaultConfigFile = "devdaze.yaml"
+
+// Runtime modes for Config.Env
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// Config holds the runtime settings for the blog
+type Config struct {
+	Port string `yaml:"port"`
+	// Listen overrides Port with a TCP address, unix:/path, fd:N or systemd
+	Listen      string `yaml:"listen"`
+	ContentDir  string `yaml:"content_dir"`
+	TemplateDir string `yaml:"template_dir"`
+	PublicDir   string `yaml:"public_dir"`
+	BaseURL     string `yaml:"base_url"`
+	Env         string `yaml:"env"`
+	Title       string `yaml:"title"`
+	// Headless turns off every HTML page, serving only the APIs, feeds and
+	// media to a separate frontend
+	Headless bool `yaml:"headless"`
+	// MinifyHTML minifies every page, including those of static builds
+	MinifyHTML bool `yaml:"minify_html"`
+	// PostsPerPage splits the index, the blog and tag listings into pages.
+	// 0 lists every post on one page.
+	PostsPerPage int `yaml:"posts_per_page"`
+	// Timezone is the IANA time zone of the site, like Europe/Berlin. Dates
+	// in frontmatter without a zone are in it, and pages and feeds show dates
+	// in it. Empty is UTC.
+	Timezone string `yaml:"timezone"`
+	// location is Timezone, loaded by validate
+	location *time.Location
+
+	// Author is the default author for posts created with `devdaze new post`
+	Author string `yaml:"author"`
+	// PostTemplate is an optional text/template file used for new posts
+	PostTemplate string `yaml:"post_template"`
+
+	Media          MediaConfig          `yaml:"media"`
+	Admin          AdminConfig          `yaml:"admin"`
+	Debug          DebugConfig          `yaml:"debug"`
+	API            APIConfig            `yaml:"api"`
+	Micropub       MicropubConfig       `yaml:"micropub"`
+	Git            GitConfig            `yaml:"git"`
+	Scheduler      SchedulerConfig      `yaml:"scheduler"`
+	Trash          TrashConfig          `yaml:"trash"`
+	Revisions      RevisionsConfig      `yaml:"revisions"`
+	Audit          AuditConfig          `yaml:"audit"`
+	Comments       CommentsConfig       `yaml:"comments"`
+	Webmention     WebmentionConfig     `yaml:"webmention"`
+	ActivityPub    ActivityPubConfig    `yaml:"activitypub"`
+	Likes          LikesConfig          `yaml:"likes"`
+	Analytics      AnalyticsConfig      `yaml:"analytics"`
+	Newsletter     NewsletterConfig     `yaml:"newsletter"`
+	Contact        ContactConfig        `yaml:"contact"`
+	I18n           I18nConfig           `yaml:"i18n"`
+	SMTP           SMTPConfig           `yaml:"smtp"`
+	IndieAuth      IndieAuthConfig      `yaml:"indieauth"`
+	TLS            TLSConfig            `yaml:"tls"`
+	Log            LogConfig            `yaml:"log"`
+	ErrorReporting ErrorReportingConfig `yaml:"error_reporting"`
+	Deploy         DeployConfig         `yaml:"deploy"`
+	Notion         NotionConfig         `yaml:"notion"`
+	Obsidian       ObsidianConfig       `yaml:"obsidian"`
+	Syndication    SyndicationConfig    `yaml:"syndication"`
+	Gemini         GeminiConfig         `yaml:"gemini"`
+	Source         ContentSourceConfig  `yaml:"source"`
+	Theme          ThemeConfig          `yaml:"theme"`
+	Head           HeadConfig           `yaml:"head"`
+	Assets         AssetsConfig         `yaml:"assets"`
+	Icons          IconsConfig          `yaml:"icons"`
+
+	// SecurityHeaders are set on every response
+	SecurityHeaders SecurityHeadersConfig `yaml:"security_headers"`
+
+	// Sites are additional blogs served from this process by Host header
+	Sites []SiteConfig `yaml:"sites"`
+}
+
+// DeployConfig controls where `devdaze deploy` publishes the static build
+type DeployConfig struct {
+	Target       string              `yaml:"target"`
+	Dir          string              `yaml:"dir"`
+	CacheControl []CacheControlRule  `yaml:"cache_control"`
+	S3           S3DeployConfig      `yaml:"s3"`
+	Netlify      NetlifyDeployConfig `yaml:"netlify"`
+	GitHub       GitHubDeployConfig  `yaml:"github"`
+}
+
+// CacheControlRule sets the Cache-Control header for files matching Pattern.
+// The first matching rule wins.
+type CacheControlRule struct {
+	Pattern string `yaml:"pattern"`
+	Value   string `yaml:"value"`
+}
+
+// S3DeployConfig publishes to an S3 bucket, optionally behind CloudFront.
+// AWS credentials come from the standard AWS environment and config files.
+type S3DeployConfig struct {
+	Bucket       string `yaml:"bucket"`
+	Region       string `yaml:"region"`
+	Prefix       string `yaml:"prefix"`
+	Distribution string `yaml:"cloudfront_distribution"`
+	Invalidate   bool   `yaml:"invalidate"`
+	// Delete removes the objects the build no longer has even when Prefix is
+	// empty, where they could belong to something other than the site
+	Delete bool `yaml:"delete"`
+}
+
+// NetlifyDeployConfig publishes to a Netlify site through its deploy API
+type NetlifyDeployConfig struct {
+	SiteID string `yaml:"site_id"`
+	Token  string `yaml:"token"`
+}
+
+// GitHubDeployConfig publishes by force pushing to a GitHub Pages branch
+type GitHubDeployConfig struct {
+	Repo   string `yaml:"repo"`
+	Branch string `yaml:"branch"`
+	Token  string `yaml:"token"`
+}
+
+// defaultConfig returns the settings used when nothing else is configured
+func defaultConfig() *Config {
+	return &Config{
+		Port:        "3000",
+		ContentDir:  "./content",
+		TemplateDir: "./internal/templates",
+		PublicDir:   "./public",
+		Env:         EnvDevelopment,
+		Title:       "DevDaze Blog",
+		Log:         LogConfig{Level: "info", Format: "text"},
+		Media: MediaConfig{
+			Dir:         "./public/media",
+			URL:         "/media",
+			MaxUploadMB: 20,
+			Widths:      []int{480, 960, 1600},
+			Formats:     []string{"webp"},
+			Quality:     80,
+			Sizes:       "(max-width: 800px) 100vw, 800px",
+		},
+		Trash:     TrashConfig{RetentionDays: 30},
+		Revisions: RevisionsConfig{Keep: 50},
+		Audit:     AuditConfig{File: "./audit.log"},
+		Comments: CommentsConfig{
+			Database:       "./comments.db",
+			MinSubmitTime:  3 * time.Second,
+			PerIPPerHour:   5,
+			PerPostPerHour: 30,
+			Moderation:     CommentModerationAll,
+		},
+		Webmention: WebmentionConfig{Database: "./webmentions.db"},
+		Likes:      LikesConfig{Database: "./likes.db"},
+		Analytics: AnalyticsConfig{
+			Database:    "./analytics.db",
+			Plausible:   PlausibleConfig{Script: "https://plausible.io/js/script.js"},
+			GoatCounter: GoatCounterConfig{Script: "https://gc.zgo.at/count.js"},
+		},
+		Newsletter: NewsletterConfig{Database: "./newsletter.db", Retries: 5},
+		Contact:    ContactConfig{PerIPPerHour: 5},
+		I18n:       I18nConfig{DefaultLanguage: "en", Catalogs: "./internal/i18n", DateFormat: "medium"},
+		SMTP:       SMTPConfig{Port: 587},
+		IndieAuth:  IndieAuthConfig{TokenTTL: 90 * 24 * time.Hour},
+		ActivityPub: ActivityPubConfig{
+			Username: "blog",
+			Database: "./activitypub.db",
+			KeyFile:  "./activitypub.pem",
+		},
+		Micropub: MicropubConfig{
+			AuthorizationEndpoint: "https://indieauth.com/auth",
+			TokenEndpoint:         "https://tokens.indieauth.com/token",
+		},
+		TLS: TLSConfig{
+			CacheDir:  "./certs",
+			HTTPAddr:  ":80",
+			HTTPSAddr: ":443",
+		},
+		ErrorReporting: ErrorReportingConfig{
+			SampleRate: 1.0,
+		},
+		Deploy: DeployConfig{
+			Dir: "./dist",
+			CacheControl: []CacheControlRule{
+				{Pattern: "*.html", Value: "public, max-age=0, must-revalidate"},
+				{Pattern: "*", Value: "public, max-age=86400"},
+			},
+			GitHub: GitHubDeployConfig{Branch: "gh-pages"},
+		},
+		Notion: NotionConfig{
+			Properties: NotionProperties{
+				Slug:        "Slug",
+				Date:        "Date",
+				Tags:        "Tags",
+				Description: "Description",
+				Author:      "Author",
+				Series:      "Series",
+				Status:      "Status",
+			},
+		},
+		Obsidian: ObsidianConfig{URL: "/vault"},
+		Syndication: SyndicationConfig{
+			Mastodon: MastodonConfig{
+				Message:    "{{ .Title }}\n\n{{ .Excerpt }}\n\n{{ .URL }}\n\n{{ .Hashtags }}",
+				Visibility: "public",
+			},
+			Bluesky: BlueskyConfig{
+				Server:  "https://bsky.social",
+				Message: "{{ .Title }}\n\n{{ .Excerpt }}\n\n{{ .Hashtags }}",
+			},
+		},
+		Theme:  ThemeConfig{Dir: "./themes", Override: "./overrides"},
+		Assets: AssetsConfig{Dir: "./assets", URL: "/assets", Sass: "sass", Minify: true},
+		Gemini: GeminiConfig{
+			Listen:   ":1965",
+			CertFile: "./gemini-cert.pem",
+			KeyFile:  "./gemini-key.pem",
+		},
+		SecurityHeaders: SecurityHeadersConfig{
+			ContentSecurityPolicy: defaultContentSecurityPolicy,
+			HSTSMaxAge:            365 * 24 * time.Hour,
+			ContentTypeOptions:    "nosniff",
+			ReferrerPolicy:        "strict-origin-when-cross-origin",
+			FrameOptions:          "SAMEORIGIN",
+		},
+	}
+}
+
+// loadConfig builds the configuration from defaults, the config file and
+// environment variables, in increasing order of precedence. An empty path
+// falls back to $DEVDAZE_CONFIG and then to devdaze.yaml, which is optional.
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+
+	explicit := true
+	if path == "" {
+		path = os.Getenv("DEVDAZE_CONFIG")
+	}
+	if path == "" {
+		path = defaultConfigFile
+		explicit = false
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("error parsing config file %s: %v", path, err)
+		}
+	case os.IsNotExist(err) && !explicit:
+		// No config file is fine, defaults and env vars still apply
+	default:
+		return nil, fmt.Errorf("error reading config file %s: %v", path, err)
+	}
+
+	applyEnv(cfg)
+	return cfg, nil
+}
+
+// Development reports whether the site runs in development mode. It is the
+// single switch for template reloading, content re-scanning, verbose error
+// pages, live reload and showing drafts; production turns all of them off.
+func (c *Config) Development() bool {
+	return c.Env == EnvDevelopment
+}
+
+// Location returns the site's time zone
+func (c *Config) Location() *time.Location {
+	if c.location == nil {
+		return time.UTC
+	}
+	return c.location
+}
+
+// validate checks settings that cannot be checked while parsing
+func (c *Config) validate() error {
+	if c.Env != EnvDevelopment && c.Env != EnvProduction {
+		return fmt.Errorf("unknown env %q (want %s or %s)", c.Env, EnvDevelopment, EnvProduction)
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return fmt.Errorf("unknown timezone %q, want a name like Europe/Berlin: %v", c.Timezone, err)
+	}
+	c.location = loc
+	if c.Micropub.Enabled && c.Micropub.Me == "" && c.BaseURL == "" {
+		return fmt.Errorf("micropub needs micropub.me or base_url to check tokens against")
+	}
+	if c.ActivityPub.Enabled && c.BaseURL == "" {
+		return fmt.Errorf("activitypub needs base_url for the blog's permanent IDs")
+	}
+	if c.IndieAuth.Enabled && c.Micropub.Me == "" && c.BaseURL == "" {
+		return fmt.Errorf("indieauth needs base_url or micropub.me to sign in as")
+	}
+	if len(c.Comments.Notify) > 0 && (c.SMTP.Host == "" || c.SMTP.From == "") {
+		return fmt.Errorf("comments.notify needs smtp.host and smtp.from")
+	}
+	if c.Newsletter.Enabled && (c.SMTP.Host == "" || c.SMTP.From == "") {
+		return fmt.Errorf("newsletter needs smtp.host and smtp.from")
+	}
+	if c.Newsletter.Enabled && c.BaseURL == "" {
+		return fmt.Errorf("newsletter needs base_url for the links in its emails")
+	}
+	if c.Contact.Enabled && (c.SMTP.Host == "" || c.SMTP.From == "") {
+		return fmt.Errorf("contact needs smtp.host and smtp.from")
+	}
+	if c.Contact.Enabled && len(c.Contact.To) == 0 {
+		return fmt.Errorf("contact needs contact.to to send messages to")
+	}
+	if err := c.Admin.validate(); err != nil {
+		return err
+	}
+	if err := c.Comments.validate(); err != nil {
+		return err
+	}
+	if err := c.Analytics.validate(); err != nil {
+		return err
+	}
+	if err := c.I18n.validate(); err != nil {
+		return err
+	}
+	if err := c.Notion.validate(); err != nil {
+		return err
+	}
+	if c.Syndication.Enabled() && c.BaseURL == "" {
+		return fmt.Errorf("syndication needs base_url for the canonical URLs of cross-posted posts")
+	}
+	if err := c.Syndication.validate(); err != nil {
+		return err
+	}
+	if err := c.Theme.validate(); err != nil {
+		return err
+	}
+	if err := c.Head.validate(); err != nil {
+		return err
+	}
+	if err := c.Assets.validate(); err != nil {
+		return err
+	}
+	if err := c.Icons.validate(); err != nil {
+		return err
+	}
+	if err := c.Source.validate(); err != nil {
+		return err
+	}
+	if err := c.Gemini.validate(); err != nil {
+		return err
+	}
+	if err := c.SecurityHeaders.validate(); err != nil {
+		return err
+	}
+	for _, hook := range c.Scheduler.Hooks {
+		if err := hook.validate(); err != nil {
+			return err
+		}
+	}
+	for _, t := range c.API.Tokens {
+		if err := t.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEnv overrides config values with any environment variables that are set
+func applyEnv(cfg *Config) {
+	overrides := []struct {
+		key string
+		dst *string
+	}{
+		{"PORT", &cfg.Port},
+		{"DEVDAZE_PORT", &cfg.Port},
+		{"DEVDAZE_LISTEN", &cfg.Listen},
+		{"DEVDAZE_CONTENT_DIR", &cfg.ContentDir},
+		{"DEVDAZE_TEMPLATE_DIR", &cfg.TemplateDir},
+		{"DEVDAZE_PUBLIC_DIR", &cfg.PublicDir},
+		{"DEVDAZE_THEME", &cfg.Theme.Name},
+		{"DEVDAZE_BASE_URL", &cfg.BaseURL},
+		{"DEVDAZE_ENV", &cfg.Env},
+		{"DEVDAZE_TITLE", &cfg.Title},
+		{"DEVDAZE_TIMEZONE", &cfg.Timezone},
+		{"DEVDAZE_AUTHOR", &cfg.Author},
+		{"DEVDAZE_POST_TEMPLATE", &cfg.PostTemplate},
+		{"DEVDAZE_DEFAULT_LANGUAGE", &cfg.I18n.DefaultLanguage},
+		{"DEVDAZE_MEDIA_DIR", &cfg.Media.Dir},
+		{"DEVDAZE_ADMIN_TOKEN", &cfg.Admin.Token},
+		{"DEVDAZE_ADMIN_USERNAME", &cfg.Admin.Username},
+		{"DEVDAZE_ADMIN_PASSWORD", &cfg.Admin.Password},
+		{"DEVDAZE_SESSION_SECRET", &cfg.Admin.SessionSecret},
+		{"DEVDAZE_GITHUB_CLIENT_ID", &cfg.Admin.OAuth.GitHub.ClientID},
+		{"DEVDAZE_GITHUB_CLIENT_SECRET", &cfg.Admin.OAuth.GitHub.ClientSecret},
+		{"DEVDAZE_GOOGLE_CLIENT_ID", &cfg.Admin.OAuth.Google.ClientID},
+		{"DEVDAZE_GOOGLE_CLIENT_SECRET", &cfg.Admin.OAuth.Google.ClientSecret},
+		{"DEVDAZE_MICROPUB_ME", &cfg.Micropub.Me},
+		{"DEVDAZE_MICROPUB_TOKEN_ENDPOINT", &cfg.Micropub.TokenEndpoint},
+		{"DEVDAZE_GIT_REMOTE", &cfg.Git.Remote},
+		{"DEVDAZE_PLAUSIBLE_DOMAIN", &cfg.Analytics.Plausible.Domain},
+		{"DEVDAZE_GOATCOUNTER_CODE", &cfg.Analytics.GoatCounter.Code},
+		{"DEVDAZE_GA_MEASUREMENT_ID", &cfg.Analytics.Google.MeasurementID},
+		{"DEVDAZE_GITHUB_WEBHOOK_SECRET", &cfg.Git.WebhookSecret},
+		{"DEVDAZE_AUDIT_FILE", &cfg.Audit.File},
+		{"DEVDAZE_AKISMET_KEY", &cfg.Comments.Akismet.Key},
+		{"DEVDAZE_SMTP_PASSWORD", &cfg.SMTP.Password},
+		{"DEVDAZE_DEBUG_USERNAME", &cfg.Debug.Username},
+		{"DEVDAZE_DEBUG_PASSWORD", &cfg.Debug.Password},
+		{"DEVDAZE_TLS_CACHE_DIR", &cfg.TLS.CacheDir},
+		{"DEVDAZE_TLS_EMAIL", &cfg.TLS.Email},
+		{"DEVDAZE_LOG_LEVEL", &cfg.Log.Level},
+		{"DEVDAZE_LOG_FORMAT", &cfg.Log.Format},
+		{"SENTRY_DSN", &cfg.ErrorReporting.SentryDSN},
+		{"DEVDAZE_SENTRY_DSN", &cfg.ErrorReporting.SentryDSN},
+		{"DEVDAZE_DEPLOY_TARGET", &cfg.Deploy.Target},
+		{"DEVDAZE_S3_BUCKET", &cfg.Deploy.S3.Bucket},
+		{"DEVDAZE_S3_REGION", &cfg.Deploy.S3.Region},
+		{"DEVDAZE_CLOUDFRONT_DISTRIBUTION", &cfg.Deploy.S3.Distribution},
+		{"NETLIFY_SITE_ID", &cfg.Deploy.Netlify.SiteID},
+		{"NETLIFY_AUTH_TOKEN", &cfg.Deploy.Netlify.Token},
+		{"DEVDAZE_GITHUB_REPO", &cfg.Deploy.GitHub.Repo},
+		{"GITHUB_TOKEN", &cfg.Deploy.GitHub.Token},
+		{"DEVDAZE_NOTION_TOKEN", &cfg.Notion.Token},
+		{"DEVDAZE_NOTION_DATABASE", &cfg.Notion.Database},
+		{"DEVDAZE_DEVTO_TOKEN", &cfg.Syndication.DevTo.Token},
+		{"DEVDAZE_HASHNODE_TOKEN", &cfg.Syndication.Hashnode.Token},
+		{"DEVDAZE_MASTODON_TOKEN", &cfg.Syndication.Mastodon.Token},
+		{"DEVDAZE_BLUESKY_PASSWORD", &cfg.Syndication.Bluesky.Password},
+	}
+
+	for _, o := range overrides {
+		if v, ok := os.LookupEnv(o.key); ok && v != "" {
+			*o.dst = v
+		}
+	}
+
+	if v := os.Getenv("DEVDAZE_HEADLESS"); v != "" {
+		cfg.Headless, _ = strconv.ParseBool(v)
+	}
+	if v := os.Getenv("DEVDAZE_MINIFY_HTML"); v != "" {
+		cfg.MinifyHTML, _ = strconv.ParseBool(v)
+	}
+	if v := os.Getenv("DEVDAZE_POSTS_PER_PAGE"); v != "" {
+		cfg.PostsPerPage, _ = strconv.Atoi(v)
+	}
+
+	// Setting domains through the environment also turns autocert on
+	if v := os.Getenv("DEVDAZE_TLS_DOMAINS"); v != "" {
+		cfg.TLS.Domains = nil
+		for _, d := range strings.Split(v, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				cfg.TLS.Domains = append(cfg.TLS.Domains, d)
+			}
+		}
+		cfg.TLS.Autocert = true
+	}
+
+	if v := os.Getenv("DEVDAZE_ADMIN_ALLOW"); v != "" {
+		cfg.Admin.OAuth.Allow = nil
+		for _, a := range strings.Split(v, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				cfg.Admin.OAuth.Allow = append(cfg.Admin.OAuth.Allow, a)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ContactConfig serves a contact form at /contact that emails messages to
+// the site owner
+type ContactConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// To are the addresses messages are sent to
+	To []string `yaml:"to"`
+	// PerIPPerHour limits how many messages one address can send, 0 for no
+	// limit
+	PerIPPerHour int `yaml:"per_ip_per_hour"`
+}
+
+const (
+	// contactFormTTL is how long a rendered contact form can be submitted
+	contactFormTTL = 24 * time.Hour
+	// contactMinSubmitTime rejects forms sent back faster than a person
+	// could write a message
+	contactMinSubmitTime = 3 * time.Second
+	// contactMaxLinks rejects messages with more links, which are nearly
+	// always spam
+	contactMaxLinks = 3
+	// contactMaxName and contactMaxMessage are the longest name and message
+	// accepted, in characters
+	contactMaxName    = 100
+	contactMaxMessage = 5000
+)
+
+// contactLinks matches the links in a message
+var contactLinks = regexp.MustCompile(`(?i)https?://|www\.`)
+
+// contactForm is what the contact page shows and gets back
+type contactForm struct {
+	Token   string
+	Name    string
+	Email   string
+	Message string
+}
+
+// registerContactRoutes wires up the contact page and tells the layout to
+// link to it. The form sends back the session's CSRF token.
+func (s *Server) registerContactRoutes() {
+	if !s.cfg.Contact.Enabled {
+		return
+	}
+	s.app.Use(func(c *fiber.Ctx) error {
+		c.Locals("ContactPage", "/contact")
+		return c.Next()
+	})
+	s.app.Get("/contact", s.sessionMiddleware, s.handleContact)
+	s.app.Post("/contact", s.sessionMiddleware, s.csrfProtect, s.handleContactSend)
+}
+
+// contactFormToken signs the time the contact form was rendered
+func (s *Server) contactFormToken() string {
+	return s.signer.Sign("contact|"+strconv.FormatInt(time.Now().Unix(), 10), contactFormTTL)
+}
+
+// renderContact shows the contact page with the form, an error above it or
+// the confirmation that the message was sent
+func (s *Server) renderContact(c *fiber.Ctx, status int, form contactForm, data fiber.Map) error {
+	form.Token = s.contactFormToken()
+	if data == nil {
+		data = fiber.Map{}
+	}
+	data["Title"] = s.t(c, "Contact")
+	data["Form"] = form
+	return c.Status(status).Render("contact", data, "layout")
+}
+
+// handleContact shows the contact form
+func (s *Server) handleContact(c *fiber.Ctx) error {
+	return s.renderContact(c, fiber.StatusOK, contactForm{}, nil)
+}
+
+// checkContactForm rejects submissions that filled in the honeypot, carry no
+// valid form token, came back too quickly or are full of links. The returned
+// reason is for the log, bots are not told what gave them away.
+func (s *Server) checkContactForm(c *fiber.Ctx, form contactForm) (string, bool) {
+	if c.FormValue(commentHoneypot) != "" {
+		return "honeypot filled in", false
+	}
+	value, ok := s.signer.Verify(c.FormValue("token"))
+	prefix, stamp, _ := strings.Cut(value, "|")
+	rendered, err := strconv.ParseInt(stamp, 10, 64)
+	if !ok || err != nil || prefix != "contact" {
+		return "missing or expired form token", false
+	}
+	if time.Since(time.Unix(rendered, 0)) < contactMinSubmitTime {
+		return "submitted too quickly", false
+	}
+	if len(contactLinks.FindAllStringIndex(form.Message, -1)) > contactMaxLinks {
+		return "too many links", false
+	}
+	return "", true
+}
+
+// validateContactForm returns what is wrong with a message, in words for
+// the person who wrote it
+func validateContactForm(form contactForm) string {
+	switch {
+	case form.Name == "":
+		return "Please enter your name."
+	case utf8.RuneCountInString(form.Name) > contactMaxName:
+		return fmt.Sprintf("Your name can be at most %d characters.", contactMaxName)
+	case strings.ContainsAny(form.Name, "\r\n<>\""):
+		return "Your name cannot contain line breaks, angle brackets or quotes."
+	case form.Message == "":
+		return "Please enter a message."
+	case utf8.RuneCountInString(form.Message) > contactMaxMessage:
+		return fmt.Sprintf("Your message can be at most %d characters.", contactMaxMessage)
+	}
+	addr, err := mail.ParseAddress(form.Email)
+	if err != nil || addr.Name != "" || !strings.Contains(addr.Address, ".") {
+		return "Enter an email address like you@example.com, so the reply can reach you."
+	}
+	return ""
+}
+
+// contactMail is the email with a message from the contact form, with the
+// sender as Reply-To so answering it reaches them
+func (s *Server) contactMail(form contactForm, ip string) outgoingMail {
+	replyTo := &mail.Address{Name: form.Name, Address: form.Email}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s <%s> wrote through the contact form of %s:\n\n", form.Name, form.Email, s.cfg.Title)
+	fmt.Fprintf(&b, "%s\n\n", form.Message)
+	fmt.Fprintf(&b, "Reply to this email to answer them.\nIP: %s\n", ip)
+	m := outgoingMail{
+		To:      s.cfg.Contact.To,
+		Subject: fmt.Sprintf("[%s] Message from %s", s.cfg.Title, form.Name),
+		Text:    b.String(),
+		Headers: map[string]string{"Reply-To": replyTo.String()},
+	}
+	return s.withHTML(m, "contact", fiber.Map{
+		"Name":    form.Name,
+		"Email":   form.Email,
+		"Message": form.Message,
+		"IP":      ip,
+	})
+}
+
+// handleContactSend emails a message from the contact form to contact.to
+func (s *Server) handleContactSend(c *fiber.Ctx) error {
+	log := requestLogger(c)
+	form := contactForm{
+		Name:    strings.TrimSpace(c.FormValue("name")),
+		Email:   strings.TrimSpace(c.FormValue("email")),
+		Message: strings.TrimSpace(c.FormValue("message")),
+	}
+	if reason, ok := s.checkContactForm(c, form); !ok {
+		log.Info("Rejected contact message", "reason", reason)
+		return s.renderContact(c, fiber.StatusOK, contactForm{}, fiber.Map{"Sent": true})
+	}
+	if problem := validateContactForm(form); problem != "" {
+		return s.renderContact(c, fiber.StatusUnprocessableEntity, form, fiber.Map{"Error": problem})
+	}
+	if !s.contactLimit.Allow(c.IP()) {
+		return s.renderContact(c, fiber.StatusTooManyRequests, form,
+			fiber.Map{"Error": "You sent too many messages, please try again later."})
+	}
+
+	if err := sendMessage(s.cfg.SMTP, s.contactMail(form, c.IP())); err != nil {
+		log.Error("Error sending contact message", "error", err)
+		return s.renderContact(c, fiber.StatusBadGateway, form,
+			fiber.Map{"Error": "Your message could not be sent right now. Please try again later."})
+	}
+	log.Info("Sent contact message")
+	return s.renderContact(c, fiber.StatusOK, contactForm{}, fiber.Map{"Sent": true})
+}
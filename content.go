@@ -0,0 +1,310 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ContentIndex holds the parsed blog posts in memory, keyed by slug
+type ContentIndex struct {
+	dir string
+	// languages are the languages posts can be translated to
+	languages []string
+	// loc is the time zone of dates written without one
+	loc *time.Location
+	// autoReload re-scans the content directory on every lookup, so edits
+	// show up without restarting the server
+	autoReload bool
+	// showDrafts includes posts marked as drafts in lookups
+	showDrafts bool
+	// vaultURL reads the directory as an Obsidian vault whose attachments
+	// are served under it, when set
+	vaultURL string
+
+	mu       sync.RWMutex
+	all      []*BlogPost
+	posts    []*BlogPost
+	bySlug   map[string]*BlogPost
+	loadedAt time.Time
+	err      error
+	// nextDue is when the earliest scheduled post becomes visible
+	nextDue time.Time
+	// translations holds the visible translations by slug and language
+	translations map[string]map[string]*BlogPost
+	// aliases maps the aliases of visible posts to the paths of the posts
+	aliases map[string]string
+	// vault resolves the wikilinks and attachments of an Obsidian vault
+	vault *vaultIndex
+}
+
+// newContentIndex creates an empty index for the given content directory,
+// the languages its posts are translated to and the site's time zone
+func newContentIndex(dir string, languages []string, loc *time.Location, autoReload, showDrafts bool) *ContentIndex {
+	return &ContentIndex{
+		dir:          dir,
+		languages:    languages,
+		loc:          loc,
+		autoReload:   autoReload,
+		showDrafts:   showDrafts,
+		bySlug:       make(map[string]*BlogPost),
+		translations: make(map[string]map[string]*BlogPost),
+		aliases:      make(map[string]string),
+	}
+}
+
+// Load re-scans the content directory and replaces the indexed posts.
+// On error the previously loaded posts are kept.
+func (idx *ContentIndex) Load() error {
+	var posts []*BlogPost
+	var err error
+	if idx.vaultURL != "" {
+		posts, err = readVault(idx.dir, idx.languages, idx.loc)
+	} else {
+		posts, err = getAllBlogPosts(idx.dir, idx.languages, idx.loc)
+	}
+	var translations []*BlogPost
+	if err == nil {
+		translations, err = getTranslatedPosts(idx.dir, idx.languages, idx.loc)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.err = err
+	if err != nil {
+		return err
+	}
+
+	visible := posts[:0:0]
+	bySlug := make(map[string]*BlogPost, len(posts))
+	var nextDue time.Time
+	for _, post := range posts {
+		if post.Scheduled() && (nextDue.IsZero() || post.Date.Before(nextDue)) {
+			nextDue = post.Date
+		}
+		if (post.Draft || post.Scheduled()) && !idx.showDrafts {
+			continue
+		}
+		visible = append(visible, post)
+		bySlug[post.Slug] = post
+	}
+
+	// Translations are shown along with their original
+	translated := make(map[string]map[string]*BlogPost)
+	for _, t := range linkTranslations(posts, translations, idx.languages) {
+		if t.Scheduled() && (nextDue.IsZero() || t.Date.Before(nextDue)) {
+			nextDue = t.Date
+		}
+		if bySlug[t.Slug] == nil || ((t.Draft || t.Scheduled()) && !idx.showDrafts) {
+			continue
+		}
+		if translated[t.Slug] == nil {
+			translated[t.Slug] = make(map[string]*BlogPost)
+		}
+		translated[t.Slug][t.Lang] = t
+	}
+
+	var vault *vaultIndex
+	if idx.vaultURL != "" {
+		vault = newVaultIndex(idx.dir, idx.vaultURL, visible)
+		vault.render(posts)
+		for _, versions := range translated {
+			for _, t := range versions {
+				vault.render([]*BlogPost{t})
+			}
+		}
+	}
+
+	aliases := make(map[string]string)
+	for _, post := range visible {
+		addAliases(aliases, post)
+	}
+	for _, versions := range translated {
+		for _, t := range versions {
+			addAliases(aliases, t)
+		}
+	}
+
+	idx.all = posts
+	idx.nextDue = nextDue
+	idx.posts = visible
+	idx.bySlug = bySlug
+	idx.translations = translated
+	idx.aliases = aliases
+	idx.vault = vault
+	idx.loadedAt = time.Now()
+	return nil
+}
+
+// refresh reloads the index first when auto reload is enabled, or when a
+// scheduled post has become due
+func (idx *ContentIndex) refresh() error {
+	if idx.autoReload {
+		return idx.Load()
+	}
+
+	idx.mu.RLock()
+	due := !idx.nextDue.IsZero() && !time.Now().Before(idx.nextDue)
+	idx.mu.RUnlock()
+	if due {
+		return idx.Load()
+	}
+	return nil
+}
+
+// Posts returns all indexed posts
+func (idx *ContentIndex) Posts() ([]*BlogPost, error) {
+	if err := idx.refresh(); err != nil {
+		return nil, err
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.posts, nil
+}
+
+// Post returns the post with the given slug
+func (idx *ContentIndex) Post(slug string) (*BlogPost, bool) {
+	if err := idx.refresh(); err != nil {
+		return nil, false
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	post, ok := idx.bySlug[slug]
+	return post, ok
+}
+
+// Translation returns the translation to lang of the post with the given slug
+func (idx *ContentIndex) Translation(lang, slug string) (*BlogPost, bool) {
+	if err := idx.refresh(); err != nil {
+		return nil, false
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	post, ok := idx.translations[slug][lang]
+	return post, ok
+}
+
+// Translations returns the translations of the post with the given slug,
+// keyed by language
+func (idx *ContentIndex) Translations(slug string) map[string]*BlogPost {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.translations[slug]
+}
+
+// Redirect returns the path of the post that has path as an alias
+func (idx *ContentIndex) Redirect(path string) (string, bool) {
+	if err := idx.refresh(); err != nil {
+		return "", false
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	target, ok := idx.aliases[aliasPath(path)]
+	return target, ok
+}
+
+// VaultFile returns the path in the vault of the attachment at rel, when
+// the directory is an Obsidian vault
+func (idx *ContentIndex) VaultFile(rel string) (string, bool) {
+	if err := idx.refresh(); err != nil {
+		return "", false
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if idx.vault == nil {
+		return "", false
+	}
+	return idx.vault.File(rel)
+}
+
+// LanguagePosts returns the posts in lang, in the order of Posts. Posts not
+// translated to lang are in the default language.
+func (idx *ContentIndex) LanguagePosts(lang string) ([]*BlogPost, error) {
+	if err := idx.refresh(); err != nil {
+		return nil, err
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var posts []*BlogPost
+	for _, post := range idx.posts {
+		if t, ok := idx.translations[post.Slug][lang]; ok {
+			posts = append(posts, t)
+		} else {
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
+
+// TranslatedPosts returns only the posts translated to lang, in the order of
+// Posts
+func (idx *ContentIndex) TranslatedPosts(lang string) ([]*BlogPost, error) {
+	if err := idx.refresh(); err != nil {
+		return nil, err
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var posts []*BlogPost
+	for _, post := range idx.posts {
+		if t, ok := idx.translations[post.Slug][lang]; ok {
+			posts = append(posts, t)
+		}
+	}
+	return posts, nil
+}
+
+// Status reports whether the index has loaded successfully, how many posts it
+// holds and the last load error, if any
+func (idx *ContentIndex) Status() (loaded bool, count int, err error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return !idx.loadedAt.IsZero() && idx.err == nil, len(idx.posts), idx.err
+}
+
+// AllPosts returns every post including drafts, for the admin area
+func (idx *ContentIndex) AllPosts() ([]*BlogPost, error) {
+	if err := idx.refresh(); err != nil {
+		return nil, err
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.all, nil
+}
+
+// IndexStats describes the state of the content index
+type IndexStats struct {
+	Posts      int
+	Drafts     int
+	Scheduled  int
+	LoadedAt   time.Time
+	AutoReload bool
+}
+
+// Stats returns counts and load information for the admin dashboard
+func (idx *ContentIndex) Stats() IndexStats {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	stats := IndexStats{
+		Posts:      len(idx.all),
+		LoadedAt:   idx.loadedAt,
+		AutoReload: idx.autoReload,
+	}
+	for _, post := range idx.all {
+		if post.Draft {
+			stats.Drafts++
+		}
+		if post.Scheduled() {
+			stats.Scheduled++
+		}
+	}
+	return stats
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// setFrontmatterField sets key to value in the frontmatter of the markdown
+// file at path, replacing an existing top-level key or adding it at the end.
+// The rest of the file is left untouched.
+func setFrontmatterField(path, key, value string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	content := string(data)
+	rawFrontmatter, _, err := splitFrontmatter(content)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	line := key + ": " + value
+	keyRe := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(key) + `:.*$`)
+
+	var updated string
+	if keyRe.MatchString(rawFrontmatter) {
+		updated = keyRe.ReplaceAllLiteralString(rawFrontmatter, line)
+	} else {
+		updated = strings.TrimRight(rawFrontmatter, " \t\r\n") + "\n" + line + "\n"
+	}
+
+	content = "---" + updated + content[3+len(rawFrontmatter):]
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// deleteFrontmatterField removes a top-level key from the frontmatter of the
+// markdown file at path, leaving the rest of the file untouched
+func deleteFrontmatterField(path, key string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	content := string(data)
+	rawFrontmatter, _, err := splitFrontmatter(content)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	keyRe := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(key) + `:.*\n?`)
+	updated := keyRe.ReplaceAllLiteralString(rawFrontmatter, "")
+
+	content = "---" + updated + content[3+len(rawFrontmatter):]
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// formatPostFile renders a post as markdown with YAML frontmatter
+func formatPostFile(post *BlogPost) ([]byte, error) {
+	frontmatter, err := yaml.Marshal(BlogMetadata{
+		Title:       post.Title,
+		Date:        post.Date,
+		Author:      post.Author,
+		Description: post.Description,
+		Tags:        post.Tags,
+		Slug:        post.Slug,
+		Draft:       post.Draft,
+		Series:      post.Series,
+		PublishAt:   post.PublishAt,
+		Comments:    post.Comments,
+		Dir:         post.Dir,
+		Aliases:     post.Aliases,
+		Canonical:   post.Canonical,
+		Syndication: post.Syndication,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte("---\n" + string(frontmatter) + "---\n\n" + strings.TrimSpace(post.Content) + "\n"), nil
+}
+
+// writePost writes post to path as a markdown file and re-indexes the content
+func (s *Server) writePost(path string, post *BlogPost) error {
+	data, err := formatPostFile(post)
+	if err != nil {
+		return err
+	}
+	err = s.revise(path, func() error {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, 0644)
+	})
+	if err != nil {
+		return err
+	}
+	return s.content.Load()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+// databases shares one handle per SQLite file across reloads, which build
+// new Servers but must not reopen the file each time
+var databases sync.Map
+
+// openDatabase opens or creates the SQLite database at path and brings its
+// schema up to date. migrations upgrade the schema one step at a time; the
+// database's user_version is the number of them applied.
+func openDatabase(path string, migrations []string) (*sql.DB, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if db, ok := databases.Load(path); ok {
+		return db.(*sql.DB), nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db, migrations); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error migrating %s: %v", path, err)
+	}
+
+	actual, loaded := databases.LoadOrStore(path, db)
+	if loaded {
+		db.Close()
+	}
+	return actual.(*sql.DB), nil
+}
+
+// migrate applies the migrations db has not seen yet
+func migrate(db *sql.DB, migrations []string) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("version %d: %v", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dateStyleNames are the date formats templates can ask for by name
+var dateStyleNames = []string{"short", "medium", "long", "full", "time"}
+
+// dateStyles are the Go layouts of the named date formats in each language
+// with built-in date names. Month and day names are written in English and
+// translated afterwards.
+var dateStyles = map[string]map[string]string{
+	"en": {"short": "1/2/06", "medium": "Jan 2, 2006", "long": "January 2, 2006", "full": "Monday, January 2, 2006", "time": "3:04 PM"},
+	"de": {"short": "02.01.06", "medium": "2. Jan 2006", "long": "2. January 2006", "full": "Monday, 2. January 2006", "time": "15:04"},
+	"fr": {"short": "02/01/2006", "medium": "2 Jan 2006", "long": "2 January 2006", "full": "Monday 2 January 2006", "time": "15:04"},
+	"es": {"short": "2/1/06", "medium": "2 Jan 2006", "long": "2 de January de 2006", "full": "Monday, 2 de January de 2006", "time": "15:04"},
+	"it": {"short": "02/01/06", "medium": "2 Jan 2006", "long": "2 January 2006", "full": "Monday 2 January 2006", "time": "15:04"},
+	"nl": {"short": "02-01-2006", "medium": "2 Jan 2006", "long": "2 January 2006", "full": "Monday 2 January 2006", "time": "15:04"},
+	"pt": {"short": "02/01/2006", "medium": "2 de Jan de 2006", "long": "2 de January de 2006", "full": "Monday, 2 de January de 2006", "time": "15:04"},
+}
+
+// builtinDateNames translate dateNames, in the same order, for the
+// languages of dateStyles
+var builtinDateNames = map[string][]string{
+	"de": {
+		"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli",
+		"August", "September", "Oktober", "November", "Dezember",
+		"Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag", "Sonntag",
+		"Jan.", "Feb.", "März", "Apr.", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez.",
+		"Mo.", "Di.", "Mi.", "Do.", "Fr.", "Sa.", "So.",
+	},
+	"fr": {
+		"janvier", "février", "mars", "avril", "mai", "juin", "juillet",
+		"août", "septembre", "octobre", "novembre", "décembre",
+		"lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi", "dimanche",
+		"janv.", "févr.", "mars", "avr.", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc.",
+		"lun.", "mar.", "mer.", "jeu.", "ven.", "sam.", "dim.",
+	},
+	"es": {
+		"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio",
+		"agosto", "septiembre", "octubre", "noviembre", "diciembre",
+		"lunes", "martes", "miércoles", "jueves", "viernes", "sábado", "domingo",
+		"ene", "feb", "mar", "abr", "jun", "jul", "ago", "sept", "oct", "nov", "dic",
+		"lun", "mar", "mié", "jue", "vie", "sáb", "dom",
+	},
+	"it": {
+		"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio",
+		"agosto", "settembre", "ottobre", "novembre", "dicembre",
+		"lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato", "domenica",
+		"gen", "feb", "mar", "apr", "giu", "lug", "ago", "set", "ott", "nov", "dic",
+		"lun", "mar", "mer", "gio", "ven", "sab", "dom",
+	},
+	"nl": {
+		"januari", "februari", "maart", "april", "mei", "juni", "juli",
+		"augustus", "september", "oktober", "november", "december",
+		"maandag", "dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag", "zondag",
+		"jan", "feb", "mrt", "apr", "jun", "jul", "aug", "sep", "okt", "nov", "dec",
+		"ma", "di", "wo", "do", "vr", "za", "zo",
+	},
+	"pt": {
+		"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho",
+		"agosto", "setembro", "outubro", "novembro", "dezembro",
+		"segunda-feira", "terça-feira", "quarta-feira", "quinta-feira", "sexta-feira", "sábado", "domingo",
+		"jan", "fev", "mar", "abr", "jun", "jul", "ago", "set", "out", "nov", "dez",
+		"seg", "ter", "qua", "qui", "sex", "sáb", "dom",
+	},
+}
+
+// validateDateFormat checks that i18n.date_format is a style or a layout
+func validateDateFormat(format string) error {
+	for _, style := range dateStyleNames {
+		if format == style {
+			return nil
+		}
+	}
+	// a layout writes something else for a date other than its reference
+	if time.Date(1999, 11, 28, 23, 59, 58, 0, time.UTC).Format(format) == format {
+		return fmt.Errorf("i18n.date_format %q is neither %s nor a Go time layout", format, strings.Join(dateStyleNames, ", "))
+	}
+	return nil
+}
+
+// floatingTime reports whether a frontmatter date like 2024-05-02 or
+// 2024-05-02 10:00 leaves out its time zone
+func floatingTime(raw string) bool {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return false
+	}
+	i := strings.IndexAny(raw, "Tt ")
+	return i < 0 || !strings.ContainsAny(raw[i:], "Zz+-")
+}
+
+// inLocation reads the date and clock time of t as being in loc
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	if t.IsZero() || loc == nil {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// baseLanguage returns the language of a code like pt-br
+func baseLanguage(lang string) string {
+	base, _, _ := strings.Cut(lang, "-")
+	return base
+}
+
+// dateLayout returns the layout of a style in locale, English for languages
+// without built-in formats. Anything else is taken as a layout already.
+func dateLayout(locale, style string) string {
+	for _, lang := range []string{locale, baseLanguage(locale), "en"} {
+		if styles, ok := dateStyles[lang]; ok {
+			if layout, ok := styles[style]; ok {
+				return layout
+			}
+		}
+	}
+	return style
+}
+
+// FormatDateStyle formats t in the site's i18n.date_format, or the style or
+// layout given, for locale. This is the date template function.
+func (m *messageCatalogs) FormatDateStyle(locale string, t time.Time, style ...string) string {
+	format := m.dateFormat
+	if len(style) > 0 && style[0] != "" {
+		format = style[0]
+	}
+	return m.FormatDate(locale, t, dateLayout(locale, format))
+}
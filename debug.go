@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/pprof"
+)
+
+// DebugConfig enables the diagnostics endpoints
+type DebugConfig struct {
+	// Pprof serves the Go profiler under /debug/pprof
+	Pprof bool `yaml:"pprof"`
+	// Metrics serves Prometheus metrics at /metrics
+	Metrics bool `yaml:"metrics"`
+	// Username and Password protect the endpoints. The admin login is used
+	// while they are empty, so scrapers can get credentials of their own.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// registerDebugRoutes mounts the enabled diagnostics endpoints behind basic auth
+func (s *Server) registerDebugRoutes() {
+	if s.cfg.Debug.Metrics {
+		s.app.Get("/metrics", s.requireDebugLogin(), s.handleMetrics)
+	}
+	if s.cfg.Debug.Pprof {
+		s.app.Use("/debug/pprof", s.requireDebugLogin())
+		s.app.Use(pprof.New())
+	}
+}
+
+// requireDebugLogin protects the diagnostics endpoints with HTTP basic auth
+func (s *Server) requireDebugLogin() fiber.Handler {
+	username, password := s.cfg.Debug.Username, s.cfg.Debug.Password
+	if username == "" && password == "" {
+		username, password = s.cfg.Admin.Username, s.cfg.Admin.Password
+	}
+	return basicAuth("DevDaze Debug", map[string]string{username: password})
+}
+
+// handleMetrics reports content and runtime gauges in the Prometheus text format
+func (s *Server) handleMetrics(c *fiber.Ctx) error {
+	stats := s.content.Stats()
+	info := currentBuildInfo()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	var b strings.Builder
+	metric := func(name, help string, value float64, labels string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s%s %g\n", name, help, name, name, labels, value)
+	}
+	metric("devdaze_build_info", "Version of the running binary.", 1,
+		fmt.Sprintf(`{version=%q,commit=%q,goversion=%q}`, info.Version, info.Commit, info.GoVersion))
+	metric("devdaze_posts", "Number of posts, including drafts.", float64(stats.Posts), "")
+	metric("devdaze_drafts", "Number of draft posts.", float64(stats.Drafts), "")
+	metric("devdaze_content_loaded_timestamp_seconds", "When the content index was last loaded.", float64(stats.LoadedAt.Unix()), "")
+	metric("devdaze_uptime_seconds", "Time since the server started.", time.Since(s.startedAt).Seconds(), "")
+	metric("devdaze_recent_errors", "Errors kept for the admin dashboard.", float64(len(s.errors.Recent())), "")
+	metric("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()), "")
+	metric("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(mem.Alloc), "")
+	metric("go_memstats_sys_bytes", "Number of bytes obtained from the system.", float64(mem.Sys), "")
+
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	return c.SendString(b.String())
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// netlifyAPI is the base URL of the Netlify REST API
+const netlifyAPI = "https://api.netlify.com/api/v1"
+
+// DeployOptions controls a single run of `devdaze deploy`
+type DeployOptions struct {
+	Target     string
+	Dir        string
+	Invalidate bool
+	DryRun     bool
+}
+
+// deploySite publishes a built site directory to the configured target
+func deploySite(ctx context.Context, out io.Writer, cfg *Config, opts DeployOptions) error {
+	if opts.Target == "" {
+		opts.Target = cfg.Deploy.Target
+	}
+	if opts.Dir == "" {
+		opts.Dir = cfg.Deploy.Dir
+	}
+
+	if _, err := os.Stat(filepath.Join(opts.Dir, "index.html")); err != nil {
+		return fmt.Errorf("%s does not look like a built site, run devdaze build first", opts.Dir)
+	}
+
+	switch opts.Target {
+	case "s3":
+		return deployS3(ctx, out, cfg, opts)
+	case "netlify":
+		return deployNetlify(ctx, out, cfg, opts)
+	case "github":
+		return deployGitHub(ctx, out, cfg, opts)
+	case "":
+		return fmt.Errorf("no deploy target set, use --target or deploy.target in the config")
+	default:
+		return fmt.Errorf("unknown deploy target %q (want s3, netlify or github)", opts.Target)
+	}
+}
+
+// cacheControlFor returns the Cache-Control value for a file in the build
+func cacheControlFor(rules []CacheControlRule, name string) string {
+	name = filepath.ToSlash(name)
+	for _, rule := range rules {
+		if ok, _ := path.Match(rule.Pattern, name); ok {
+			return rule.Value
+		}
+		if ok, _ := path.Match(rule.Pattern, path.Base(name)); ok {
+			return rule.Value
+		}
+	}
+	return ""
+}
+
+// siteFiles lists the files of a built site relative to dir, skipping the
+// build manifest
+func siteFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if rel != manifestFile {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return files, err
+}
+
+// deployS3 uploads changed files to the bucket, removes stale ones under the
+// prefix, or anywhere with deploy.s3.delete, and optionally invalidates the CloudFront distribution in front of it
+func deployS3(ctx context.Context, out io.Writer, cfg *Config, opts DeployOptions) error {
+	s3cfg := cfg.Deploy.S3
+	if s3cfg.Bucket == "" {
+		return fmt.Errorf("deploy.s3.bucket is not set")
+	}
+
+	var loadOpts []func(*awsconfig.LoadOptions) error
+	if s3cfg.Region != "" {
+		loadOpts = append(loadOpts, awsconfig.WithRegion(s3cfg.Region))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return fmt.Errorf("error loading AWS credentials: %v", err)
+	}
+	client := s3.NewFromConfig(awsCfg)
+
+	prefix := strings.Trim(s3cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	// Existing objects keyed by name, with their ETag (the MD5 for simple uploads)
+	existing := make(map[string]string)
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s3cfg.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing bucket %s: %v", s3cfg.Bucket, err)
+		}
+		for _, obj := range page.Contents {
+			existing[aws.ToString(obj.Key)] = strings.Trim(aws.ToString(obj.ETag), `"`)
+		}
+	}
+
+	files, err := siteFiles(opts.Dir)
+	if err != nil {
+		return err
+	}
+
+	uploaded := 0
+	for _, name := range files {
+		key := prefix + name
+		data, err := os.ReadFile(filepath.Join(opts.Dir, name))
+		if err != nil {
+			return err
+		}
+
+		sum := md5.Sum(data)
+		etag, found := existing[key]
+		delete(existing, key)
+		if found && etag == hex.EncodeToString(sum[:]) {
+			continue
+		}
+
+		uploaded++
+		if opts.DryRun {
+			fmt.Fprintf(out, "upload %s\n", key)
+			continue
+		}
+
+		input := &s3.PutObjectInput{
+			Bucket: aws.String(s3cfg.Bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(data),
+		}
+		if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+			input.ContentType = aws.String(ct)
+		}
+		if cc := cacheControlFor(cfg.Deploy.CacheControl, name); cc != "" {
+			input.CacheControl = aws.String(cc)
+		}
+		if _, err := client.PutObject(ctx, input); err != nil {
+			return fmt.Errorf("error uploading %s: %v", key, err)
+		}
+	}
+
+	// Whatever is left under the prefix is no longer part of the site. At the
+	// root of the bucket it may belong to something else, so it is only
+	// removed when the config asks for it.
+	if prefix == "" && !s3cfg.Delete && len(existing) > 0 {
+		fmt.Fprintf(out, "Kept %d objects that are not part of the build, set deploy.s3.prefix or deploy.s3.delete: true to remove them\n", len(existing))
+		existing = nil
+	}
+	keys := make([]string, 0, len(existing))
+	for key := range existing {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(out, "delete %s\n", key)
+		if opts.DryRun {
+			continue
+		}
+		_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(s3cfg.Bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("error deleting %s: %v", key, err)
+		}
+	}
+
+	fmt.Fprintf(out, "Uploaded %d files and removed %d from s3://%s/%s\n", uploaded, len(keys), s3cfg.Bucket, prefix)
+
+	if !(opts.Invalidate || s3cfg.Invalidate) || s3cfg.Distribution == "" || opts.DryRun {
+		return nil
+	}
+
+	cf := cloudfront.NewFromConfig(awsCfg)
+	paths := "/" + prefix + "*"
+	_, err = cf.CreateInvalidation(ctx, &cloudfront.CreateInvalidationInput{
+		DistributionId: aws.String(s3cfg.Distribution),
+		InvalidationBatch: &cftypes.InvalidationBatch{
+			CallerReference: aws.String(fmt.Sprintf("devdaze-%d", time.Now().UnixNano())),
+			Paths: &cftypes.Paths{
+				Quantity: aws.Int32(1),
+				Items:    []string{paths},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error invalidating CloudFront distribution %s: %v", s3cfg.Distribution, err)
+	}
+
+	fmt.Fprintf(out, "Invalidated %s on CloudFront distribution %s\n", paths, s3cfg.Distribution)
+	return nil
+}
+
+// deployNetlify uploads the site as a zip through the Netlify deploy API.
+// Cache-Control rules are passed along as a generated _headers file unless
+// the site already ships its own.
+func deployNetlify(ctx context.Context, out io.Writer, cfg *Config, opts DeployOptions) error {
+	netlify := cfg.Deploy.Netlify
+	if netlify.SiteID == "" || netlify.Token == "" {
+		return fmt.Errorf("deploy.netlify.site_id and a token (NETLIFY_AUTH_TOKEN) are required")
+	}
+
+	files, err := siteFiles(opts.Dir)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	hasHeaders := false
+	for _, name := range files {
+		if name == "_headers" {
+			hasHeaders = true
+		}
+		data, err := os.ReadFile(filepath.Join(opts.Dir, name))
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+
+	if !hasHeaders && len(cfg.Deploy.CacheControl) > 0 {
+		w, err := zw.Create("_headers")
+		if err != nil {
+			return err
+		}
+		for _, rule := range cfg.Deploy.CacheControl {
+			fmt.Fprintf(w, "/%s\n  Cache-Control: %s\n", strings.TrimPrefix(rule.Pattern, "/"), rule.Value)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	if opts.DryRun {
+		fmt.Fprintf(out, "Would upload %d files (%d bytes) to Netlify site %s\n", len(files), buf.Len(), netlify.SiteID)
+		return nil
+	}
+
+	url := fmt.Sprintf("%s/sites/%s/deploys", netlifyAPI, netlify.SiteID)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+netlify.Token)
+	req.Header.Set("Content-Type", "application/zip")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error uploading to Netlify: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("netlify deploy failed: %s: %s", resp.Status, body)
+	}
+
+	var deploy struct {
+		ID  string `json:"id"`
+		URL string `json:"deploy_ssl_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&deploy); err != nil {
+		return fmt.Errorf("error reading Netlify response: %v", err)
+	}
+
+	fmt.Fprintf(out, "Created Netlify deploy %s at %s\n", deploy.ID, deploy.URL)
+	return nil
+}
+
+// deployGitHub commits the site to a fresh repository and force pushes it to
+// the GitHub Pages branch, so the branch only ever holds the latest build
+func deployGitHub(ctx context.Context, out io.Writer, cfg *Config, opts DeployOptions) error {
+	gh := cfg.Deploy.GitHub
+	if gh.Repo == "" {
+		return fmt.Errorf("deploy.github.repo is not set")
+	}
+
+	remote := gh.Repo
+	if !strings.Contains(remote, "://") && !strings.HasPrefix(remote, "git@") {
+		if gh.Token != "" {
+			remote = fmt.Sprintf("https://x-access-token:%s@github.com/%s.git", gh.Token, gh.Repo)
+		} else {
+			remote = fmt.Sprintf("https://github.com/%s.git", gh.Repo)
+		}
+	}
+
+	workDir, err := os.MkdirTemp("", "devdaze-deploy-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	files, err := siteFiles(opts.Dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(opts.Dir, name))
+		if err != nil {
+			return err
+		}
+		if err := writeOutputFile(workDir, name, data); err != nil {
+			return err
+		}
+	}
+
+	// Stop GitHub Pages from running the site through Jekyll
+	if err := writeOutputFile(workDir, ".nojekyll", nil); err != nil {
+		return err
+	}
+
+	git := func(args ...string) error {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = workDir
+		cmd.Env = gitEnv()
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			// Never echo the remote URL, it may contain the token
+			return fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.ReplaceAll(string(output), remote, gh.Repo))
+		}
+		return nil
+	}
+
+	message := "Deploy " + time.Now().UTC().Format(time.RFC3339)
+	steps := [][]string{
+		{"init", "-q"},
+		{"checkout", "-q", "-b", gh.Branch},
+		{"add", "-A"},
+		{"commit", "-q", "-m", message},
+	}
+	if !opts.DryRun {
+		steps = append(steps, []string{"push", "-q", "--force", remote, gh.Branch})
+	}
+	for _, step := range steps {
+		if err := git(step...); err != nil {
+			return err
+		}
+	}
+
+	if opts.DryRun {
+		fmt.Fprintf(out, "Would push %d files to %s on %s\n", len(files), gh.Branch, gh.Repo)
+		return nil
+	}
+
+	fmt.Fprintf(out, "Pushed %d files to %s on %s\n", len(files), gh.Branch, gh.Repo)
+	return nil
+}
+
+// envOr returns the environment variable key, or fallback when it is unset
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
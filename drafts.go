@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// previewLinkTTL is how long a shared draft preview link stays valid
+const previewLinkTTL = 7 * 24 * time.Hour
+
+// DraftEntry is a draft or scheduled post listed in the drafts view
+type DraftEntry struct {
+	Post *BlogPost
+	// PreviewURL can be shared with reviewers who cannot sign in
+	PreviewURL string
+}
+
+// previewURL returns a signed link that shows a post before it is published
+func (s *Server) previewURL(c *fiber.Ctx, slug string) string {
+	base := s.cfg.BaseURL
+	if base == "" {
+		base = c.BaseURL()
+	}
+	token := s.signer.Sign("preview:"+slug, previewLinkTTL)
+	return base + "/preview/" + url.PathEscape(slug) + "?token=" + url.QueryEscape(token)
+}
+
+// requirePreviewAccess lets requests with a valid preview link through and
+// asks everyone else to sign in
+func (s *Server) requirePreviewAccess() fiber.Handler {
+	login := s.requireAdminLogin()
+	return func(c *fiber.Ctx) error {
+		if token := c.Query("token"); token != "" {
+			if value, ok := s.signer.Verify(token); ok && value == "preview:"+c.Params("slug") {
+				return c.Next()
+			}
+		}
+		return login(c)
+	}
+}
+
+// handleDraftList shows drafts and scheduled posts with publish actions and
+// shareable preview links
+func (s *Server) handleDraftList(c *fiber.Ctx) error {
+	posts, err := s.content.AllPosts()
+	if err != nil {
+		return s.internalError(c, "Error loading blog posts", err)
+	}
+
+	user := currentUser(c)
+	var drafts, scheduled []DraftEntry
+	for _, post := range posts {
+		if !user.canEdit(post) {
+			continue
+		}
+		entry := DraftEntry{Post: post, PreviewURL: s.previewURL(c, post.Slug)}
+		switch {
+		case post.Draft:
+			drafts = append(drafts, entry)
+		case post.Scheduled():
+			scheduled = append(scheduled, entry)
+		}
+	}
+	sort.Slice(scheduled, func(i, j int) bool {
+		return scheduled[i].Post.Date.Before(scheduled[j].Post.Date)
+	})
+
+	return c.Render("admin_drafts", fiber.Map{
+		"Title":     "Drafts",
+		"Notice":    c.Query("notice"),
+		"Drafts":    drafts,
+		"Scheduled": scheduled,
+		"Now":       time.Now().In(s.cfg.Location()).Format("2006-01-02T15:04"),
+	})
+}
+
+// handleAdminSchedule sets the publish date of a post and clears its draft
+// flag, so it goes live once the date has passed
+func (s *Server) handleAdminSchedule(c *fiber.Ctx) error {
+	post := s.findPost(c.Params("slug"))
+	if post == nil {
+		return fiber.ErrNotFound
+	}
+
+	at, err := time.ParseInLocation("2006-01-02T15:04", c.FormValue("at"), s.cfg.Location())
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Expected a date and time to publish at")
+	}
+
+	err = s.revise(post.FilePath, func() error {
+		if err := setFrontmatterField(post.FilePath, "date", at.Format(time.RFC3339)); err != nil {
+			return err
+		}
+		return setFrontmatterField(post.FilePath, "draft", "false")
+	})
+	if err != nil {
+		return s.internalError(c, "Scheduling failed", err)
+	}
+	if err := s.content.Load(); err != nil {
+		return s.internalError(c, "Scheduling failed", err)
+	}
+
+	requestLogger(c).Info("Scheduled post", "slug", post.Slug, "at", at)
+	s.recordEdit(c, "Schedule %s for %s", post.Slug, at.Format(time.RFC3339))
+	notice := "Scheduled " + post.Title + " for " + at.Format("Jan 2, 2006 15:04")
+	return c.Redirect("/admin/drafts?notice="+url.QueryEscape(notice), fiber.StatusSeeOther)
+}
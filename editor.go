@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ContentFile is a markdown file listed in the editor
+type ContentFile struct {
+	Path   string
+	Title  string
+	Author string
+	Draft  bool
+	Error  string
+}
+
+// resolveContentPath maps a path relative to the content directory onto the
+// filesystem, rejecting anything that is not a markdown file inside it
+func resolveContentPath(contentDir, rel string) (string, error) {
+	rel = filepath.Clean("/" + filepath.FromSlash(rel))[1:]
+	if rel == "" || !strings.HasSuffix(rel, ".md") {
+		return "", fmt.Errorf("%q is not a markdown file", rel)
+	}
+	if first, _, _ := strings.Cut(filepath.ToSlash(rel), "/"); internalDir(first) {
+		return "", fmt.Errorf("%q is in the trash or revision history", rel)
+	}
+	return filepath.Join(contentDir, rel), nil
+}
+
+// internalDir reports whether a directory in the content directory holds
+// DevDaze's own copies of posts rather than content
+func internalDir(name string) bool {
+	return name == trashDir || name == revisionsDir
+}
+
+// listContentFiles returns every markdown file under the content directory
+func listContentFiles(contentDir string) ([]ContentFile, error) {
+	var files []ContentFile
+	err := filepath.WalkDir(contentDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && internalDir(d.Name()) {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".md") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(contentDir, path)
+		if err != nil {
+			return err
+		}
+
+		file := ContentFile{Path: filepath.ToSlash(rel)}
+		content, err := os.ReadFile(path)
+		if err == nil {
+			var post *BlogPost
+			if post, err = parseMarkdownFile(content, time.UTC); err == nil {
+				file.Title = post.Title
+				file.Author = post.Author
+				file.Draft = post.Draft
+			}
+		}
+		if err != nil {
+			file.Error = err.Error()
+		}
+
+		files = append(files, file)
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return files, err
+}
+
+// editorPath returns the content path from a /admin/editor/* route
+func editorPath(c *fiber.Ctx) string {
+	rel, err := url.PathUnescape(c.Params("*"))
+	if err != nil {
+		return c.Params("*")
+	}
+	return rel
+}
+
+// handleEditorList shows every content file with links to edit them
+func (s *Server) handleEditorList(c *fiber.Ctx) error {
+	files, err := listContentFiles(s.cfg.ContentDir)
+	if err != nil {
+		return s.internalError(c, "Error listing content", err)
+	}
+
+	// Authors only see their own drafts
+	user := currentUser(c)
+	visible := files[:0]
+	for _, f := range files {
+		if user.IsAdmin() || (f.Error == "" && user.canEdit(&BlogPost{Draft: f.Draft, Author: f.Author})) {
+			visible = append(visible, f)
+		}
+	}
+
+	return c.Render("admin_editor_list", fiber.Map{
+		"Title":  "Editor",
+		"Notice": c.Query("notice"),
+		"Files":  visible,
+	})
+}
+
+// handleEditorCreate starts editing a new file named after the submitted slug
+func (s *Server) handleEditorCreate(c *fiber.Ctx) error {
+	slug := slugify(c.FormValue("title"))
+	if slug == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "A title is required")
+	}
+	return c.Redirect("/admin/editor/"+slug+".md?title="+url.QueryEscape(c.FormValue("title")), fiber.StatusSeeOther)
+}
+
+// handleEditorEdit shows a file in the editor, pre-filling new files from
+// the post template
+func (s *Server) handleEditorEdit(c *fiber.Ctx) error {
+	rel := editorPath(c)
+	path, err := resolveContentPath(s.cfg.ContentDir, rel)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := s.checkEditable(c, path); err != nil {
+		return err
+	}
+
+	exists := true
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		exists = false
+		opts := NewPostOptions{
+			Title: c.Query("title"),
+			Slug:  strings.TrimSuffix(filepath.Base(path), ".md"),
+		}
+		// Authors start from a draft of their own
+		if user := currentUser(c); !user.IsAdmin() {
+			opts.Author = user.Author
+			opts.Draft = true
+		}
+		content, err = renderPostTemplate(s.cfg, &opts)
+	}
+	if err != nil {
+		return s.internalError(c, "Error opening file", err)
+	}
+
+	return c.Render("admin_editor", fiber.Map{
+		"Title":   "Editing " + rel,
+		"Path":    rel,
+		"Exists":  exists,
+		"Content": string(content),
+		"Notice":  c.Query("notice"),
+	})
+}
+
+// handleEditorSave writes the submitted markdown back to the content directory
+func (s *Server) handleEditorSave(c *fiber.Ctx) error {
+	rel := editorPath(c)
+	path, err := resolveContentPath(s.cfg.ContentDir, rel)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := s.checkEditable(c, path); err != nil {
+		return err
+	}
+
+	// Normalize browser line endings before saving
+	content := strings.ReplaceAll(c.FormValue("content"), "\r\n", "\n")
+	post, err := parseMarkdownFile([]byte(content), s.cfg.Location())
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "Not saved: "+err.Error())
+	}
+	if user := currentUser(c); !user.canEdit(post) {
+		return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("Not saved: authors can only save drafts with author %q", user.Author))
+	}
+
+	verb := "Update"
+	if !fileExists(path) {
+		verb = "Create"
+	}
+	before := s.findPostFile(path)
+	err = s.revise(path, func() error {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(path, []byte(content), 0644)
+	})
+	if err != nil {
+		return s.internalError(c, "Error saving file", err)
+	}
+	if err := s.content.Load(); err != nil {
+		return s.internalError(c, "Saved, but re-indexing failed", err)
+	}
+
+	requestLogger(c).Info("Saved content file", "path", rel)
+	s.recordEdit(c, "%s %s\n\nSaved in the admin editor.", verb, rel)
+	s.postChanged(before, s.findPostFile(path))
+	return c.Redirect("/admin/editor/"+rel+"?notice=Saved", fiber.StatusSeeOther)
+}
+
+// handlePreview renders markdown, with or without frontmatter, through the
+// same pipeline as published posts
+func (s *Server) handlePreview(c *fiber.Ctx) error {
+	source := string(c.Body())
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationForm) {
+		source = c.FormValue("content")
+	}
+
+	if !strings.HasPrefix(source, "---") {
+		return c.JSON(fiber.Map{"html": renderMarkdown(source)})
+	}
+
+	post, err := parseMarkdownFile([]byte(source), s.cfg.Location())
+	if err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{
+		"html":  post.HTMLContent,
+		"title": post.Title,
+		"draft": post.Draft,
+	})
+}
+
+// checkEditable rejects editing an existing file the signed-in user does not own
+func (s *Server) checkEditable(c *fiber.Ctx, path string) error {
+	user := currentUser(c)
+	if user.IsAdmin() {
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening file: %v", err)
+	}
+	post, err := parseMarkdownFile(content, s.cfg.Location())
+	if err != nil || !user.canEdit(post) {
+		return fiber.NewError(fiber.StatusForbidden, "Authors can only edit their own drafts")
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveContentPath(t *testing.T) {
+	dir := filepath.FromSlash("/srv/content")
+	tests := []struct {
+		rel     string
+		want    string
+		wantErr bool
+	}{
+		{rel: "hello.md", want: "hello.md"},
+		{rel: "de/hello.md", want: "de/hello.md"},
+		{rel: "/hello.md", want: "hello.md"},
+		{rel: "../../etc/passwd.md", want: "etc/passwd.md"},
+		{rel: "drafts/../hello.md", want: "hello.md"},
+		{rel: "", wantErr: true},
+		{rel: "/", wantErr: true},
+		{rel: "notes.txt", wantErr: true},
+		{rel: "../config.yaml", wantErr: true},
+		{rel: ".trash/hello.md", wantErr: true},
+		{rel: ".revisions/hello.md", wantErr: true},
+		{rel: "drafts/../.trash/hello.md", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveContentPath(dir, tt.rel)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("resolveContentPath(%q) = %q, want an error", tt.rel, got)
+			}
+			continue
+		}
+		if want := filepath.Join(dir, filepath.FromSlash(tt.want)); err != nil || got != want {
+			t.Errorf("resolveContentPath(%q) = %q, %v, want %q", tt.rel, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// emailLayout is the template every email is rendered inside, with the
+// email's own template, like emails/comment, as its content
+const emailLayout = "emails/layout"
+
+// renderEmail renders the HTML version of an email from emails/<name>.html
+// inside the email layout. Like pages, the templates come from the theme
+// layers, so a theme or the override folder can restyle them.
+func (s *Server) renderEmail(name, subject string, data fiber.Map) (string, error) {
+	data["Subject"] = subject
+	data["Site"] = s.cfg.Title
+	if s.cfg.BaseURL != "" {
+		data["SiteURL"] = s.absoluteURL(nil, "/")
+	}
+	var body bytes.Buffer
+	if err := s.engine.Render(&body, "emails/"+name, data, emailLayout); err != nil {
+		return "", fmt.Errorf("rendering emails/%s: %v", name, err)
+	}
+	return body.String(), nil
+}
+
+// withHTML adds the HTML version from emails/<name>.html to a plain text
+// email. If the template fails the email is still sent as plain text, which
+// says the same.
+func (s *Server) withHTML(m outgoingMail, name string, data fiber.Map) outgoingMail {
+	html, err := s.renderEmail(name, m.Subject, data)
+	if err != nil {
+		slog.Error("Error rendering email", "template", name, "error", err)
+		return m
+	}
+	m.HTML = html
+	return m
+}
+
+// emailPreviews are the emails the admin area shows with sample data
+var emailPreviews = []string{"newsletter", "confirm", "comment", "reply", "contact"}
+
+// previewEmail renders one of the emailPreviews about the latest post, or a
+// made-up one before there are posts. The sample comment has no ID, so the
+// moderation links in its preview match no comment.
+func (s *Server) previewEmail(c *fiber.Ctx, name string) (outgoingMail, error) {
+	post := &BlogPost{
+		Title:       "An example post",
+		Slug:        "example",
+		Date:        time.Now(),
+		Author:      s.cfg.Author,
+		HTMLContent: "<p>The content of the post shows here.</p>",
+	}
+	if posts, err := s.content.Posts(); err == nil && len(posts) > 0 {
+		post = posts[0]
+	}
+	comment := &Comment{
+		Post:   post.Slug,
+		Author: "Ada Lovelace",
+		Email:  "ada@example.com",
+		Body:   "Thanks for writing this up!\n\nOne question: does it work offline too?",
+		Status: CommentPending,
+		IP:     "203.0.113.7",
+	}
+
+	switch name {
+	case "newsletter":
+		return s.newsletterMail(post, NewsletterSend{Email: "reader@example.com", Token: "preview"})
+	case "confirm":
+		return s.confirmMail(c, &Subscriber{Email: "reader@example.com", Token: "preview"}), nil
+	case "comment":
+		return s.commentMail(c, post, comment, s.cfg.Comments.Notify), nil
+	case "reply":
+		return s.replyMail(c, post, comment, "reader@example.com"), nil
+	case "contact":
+		form := contactForm{Name: comment.Author, Email: comment.Email, Message: comment.Body}
+		return s.contactMail(form, comment.IP), nil
+	}
+	return outgoingMail{}, fiber.ErrNotFound
+}
+
+// handleEmailPreviews lists the emails the site sends, showing one of them
+func (s *Server) handleEmailPreviews(c *fiber.Ctx) error {
+	type preview struct {
+		Name, Subject string
+	}
+	var previews []preview
+	for _, name := range emailPreviews {
+		m, err := s.previewEmail(c, name)
+		if err != nil {
+			return s.internalError(c, "Error rendering email", err)
+		}
+		previews = append(previews, preview{name, m.Subject})
+	}
+	shown := c.Query("email", emailPreviews[0])
+	return c.Render("admin_emails", fiber.Map{
+		"Title":    "Emails",
+		"Previews": previews,
+		"Shown":    shown,
+	})
+}
+
+// handleEmailPreview shows an email with sample data, as HTML or with
+// ?format=text as its plain text version
+func (s *Server) handleEmailPreview(c *fiber.Ctx) error {
+	m, err := s.previewEmail(c, c.Params("name"))
+	if err == fiber.ErrNotFound {
+		return fiber.NewError(fiber.StatusNotFound, "No such email")
+	}
+	if err != nil {
+		return s.internalError(c, "Error rendering email", err)
+	}
+	if c.Query("format") == "text" || m.HTML == "" {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+		return c.SendString(m.Text)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.SendString(m.HTML)
+}
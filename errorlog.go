@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrorEntry is one error recorded for the admin dashboard
+type ErrorEntry struct {
+	Time      time.Time
+	RequestID string
+	Method    string
+	Path      string
+	Message   string
+}
+
+// errorLog is an ErrorReporter that keeps the most recent errors in memory
+type errorLog struct {
+	mu      sync.Mutex
+	entries []ErrorEntry
+	size    int
+}
+
+// newErrorLog keeps up to size entries
+func newErrorLog(size int) *errorLog {
+	return &errorLog{size: size}
+}
+
+func (l *errorLog) Report(c *fiber.Ctx, err error) {
+	entry := ErrorEntry{
+		Time:      time.Now(),
+		RequestID: requestID(c),
+		Method:    c.Method(),
+		Path:      c.Path(),
+		Message:   err.Error(),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > l.size {
+		l.entries = l.entries[len(l.entries)-l.size:]
+	}
+}
+
+func (l *errorLog) Flush(timeout time.Duration) {}
+
+// Recent returns the recorded errors, newest first
+func (l *errorLog) Recent() []ErrorEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	recent := make([]ErrorEntry, len(l.entries))
+	for i, e := range l.entries {
+		recent[len(l.entries)-1-i] = e
+	}
+	return recent
+}
+
+// multiReporter fans each report out to several reporters
+type multiReporter []ErrorReporter
+
+func (m multiReporter) Report(c *fiber.Ctx, err error) {
+	for _, r := range m {
+		r.Report(c, err)
+	}
+}
+
+func (m multiReporter) Flush(timeout time.Duration) {
+	for _, r := range m {
+		r.Flush(timeout)
+	}
+}
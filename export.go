@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ExportOptions controls an export of the content as the source of another
+// static site generator
+type ExportOptions struct {
+	// Format is the generator to export for: hugo or jekyll
+	Format string
+	// OutputDir is the directory the site is written to
+	OutputDir string
+}
+
+// exportSite writes every post, draft and translation as a Hugo or Jekyll
+// site, with a config that keeps the /blog/my-post URLs, and copies the media
+// directory into it
+func exportSite(out io.Writer, cfg *Config, opts ExportOptions) error {
+	var render func(post *BlogPost) (string, yaml.MapSlice)
+	var configFile string
+	var siteConfig yaml.MapSlice
+	mediaPrefix := strings.Trim(cfg.Media.URL, "/")
+	switch opts.Format {
+	case "hugo":
+		render, configFile, siteConfig = exportHugoPost, "hugo.yaml", hugoConfig(cfg)
+		mediaPrefix = filepath.Join("static", mediaPrefix)
+	case "jekyll":
+		render, configFile, siteConfig = exportJekyllPost, "_config.yml", jekyllConfig(cfg)
+	default:
+		return fmt.Errorf("unknown export format %q, expected hugo or jekyll", opts.Format)
+	}
+	if err := checkOutputDir(cfg, opts.OutputDir); err != nil {
+		return err
+	}
+
+	posts, err := readPosts(cfg)
+	if err != nil {
+		return fmt.Errorf("error loading blog posts: %v", err)
+	}
+	translations, err := getTranslatedPosts(cfg.ContentDir, cfg.I18n.Languages, cfg.Location())
+	if err != nil {
+		return fmt.Errorf("error loading translations: %v", err)
+	}
+	translations = linkTranslations(posts, translations, cfg.I18n.Languages)
+
+	aliases := false
+	for _, post := range append(posts, translations...) {
+		name, frontmatter := render(post)
+		data, err := yaml.Marshal(frontmatter)
+		if err != nil {
+			return fmt.Errorf("error exporting %s: %v", post.FilePath, err)
+		}
+		body := "---\n" + string(data) + "---\n\n" + strings.TrimSpace(post.Content) + "\n"
+		if err := writeOutputFile(opts.OutputDir, name, []byte(body)); err != nil {
+			return err
+		}
+		aliases = aliases || len(post.Aliases) > 0
+	}
+
+	// Jekyll needs a plugin for redirect_from
+	if opts.Format == "jekyll" && aliases {
+		siteConfig = append(siteConfig, yaml.MapItem{Key: "plugins", Value: []string{"jekyll-redirect-from"}})
+	}
+	written := "written"
+	if fileExists(filepath.Join(opts.OutputDir, configFile)) {
+		written = "kept as it was"
+	} else {
+		data, err := yaml.Marshal(siteConfig)
+		if err != nil {
+			return err
+		}
+		if err := writeOutputFile(opts.OutputDir, configFile, data); err != nil {
+			return err
+		}
+	}
+
+	manifest := &buildManifest{Assets: make(map[string]string)}
+	copied, err := copyAssets(cfg.Media.Dir, opts.OutputDir, mediaPrefix, &buildManifest{}, manifest)
+	if err != nil {
+		return fmt.Errorf("error copying media: %v", err)
+	}
+
+	fmt.Fprintf(out, "Exported %d posts and %d translations into %s (%s %s, %d media files copied)\n",
+		len(posts), len(translations), opts.OutputDir, configFile, written, copied)
+	return nil
+}
+
+// exportFrontmatter is the frontmatter both generators read the same way
+func exportFrontmatter(post *BlogPost) yaml.MapSlice {
+	fm := yaml.MapSlice{
+		{Key: "title", Value: post.Title},
+		{Key: "date", Value: post.Date},
+	}
+	optional := []yaml.MapItem{
+		{Key: "author", Value: post.Author},
+		{Key: "description", Value: post.Description},
+		{Key: "tags", Value: post.Tags},
+		{Key: "series", Value: post.Series},
+		{Key: "canonical", Value: post.Canonical},
+		{Key: "dir", Value: post.Dir},
+	}
+	for _, item := range optional {
+		switch v := item.Value.(type) {
+		case string:
+			if v == "" {
+				continue
+			}
+		case []string:
+			if len(v) == 0 {
+				continue
+			}
+		}
+		fm = append(fm, item)
+	}
+	if !post.CommentsAllowed() {
+		fm = append(fm, yaml.MapItem{Key: "comments", Value: false})
+	}
+	return fm
+}
+
+// exportHugoPost places a post in the posts section, next to its
+// translations as my-post.de.md. Drafts scheduled with publish_at become
+// posts dated in the future, which Hugo leaves out until then.
+func exportHugoPost(post *BlogPost) (string, yaml.MapSlice) {
+	fm := exportFrontmatter(post)
+	fm = append(fm, yaml.MapItem{Key: "slug", Value: post.Slug})
+	if post.Series != "" {
+		// Series is a taxonomy, which Hugo reads as a list
+		for i := range fm {
+			if fm[i].Key == "series" {
+				fm[i].Value = []string{post.Series}
+			}
+		}
+	}
+	switch {
+	case post.Draft && !post.PublishAt.IsZero():
+		fm = append(fm, yaml.MapItem{Key: "publishDate", Value: post.PublishAt})
+	case post.Draft:
+		fm = append(fm, yaml.MapItem{Key: "draft", Value: true})
+	}
+	if len(post.Aliases) > 0 {
+		fm = append(fm, yaml.MapItem{Key: "aliases", Value: post.Aliases})
+	}
+
+	name := post.Slug + ".md"
+	if post.Lang != "" {
+		name = post.Slug + "." + post.Lang + ".md"
+	}
+	return filepath.Join("content", "posts", name), fm
+}
+
+// exportJekyllPost places a post in _posts, or _drafts while it is a draft.
+// Translations are posts of their own, at the URL of their language.
+func exportJekyllPost(post *BlogPost) (string, yaml.MapSlice) {
+	scheduled := post.Draft && !post.PublishAt.IsZero()
+	if scheduled {
+		// Jekyll leaves posts dated in the future out until then
+		p := *post
+		p.Date = post.PublishAt
+		post = &p
+	}
+	fm := append(yaml.MapSlice{{Key: "layout", Value: "post"}}, exportFrontmatter(post)...)
+	if len(post.Aliases) > 0 {
+		fm = append(fm, yaml.MapItem{Key: "redirect_from", Value: post.Aliases})
+	}
+
+	name := post.Slug
+	if post.Lang != "" {
+		name += "-" + post.Lang
+		fm = append(fm,
+			yaml.MapItem{Key: "lang", Value: post.Lang},
+			yaml.MapItem{Key: "permalink", Value: postPath(post) + "/"},
+		)
+	}
+	if post.Draft && !scheduled {
+		return filepath.Join("_drafts", name+".md"), fm
+	}
+	return filepath.Join("_posts", post.Date.Format("2006-01-02")+"-"+name+".md"), fm
+}
+
+// hugoConfig is the config of an exported Hugo site
+func hugoConfig(cfg *Config) yaml.MapSlice {
+	site := yaml.MapSlice{
+		{Key: "baseURL", Value: cfg.BaseURL},
+		{Key: "title", Value: cfg.Title},
+		{Key: "languageCode", Value: cfg.I18n.DefaultLanguage},
+		{Key: "defaultContentLanguage", Value: cfg.I18n.DefaultLanguage},
+		{Key: "permalinks", Value: yaml.MapSlice{{Key: "posts", Value: "/blog/:slug/"}}},
+		{Key: "taxonomies", Value: yaml.MapSlice{{Key: "tag", Value: "tags"}, {Key: "series", Value: "series"}}},
+	}
+	if cfg.Timezone != "" {
+		site = append(site, yaml.MapItem{Key: "timeZone", Value: cfg.Timezone})
+	}
+	if len(cfg.I18n.Languages) > 0 {
+		languages := yaml.MapSlice{{Key: cfg.I18n.DefaultLanguage, Value: yaml.MapSlice{{Key: "weight", Value: 1}}}}
+		for i, lang := range cfg.I18n.Languages {
+			languages = append(languages, yaml.MapItem{Key: lang, Value: yaml.MapSlice{
+				{Key: "weight", Value: i + 2},
+				{Key: "languageName", Value: languageName(lang)},
+				{Key: "languageDirection", Value: textDirection(lang)},
+			}})
+		}
+		site = append(site, yaml.MapItem{Key: "languages", Value: languages})
+	}
+	return site
+}
+
+// jekyllConfig is the config of an exported Jekyll site
+func jekyllConfig(cfg *Config) yaml.MapSlice {
+	site := yaml.MapSlice{
+		{Key: "title", Value: cfg.Title},
+		{Key: "url", Value: strings.TrimSuffix(cfg.BaseURL, "/")},
+		{Key: "lang", Value: cfg.I18n.DefaultLanguage},
+		{Key: "permalink", Value: "/blog/:title/"},
+	}
+	if cfg.Timezone != "" {
+		site = append(site, yaml.MapItem{Key: "timezone", Value: cfg.Timezone})
+	}
+	return site
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// feedSize is the number of latest posts the feeds list
+const feedSize = 20
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Lang    string      `xml:"xml:lang,attr,omitempty"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel      string `xml:"rel,attr,omitempty"`
+	Type     string `xml:"type,attr,omitempty"`
+	Hreflang string `xml:"hreflang,attr,omitempty"`
+	Href     string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Link      atomLink    `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    *atomAuthor `xml:"author,omitempty"`
+	Summary   string      `xml:"summary,omitempty"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	NS       string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapRef `xml:"sitemap"`
+}
+
+type sitemapRef struct {
+	Loc string `xml:"loc"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	NS      string       `xml:"xmlns,attr"`
+	XHTML   string       `xml:"xmlns:xhtml,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+	// Alternates are the versions of the page in every language
+	Alternates []sitemapLink `xml:"xhtml:link"`
+}
+
+type sitemapLink struct {
+	Rel      string `xml:"rel,attr"`
+	Hreflang string `xml:"hreflang,attr"`
+	Href     string `xml:"href,attr"`
+}
+
+// feedLink is a feed the head of every page points feed readers at
+type feedLink struct {
+	Type  string
+	Lang  string
+	Title string
+	URL   string
+}
+
+// siteLanguages returns the default language followed by the languages
+// posts are translated to
+func (s *Server) siteLanguages() []string {
+	return append([]string{s.cfg.I18n.DefaultLanguage}, s.cfg.I18n.Languages...)
+}
+
+// sitePrefix returns the path prefix of lang, empty for the default language
+func (s *Server) sitePrefix(lang string) string {
+	if lang == s.cfg.I18n.DefaultLanguage {
+		return ""
+	}
+	return languagePrefix(lang)
+}
+
+// registerFeedRoutes serves an RSS and an Atom feed and a sitemap for every
+// language, and lists the feeds to every page as Feeds
+func (s *Server) registerFeedRoutes() {
+	s.app.Use(func(c *fiber.Ctx) error {
+		c.Locals("Feeds", s.feedLinks(c))
+		return c.Next()
+	})
+	for _, lang := range s.siteLanguages() {
+		prefix := s.sitePrefix(lang)
+		s.app.Get(prefix+"/feed.rss", s.handleRSSFeed(lang))
+		s.app.Get(prefix+"/feed.atom", s.handleAtomFeed(lang))
+		s.app.Get("/sitemap-"+lang+".xml", s.handleSitemap(lang))
+	}
+	s.app.Get("/sitemap.xml", s.handleSitemapIndex)
+}
+
+// feedLinks lists the RSS feed of every language, the one of the page's
+// language first so feed readers pick it
+func (s *Server) feedLinks(c *fiber.Ctx) []feedLink {
+	current := s.pathLanguage(c)
+	if current == "" {
+		current = s.cfg.I18n.DefaultLanguage
+	}
+	var links []feedLink
+	for _, lang := range s.siteLanguages() {
+		link := feedLink{
+			Type:  "application/rss+xml",
+			Lang:  lang,
+			Title: s.feedTitle(lang),
+			URL:   s.sitePrefix(lang) + "/feed.rss",
+		}
+		if lang == current {
+			links = append([]feedLink{link}, links...)
+		} else {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// feedTitle names the feed of lang after the site, and its language when
+// there are several
+func (s *Server) feedTitle(lang string) string {
+	if len(s.cfg.I18n.Languages) == 0 {
+		return s.cfg.Title
+	}
+	return s.cfg.Title + " (" + languageName(lang) + ")"
+}
+
+// publishedIn returns the published posts in lang. Posts that are not
+// translated are left out of the other languages.
+func (s *Server) publishedIn(lang string) ([]*BlogPost, error) {
+	var posts []*BlogPost
+	var err error
+	if lang == s.cfg.I18n.DefaultLanguage {
+		posts, err = s.content.Posts()
+	} else {
+		posts, err = s.content.TranslatedPosts(lang)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var visible []*BlogPost
+	for _, post := range posts {
+		if apiVisible(post) {
+			visible = append(visible, post)
+		}
+	}
+	return visible, nil
+}
+
+// feedPosts returns the latest published posts in lang, newest first
+func (s *Server) feedPosts(lang string) ([]*BlogPost, error) {
+	visible, err := s.publishedIn(lang)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(visible, func(i, j int) bool { return visible[i].Date.After(visible[j].Date) })
+	if len(visible) > feedSize {
+		visible = visible[:feedSize]
+	}
+	return visible, nil
+}
+
+// feedAlternates links a feed to itself and to the feeds of the other
+// languages, named like /de/feed.rss
+func (s *Server) feedAlternates(c *fiber.Ctx, lang, name, mimeType string) []atomLink {
+	links := []atomLink{{Rel: "self", Type: mimeType, Href: s.absoluteURL(c, s.sitePrefix(lang)+name)}}
+	for _, other := range s.siteLanguages() {
+		if other != lang {
+			links = append(links, atomLink{
+				Rel:      "alternate",
+				Type:     mimeType,
+				Hreflang: other,
+				Href:     s.absoluteURL(c, s.sitePrefix(other)+name),
+			})
+		}
+	}
+	return links
+}
+
+// feedContent returns the HTML of a post with absolute links and images
+func (s *Server) feedContent(post *BlogPost, link string) (string, error) {
+	base, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	return absolutizeHTML(s.images.Rewrite(post.HTMLContent), base), nil
+}
+
+// handleRSSFeed serves the latest posts in lang as an RSS feed
+func (s *Server) handleRSSFeed(lang string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		posts, err := s.feedPosts(lang)
+		if err != nil {
+			return s.internalError(c, "Error loading blog posts", err)
+		}
+
+		channel := rssChannel{
+			Title:       s.feedTitle(lang),
+			Link:        s.absoluteURL(c, s.sitePrefix(lang)+"/blog"),
+			Description: "The latest posts of " + s.cfg.Title,
+			Language:    lang,
+			Links:       s.feedAlternates(c, lang, "/feed.rss", "application/rss+xml"),
+		}
+		for _, post := range posts {
+			link := s.absoluteURL(c, s.sitePrefix(lang)+"/blog/"+post.Slug)
+			content, err := s.feedContent(post, link)
+			if err != nil {
+				return s.internalError(c, "Error building feed", err)
+			}
+			channel.Items = append(channel.Items, rssItem{
+				Title:       post.Title,
+				Link:        link,
+				GUID:        link,
+				Creator:     post.Author,
+				PubDate:     post.Date.In(s.cfg.Location()).Format(time.RFC1123Z),
+				Description: post.Description,
+				Content:     content,
+			})
+		}
+
+		body, err := xml.MarshalIndent(rssFeed{
+			Version: "2.0",
+			DC:      "http://purl.org/dc/elements/1.1/",
+			Content: "http://purl.org/rss/1.0/modules/content/",
+			Atom:    "http://www.w3.org/2005/Atom",
+			Channel: channel,
+		}, "", "  ")
+		if err != nil {
+			return s.internalError(c, "Error encoding feed", err)
+		}
+		c.Set(fiber.HeaderContentType, "application/rss+xml; charset=utf-8")
+		return c.Send(append([]byte(xml.Header), body...))
+	}
+}
+
+// handleAtomFeed serves the latest posts in lang as an Atom feed
+func (s *Server) handleAtomFeed(lang string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		posts, err := s.feedPosts(lang)
+		if err != nil {
+			return s.internalError(c, "Error loading blog posts", err)
+		}
+
+		feed := atomFeed{
+			Lang:    lang,
+			Title:   s.feedTitle(lang),
+			ID:      s.absoluteURL(c, s.sitePrefix(lang)+"/feed.atom"),
+			Updated: time.Now().In(s.cfg.Location()).Format(time.RFC3339),
+			Links: append(s.feedAlternates(c, lang, "/feed.atom", "application/atom+xml"),
+				atomLink{Rel: "alternate", Type: "text/html", Href: s.absoluteURL(c, s.sitePrefix(lang)+"/blog")}),
+		}
+		if len(posts) > 0 {
+			feed.Updated = posts[0].Date.In(s.cfg.Location()).Format(time.RFC3339)
+		}
+		for _, post := range posts {
+			link := s.absoluteURL(c, s.sitePrefix(lang)+"/blog/"+post.Slug)
+			content, err := s.feedContent(post, link)
+			if err != nil {
+				return s.internalError(c, "Error building feed", err)
+			}
+			date := post.Date.In(s.cfg.Location()).Format(time.RFC3339)
+			entry := atomEntry{
+				Title:     post.Title,
+				ID:        link,
+				Link:      atomLink{Rel: "alternate", Type: "text/html", Href: link},
+				Published: date,
+				Updated:   date,
+				Summary:   post.Description,
+				Content:   atomContent{Type: "html", Body: content},
+			}
+			if post.Author != "" {
+				entry.Author = &atomAuthor{Name: post.Author}
+			}
+			feed.Entries = append(feed.Entries, entry)
+		}
+
+		body, err := xml.MarshalIndent(feed, "", "  ")
+		if err != nil {
+			return s.internalError(c, "Error encoding feed", err)
+		}
+		c.Set(fiber.HeaderContentType, "application/atom+xml; charset=utf-8")
+		return c.Send(append([]byte(xml.Header), body...))
+	}
+}
+
+// handleSitemapIndex lists the sitemap of every language
+func (s *Server) handleSitemapIndex(c *fiber.Ctx) error {
+	index := sitemapIndex{NS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, lang := range s.siteLanguages() {
+		index.Sitemaps = append(index.Sitemaps, sitemapRef{Loc: s.absoluteURL(c, "/sitemap-"+lang+".xml")})
+	}
+	return s.sendXML(c, index)
+}
+
+// handleSitemap lists the pages in lang, each with its versions in the other
+// languages. Posts shown in the default language because they are not
+// translated are left out, their canonical page is in the default sitemap.
+func (s *Server) handleSitemap(lang string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		posts, err := s.publishedIn(lang)
+		if err != nil {
+			return s.internalError(c, "Error loading blog posts", err)
+		}
+
+		set := sitemapURLSet{NS: "http://www.sitemaps.org/schemas/sitemap/0.9", XHTML: "http://www.w3.org/1999/xhtml"}
+		var listing []sitemapLink
+		if len(s.cfg.I18n.Languages) > 0 {
+			for _, other := range s.siteLanguages() {
+				listing = append(listing, sitemapLink{Rel: "alternate", Hreflang: other, Href: s.absoluteURL(c, s.sitePrefix(other)+"/blog")})
+			}
+			listing = append(listing, sitemapLink{Rel: "alternate", Hreflang: "x-default", Href: s.absoluteURL(c, "/blog")})
+		}
+		if lang == s.cfg.I18n.DefaultLanguage {
+			set.URLs = append(set.URLs, sitemapURL{Loc: s.absoluteURL(c, "/")})
+		}
+		set.URLs = append(set.URLs, sitemapURL{Loc: s.absoluteURL(c, s.sitePrefix(lang)+"/blog"), Alternates: listing})
+
+		for _, post := range posts {
+			entry := sitemapURL{
+				Loc:     s.absoluteURL(c, s.sitePrefix(lang)+"/blog/"+post.Slug),
+				LastMod: post.Date.In(s.cfg.Location()).Format(time.RFC3339),
+			}
+			if versions := s.languageLinks(c, post); len(versions) > 0 {
+				for _, v := range versions {
+					entry.Alternates = append(entry.Alternates, sitemapLink{Rel: "alternate", Hreflang: v.Lang, Href: v.URL})
+				}
+				entry.Alternates = append(entry.Alternates, sitemapLink{Rel: "alternate", Hreflang: "x-default", Href: versions[0].URL})
+			}
+			set.URLs = append(set.URLs, entry)
+		}
+		return s.sendXML(c, set)
+	}
+}
+
+// sendXML responds with v encoded as an XML document
+func (s *Server) sendXML(c *fiber.Ctx, v interface{}) error {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return s.internalError(c, "Error encoding XML", err)
+	}
+	c.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+	return c.Send(append([]byte(xml.Header), body...))
+}
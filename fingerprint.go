@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// assetManifest maps files of the public folders to names with a hash of
+// their content, like css/main.css to css/main.1a2b3c4d.css. Files are
+// hashed the first time a page links them, as they don't change while the
+// server runs outside development.
+type assetManifest struct {
+	dirs []string
+
+	mu sync.Mutex
+	// files maps each file linked so far to its fingerprinted name
+	files map[string]string
+}
+
+// fingerprintedName finds the file a name like css/main.1a2b3c4d.css is
+// the fingerprinted version of
+var fingerprintedName = regexp.MustCompile(`^(.+)\.([0-9a-f]{8})(\.[^./]+)$`)
+
+func newAssetManifest(cfg *Config) *assetManifest {
+	return &assetManifest{dirs: cfg.PublicDirs(), files: make(map[string]string)}
+}
+
+// fingerprint returns the name with the hash of its content of a file
+func fingerprint(rel, hash string) string {
+	ext := path.Ext(rel)
+	return strings.TrimSuffix(rel, ext) + "." + hash[:8] + ext
+}
+
+// Lookup returns the fingerprinted name of rel, a path inside the public
+// folders, reading it from the theme layer that has it
+func (m *assetManifest) Lookup(rel string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if name, ok := m.files[rel]; ok {
+		return name, nil
+	}
+	for _, dir := range m.dirs {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		name := fingerprint(rel, hex.EncodeToString(sum[:]))
+		m.files[rel] = name
+		return name, nil
+	}
+	return "", fmt.Errorf("asset %q not found in the public folders", rel)
+}
+
+// Linked returns the files linked so far and their fingerprinted names
+func (m *assetManifest) Linked() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	linked := make(map[string]string, len(m.files))
+	for rel, name := range m.files {
+		linked[rel] = name
+	}
+	return linked
+}
+
+// assetPath cleans the path templates pass to asset, relative to the public
+// folders
+func assetPath(p string) (string, error) {
+	rel := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if rel == "" || path.Ext(rel) == "" {
+		return "", fmt.Errorf("asset %q is not a file", p)
+	}
+	return rel, nil
+}
+
+// assetURL returns the URL pages link a public file with: the fingerprinted
+// name outside development, so browsers can cache it forever, and the plain
+// path in development. This is the asset template function.
+func (s *Server) assetURL(p string) (string, error) {
+	rel, err := assetPath(p)
+	if err != nil {
+		return "", err
+	}
+	if s.cfg.Development() {
+		for _, dir := range s.cfg.PublicDirs() {
+			if fileExists(filepath.Join(dir, filepath.FromSlash(rel))) {
+				return "/" + rel, nil
+			}
+		}
+		return "", fmt.Errorf("asset %q not found in the public folders", rel)
+	}
+	name, err := s.assets.Lookup(rel)
+	if err != nil {
+		return "", err
+	}
+	return "/" + name, nil
+}
+
+// serveFingerprinted serves a fingerprinted name as the file it was made
+// from, with a year's max-age since its name changes with its content.
+// Static files are served once the path is rewritten.
+func (s *Server) serveFingerprinted(c *fiber.Ctx) error {
+	m := fingerprintedName.FindStringSubmatch(strings.TrimPrefix(c.Path(), "/"))
+	if m == nil {
+		return c.Next()
+	}
+	rel := m[1] + m[3]
+	if name, err := s.assets.Lookup(rel); err != nil || name != m[0] {
+		return c.Next()
+	}
+	c.Path("/" + rel)
+	if err := c.Next(); err != nil {
+		return err
+	}
+	if c.Response().StatusCode() == fiber.StatusOK {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=31536000, immutable")
+	}
+	return nil
+}
+
+// writeFingerprinted writes the fingerprinted copies of the public files the
+// pages link into a static build. Files linked by pages this build didn't
+// render again are kept from the previous build, as long as their content is
+// the same.
+func writeFingerprinted(dir string, linked map[string]string, previous, current *buildManifest) (int, error) {
+	for rel, name := range previous.Fingerprints {
+		h := current.Assets[filepath.FromSlash(rel)]
+		if _, ok := linked[rel]; !ok && h != "" && fingerprint(rel, h) == name {
+			linked[rel] = name
+		}
+	}
+	written := 0
+	for rel, name := range linked {
+		src, dst := filepath.FromSlash(rel), filepath.FromSlash(name)
+		current.Fingerprints[rel] = name
+		current.Assets[dst] = current.Assets[src]
+		if previous.Assets[dst] == current.Assets[src] && fileExists(filepath.Join(dir, dst)) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, src))
+		if err != nil {
+			return written, fmt.Errorf("error copying %s: %v", name, err)
+		}
+		if err := writeOutputFile(dir, dst, data); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
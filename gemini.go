@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"mime"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// GeminiConfig serves a mirror of the blog over the Gemini protocol
+type GeminiConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Listen is the address of the Gemini listener, :1965 by default
+	Listen string `yaml:"listen"`
+	// CertFile and KeyFile hold the TLS certificate. A self-signed one,
+	// which Gemini clients trust on first use, is created when neither
+	// exists.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+func (c GeminiConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Listen == "" {
+		return fmt.Errorf("gemini.listen is required when gemini is enabled")
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return fmt.Errorf("gemini needs both cert_file and key_file")
+	}
+	return nil
+}
+
+// Gemini status codes
+const (
+	geminiSuccess       = 20
+	geminiRedirect      = 31
+	geminiNotFound      = 51
+	geminiProxyRefused  = 53
+	geminiBadRequest    = 59
+	geminiTemporaryFail = 40
+)
+
+// maxGeminiRequest is the longest request line, a URL of 1024 bytes and CRLF
+const maxGeminiRequest = 1026
+
+// geminiResponse is the header and body of a response
+type geminiResponse struct {
+	Status int
+	Meta   string
+	Body   []byte
+}
+
+// gemtextResponse is a successful response of a gemtext page in lang
+func gemtextResponse(lang, body string) geminiResponse {
+	meta := "text/gemini"
+	if lang != "" {
+		meta += "; lang=" + lang
+	}
+	return geminiResponse{Status: geminiSuccess, Meta: meta, Body: []byte(body)}
+}
+
+// listenGemini opens the TLS listener of the Gemini mirror. The certificate
+// created when there is none covers the host of base_url and of every site.
+func listenGemini(cfg *Config, hosts []string) (net.Listener, error) {
+	if u, err := url.Parse(cfg.BaseURL); err == nil && u.Hostname() != "" {
+		hosts = append([]string{u.Hostname()}, hosts...)
+	}
+	cert, err := loadGeminiCert(cfg.Gemini, hosts)
+	if err != nil {
+		return nil, fmt.Errorf("error loading Gemini certificate: %v", err)
+	}
+	return tls.Listen("tcp", cfg.Gemini.Listen, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+}
+
+// loadGeminiCert reads the certificate of the Gemini listener, creating a
+// self-signed one for hosts when neither file exists yet
+func loadGeminiCert(gc GeminiConfig, hosts []string) (tls.Certificate, error) {
+	certExists, keyExists := fileExists(gc.CertFile), fileExists(gc.KeyFile)
+	if certExists || keyExists {
+		return tls.LoadX509KeyPair(gc.CertFile, gc.KeyFile)
+	}
+	if len(hosts) == 0 {
+		hosts = []string{"localhost"}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		// Clients pin the certificate, so it should outlive the capsule
+		NotAfter:    time.Now().AddDate(20, 0, 0),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	for _, file := range []string{gc.CertFile, gc.KeyFile} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	if err := os.WriteFile(gc.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(gc.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return tls.Certificate{}, err
+	}
+	slog.Info("Created Gemini certificate", "file", gc.CertFile, "hosts", hosts)
+	return tls.LoadX509KeyPair(gc.CertFile, gc.KeyFile)
+}
+
+// serveGemini answers Gemini requests until ln is closed, each with the
+// sites current when it arrives
+func (set *siteSet) serveGemini(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Warn("Error accepting Gemini connection", "error", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go set.handleGemini(conn)
+	}
+}
+
+// handleGemini reads the request line of a connection and writes the
+// response of the site its host names
+func (set *siteSet) handleGemini(conn net.Conn) {
+	defer conn.Close()
+	start := time.Now()
+	conn.SetDeadline(start.Add(30 * time.Second))
+
+	line, err := bufio.NewReaderSize(conn, maxGeminiRequest).ReadSlice('\n')
+	var resp geminiResponse
+	var u *url.URL
+	switch {
+	case errors.Is(err, bufio.ErrBufferFull):
+		resp = geminiResponse{Status: geminiBadRequest, Meta: "Request too long"}
+	case err != nil:
+		return
+	default:
+		u, err = url.Parse(strings.TrimRight(string(line), "\r\n"))
+		switch {
+		case err != nil || u.Host == "":
+			resp = geminiResponse{Status: geminiBadRequest, Meta: "Invalid URL"}
+		case u.Scheme != "gemini":
+			resp = geminiResponse{Status: geminiProxyRefused, Meta: "Only gemini:// URLs are served"}
+		default:
+			resp = set.current.Load().serverFor(u.Hostname()).gemini(u)
+		}
+	}
+
+	fmt.Fprintf(conn, "%d %s\r\n", resp.Status, resp.Meta)
+	if resp.Status == geminiSuccess {
+		conn.Write(resp.Body)
+	}
+	if u != nil {
+		slog.Info("Gemini request", "host", u.Hostname(), "path", u.Path, "status", resp.Status,
+			"latency_ms", float64(time.Since(start).Microseconds())/1000, "bytes", len(resp.Body))
+	}
+}
+
+// gemini answers a request for the capsule: the index of posts, a post or
+// translation at the same path as on the web, media files and the aliases
+// of posts
+func (s *Server) gemini(u *url.URL) geminiResponse {
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+	if p != "/" && strings.HasSuffix(p, "/") && !slices.Contains(s.cfg.I18n.Languages, strings.Trim(p, "/")) {
+		return geminiResponse{Status: geminiRedirect, Meta: strings.TrimSuffix(p, "/")}
+	}
+
+	lang, rest := s.geminiLanguage(p)
+	if lang != "" && rest == "" {
+		return geminiResponse{Status: geminiRedirect, Meta: p + "/"}
+	}
+	if rest == "/" {
+		return s.geminiIndex(lang)
+	}
+	if post := s.geminiPostAt(lang, rest); post != nil {
+		return s.geminiPost(post)
+	}
+	if file, ok := s.geminiFile(p); ok {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return geminiResponse{Status: geminiNotFound, Meta: "Not found"}
+		}
+		kind := mime.TypeByExtension(filepath.Ext(file))
+		if kind == "" {
+			kind = "application/octet-stream"
+		}
+		return geminiResponse{Status: geminiSuccess, Meta: kind, Body: data}
+	}
+	if target, ok := s.content.Redirect(p); ok {
+		return geminiResponse{Status: geminiRedirect, Meta: target}
+	}
+	return geminiResponse{Status: geminiNotFound, Meta: "Not found"}
+}
+
+// geminiLanguage splits a path into its language prefix, empty for the
+// default language, and the path after it
+func (s *Server) geminiLanguage(p string) (string, string) {
+	for _, l := range s.cfg.I18n.Languages {
+		if p == "/"+l || strings.HasPrefix(p, "/"+l+"/") {
+			return l, strings.TrimPrefix(p, "/"+l)
+		}
+	}
+	return "", p
+}
+
+// geminiPostAt returns the visible post or translation at /blog/<slug>
+func (s *Server) geminiPostAt(lang, p string) *BlogPost {
+	slug, ok := strings.CutPrefix(p, "/blog/")
+	if !ok {
+		return nil
+	}
+	var post *BlogPost
+	if lang == "" {
+		post, ok = s.content.Post(slug)
+	} else {
+		post, ok = s.content.Translation(lang, slug)
+	}
+	if !ok || !apiVisible(post) {
+		return nil
+	}
+	return post
+}
+
+// geminiIndex lists the posts in lang, newest first, as a Gemini feed of
+// dated links
+func (s *Server) geminiIndex(lang string) geminiResponse {
+	posts, err := s.publishedIn(s.geminiLang(lang))
+	if err != nil {
+		slog.Error("Error loading blog posts", "dir", s.cfg.ContentDir, "error", err)
+		return geminiResponse{Status: geminiTemporaryFail, Meta: "Error loading blog posts"}
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].Date.After(posts[j].Date) })
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", s.cfg.Title)
+	if len(s.cfg.I18n.Languages) > 0 {
+		if lang != "" {
+			fmt.Fprintf(&b, "=> / %s\n", languageName(s.cfg.I18n.DefaultLanguage))
+		}
+		for _, l := range s.cfg.I18n.Languages {
+			if l != lang {
+				fmt.Fprintf(&b, "=> /%s/ %s\n", l, languageName(l))
+			}
+		}
+		b.WriteString("\n")
+	}
+	for _, post := range posts {
+		fmt.Fprintf(&b, "=> %s %s %s\n", postPath(post), post.Date.In(s.cfg.Location()).Format("2006-01-02"), geminiLine(post.Title))
+	}
+	if s.cfg.BaseURL != "" {
+		fmt.Fprintf(&b, "\n=> %s On the web\n", s.cfg.BaseURL)
+	}
+	return gemtextResponse(s.geminiLang(lang), b.String())
+}
+
+// geminiPost renders a post as gemtext, with links to its translations
+func (s *Server) geminiPost(post *BlogPost) geminiResponse {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", geminiLine(post.Title))
+	meta := post.Date.In(s.cfg.Location()).Format("2006-01-02")
+	if post.Author != "" {
+		meta += " · " + post.Author
+	}
+	b.WriteString(meta + "\n")
+	if len(post.Tags) > 0 {
+		b.WriteString(strings.Join(post.Tags, ", ") + "\n")
+	}
+	b.WriteString("\n")
+	b.WriteString(htmlToGemtext(post.HTMLContent, s.geminiLink))
+	b.WriteString("\n")
+
+	var languages []string
+	if post.Lang != "" {
+		languages = append(languages, "=> /blog/"+post.Slug+" "+languageName(s.cfg.I18n.DefaultLanguage))
+	}
+	for _, l := range s.cfg.I18n.Languages {
+		if _, ok := s.content.Translations(post.Slug)[l]; ok && l != post.Lang {
+			languages = append(languages, "=> /"+l+"/blog/"+post.Slug+" "+languageName(l))
+		}
+	}
+	if len(languages) > 0 {
+		b.WriteString("\n" + strings.Join(languages, "\n") + "\n")
+	}
+	fmt.Fprintf(&b, "\n=> %s/ %s\n", languagePrefix(post.Lang), s.cfg.Title)
+	return gemtextResponse(s.geminiLang(post.Lang), b.String())
+}
+
+// geminiLang is the language of pages in lang, the default language for ""
+func (s *Server) geminiLang(lang string) string {
+	if lang == "" {
+		return s.cfg.I18n.DefaultLanguage
+	}
+	return lang
+}
+
+// geminiFile returns the file of the media directory, or the attachment of
+// an Obsidian vault, at p
+func (s *Server) geminiFile(p string) (string, bool) {
+	if rel, ok := strings.CutPrefix(p, strings.TrimSuffix(s.cfg.Media.URL, "/")+"/"); ok {
+		rel = path.Clean(rel)
+		if strings.HasPrefix(rel, "..") {
+			return "", false
+		}
+		return filepath.Join(s.cfg.Media.Dir, filepath.FromSlash(rel)), true
+	}
+	if rel, ok := strings.CutPrefix(p, strings.TrimSuffix(s.cfg.Obsidian.URL, "/")+"/"); ok && s.cfg.Obsidian.Enabled {
+		return s.content.VaultFile(rel)
+	}
+	return "", false
+}
+
+// geminiLink returns the target of a link in a post. Paths the capsule
+// serves stay as they are, other paths of the site point to the web.
+func (s *Server) geminiLink(href string) string {
+	if !strings.HasPrefix(href, "/") || strings.HasPrefix(href, "//") || s.cfg.BaseURL == "" {
+		return href
+	}
+	p, _, _ := strings.Cut(href, "#")
+	p, _, _ = strings.Cut(p, "?")
+	lang, rest := s.geminiLanguage(p)
+	if _, file := s.geminiFile(p); file || rest == "/" || s.geminiPostAt(lang, rest) != nil {
+		return href
+	}
+	if _, alias := s.content.Redirect(p); alias {
+		return href
+	}
+	return s.absoluteURL(nil, href)
+}
+
+// geminiLine makes text fit on one line of gemtext
+func geminiLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// geminiLinkLine is a link to url in gemtext
+type geminiLinkLine struct {
+	URL  string
+	Text string
+}
+
+// htmlToGemtext converts the HTML of a post to gemtext. Gemtext has no
+// inline links, so the links of a paragraph follow it on lines of their own,
+// as do images.
+func htmlToGemtext(content string, link func(string) string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(content), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return content
+	}
+	var blocks []string
+	for _, n := range nodes {
+		blocks = append(blocks, gemtextBlocks(n, link)...)
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// gemtextBlocks converts a block of HTML to the blocks of gemtext it becomes
+func gemtextBlocks(n *html.Node, link func(string) string) []string {
+	withLinks := func(text string, links []geminiLinkLine) []string {
+		var lines []string
+		if text != "" {
+			lines = append(lines, text)
+		}
+		for _, l := range links {
+			lines = append(lines, "=> "+link(l.URL)+" "+l.Text)
+		}
+		if len(lines) == 0 {
+			return nil
+		}
+		return []string{strings.Join(lines, "\n")}
+	}
+
+	switch n.Type {
+	case html.TextNode:
+		return withLinks(geminiLine(n.Data), nil)
+	case html.ElementNode:
+	default:
+		return nil
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := min(int(n.Data[1]-'0'), 3)
+		text, links := gemtextInline(n)
+		return withLinks(strings.Repeat("#", level)+" "+text, links)
+	case atom.P:
+		return withLinks(gemtextInline(n))
+	case atom.Img:
+		return withLinks("", []geminiLinkLine{imageLink(n)})
+	case atom.Ul, atom.Ol:
+		var items []string
+		var links []geminiLinkLine
+		for li := n.FirstChild; li != nil; li = li.NextSibling {
+			if li.DataAtom != atom.Li {
+				continue
+			}
+			text, l := gemtextInline(li)
+			items = append(items, "* "+text)
+			links = append(links, l...)
+		}
+		return withLinks(strings.Join(items, "\n"), links)
+	case atom.Pre:
+		alt := ""
+		if code := n.FirstChild; code != nil && code.DataAtom == atom.Code {
+			alt = strings.TrimPrefix(attr(code, "class"), "language-")
+		}
+		return []string{"```" + alt + "\n" + strings.TrimRight(rawText(n), "\n") + "\n```"}
+	case atom.Blockquote:
+		var lines []string
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			for _, block := range gemtextBlocks(c, link) {
+				for _, line := range strings.Split(block, "\n") {
+					if strings.HasPrefix(line, "=> ") {
+						lines = append(lines, line)
+					} else {
+						lines = append(lines, "> "+line)
+					}
+				}
+			}
+		}
+		return withLinks(strings.Join(lines, "\n"), nil)
+	case atom.Table:
+		var rows []string
+		var walk func(*html.Node)
+		walk = func(n *html.Node) {
+			if n.DataAtom == atom.Tr {
+				var cells []string
+				for c := n.FirstChild; c != nil; c = c.NextSibling {
+					if c.DataAtom == atom.Td || c.DataAtom == atom.Th {
+						cells = append(cells, textContent(c))
+					}
+				}
+				rows = append(rows, strings.Join(cells, " | "))
+				return
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+		}
+		walk(n)
+		return []string{"```\n" + strings.Join(rows, "\n") + "\n```"}
+	case atom.Hr, atom.Script, atom.Style:
+		return nil
+	}
+
+	// Containers like div and figure hold more blocks
+	var blocks []string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		blocks = append(blocks, gemtextBlocks(c, link)...)
+	}
+	return blocks
+}
+
+// gemtextInline returns the text of an inline element and the links and
+// images in it
+func gemtextInline(n *html.Node) (string, []geminiLinkLine) {
+	var b strings.Builder
+	var links []geminiLinkLine
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+			return
+		case n.DataAtom == atom.Br:
+			b.WriteByte(' ')
+			return
+		case n.DataAtom == atom.Img:
+			links = append(links, imageLink(n))
+			return
+		case n.DataAtom == atom.Ul || n.DataAtom == atom.Ol:
+			// Nested lists continue the item
+			b.WriteByte(' ')
+		case n.DataAtom == atom.A && attr(n, "href") != "" && !strings.HasPrefix(attr(n, "href"), "#"):
+			text := textContent(n)
+			if text == "" {
+				text = attr(n, "href")
+			}
+			links = append(links, geminiLinkLine{URL: attr(n, "href"), Text: text})
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return geminiLine(b.String()), links
+}
+
+// imageLink is the link an image becomes
+func imageLink(n *html.Node) geminiLinkLine {
+	text := geminiLine(attr(n, "alt"))
+	if text == "" {
+		text = path.Base(attr(n, "src"))
+	}
+	return geminiLinkLine{URL: attr(n, "src"), Text: "🖼 " + text}
+}
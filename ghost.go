@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ghostExport is the JSON file Ghost exports a site as, under Settings, Labs.
+// Newer versions wrap it in a db list.
+type ghostExport struct {
+	DB   []ghostDB `json:"db"`
+	Data ghostData `json:"data"`
+}
+
+// ghostDB is one database of a Ghost export
+type ghostDB struct {
+	Data ghostData `json:"data"`
+}
+
+// ghostData holds the tables of a Ghost export the importer reads
+type ghostData struct {
+	Posts        []ghostPost `json:"posts"`
+	Tags         []ghostTag  `json:"tags"`
+	Users        []ghostUser `json:"users"`
+	PostsTags    []ghostLink `json:"posts_tags"`
+	PostsAuthors []ghostLink `json:"posts_authors"`
+}
+
+// ghostID is the ID of a Ghost row, a string in current exports and a number
+// in old ones
+type ghostID string
+
+// UnmarshalJSON reads both kinds of IDs
+func (id *ghostID) UnmarshalJSON(data []byte) error {
+	*id = ghostID(strings.Trim(string(data), `"`))
+	return nil
+}
+
+// ghostPost is a post or page of a Ghost export
+type ghostPost struct {
+	ID              ghostID `json:"id"`
+	Title           string  `json:"title"`
+	Slug            string  `json:"slug"`
+	Mobiledoc       string  `json:"mobiledoc"`
+	HTML            string  `json:"html"`
+	Type            string  `json:"type"`
+	Page            bool    `json:"page"`
+	Status          string  `json:"status"`
+	CustomExcerpt   string  `json:"custom_excerpt"`
+	MetaDescription string  `json:"meta_description"`
+	AuthorID        ghostID `json:"author_id"`
+	CreatedAt       string  `json:"created_at"`
+	PublishedAt     string  `json:"published_at"`
+}
+
+// ghostTag is a tag of a Ghost export. Internal tags start with #.
+type ghostTag struct {
+	ID   ghostID `json:"id"`
+	Name string  `json:"name"`
+}
+
+// ghostUser is an author of a Ghost export
+type ghostUser struct {
+	ID   ghostID `json:"id"`
+	Name string  `json:"name"`
+}
+
+// ghostLink relates a post to a tag or an author, in sort order
+type ghostLink struct {
+	PostID    ghostID `json:"post_id"`
+	TagID     ghostID `json:"tag_id"`
+	AuthorID  ghostID `json:"author_id"`
+	SortOrder int     `json:"sort_order"`
+}
+
+// readGhost reads the published, scheduled and draft posts of a Ghost export
+func (imp *siteImporter) readGhost() (func(*importedPost) string, error) {
+	raw, err := os.ReadFile(imp.opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	var export ghostExport
+	if err := json.Unmarshal(raw, &export); err != nil {
+		return nil, fmt.Errorf("error reading Ghost export: %v", err)
+	}
+	data := export.Data
+	if len(export.DB) > 0 {
+		data = export.DB[0].Data
+	}
+
+	tags := make(map[ghostID]string, len(data.Tags))
+	for _, t := range data.Tags {
+		if !strings.HasPrefix(t.Name, "#") {
+			tags[t.ID] = t.Name
+		}
+	}
+	users := make(map[ghostID]string, len(data.Users))
+	for _, u := range data.Users {
+		users[u.ID] = u.Name
+	}
+	sort.SliceStable(data.PostsTags, func(i, j int) bool { return data.PostsTags[i].SortOrder < data.PostsTags[j].SortOrder })
+	sort.SliceStable(data.PostsAuthors, func(i, j int) bool {
+		return data.PostsAuthors[i].SortOrder < data.PostsAuthors[j].SortOrder
+	})
+	postTags := make(map[ghostID][]string)
+	for _, link := range data.PostsTags {
+		if name, ok := tags[link.TagID]; ok {
+			postTags[link.PostID] = append(postTags[link.PostID], name)
+		}
+	}
+	postAuthors := make(map[ghostID]string)
+	for _, link := range data.PostsAuthors {
+		if _, ok := postAuthors[link.PostID]; !ok {
+			postAuthors[link.PostID] = users[link.AuthorID]
+		}
+	}
+
+	for _, gp := range data.Posts {
+		if gp.Page || (gp.Type != "" && gp.Type != "post") {
+			continue
+		}
+		var draft bool
+		switch gp.Status {
+		case "published", "scheduled":
+		case "draft":
+			draft = true
+		default:
+			continue
+		}
+
+		date, ok := timeValue(gp.PublishedAt, time.UTC)
+		if !ok {
+			date, _ = timeValue(gp.CreatedAt, time.UTC)
+		}
+		author, ok := postAuthors[gp.ID]
+		if !ok {
+			author = users[gp.AuthorID]
+		}
+		description := gp.CustomExcerpt
+		if description == "" {
+			description = gp.MetaDescription
+		}
+
+		post := &BlogPost{
+			Title:       gp.Title,
+			Date:        date.In(imp.cfg.Location()),
+			Author:      author,
+			Description: strings.TrimSpace(description),
+			Tags:        postTags[gp.ID],
+			Slug:        slugify(gp.Slug),
+			Draft:       draft,
+		}
+		if post.Slug == "" {
+			post.Slug = slugify(gp.Title)
+		}
+		if post.Title == "" {
+			post.Title = post.Slug
+		}
+		if !draft {
+			// Ghost serves posts at /my-post/ by default
+			post.Aliases = importAliases(post, "/"+post.Slug, nil, "/")
+		}
+
+		file := imp.opts.Path + "#" + post.Slug
+		if gp.Mobiledoc != "" {
+			content, err := mobiledocMarkdown(gp.Mobiledoc)
+			if err == nil {
+				post.Content = content
+			} else {
+				slog.Warn("Error reading mobiledoc, using the HTML instead", "file", file, "error", err)
+				post.Content = htmlToMarkdown(gp.HTML)
+			}
+		} else {
+			post.Content = htmlToMarkdown(gp.HTML)
+		}
+		imp.posts = append(imp.posts, &importedPost{post: post, file: file})
+	}
+	if len(imp.posts) == 0 {
+		return nil, fmt.Errorf("no posts found in %s", imp.opts.Path)
+	}
+
+	// Current exports write the site's own URL as a placeholder
+	return func(p *importedPost) string {
+		return strings.ReplaceAll(p.post.Content, "__GHOST_URL__", "")
+	}, nil
+}
+
+// mobiledoc is the document format of Ghost's editor before Lexical
+type mobiledoc struct {
+	Cards    []json.RawMessage   `json:"cards"`
+	Markups  [][]json.RawMessage `json:"markups"`
+	Sections [][]json.RawMessage `json:"sections"`
+}
+
+// mobiledocMarkdown converts a mobiledoc document to markdown. Markdown cards
+// are kept as they are, and other sections go through htmlToMarkdown.
+func mobiledocMarkdown(doc string) (string, error) {
+	var md mobiledoc
+	if err := json.Unmarshal([]byte(doc), &md); err != nil {
+		return "", err
+	}
+
+	// Markups are tags like ["a", ["href", "https://..."]]
+	markups := make([]string, len(md.Markups))
+	closers := make([]string, len(md.Markups))
+	for i, m := range md.Markups {
+		var tag string
+		var attrs []string
+		if len(m) > 0 {
+			json.Unmarshal(m[0], &tag)
+		}
+		if len(m) > 1 {
+			json.Unmarshal(m[1], &attrs)
+		}
+		open := "<" + tag
+		for j := 0; j+1 < len(attrs); j += 2 {
+			open += " " + attrs[j] + `="` + html.EscapeString(attrs[j+1]) + `"`
+		}
+		markups[i], closers[i] = open+">", "</"+tag+">"
+	}
+
+	// markers renders a list of [type, opened, closed, text] markers as HTML
+	markers := func(raw json.RawMessage) string {
+		var list [][]json.RawMessage
+		json.Unmarshal(raw, &list)
+		var b strings.Builder
+		var open []int
+		for _, m := range list {
+			if len(m) < 4 {
+				continue
+			}
+			var kind, closed int
+			var opened []int
+			var text string
+			json.Unmarshal(m[0], &kind)
+			json.Unmarshal(m[1], &opened)
+			json.Unmarshal(m[2], &closed)
+			if kind == 0 {
+				json.Unmarshal(m[3], &text)
+			}
+			for _, i := range opened {
+				if i >= 0 && i < len(markups) {
+					b.WriteString(markups[i])
+					open = append(open, i)
+				}
+			}
+			b.WriteString(html.EscapeString(text))
+			for ; closed > 0 && len(open) > 0; closed-- {
+				b.WriteString(closers[open[len(open)-1]])
+				open = open[:len(open)-1]
+			}
+		}
+		return b.String()
+	}
+
+	var blocks []string
+	for _, section := range md.Sections {
+		if len(section) < 2 {
+			continue
+		}
+		var kind int
+		json.Unmarshal(section[0], &kind)
+		switch kind {
+		case 1: // markup section: [1, "p", markers]
+			var tag string
+			json.Unmarshal(section[1], &tag)
+			if len(section) > 2 {
+				blocks = append(blocks, htmlToMarkdown("<"+tag+">"+markers(section[2])+"</"+tag+">"))
+			}
+		case 2: // image section: [2, src]
+			var src string
+			json.Unmarshal(section[1], &src)
+			blocks = append(blocks, "![]("+src+")")
+		case 3: // list section: [3, "ul", [markers, ...]]
+			var tag string
+			var items []json.RawMessage
+			json.Unmarshal(section[1], &tag)
+			if len(section) > 2 {
+				json.Unmarshal(section[2], &items)
+			}
+			var b strings.Builder
+			for _, item := range items {
+				b.WriteString("<li>" + markers(item) + "</li>")
+			}
+			blocks = append(blocks, htmlToMarkdown("<"+tag+">"+b.String()+"</"+tag+">"))
+		case 10: // card section: [10, card index]
+			var i int
+			json.Unmarshal(section[1], &i)
+			if i >= 0 && i < len(md.Cards) {
+				blocks = append(blocks, mobiledocCard(md.Cards[i]))
+			}
+		}
+	}
+
+	var kept []string
+	for _, block := range blocks {
+		if block = strings.TrimSpace(block); block != "" {
+			kept = append(kept, block)
+		}
+	}
+	return strings.Join(kept, "\n\n"), nil
+}
+
+// mobiledocCard converts a card, a ["name", payload] pair, to markdown
+func mobiledocCard(raw json.RawMessage) string {
+	var card []json.RawMessage
+	if json.Unmarshal(raw, &card) != nil || len(card) < 2 {
+		return ""
+	}
+	var name string
+	json.Unmarshal(card[0], &name)
+	var payload struct {
+		Markdown string `json:"markdown"`
+		HTML     string `json:"html"`
+		Src      string `json:"src"`
+		Alt      string `json:"alt"`
+		Caption  string `json:"caption"`
+		Code     string `json:"code"`
+		Language string `json:"language"`
+	}
+	json.Unmarshal(card[1], &payload)
+
+	switch name {
+	case "markdown", "card-markdown":
+		return payload.Markdown
+	case "html", "embed", "bookmark":
+		return payload.HTML
+	case "image":
+		image := "![" + markdownEscaper.Replace(payload.Alt) + "](" + payload.Src + ")"
+		if payload.Caption != "" {
+			image += "\n\n" + wrapInline(htmlToMarkdown(payload.Caption), "*")
+		}
+		return image
+	case "code":
+		return "```" + payload.Language + "\n" + strings.Trim(payload.Code, "\n") + "\n```"
+	case "hr":
+		return "---"
+	}
+	slog.Warn("Skipping mobiledoc card without a markdown equivalent", "card", name)
+	return ""
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GitConfig records every edit made through the admin area, the API and
+// Micropub as a commit in the content directory's git repository
+type GitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Remote, when set, is pushed to after each commit
+	Remote string `yaml:"remote"`
+	// Branch is the remote branch to push to and pull from, defaulting to
+	// the current one
+	Branch string `yaml:"branch"`
+	// WebhookSecret enables /hooks/github, which pulls the repository when
+	// GitHub reports a push
+	WebhookSecret string `yaml:"webhook_secret"`
+}
+
+// contentRepo commits changes to the content directory
+type contentRepo struct {
+	dir    string
+	remote string
+	branch string
+
+	// mu serializes commits, git cannot run two at once in one repository
+	mu sync.Mutex
+}
+
+// newContentRepo returns the repository for the content directory, creating
+// it and committing the existing posts when the directory is not inside one
+// yet. It returns nil while git history is disabled.
+func newContentRepo(cfg *Config) *contentRepo {
+	if !cfg.Git.Enabled {
+		return nil
+	}
+
+	r := &contentRepo{dir: cfg.ContentDir, remote: cfg.Git.Remote, branch: cfg.Git.Branch}
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		slog.Error("Error creating content directory", "dir", r.dir, "error", err)
+		return r
+	}
+	if _, err := r.git(context.Background(), nil, "rev-parse", "--git-dir"); err != nil {
+		if _, err := r.git(context.Background(), nil, "init", "-q"); err != nil {
+			slog.Error("Error creating content repository", "dir", r.dir, "error", err)
+		} else if err := r.Commit("", "Import existing content"); err != nil {
+			slog.Error("Error committing existing content", "dir", r.dir, "error", err)
+		} else {
+			slog.Info("Created content repository", "dir", r.dir)
+		}
+	}
+	return r
+}
+
+// gitEnv sets the committer, and the author unless one is given, from the
+// usual git variables with DevDaze as the fallback identity
+func gitEnv() []string {
+	return append(os.Environ(),
+		"GIT_AUTHOR_NAME="+envOr("GIT_AUTHOR_NAME", "DevDaze"),
+		"GIT_AUTHOR_EMAIL="+envOr("GIT_AUTHOR_EMAIL", "devdaze@localhost"),
+		"GIT_COMMITTER_NAME="+envOr("GIT_COMMITTER_NAME", "DevDaze"),
+		"GIT_COMMITTER_EMAIL="+envOr("GIT_COMMITTER_EMAIL", "devdaze@localhost"),
+	)
+}
+
+// git runs a git command in the content directory. env is added to the
+// environment of the command.
+func (r *contentRepo) git(ctx context.Context, env []string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = r.dir
+	cmd.Env = append(gitEnv(), env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Commit stages everything that changed in the content directory and
+// commits it as author. It does nothing when there is nothing to commit.
+// Pushing happens in the background, a slow or unreachable remote never
+// holds up the edit.
+func (r *contentRepo) Commit(author, message string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := r.git(ctx, nil, "add", "-A", "--", "."); err != nil {
+		return err
+	}
+	if _, err := r.git(ctx, nil, "diff", "--cached", "--quiet", "--", "."); err == nil {
+		return nil
+	}
+
+	var env []string
+	if author != "" {
+		email := "devdaze@localhost"
+		if strings.Contains(author, "@") {
+			email = author
+		}
+		env = []string{"GIT_AUTHOR_NAME=" + author, "GIT_AUTHOR_EMAIL=" + email}
+	}
+	if _, err := r.git(ctx, env, "commit", "-q", "-m", message, "--", "."); err != nil {
+		return err
+	}
+
+	if r.remote != "" {
+		go r.push()
+	}
+	return nil
+}
+
+// push sends the current branch to the configured remote
+func (r *contentRepo) push() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	ref := "HEAD"
+	if r.branch != "" {
+		ref = "HEAD:" + r.branch
+	}
+	if _, err := r.git(ctx, nil, "push", "-q", r.remote, ref); err != nil {
+		slog.Warn("Error pushing content repository", "remote", r.remote, "error", err)
+	}
+}
+
+// editor names who made the request's change: the signed-in admin, the API
+// token or the Micropub client
+func editor(c *fiber.Ctx) string {
+	if user, ok := c.Locals("username").(string); ok && user != "" {
+		return user
+	}
+	if token, ok := c.Locals("apiToken").(APIToken); ok {
+		return "api:" + token.Name
+	}
+	if token, ok := c.Locals("micropubToken").(micropubToken); ok {
+		return token.ClientID
+	}
+	return ""
+}
+
+// recordEdit writes an edit to the audit log and commits the content
+// directory. The edit itself has succeeded, so failures are only logged.
+func (s *Server) recordEdit(c *fiber.Ctx, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	summary, _, _ := strings.Cut(message, "\n")
+	s.audit(c, "%s", summary)
+	if s.repo == nil {
+		return
+	}
+	if err := s.repo.Commit(editor(c), message); err != nil {
+		requestLogger(c).Error("Error committing content change", "message", message, "error", err)
+	}
+}
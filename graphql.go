@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/graphql-go/graphql"
+)
+
+// graphqlSchema is built once, on the first GraphQL request. Resolvers read
+// the posts from the request context, so the schema is shared by all sites.
+var graphqlSchema = sync.OnceValues(newGraphQLSchema)
+
+// graphqlContextKey is the context key of the request's graphqlData
+type graphqlContextKey struct{}
+
+// graphqlData is the content a single GraphQL request can see
+type graphqlData struct {
+	posts []*BlogPost
+	// private is set for requests with a read token, which may also see
+	// drafts and scheduled posts
+	private bool
+}
+
+// requestData returns the graphqlData of the request being resolved
+func requestData(p graphql.ResolveParams) *graphqlData {
+	return p.Context.Value(graphqlContextKey{}).(*graphqlData)
+}
+
+// published returns the posts that can be read without a token
+func (d *graphqlData) published() []*BlogPost {
+	var posts []*BlogPost
+	for _, post := range d.posts {
+		if apiVisible(post) {
+			posts = append(posts, post)
+		}
+	}
+	return posts
+}
+
+// GraphQLGroup is a tag, author or series with its published posts
+type GraphQLGroup struct {
+	Name  string
+	Posts []*BlogPost
+}
+
+// groupPosts groups published posts by the names key returns for each,
+// most used first
+func groupPosts(posts []*BlogPost, key func(*BlogPost) []string) []GraphQLGroup {
+	byName := map[string]*GraphQLGroup{}
+	var order []string
+	for _, post := range posts {
+		for _, name := range key(post) {
+			if name == "" {
+				continue
+			}
+			g, ok := byName[strings.ToLower(name)]
+			if !ok {
+				g = &GraphQLGroup{Name: name}
+				byName[strings.ToLower(name)] = g
+				order = append(order, strings.ToLower(name))
+			}
+			g.Posts = append(g.Posts, post)
+		}
+	}
+
+	groups := make([]GraphQLGroup, 0, len(order))
+	for _, name := range order {
+		groups = append(groups, *byName[name])
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if len(groups[i].Posts) != len(groups[j].Posts) {
+			return len(groups[i].Posts) > len(groups[j].Posts)
+		}
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// findGroup returns the group with the given name, ignoring case
+func findGroup(groups []GraphQLGroup, name string) *GraphQLGroup {
+	for i := range groups {
+		if strings.EqualFold(groups[i].Name, name) {
+			return &groups[i]
+		}
+	}
+	return nil
+}
+
+// postTags, postAuthor and postSeries are the grouping keys of posts
+func postTags(p *BlogPost) []string   { return p.Tags }
+func postAuthor(p *BlogPost) []string { return []string{p.Author} }
+func postSeries(p *BlogPost) []string { return []string{p.Series} }
+
+// GraphQLConnection is one page of a post listing
+type GraphQLConnection struct {
+	Nodes   []*BlogPost
+	Total   int
+	Offset  int
+	Limit   int
+	HasMore bool
+}
+
+// filterPosts applies the filter, orderBy, first and offset arguments of a
+// posts field to posts. scoped is false for the top-level field, where the
+// status filter can reach drafts and scheduled posts.
+func filterPosts(p graphql.ResolveParams, posts []*BlogPost, scoped bool) (*GraphQLConnection, error) {
+	data := requestData(p)
+	filter, _ := p.Args["filter"].(map[string]interface{})
+	str := func(key string) string {
+		v, _ := filter[key].(string)
+		return v
+	}
+
+	status := "PUBLISHED"
+	if v := str("status"); v != "" {
+		status = v
+	}
+	if status != "PUBLISHED" && !data.private {
+		return nil, fmt.Errorf("status %s needs a token with the read scope", status)
+	}
+	if !scoped {
+		posts = data.posts
+	}
+
+	var after, before time.Time
+	if v, ok := filter["after"].(time.Time); ok {
+		after = v
+	}
+	if v, ok := filter["before"].(time.Time); ok {
+		before = v
+	}
+	search := strings.ToLower(str("search"))
+
+	var matched []*BlogPost
+	for _, post := range posts {
+		switch {
+		case status == "PUBLISHED" && !apiVisible(post),
+			status == "DRAFT" && !post.Draft,
+			status == "SCHEDULED" && !post.Scheduled(),
+			str("tag") != "" && !containsFold(post.Tags, str("tag")),
+			str("author") != "" && !strings.EqualFold(post.Author, str("author")),
+			str("series") != "" && !strings.EqualFold(post.Series, str("series")),
+			search != "" && !strings.Contains(strings.ToLower(post.Title+" "+post.Description+" "+post.Content), search),
+			!after.IsZero() && !post.Date.After(after),
+			!before.IsZero() && !post.Date.Before(before):
+			continue
+		}
+		matched = append(matched, post)
+	}
+
+	orderBy, _ := p.Args["orderBy"].(string)
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		switch orderBy {
+		case "DATE_ASC":
+			return a.Date.Before(b.Date)
+		case "TITLE_ASC":
+			return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+		case "TITLE_DESC":
+			return strings.ToLower(a.Title) > strings.ToLower(b.Title)
+		default:
+			return a.Date.After(b.Date)
+		}
+	})
+
+	limit, _ := p.Args["first"].(int)
+	offset, _ := p.Args["offset"].(int)
+	if limit < 1 || limit > 100 || offset < 0 {
+		return nil, fmt.Errorf("first must be between 1 and 100 and offset not negative")
+	}
+	conn := &GraphQLConnection{Total: len(matched), Offset: offset, Limit: limit, Nodes: []*BlogPost{}}
+	if offset < len(matched) {
+		conn.Nodes = matched[offset:min(offset+limit, len(matched))]
+	}
+	conn.HasMore = offset+len(conn.Nodes) < len(matched)
+	return conn, nil
+}
+
+// newGraphQLSchema describes the posts, tags, authors and series of the blog
+func newGraphQLSchema() (graphql.Schema, error) {
+	postStatus := graphql.NewEnum(graphql.EnumConfig{
+		Name: "PostStatus",
+		Values: graphql.EnumValueConfigMap{
+			"PUBLISHED": {Value: "PUBLISHED", Description: "Visible on the site"},
+			"DRAFT":     {Value: "DRAFT", Description: "Marked as draft; needs a read token"},
+			"SCHEDULED": {Value: "SCHEDULED", Description: "Dated in the future; needs a read token"},
+			"ALL":       {Value: "ALL", Description: "Every post; needs a read token"},
+		},
+	})
+	postOrder := graphql.NewEnum(graphql.EnumConfig{
+		Name: "PostOrder",
+		Values: graphql.EnumValueConfigMap{
+			"DATE_DESC":  {Value: "DATE_DESC"},
+			"DATE_ASC":   {Value: "DATE_ASC"},
+			"TITLE_ASC":  {Value: "TITLE_ASC"},
+			"TITLE_DESC": {Value: "TITLE_DESC"},
+		},
+	})
+	postFilter := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "PostFilter",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"status": {Type: postStatus, DefaultValue: "PUBLISHED"},
+			"tag":    {Type: graphql.String},
+			"author": {Type: graphql.String},
+			"series": {Type: graphql.String},
+			"search": {Type: graphql.String, Description: "Text the title, description or body contains"},
+			"after":  {Type: graphql.DateTime},
+			"before": {Type: graphql.DateTime},
+		},
+	})
+	postsArgs := func(order string) graphql.FieldConfigArgument {
+		return graphql.FieldConfigArgument{
+			"filter":  {Type: postFilter},
+			"orderBy": {Type: postOrder, DefaultValue: order},
+			"first":   {Type: graphql.Int, DefaultValue: 20},
+			"offset":  {Type: graphql.Int, DefaultValue: 0},
+		}
+	}
+
+	post := graphql.NewObject(graphql.ObjectConfig{Name: "Post", Fields: graphql.Fields{}})
+	connection := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "PostConnection",
+		Description: "A page of posts",
+		Fields: graphql.Fields{
+			"nodes":   {Type: graphql.NewList(post)},
+			"total":   {Type: graphql.Int},
+			"offset":  {Type: graphql.Int},
+			"limit":   {Type: graphql.Int},
+			"hasMore": {Type: graphql.Boolean},
+		},
+	})
+	// group builds the type of tags, authors and series; their posts
+	// field takes the same arguments as the top-level posts field
+	group := func(name, order string) *graphql.Object {
+		return graphql.NewObject(graphql.ObjectConfig{
+			Name: name,
+			Fields: graphql.Fields{
+				"name": {Type: graphql.String},
+				"count": {Type: graphql.Int, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return len(p.Source.(GraphQLGroup).Posts), nil
+				}},
+				"posts": {Type: connection, Args: postsArgs(order), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return filterPosts(p, p.Source.(GraphQLGroup).Posts, true)
+				}},
+			},
+		})
+	}
+	tag := group("Tag", "DATE_DESC")
+	author := group("Author", "DATE_DESC")
+	series := group("Series", "DATE_ASC")
+
+	postField := func(get func(*BlogPost) interface{}) graphql.FieldResolveFn {
+		return func(p graphql.ResolveParams) (interface{}, error) {
+			return get(p.Source.(*BlogPost)), nil
+		}
+	}
+	// groupField resolves the named group among the request's published posts
+	groupField := func(key func(*BlogPost) []string, name func(*BlogPost) string) graphql.FieldResolveFn {
+		return func(p graphql.ResolveParams) (interface{}, error) {
+			g := findGroup(groupPosts(requestData(p).published(), key), name(p.Source.(*BlogPost)))
+			if g == nil {
+				return nil, nil
+			}
+			return *g, nil
+		}
+	}
+	post.AddFieldConfig("title", &graphql.Field{Type: graphql.String})
+	post.AddFieldConfig("slug", &graphql.Field{Type: graphql.String})
+	post.AddFieldConfig("date", &graphql.Field{Type: graphql.DateTime})
+	post.AddFieldConfig("description", &graphql.Field{Type: graphql.String})
+	post.AddFieldConfig("draft", &graphql.Field{Type: graphql.Boolean})
+	post.AddFieldConfig("scheduled", &graphql.Field{Type: graphql.Boolean,
+		Resolve: postField(func(p *BlogPost) interface{} { return p.Scheduled() })})
+	post.AddFieldConfig("url", &graphql.Field{Type: graphql.String,
+		Resolve: postField(func(p *BlogPost) interface{} { return "/blog/" + p.Slug })})
+	post.AddFieldConfig("content", &graphql.Field{Type: graphql.String, Description: "Markdown body"})
+	post.AddFieldConfig("html", &graphql.Field{Type: graphql.String, Description: "Rendered body",
+		Resolve: postField(func(p *BlogPost) interface{} { return p.HTMLContent })})
+	post.AddFieldConfig("tags", &graphql.Field{Type: graphql.NewList(tag),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			groups := groupPosts(requestData(p).published(), postTags)
+			tags := []GraphQLGroup{}
+			for _, name := range p.Source.(*BlogPost).Tags {
+				if g := findGroup(groups, name); g != nil {
+					tags = append(tags, *g)
+				} else {
+					tags = append(tags, GraphQLGroup{Name: name})
+				}
+			}
+			return tags, nil
+		}})
+	post.AddFieldConfig("author", &graphql.Field{Type: author,
+		Resolve: groupField(postAuthor, func(p *BlogPost) string { return p.Author })})
+	post.AddFieldConfig("series", &graphql.Field{Type: series,
+		Resolve: groupField(postSeries, func(p *BlogPost) string { return p.Series })})
+
+	// list and lookup resolve the top-level fields of a group type
+	list := func(key func(*BlogPost) []string) graphql.FieldResolveFn {
+		return func(p graphql.ResolveParams) (interface{}, error) {
+			return groupPosts(requestData(p).published(), key), nil
+		}
+	}
+	lookup := func(key func(*BlogPost) []string) graphql.FieldResolveFn {
+		return func(p graphql.ResolveParams) (interface{}, error) {
+			g := findGroup(groupPosts(requestData(p).published(), key), p.Args["name"].(string))
+			if g == nil {
+				return nil, nil
+			}
+			return *g, nil
+		}
+	}
+	nameArg := graphql.FieldConfigArgument{"name": {Type: graphql.NewNonNull(graphql.String)}}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"posts": {Type: connection, Args: postsArgs("DATE_DESC"),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return filterPosts(p, nil, false)
+				}},
+			"post": {Type: post, Args: graphql.FieldConfigArgument{"slug": {Type: graphql.NewNonNull(graphql.String)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					data := requestData(p)
+					for _, post := range data.posts {
+						if post.Slug == p.Args["slug"] && (apiVisible(post) || data.private) {
+							return post, nil
+						}
+					}
+					return nil, nil
+				}},
+			"tags":       {Type: graphql.NewList(tag), Resolve: list(postTags)},
+			"tag":        {Type: tag, Args: nameArg, Resolve: lookup(postTags)},
+			"authors":    {Type: graphql.NewList(author), Resolve: list(postAuthor)},
+			"author":     {Type: author, Args: nameArg, Resolve: lookup(postAuthor)},
+			"seriesList": {Type: graphql.NewList(series), Resolve: list(postSeries)},
+			"series":     {Type: series, Args: nameArg, Resolve: lookup(postSeries)},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// graphqlRequest is the body of a GraphQL request
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// handleGraphQL runs a GraphQL query sent as a JSON POST body or as GET
+// query parameters
+func (s *Server) handleGraphQL(c *fiber.Ctx) error {
+	var req graphqlRequest
+	if c.Method() == fiber.MethodPost {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return apiError(c, fiber.StatusBadRequest, "invalid JSON: %v", err)
+		}
+	} else {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if v := c.Query("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				return apiError(c, fiber.StatusBadRequest, "invalid variables: %v", err)
+			}
+		}
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		return apiError(c, fiber.StatusBadRequest, "the query is required")
+	}
+
+	schema, err := graphqlSchema()
+	if err != nil {
+		return s.internalError(c, "Error building GraphQL schema", err)
+	}
+	posts, err := s.content.AllPosts()
+	if err != nil {
+		return s.internalError(c, "Error loading blog posts", err)
+	}
+
+	data := &graphqlData{posts: posts, private: apiTokenAllows(c, ScopeRead)}
+	result := graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+		Context:        context.WithValue(c.UserContext(), graphqlContextKey{}, data),
+	})
+	return c.JSON(result)
+}
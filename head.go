@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HeadConfig adds the site's own tags to every page, like web fonts,
+// verification tags or the script of a service without built-in support,
+// without changing the templates
+type HeadConfig struct {
+	// Tags are HTML tags put in the head as they are
+	Tags []string `yaml:"tags"`
+	// Stylesheets are CSS URLs linked after the built-in styles, so they can
+	// override them
+	Stylesheets []string `yaml:"stylesheets"`
+	// Scripts are JavaScript snippets run at the end of the body. Snippets
+	// that are already <script> tags, like ones loading a file, are added
+	// as they are.
+	Scripts []string `yaml:"scripts"`
+}
+
+func (c HeadConfig) validate() error {
+	for _, tag := range c.Tags {
+		if !strings.HasPrefix(strings.TrimSpace(tag), "<") {
+			return fmt.Errorf("head.tags %q is not an HTML tag", tag)
+		}
+	}
+	for _, href := range c.Stylesheets {
+		if u, err := url.Parse(href); err != nil || (u.Host == "" && !strings.HasPrefix(href, "/")) {
+			return fmt.Errorf("head.stylesheets %q is not an absolute URL or path", href)
+		}
+	}
+	return nil
+}
+
+// headTags are the tags HeadConfig adds to the head
+var headTags = template.Must(template.New("head").Parse(`
+{{- range .Stylesheets }}
+    <link rel="stylesheet" href="{{ . }}">
+{{- end }}
+{{- range .Tags }}
+    {{ . }}
+{{- end }}`))
+
+// registerHeadTags passes the tags from the head config to every page, as
+// HeadTags for layout.html to put in the head and BodyScripts for the end
+// of the body
+func (s *Server) registerHeadTags() {
+	cfg := s.cfg.Head
+	tags := make([]template.HTML, len(cfg.Tags))
+	for i, tag := range cfg.Tags {
+		tags[i] = template.HTML(tag)
+	}
+	var head bytes.Buffer
+	if err := headTags.Execute(&head, struct {
+		Stylesheets []string
+		Tags        []template.HTML
+	}{cfg.Stylesheets, tags}); err != nil {
+		slog.Error("Error rendering head tags", "error", err)
+		return
+	}
+
+	var scripts strings.Builder
+	for _, script := range cfg.Scripts {
+		script = strings.TrimSpace(script)
+		if strings.HasPrefix(script, "<script") {
+			fmt.Fprintf(&scripts, "\n    %s", script)
+		} else {
+			fmt.Fprintf(&scripts, "\n    <script>\n%s\n    </script>", script)
+		}
+	}
+
+	if head.Len() == 0 && scripts.Len() == 0 {
+		return
+	}
+	headHTML, scriptsHTML := template.HTML(head.String()), template.HTML(scripts.String())
+	s.app.Use(func(c *fiber.Ctx) error {
+		nonce := cspNonce(c)
+		c.Locals("HeadTags", withNonce(headHTML, nonce))
+		c.Locals("BodyScripts", withNonce(scriptsHTML, nonce))
+		return c.Next()
+	})
+}
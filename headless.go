@@ -0,0 +1,23 @@
+package main
+
+import "strings"
+
+// registerHeadlessRoutes serves the blog as a content backend for a separate
+// frontend: the JSON and GraphQL APIs, Micropub, feeds, sitemaps and media,
+// but no HTML pages
+func (s *Server) registerHeadlessRoutes() {
+	app := s.app
+	s.registerHealthRoutes()
+
+	app.Post("/admin/reload", s.requireAdminToken, s.handleAdminReload)
+	s.registerAPIRoutes()
+	s.registerMicropubRoutes()
+	s.registerHookRoutes()
+	s.registerFeedRoutes()
+	s.registerDebugRoutes()
+
+	app.Static(s.cfg.Media.URL, s.cfg.Media.Dir, s.staticConfig())
+	if s.cfg.Obsidian.Enabled {
+		app.Get(strings.TrimSuffix(s.cfg.Obsidian.URL, "/")+"/*", s.handleVaultFile)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// handleHealth reports that the process is up and able to serve requests
+func (s *Server) handleHealth(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// handleReady reports whether the content index is loaded and the templates
+// compiled, returning 503 until both are true so traffic is held back
+func (s *Server) handleReady(c *fiber.Ctx) error {
+	loaded, count, err := s.content.Status()
+	content := fiber.Map{"ok": loaded, "posts": count}
+	if err != nil {
+		content["error"] = err.Error()
+	}
+
+	templates := fiber.Map{"ok": s.templatesErr == nil}
+	if s.templatesErr != nil {
+		templates["error"] = s.templatesErr.Error()
+	}
+
+	status := "ready"
+	code := fiber.StatusOK
+	if !loaded || s.templatesErr != nil {
+		status = "not ready"
+		code = fiber.StatusServiceUnavailable
+	}
+
+	return c.Status(code).JSON(fiber.Map{
+		"status": status,
+		"checks": fiber.Map{
+			"content":   content,
+			"templates": templates,
+		},
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/html"
+	"github.com/tdewolff/minify/v2/js"
+)
+
+// htmlMinifier minifies pages along with their inline styles and scripts.
+// Document and end tags and attribute quotes are kept, so pages stay valid
+// for tools that read them, like the accessibility audit.
+var htmlMinifier = func() *minify.M {
+	m := minify.New()
+	m.Add("text/html", &html.Minifier{KeepDocumentTags: true, KeepEndTags: true, KeepQuotes: true})
+	m.AddFunc("text/css", css.Minify)
+	m.AddFuncRegexp(regexp.MustCompile(`^(application|text)/(x-)?(java|ecma)script$`), js.Minify)
+	return m
+}()
+
+// minifyHTMLMiddleware minifies successful HTML responses, and with them the
+// pages of static builds. A page that can't be minified is sent as it is.
+func minifyHTMLMiddleware(c *fiber.Ctx) error {
+	if err := c.Next(); err != nil {
+		return err
+	}
+	if c.Response().StatusCode() != fiber.StatusOK || c.Response().IsBodyStream() ||
+		!strings.HasPrefix(string(c.Response().Header.ContentType()), fiber.MIMETextHTML) {
+		return nil
+	}
+	out, err := htmlMinifier.Bytes("text/html", c.Response().Body())
+	if err != nil {
+		requestLogger(c).Debug("Error minifying page", "path", c.Path(), "error", err)
+		return nil
+	}
+	c.Response().SetBodyRaw(out)
+	return nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// I18nConfig serves translations of posts under a prefix per language, like
+// /de/blog/my-post for the German version of /blog/my-post
+type I18nConfig struct {
+	// DefaultLanguage is the language of the posts in the content directory
+	DefaultLanguage string `yaml:"default_language"`
+	// Languages are the languages posts are translated to. Translations are
+	// my-post.de.md next to my-post.md, or de/my-post.md in the content
+	// directory.
+	Languages []string `yaml:"languages"`
+	// Catalogs is the directory of the message catalogs that translate the
+	// templates' strings, one YAML file per language
+	Catalogs string `yaml:"catalogs"`
+	// DateFormat is how templates show dates by default: short, medium,
+	// long or full in each locale's own ordering, or a Go time layout
+	DateFormat string `yaml:"date_format"`
+}
+
+// languageCode matches the language codes used in file names and URLs
+var languageCode = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})?$`)
+
+// validate checks the language codes and the date format
+func (c *I18nConfig) validate() error {
+	if err := validateDateFormat(c.DateFormat); err != nil {
+		return err
+	}
+	if !languageCode.MatchString(c.DefaultLanguage) {
+		return fmt.Errorf("i18n.default_language %q is not a lowercase language code like en or pt-br", c.DefaultLanguage)
+	}
+	for i, lang := range c.Languages {
+		if !languageCode.MatchString(lang) {
+			return fmt.Errorf("i18n.languages: %q is not a lowercase language code like de or pt-br", lang)
+		}
+		if lang == c.DefaultLanguage || slices.Contains(c.Languages[:i], lang) {
+			return fmt.Errorf("i18n.languages: %q is listed twice or is the default language", lang)
+		}
+	}
+	return nil
+}
+
+// splitLanguage splits a post file name like my-post.de.md into its name and
+// language, which is empty unless it is one of languages
+func splitLanguage(name string, languages []string) (string, string) {
+	base := strings.TrimSuffix(name, ".md")
+	if i := strings.LastIndex(base, "."); i >= 0 && slices.Contains(languages, base[i+1:]) {
+		return base[:i], base[i+1:]
+	}
+	return base, ""
+}
+
+// translationKey is the name a post and its translations have in common
+func translationKey(post *BlogPost, languages []string) string {
+	name, _ := splitLanguage(filepath.Base(post.FilePath), languages)
+	return name
+}
+
+// getTranslatedPosts loads the translations in the content directory, both
+// my-post.de.md and de/my-post.md
+func getTranslatedPosts(contentDir string, languages []string, loc *time.Location) ([]*BlogPost, error) {
+	var translations []*BlogPost
+	if len(languages) == 0 {
+		return translations, nil
+	}
+	suffixed, err := readPostDir(contentDir, loc, func(name string) bool {
+		_, lang := splitLanguage(name, languages)
+		return lang != ""
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, post := range suffixed {
+		_, post.Lang = splitLanguage(filepath.Base(post.FilePath), languages)
+		translations = append(translations, post)
+	}
+	for _, lang := range languages {
+		posts, err := readPostDir(filepath.Join(contentDir, lang), loc, func(string) bool { return true })
+		if err != nil {
+			return nil, err
+		}
+		for _, post := range posts {
+			post.Lang = lang
+			translations = append(translations, post)
+		}
+	}
+	return translations, nil
+}
+
+// linkTranslations matches translations to their original post by file name.
+// A translation gets its original's slug, so both share comments, likes and
+// views, and the date, author, tags and series it leaves out. Translations
+// without an original are skipped.
+func linkTranslations(originals, translations []*BlogPost, languages []string) []*BlogPost {
+	byKey := make(map[string]*BlogPost, len(originals))
+	for _, post := range originals {
+		byKey[translationKey(post, languages)] = post
+	}
+	linked := translations[:0:0]
+	for _, t := range translations {
+		original := byKey[translationKey(t, languages)]
+		if original == nil {
+			slog.Warn("Skipping translation without an original post", "file", t.FilePath)
+			continue
+		}
+		t.Slug = original.Slug
+		if t.Date.IsZero() {
+			t.Date = original.Date
+		}
+		if t.Author == "" {
+			t.Author = original.Author
+		}
+		if len(t.Tags) == 0 {
+			t.Tags = original.Tags
+		}
+		if t.Series == "" {
+			t.Series = original.Series
+		}
+		linked = append(linked, t)
+	}
+	return linked
+}
+
+// languageName returns the name of a language in that language, like Deutsch
+func languageName(lang string) string {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return lang
+	}
+	if name := display.Self.Name(tag); name != "" {
+		return name
+	}
+	return lang
+}
+
+// rtlScripts are the scripts written from right to left
+var rtlScripts = map[string]bool{
+	"Adlm": true, "Arab": true, "Hebr": true, "Nkoo": true,
+	"Rohg": true, "Syrc": true, "Thaa": true,
+}
+
+// textDirection returns rtl for languages written from right to left, like
+// ar, fa or he, and ltr for the others
+func textDirection(lang string) string {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return "ltr"
+	}
+	if script, _ := tag.Script(); rtlScripts[script.String()] {
+		return "rtl"
+	}
+	return "ltr"
+}
+
+// languageLink is an entry of the language switcher on translated posts
+type languageLink struct {
+	Lang    string
+	Name    string
+	URL     string
+	Current bool
+}
+
+// languagePrefix returns the path prefix of a language, empty for the
+// default one
+func languagePrefix(lang string) string {
+	if lang == "" {
+		return ""
+	}
+	return "/" + lang
+}
+
+// pathLanguage returns the language of a /de/... path, or nothing for paths
+// without a language prefix
+func (s *Server) pathLanguage(c *fiber.Ctx) string {
+	for _, lang := range s.cfg.I18n.Languages {
+		if p := c.Path(); p == languagePrefix(lang) || strings.HasPrefix(p, languagePrefix(lang)+"/") {
+			return lang
+		}
+	}
+	return ""
+}
+
+// languageLinks lists the versions of a post in every language it is
+// available in, or nothing when it has no translations
+func (s *Server) languageLinks(c *fiber.Ctx, post *BlogPost) []languageLink {
+	translations := s.content.Translations(post.Slug)
+	if len(translations) == 0 {
+		return nil
+	}
+	links := []languageLink{{
+		Lang:    s.cfg.I18n.DefaultLanguage,
+		Name:    languageName(s.cfg.I18n.DefaultLanguage),
+		URL:     s.absoluteURL(c, "/blog/"+post.Slug),
+		Current: post.Lang == "",
+	}}
+	for _, lang := range s.cfg.I18n.Languages {
+		if _, ok := translations[lang]; ok {
+			links = append(links, languageLink{
+				Lang:    lang,
+				Name:    languageName(lang),
+				URL:     s.absoluteURL(c, languagePrefix(lang)+"/blog/"+post.Slug),
+				Current: post.Lang == lang,
+			})
+		}
+	}
+	return links
+}
+
+// registerLanguageRoutes serves the posts and listing of every language, and
+// passes the default language to every page as Lang and DefaultLang
+func (s *Server) registerLanguageRoutes() {
+	s.app.Use(func(c *fiber.Ctx) error {
+		c.Locals("Lang", s.cfg.I18n.DefaultLanguage)
+		c.Locals("DefaultLang", s.cfg.I18n.DefaultLanguage)
+		return c.Next()
+	})
+	for _, lang := range s.cfg.I18n.Languages {
+		s.app.Get(languagePrefix(lang)+"/blog/:slug", s.handleTranslation(lang))
+		s.paginationRoutes(languagePrefix(lang)+"/blog", s.handleLanguageBlog(lang))
+	}
+}
+
+// handleTranslation shows the translation of a post to lang, or the post in
+// the default language with a notice while it has none
+func (s *Server) handleTranslation(lang string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		post, ok := s.content.Translation(lang, c.Params("slug"))
+		if !ok {
+			if post, ok = s.content.Post(c.Params("slug")); !ok {
+				return fiber.NewError(fiber.StatusNotFound, "Blog post not found")
+			}
+			c.Locals(missingTranslationLocal, lang)
+		}
+		c.Locals(viewedPostLocal, post.Slug)
+		return s.renderPost(c, post)
+	}
+}
+
+// missingTranslationLocal holds the language a post was asked for in but
+// is not translated to
+const missingTranslationLocal = "devdaze.missingTranslation"
+
+// translationFallback tells the post page that it shows the post in the
+// default language because it is not translated to the language of the path
+func (s *Server) translationFallback(c *fiber.Ctx, view *PostView) {
+	lang, ok := c.Locals(missingTranslationLocal).(string)
+	if !ok {
+		return
+	}
+	view.MissingLanguage = languageName(lang)
+	view.DefaultLanguageName = languageName(s.cfg.I18n.DefaultLanguage)
+	view.SEO.Canonical = s.absoluteURL(c, "/blog/"+c.Params("slug"))
+}
+
+// handleLanguageBlog lists the posts in lang, and those not translated yet in
+// the default language
+func (s *Server) handleLanguageBlog(lang string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		posts, err := s.content.LanguagePosts(lang)
+		if err != nil {
+			return s.internalError(c, "Error loading blog posts", err)
+		}
+		list, err := s.listView(c, s.t(c, "All Blog Posts"), languagePrefix(lang)+"/blog", posts)
+		if err != nil {
+			return err
+		}
+		list.Lang = lang
+		list.LanguagePrefix = languagePrefix(lang)
+		list.DefaultLanguageName = languageName(s.cfg.I18n.DefaultLanguage)
+		return renderView(c, "blog", list)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"image"
+	"image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/image/draw"
+)
+
+// IconsConfig generates the favicon, the home screen icons and the web app
+// manifest from one image, and links them from every page
+type IconsConfig struct {
+	// Source is a PNG or JPEG, ideally square and at least 512 pixels wide.
+	// Other images are cropped to their center.
+	Source string `yaml:"source"`
+	// ThemeColor colors the browser around the site on phones
+	ThemeColor string `yaml:"theme_color"`
+	// BackgroundColor is shown while the site opens from the home screen
+	BackgroundColor string `yaml:"background_color"`
+}
+
+func (c IconsConfig) validate() error {
+	if c.Source == "" {
+		return nil
+	}
+	switch strings.ToLower(path.Ext(c.Source)) {
+	case ".png", ".jpg", ".jpeg":
+	default:
+		return fmt.Errorf("icons.source %q must be a PNG or JPEG image", c.Source)
+	}
+	return nil
+}
+
+// faviconSizes are the sizes packed into favicon.ico
+var faviconSizes = []int{16, 32, 48}
+
+// siteIcon is a generated icon, or the manifest listing them
+type siteIcon struct {
+	// Path is the URL path the icon is served at, like /favicon.ico
+	Path        string
+	ContentType string
+	Content     []byte
+}
+
+// webManifest is the web app manifest phones add the site to the home
+// screen with
+type webManifest struct {
+	Name            string            `json:"name"`
+	ShortName       string            `json:"short_name"`
+	StartURL        string            `json:"start_url"`
+	Display         string            `json:"display"`
+	ThemeColor      string            `json:"theme_color,omitempty"`
+	BackgroundColor string            `json:"background_color,omitempty"`
+	Icons           []webManifestIcon `json:"icons"`
+}
+
+type webManifestIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+// buildIcons generates every icon from icons.source. It returns nothing when
+// no source is configured.
+func buildIcons(cfg *Config) ([]siteIcon, error) {
+	if cfg.Icons.Source == "" {
+		return nil, nil
+	}
+	f, err := os.Open(cfg.Icons.Source)
+	if err != nil {
+		return nil, fmt.Errorf("error opening icon source: %v", err)
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding icon source %s: %v", cfg.Icons.Source, err)
+	}
+	src = cropSquare(src)
+
+	favicon, err := encodeICO(src, faviconSizes)
+	if err != nil {
+		return nil, err
+	}
+	icons := []siteIcon{{Path: "/favicon.ico", ContentType: "image/x-icon", Content: favicon}}
+	manifest := webManifest{
+		Name:            cfg.Title,
+		ShortName:       cfg.Title,
+		StartURL:        "/",
+		Display:         "standalone",
+		ThemeColor:      cfg.Icons.ThemeColor,
+		BackgroundColor: cfg.Icons.BackgroundColor,
+	}
+	for _, icon := range []struct {
+		path     string
+		size     int
+		manifest bool
+	}{
+		{"/apple-touch-icon.png", 180, false},
+		{"/icon-192.png", 192, true},
+		{"/icon-512.png", 512, true},
+	} {
+		data, err := encodeIconPNG(src, icon.size)
+		if err != nil {
+			return nil, err
+		}
+		icons = append(icons, siteIcon{Path: icon.path, ContentType: "image/png", Content: data})
+		if icon.manifest {
+			manifest.Icons = append(manifest.Icons, webManifestIcon{
+				Src:   icon.path,
+				Sizes: fmt.Sprintf("%dx%d", icon.size, icon.size),
+				Type:  "image/png",
+			})
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	icons = append(icons, siteIcon{Path: "/site.webmanifest", ContentType: "application/manifest+json", Content: data})
+	return icons, nil
+}
+
+// cropSquare cuts the largest square out of the center of img
+func cropSquare(img image.Image) image.Image {
+	b := img.Bounds()
+	size := min(b.Dx(), b.Dy())
+	x, y := b.Min.X+(b.Dx()-size)/2, b.Min.Y+(b.Dy()-size)/2
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(dst, dst.Bounds(), img, image.Pt(x, y), draw.Src)
+	return dst
+}
+
+// scaleIcon scales a square image to size pixels, up or down
+func scaleIcon(img image.Image, size int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+func encodeIconPNG(img image.Image, size int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleIcon(img, size)); err != nil {
+		return nil, fmt.Errorf("error encoding %dpx icon: %v", size, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeICO packs PNGs of the given sizes into an .ico file, as every
+// current browser reads them
+func encodeICO(img image.Image, sizes []int) ([]byte, error) {
+	images := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		data, err := encodeIconPNG(img, size)
+		if err != nil {
+			return nil, err
+		}
+		images[i] = data
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, [3]uint16{0, 1, uint16(len(sizes))})
+	offset := 6 + 16*len(sizes)
+	for i, size := range sizes {
+		// A dimension of 0 stands for 256
+		dim := uint8(size % 256)
+		binary.Write(&buf, binary.LittleEndian, struct {
+			Width, Height, Colors, Reserved uint8
+			Planes, BitCount                uint16
+			Size, Offset                    uint32
+		}{dim, dim, 0, 0, 1, 32, uint32(len(images[i])), uint32(offset)})
+		offset += len(images[i])
+	}
+	for _, data := range images {
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// iconTags link the generated icons from the head
+var iconTags = template.Must(template.New("icons").Parse(`
+    <link rel="icon" href="/favicon.ico" sizes="48x48">
+    <link rel="apple-touch-icon" href="/apple-touch-icon.png">
+    <link rel="manifest" href="/site.webmanifest">
+{{- with .ThemeColor }}
+    <meta name="theme-color" content="{{ . }}">
+{{- end }}`))
+
+// registerIcons serves the icons generated at startup, in place of any in
+// the public folders, and passes the tags linking them to every page as
+// IconTags
+func (s *Server) registerIcons() {
+	if len(s.icons) == 0 {
+		return
+	}
+	var tags bytes.Buffer
+	if err := iconTags.Execute(&tags, s.cfg.Icons); err != nil {
+		return
+	}
+	html := template.HTML(tags.String())
+	s.app.Use(func(c *fiber.Ctx) error {
+		c.Locals("IconTags", html)
+		return c.Next()
+	})
+
+	cacheControl := "no-cache"
+	if !s.cfg.Development() {
+		cacheControl = "public, max-age=3600"
+	}
+	for _, icon := range s.icons {
+		s.app.Get(icon.Path, func(c *fiber.Ctx) error {
+			c.Set(fiber.HeaderContentType, icon.ContentType)
+			c.Set(fiber.HeaderCacheControl, cacheControl)
+			return c.Send(icon.Content)
+		})
+	}
+}
+
+// writeIcons writes the icons of a static build into the output directory,
+// unless the previous build already did, and records them in the manifest.
+// It returns how many it wrote.
+func writeIcons(cfg *Config, dir string, previous, current *buildManifest) (int, error) {
+	icons, err := buildIcons(cfg)
+	if err != nil {
+		return 0, err
+	}
+	written := 0
+	for _, icon := range icons {
+		rel := strings.TrimPrefix(icon.Path, "/")
+		sum := sha256.Sum256(icon.Content)
+		h := hex.EncodeToString(sum[:])
+		// A file of the same name in the public folders was just copied over
+		// the icon, so it has to be written again
+		_, copied := current.Assets[rel]
+		current.Assets[rel] = h
+		if !copied && previous.Assets[rel] == h && fileExists(filepath.Join(dir, rel)) {
+			continue
+		}
+		if err := writeOutputFile(dir, rel, icon.Content); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
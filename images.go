@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gen2brain/avif"
+	"github.com/gen2brain/webp"
+	"golang.org/x/image/draw"
+)
+
+// variantsDir is the media subdirectory holding generated image variants
+const variantsDir = "_variants"
+
+// resizableExtensions are the image types the pipeline can decode and resize
+var resizableExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true}
+
+// imageFormats maps the extra formats that can be generated to their MIME types
+var imageFormats = map[string]string{
+	"avif": "image/avif",
+	"webp": "image/webp",
+}
+
+// imagePipeline generates resized and re-encoded variants of media images
+// and rewrites rendered HTML to reference them
+type imagePipeline struct {
+	dir     string
+	url     string
+	widths  []int
+	formats []string
+	quality int
+	sizes   string
+}
+
+// newImagePipeline creates a pipeline for the configured media directory
+func newImagePipeline(cfg *Config) *imagePipeline {
+	widths := append([]int(nil), cfg.Media.Widths...)
+	sort.Ints(widths)
+
+	return &imagePipeline{
+		dir:     cfg.Media.Dir,
+		url:     strings.TrimSuffix(cfg.Media.URL, "/"),
+		widths:  widths,
+		formats: cfg.Media.Formats,
+		quality: cfg.Media.Quality,
+		sizes:   cfg.Media.Sizes,
+	}
+}
+
+// variantPath returns where the variant of a media file is stored, relative
+// to the media directory
+func variantPath(name string, width int, ext string) string {
+	stem := strings.TrimSuffix(name, path.Ext(name))
+	return path.Join(variantsDir, fmt.Sprintf("%s-%d.%s", stem, width, ext))
+}
+
+// Process generates the missing variants of one media file. name is relative
+// to the media directory; files that are not resizable images are ignored.
+func (p *imagePipeline) Process(name string) (int, error) {
+	ext := strings.ToLower(path.Ext(name))
+	if !resizableExtensions[ext] {
+		return 0, nil
+	}
+
+	src := filepath.Join(p.dir, filepath.FromSlash(name))
+	info, err := os.Stat(src)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	widths, original := p.outputWidths(f)
+	var img image.Image
+	generated := 0
+	for _, width := range widths {
+		for _, format := range p.variantFormats(ext) {
+			// The original file already covers its own format at full size
+			if width == original && format == strings.TrimPrefix(ext, ".") {
+				continue
+			}
+
+			dst := filepath.Join(p.dir, filepath.FromSlash(variantPath(name, width, format)))
+			if existing, err := os.Stat(dst); err == nil && !existing.ModTime().Before(info.ModTime()) {
+				continue
+			}
+
+			// Only decode the original once something actually needs generating
+			if img == nil {
+				if _, err := f.Seek(0, 0); err != nil {
+					return generated, err
+				}
+				if img, _, err = image.Decode(f); err != nil {
+					return generated, fmt.Errorf("error decoding %s: %v", name, err)
+				}
+			}
+
+			if err := p.writeVariant(dst, resizeImage(img, width), format); err != nil {
+				return generated, fmt.Errorf("error generating %s: %v", dst, err)
+			}
+			generated++
+		}
+	}
+	return generated, nil
+}
+
+// ProcessAll generates missing variants for every image in the media directory
+func (p *imagePipeline) ProcessAll() (int, error) {
+	if _, err := os.Stat(p.dir); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	generated := 0
+	err := filepath.WalkDir(p.dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == variantsDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(p.dir, file)
+		if err != nil {
+			return err
+		}
+		n, err := p.Process(filepath.ToSlash(rel))
+		generated += n
+		if err != nil {
+			// A single unreadable image should not stop the rest
+			slog.Warn("Error processing image", "file", rel, "error", err)
+		}
+		return nil
+	})
+	return generated, err
+}
+
+// outputWidths returns the widths generated for an image, each configured
+// width smaller than the original plus the original width itself, and the
+// original width
+func (p *imagePipeline) outputWidths(f *os.File) ([]int, int) {
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, 0
+	}
+
+	var widths []int
+	for _, w := range p.widths {
+		if w > 0 && w < cfg.Width {
+			widths = append(widths, w)
+		}
+	}
+	return append(widths, cfg.Width), cfg.Width
+}
+
+// variantFormats returns the encodings generated for an image with the given
+// extension: the original format plus each configured extra format
+func (p *imagePipeline) variantFormats(ext string) []string {
+	formats := []string{strings.TrimPrefix(ext, ".")}
+	for _, format := range p.formats {
+		if _, ok := imageFormats[format]; ok {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// writeVariant encodes img in the given format and writes it to dst
+func (p *imagePipeline) writeVariant(dst string, img image.Image, format string) error {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpg", "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: p.quality})
+	case "png":
+		err = png.Encode(&buf, img)
+	case "webp":
+		err = webp.Encode(&buf, img, webp.Options{Quality: p.quality, Method: 4})
+	case "avif":
+		err = avif.Encode(&buf, img, avif.Options{Quality: p.quality, QualityAlpha: p.quality, Speed: 8})
+	default:
+		err = fmt.Errorf("unsupported format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, buf.Bytes(), 0644)
+}
+
+// resizeImage scales img down to the given width, keeping its aspect ratio
+func resizeImage(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= width {
+		return img
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+var (
+	imgTagPattern  = regexp.MustCompile(`<img\b[^>]*>`)
+	imgAttrPattern = regexp.MustCompile(`\s([a-zA-Z-]+)="([^"]*)"`)
+)
+
+// Rewrite adds lazy loading to every image in rendered HTML and points media
+// images at their generated variants with srcset and <picture> sources
+func (p *imagePipeline) Rewrite(content string) string {
+	if !strings.Contains(content, "<img") {
+		return content
+	}
+	return imgTagPattern.ReplaceAllStringFunc(content, p.rewriteTag)
+}
+
+// rewriteTag rewrites a single <img> tag
+func (p *imagePipeline) rewriteTag(tag string) string {
+	attrs := map[string]string{}
+	var order []string
+	for _, m := range imgAttrPattern.FindAllStringSubmatch(tag, -1) {
+		name := strings.ToLower(m[1])
+		if _, seen := attrs[name]; !seen {
+			order = append(order, name)
+		}
+		attrs[name] = html.UnescapeString(m[2])
+	}
+
+	set := func(name, value string) {
+		if _, ok := attrs[name]; !ok {
+			order = append(order, name)
+			attrs[name] = value
+		}
+	}
+	set("loading", "lazy")
+	set("decoding", "async")
+
+	name, ok := p.mediaName(attrs["src"])
+	if !ok {
+		return buildTag("img", attrs, order)
+	}
+
+	info, sources := p.variants(name)
+	if info == nil {
+		return buildTag("img", attrs, order)
+	}
+
+	set("width", strconv.Itoa(info.Width))
+	set("height", strconv.Itoa(info.Height))
+	if srcset := sources[strings.TrimPrefix(strings.ToLower(path.Ext(name)), ".")]; srcset != "" {
+		set("srcset", srcset)
+		if p.sizes != "" {
+			set("sizes", p.sizes)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("<picture>")
+	// Browsers use the first source they support, so list the smallest format first
+	for _, format := range []string{"avif", "webp"} {
+		if srcset := sources[format]; srcset != "" {
+			source := map[string]string{"type": imageFormats[format], "srcset": srcset, "sizes": p.sizes}
+			order := []string{"type", "srcset"}
+			if p.sizes != "" {
+				order = append(order, "sizes")
+			}
+			b.WriteString(buildTag("source", source, order))
+		}
+	}
+	b.WriteString(buildTag("img", attrs, order))
+	b.WriteString("</picture>")
+	return b.String()
+}
+
+// mediaName returns the media file an image URL refers to, if it is a
+// resizable image in the media directory
+func (p *imagePipeline) mediaName(src string) (string, bool) {
+	if !strings.HasPrefix(src, p.url+"/") {
+		return "", false
+	}
+	name := path.Clean(strings.TrimPrefix(src, p.url+"/"))
+	if strings.HasPrefix(name, "..") || strings.HasPrefix(name, variantsDir+"/") {
+		return "", false
+	}
+	return name, resizableExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// variants looks up the generated variants of an image and returns its
+// dimensions and a srcset per format. Only variants that exist on disk are
+// included, so images that have not been processed yet are left alone.
+func (p *imagePipeline) variants(name string) (*image.Config, map[string]string) {
+	f, err := os.Open(filepath.Join(p.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, nil
+	}
+	defer f.Close()
+
+	info, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, nil
+	}
+
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(name)), ".")
+	sources := map[string]string{}
+	for _, format := range p.variantFormats("." + ext) {
+		var entries []string
+		for _, w := range p.widths {
+			if w <= 0 || w >= info.Width {
+				continue
+			}
+			variant := variantPath(name, w, format)
+			if fileExists(filepath.Join(p.dir, filepath.FromSlash(variant))) {
+				entries = append(entries, fmt.Sprintf("%s/%s %dw", p.url, variant, w))
+			}
+		}
+
+		// The original is the largest entry in its own format
+		full := p.url + "/" + variantPath(name, info.Width, format)
+		if format == ext {
+			full = p.url + "/" + name
+		} else if !fileExists(filepath.Join(p.dir, filepath.FromSlash(variantPath(name, info.Width, format)))) {
+			full = ""
+		}
+		if full != "" {
+			entries = append(entries, fmt.Sprintf("%s %dw", full, info.Width))
+		}
+
+		if len(entries) > 1 || (format != ext && len(entries) > 0) {
+			sources[format] = strings.Join(entries, ", ")
+		}
+	}
+	return &info, sources
+}
+
+// buildTag renders an HTML tag from its attributes in their original order
+func buildTag(name string, attrs map[string]string, order []string) string {
+	var b strings.Builder
+	b.WriteString("<" + name)
+	for _, attr := range order {
+		fmt.Fprintf(&b, ` %s="%s"`, attr, html.EscapeString(attrs[attr]))
+	}
+	b.WriteString(">")
+	return b.String()
+}
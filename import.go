@@ -0,0 +1,932 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ImportOptions controls an import of the posts of another static site
+// generator into the content directory
+type ImportOptions struct {
+	// Source is the generator or platform the site is built with: jekyll,
+	// hugo, wordpress, ghost or medium
+	Source string
+	// Path is the root directory of a Jekyll or Hugo site, or the export
+	// file of a WordPress, Ghost or Medium one
+	Path string
+	// Sections are the sections of a Hugo site that hold posts
+	Sections []string
+	// Redirects is a file to write the old URLs of the posts to, as a
+	// _redirects file of Netlify and similar hosts
+	Redirects string
+	// DryRun lists the posts that would be imported without writing anything
+	DryRun bool
+}
+
+// defaultHugoSections are the Hugo sections imported when none are given
+var defaultHugoSections = []string{"posts", "post", "blog"}
+
+// importedPost is a post read from another generator, before its links and
+// assets are rewritten
+type importedPost struct {
+	post *BlogPost
+	// file is the path of the post in the imported site
+	file string
+	// keys are the names other posts link to this one by, like the
+	// 2024-05-02-my-post of Jekyll's post_url
+	keys []string
+}
+
+// siteImporter converts the posts of a site into this engine's content
+type siteImporter struct {
+	out  io.Writer
+	cfg  *Config
+	opts ImportOptions
+	// assetRoot is the directory the site serves root-relative paths like
+	// /images/cover.png from
+	assetRoot string
+	// contentRoot is the directory posts are read from
+	contentRoot string
+	posts       []*importedPost
+	// copied maps the assets copied so far to their new URL
+	copied map[string]string
+}
+
+// importSite converts the posts of a Jekyll, Hugo, WordPress, Ghost or Medium
+// site into markdown files in the content directory. Their old URLs become
+// aliases, and the files they reference are copied to the media directory.
+func importSite(out io.Writer, cfg *Config, opts ImportOptions) error {
+	imp := &siteImporter{out: out, cfg: cfg, opts: opts, copied: make(map[string]string)}
+
+	// Each source reads its posts and returns how to convert their bodies
+	var err error
+	var convert func(p *importedPost) string
+	switch opts.Source {
+	case "jekyll", "hugo":
+		if info, err := os.Stat(opts.Path); err != nil || !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", opts.Path)
+		}
+		if opts.Source == "jekyll" {
+			convert, err = imp.readJekyll()
+		} else {
+			convert, err = imp.readHugo()
+		}
+	case "wordpress":
+		convert, err = imp.readWordPress()
+	case "ghost":
+		convert, err = imp.readGhost()
+	case "medium":
+		convert, err = imp.readMedium()
+	default:
+		return fmt.Errorf("unknown import source %q, expected jekyll, hugo, wordpress, ghost or medium", opts.Source)
+	}
+	if err != nil {
+		return err
+	}
+
+	written, skipped := 0, 0
+	var redirects strings.Builder
+	slugs := make(map[string]string)
+	for _, p := range imp.posts {
+		if first, ok := slugs[p.post.Slug]; ok {
+			slog.Warn("Skipping post with a duplicate slug", "file", p.file, "slug", p.post.Slug, "first", first)
+			skipped++
+			continue
+		}
+		slugs[p.post.Slug] = p.file
+
+		p.post.Content = convert(p)
+		ok, err := imp.write(p)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			skipped++
+			continue
+		}
+		written++
+		for _, alias := range p.post.Aliases {
+			fmt.Fprintf(&redirects, "%s %s 301\n", alias, postPath(p.post))
+		}
+	}
+
+	if opts.Redirects != "" && !opts.DryRun {
+		if err := os.WriteFile(opts.Redirects, []byte(redirects.String()), 0644); err != nil {
+			return fmt.Errorf("error writing redirects: %v", err)
+		}
+	}
+
+	verb := "Imported"
+	if opts.DryRun {
+		verb = "Would import"
+	}
+	fmt.Fprintf(out, "%s %d posts from %s into %s (%d assets copied, %d skipped)\n",
+		verb, written, opts.Path, cfg.ContentDir, len(imp.copied), skipped)
+	return nil
+}
+
+// write writes an imported post to the content directory, leaving existing
+// files alone, and reports whether it did
+func (imp *siteImporter) write(p *importedPost) (bool, error) {
+	target := filepath.Join(imp.cfg.ContentDir, p.post.Slug+".md")
+	if fileExists(target) {
+		slog.Warn("Skipping post that already exists", "file", p.file, "target", target)
+		return false, nil
+	}
+	if imp.opts.DryRun {
+		fmt.Fprintf(imp.out, "%s -> %s\n", p.file, target)
+		return true, nil
+	}
+
+	data, err := formatPostFile(p.post)
+	if err != nil {
+		return false, fmt.Errorf("error converting %s: %v", p.file, err)
+	}
+	if err := os.MkdirAll(imp.cfg.ContentDir, 0755); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(target, data, 0644); err != nil {
+		return false, err
+	}
+	fmt.Fprintf(imp.out, "%s -> %s\n", p.file, target)
+	return true, nil
+}
+
+// jekyllPermalinkStyles are the permalink styles Jekyll names
+var jekyllPermalinkStyles = map[string]string{
+	"date":     "/:categories/:year/:month/:day/:title:output_ext",
+	"pretty":   "/:categories/:year/:month/:day/:title/",
+	"ordinal":  "/:categories/:year/:y_day/:title:output_ext",
+	"weekdate": "/:categories/:year/W:week/:short_day/:title:output_ext",
+	"none":     "/:categories/:title:output_ext",
+}
+
+// jekyllPostName matches the 2024-05-02-my-post file names of Jekyll posts
+var jekyllPostName = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-(.+)$`)
+
+// Liquid tags Jekyll posts link to other posts and assets with
+var (
+	liquidPostURL   = regexp.MustCompile(`\{%-?\s*post_url\s+(\S+?)\s*-?%\}`)
+	liquidSiteURL   = regexp.MustCompile(`\{\{-?\s*site\.(?:baseurl|url)\s*-?\}\}`)
+	liquidURLFilter = regexp.MustCompile(`\{\{-?\s*["']([^"']+)["']\s*\|\s*(?:relative_url|absolute_url)\s*-?\}\}`)
+	liquidTag       = regexp.MustCompile(`\{%|\{\{`)
+)
+
+// readJekyll reads the posts in _posts and the drafts in _drafts, and
+// returns the function that rewrites their post_url links and copies their
+// assets
+func (imp *siteImporter) readJekyll() (func(*importedPost) string, error) {
+	imp.assetRoot = imp.opts.Path
+	imp.contentRoot = imp.opts.Path
+
+	config, err := readSiteConfig(imp.opts.Path, "_config.yml", "_config.yaml")
+	if err != nil {
+		return nil, err
+	}
+	permalink := stringValue(config["permalink"])
+	if permalink == "" {
+		permalink = "date"
+	}
+	if style, ok := jekyllPermalinkStyles[permalink]; ok {
+		permalink = style
+	}
+
+	for _, dir := range []string{"_posts", "_drafts"} {
+		err := walkMarkdown(filepath.Join(imp.opts.Path, dir), func(file string, fm map[string]interface{}, body string) error {
+			p := imp.jekyllPost(file, fm, body, permalink)
+			if dir == "_drafts" {
+				// Drafts were never published under their permalink
+				p.post.Draft = true
+				p.post.Aliases = importAliases(p.post, "", listValue(fm["redirect_from"], ""), "/")
+			}
+			imp.posts = append(imp.posts, p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(imp.posts) == 0 {
+		return nil, fmt.Errorf("no posts found in %s", filepath.Join(imp.opts.Path, "_posts"))
+	}
+
+	bySlug := imp.postKeys()
+	return func(p *importedPost) string {
+		body := liquidPostURL.ReplaceAllStringFunc(p.post.Content, func(tag string) string {
+			key := path.Base(liquidPostURL.FindStringSubmatch(tag)[1])
+			if slug, ok := bySlug[key]; ok {
+				return "/blog/" + slug
+			}
+			return tag
+		})
+		body = liquidURLFilter.ReplaceAllString(body, "$1")
+		return imp.copyAssets(p, liquidSiteURL.ReplaceAllString(body, ""))
+	}, nil
+}
+
+// jekyllPost converts a Jekyll post, dated by its file name unless its
+// frontmatter says otherwise
+func (imp *siteImporter) jekyllPost(file string, fm map[string]interface{}, body, permalink string) *importedPost {
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	title := name
+	date := fileDate(file, imp.cfg.Location())
+	if m := jekyllPostName.FindStringSubmatch(name); m != nil {
+		title = m[2]
+		if t, err := time.ParseInLocation("2006-01-02", m[1], imp.cfg.Location()); err == nil {
+			date = t
+		}
+	}
+	if t, ok := timeValue(fm["date"], imp.cfg.Location()); ok {
+		date = t
+	}
+	// :title keeps the case of the file name, :slug does not
+	urlTitle := title
+	if s := stringValue(fm["slug"]); s != "" {
+		urlTitle = s
+	}
+	slug := slugify(urlTitle)
+
+	categories := append(listValue(fm["categories"], " "), listValue(fm["category"], " ")...)
+	post := &BlogPost{
+		Title:       stringValue(fm["title"]),
+		Date:        date,
+		Author:      firstValue(fm["author"]),
+		Description: firstString(fm, "description", "excerpt"),
+		Tags:        mergeTags(listValue(fm["tags"], " "), categories),
+		Slug:        slug,
+		Content:     strings.TrimSpace(body),
+	}
+	if published, ok := boolValue(fm["published"]); ok && !published {
+		post.Draft = true
+	}
+	if post.Title == "" {
+		post.Title = title
+	}
+
+	if p := stringValue(fm["permalink"]); p != "" {
+		permalink = p
+	}
+	var categoryPath []string
+	for _, category := range categories {
+		categoryPath = append(categoryPath, slugify(category))
+	}
+	old := strings.NewReplacer(
+		":categories", strings.Join(categoryPath, "/"),
+		":year", date.Format("2006"),
+		":short_year", date.Format("06"),
+		":month", date.Format("01"),
+		":i_month", strconv.Itoa(int(date.Month())),
+		":short_month", date.Format("Jan"),
+		":long_month", date.Format("January"),
+		":day", date.Format("02"),
+		":i_day", strconv.Itoa(date.Day()),
+		":y_day", fmt.Sprintf("%03d", date.YearDay()),
+		":week", weekNumber(date),
+		":short_day", date.Format("Mon"),
+		":long_day", date.Format("Monday"),
+		":hour", date.Format("15"),
+		":minute", date.Format("04"),
+		":second", date.Format("05"),
+		":title", urlTitle,
+		":slug", slug,
+		":output_ext", ".html",
+	).Replace(permalink)
+	post.Aliases = importAliases(post, old, listValue(fm["redirect_from"], ""), "/")
+
+	return &importedPost{post: post, file: file, keys: []string{name}}
+}
+
+// Hugo shortcodes posts link to other posts with
+var (
+	hugoRef       = regexp.MustCompile(`\{\{[<%]\s*(?:rel)?ref\s+"([^"]+)"\s*[>%]\}\}`)
+	hugoShortcode = regexp.MustCompile(`\{\{[<%]`)
+)
+
+// readHugo reads the posts in the sections of a Hugo site, and returns the
+// function that rewrites their ref and relref links and copies their assets
+func (imp *siteImporter) readHugo() (func(*importedPost) string, error) {
+	config, err := readSiteConfig(imp.opts.Path, "hugo.toml", "hugo.yaml", "hugo.yml", "hugo.json",
+		"config.toml", "config.yaml", "config.yml", "config.json")
+	if err != nil {
+		return nil, err
+	}
+	imp.contentRoot = filepath.Join(imp.opts.Path, "content")
+	if dir := stringValue(config["contentdir"]); dir != "" {
+		imp.contentRoot = filepath.Join(imp.opts.Path, dir)
+	}
+	imp.assetRoot = filepath.Join(imp.opts.Path, "static")
+	if dir := stringValue(config["staticdir"]); dir != "" {
+		imp.assetRoot = filepath.Join(imp.opts.Path, dir)
+	}
+
+	sections := imp.opts.Sections
+	if len(sections) == 0 {
+		for _, section := range defaultHugoSections {
+			if info, err := os.Stat(filepath.Join(imp.contentRoot, section)); err == nil && info.IsDir() {
+				sections = append(sections, section)
+			}
+		}
+		if len(sections) == 0 {
+			return nil, fmt.Errorf("%s has none of the %s sections, pick the sections holding posts with --section",
+				imp.contentRoot, strings.Join(defaultHugoSections, ", "))
+		}
+	}
+
+	for _, section := range sections {
+		permalink := firstString(config, "permalinks."+strings.ToLower(section), "permalinks.page."+strings.ToLower(section))
+		err := walkMarkdown(filepath.Join(imp.contentRoot, section), func(file string, fm map[string]interface{}, body string) error {
+			if strings.HasPrefix(filepath.Base(file), "_index.") {
+				return nil
+			}
+			imp.posts = append(imp.posts, imp.hugoPost(file, section, fm, body, permalink))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(imp.posts) == 0 {
+		return nil, fmt.Errorf("no posts found in the %s sections of %s", strings.Join(sections, ", "), imp.contentRoot)
+	}
+
+	bySlug := imp.postKeys()
+	return func(p *importedPost) string {
+		body := hugoRef.ReplaceAllStringFunc(p.post.Content, func(shortcode string) string {
+			ref, fragment, _ := strings.Cut(hugoRef.FindStringSubmatch(shortcode)[1], "#")
+			key := strings.TrimSuffix(strings.Trim(ref, "/"), ".md")
+			if slug, ok := bySlug[key]; ok {
+				if fragment != "" {
+					return "/blog/" + slug + "#" + fragment
+				}
+				return "/blog/" + slug
+			}
+			return shortcode
+		})
+		return imp.copyAssets(p, body)
+	}, nil
+}
+
+// hugoPost converts a Hugo page, either a file or the index.md of a page
+// bundle
+func (imp *siteImporter) hugoPost(file, section string, fm map[string]interface{}, body, permalink string) *importedPost {
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	if name == "index" {
+		name = filepath.Base(filepath.Dir(file))
+	}
+	rel, _ := filepath.Rel(imp.contentRoot, file)
+	rel = filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel)))
+
+	date := fileDate(file, imp.cfg.Location())
+	for _, key := range []string{"date", "publishdate", "pubdate"} {
+		if t, ok := timeValue(fm[key], imp.cfg.Location()); ok {
+			date = t
+			break
+		}
+	}
+	title := stringValue(fm["title"])
+	slug := slugify(name)
+	if s := stringValue(fm["slug"]); s != "" {
+		slug = slugify(s)
+	}
+	draft, _ := boolValue(fm["draft"])
+
+	post := &BlogPost{
+		Title:       title,
+		Date:        date,
+		Author:      firstString(fm, "author", "authors"),
+		Description: firstString(fm, "description", "summary"),
+		Tags:        mergeTags(listValue(fm["tags"], ","), listValue(fm["categories"], ",")),
+		Slug:        slug,
+		Draft:       draft,
+		Series:      firstValue(fm["series"]),
+		Content:     strings.TrimSpace(body),
+	}
+	if post.Title == "" {
+		post.Title = name
+	}
+
+	// Hugo's default URL is the path of the page with its slug last
+	dir := path.Dir(rel)
+	if path.Base(rel) == "index" {
+		dir = path.Dir(dir)
+	}
+	old := "/" + dir + "/" + slug + "/"
+	if permalink != "" {
+		titleSlug := slugify(title)
+		if titleSlug == "" {
+			titleSlug = slug
+		}
+		old = strings.NewReplacer(
+			":year", date.Format("2006"),
+			":monthname", strings.ToLower(date.Format("January")),
+			":month", date.Format("01"),
+			":day", date.Format("02"),
+			":weekdayname", strings.ToLower(date.Format("Monday")),
+			":weekday", strconv.Itoa(int(date.Weekday())),
+			":yearday", strconv.Itoa(date.YearDay()),
+			":sections", dir,
+			":section", section,
+			":title", titleSlug,
+			":slugorcontentbasename", slug,
+			":slugorfilename", slug,
+			":slug", slug,
+			":contentbasename", slugify(name),
+			":filename", slugify(name),
+		).Replace(permalink)
+	}
+	if url := stringValue(fm["url"]); url != "" {
+		old = url
+	}
+	post.Aliases = importAliases(post, old, listValue(fm["aliases"], ""), "/"+section+"/")
+
+	keys := []string{name, rel, strings.TrimSuffix(rel, "/index")}
+	return &importedPost{post: post, file: file, keys: keys}
+}
+
+// postKeys maps the names posts link to each other by to their slugs
+func (imp *siteImporter) postKeys() map[string]string {
+	bySlug := make(map[string]string)
+	for _, p := range imp.posts {
+		for _, key := range p.keys {
+			bySlug[key] = p.post.Slug
+		}
+	}
+	return bySlug
+}
+
+// importAliases lists the old URL of a post and its redirects, resolving
+// relative ones against base and leaving out its new URL
+func importAliases(post *BlogPost, old string, redirects []string, base string) []string {
+	var aliases []string
+	for _, alias := range append([]string{old}, redirects...) {
+		if alias == "" {
+			continue
+		}
+		if !strings.HasPrefix(alias, "/") {
+			alias = base + alias
+		}
+		alias = path.Clean(strings.ReplaceAll(alias, "//", "/"))
+		if alias != "/blog/"+post.Slug && !slices.Contains(aliases, alias) {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases
+}
+
+// assetRef matches the URLs of markdown links and images, reference
+// definitions and the src and href attributes of HTML
+var assetRef = regexp.MustCompile(`(?m)(?:\]\(\s*<?|\b(?:src|href)\s*=\s*["']|^\s*\[[^\]]+\]:\s*)([^)"'\s>]+)`)
+
+// copyAssets copies the local files body references to the media directory
+// and points the references at their copies. Liquid tags and shortcodes left
+// over are logged, as they are shown as they are.
+func (imp *siteImporter) copyAssets(p *importedPost, body string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range assetRef.FindAllStringSubmatchIndex(body, -1) {
+		b.WriteString(body[last:m[2]])
+		b.WriteString(imp.copyAsset(p, body[m[2]:m[3]]))
+		last = m[3]
+	}
+	b.WriteString(body[last:])
+
+	switch imp.opts.Source {
+	case "jekyll":
+		if liquidTag.MatchString(b.String()) {
+			slog.Warn("Post keeps Liquid tags that have to be converted by hand", "file", p.file)
+		}
+	case "hugo":
+		if hugoShortcode.MatchString(b.String()) {
+			slog.Warn("Post keeps shortcodes that have to be converted by hand", "file", p.file)
+		}
+	}
+	return b.String()
+}
+
+// copyAsset copies the file a URL in a post points to, if it is a local one,
+// and returns the URL of the copy. Root-relative URLs are files of the site,
+// others are next to the post, like the resources of a Hugo page bundle.
+func (imp *siteImporter) copyAsset(p *importedPost, ref string) string {
+	if strings.Contains(ref, "://") || strings.HasPrefix(ref, "//") || strings.HasPrefix(ref, "#") ||
+		strings.HasPrefix(ref, "mailto:") || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "/blog/") {
+		return ref
+	}
+	name, suffix := ref, ""
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		name, suffix = ref[:i], ref[i:]
+	}
+	if ext := strings.ToLower(path.Ext(name)); ext == "" || ext == ".md" || ext == ".html" {
+		return ref
+	}
+
+	var src, rel string
+	if strings.HasPrefix(name, "/") {
+		src = filepath.Join(imp.assetRoot, filepath.FromSlash(name))
+		rel = strings.TrimPrefix(path.Clean(name), "/")
+	} else {
+		src = filepath.Join(filepath.Dir(p.file), filepath.FromSlash(name))
+		r, err := filepath.Rel(imp.contentRoot, src)
+		if err != nil || strings.HasPrefix(r, "..") {
+			return ref
+		}
+		rel = p.post.Slug + "/" + path.Clean(name)
+		if strings.HasPrefix(rel, p.post.Slug+"/..") {
+			rel = filepath.ToSlash(r)
+		}
+	}
+	if url, ok := imp.copied[src]; ok {
+		return url + suffix
+	}
+	if info, err := os.Stat(src); err != nil || !info.Mode().IsRegular() {
+		return ref
+	}
+
+	if !imp.opts.DryRun {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			slog.Warn("Error reading asset", "file", src, "error", err)
+			return ref
+		}
+		if err := writeOutputFile(imp.cfg.Media.Dir, filepath.FromSlash(rel), data); err != nil {
+			slog.Warn("Error copying asset", "file", src, "error", err)
+			return ref
+		}
+	}
+	url := strings.TrimSuffix(imp.cfg.Media.URL, "/") + "/" + rel
+	imp.copied[src] = url
+	return url + suffix
+}
+
+// walkMarkdown calls fn with the frontmatter and body of each markdown file
+// under dir. A missing dir has no files, and files that cannot be read are
+// skipped with a warning.
+func walkMarkdown(dir string, fn func(file string, fm map[string]interface{}, body string) error) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".md", ".markdown", ".mdown", ".mkd":
+		default:
+			return nil
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			slog.Warn("Error reading file", "file", file, "error", err)
+			return nil
+		}
+		fm, body, err := readFrontmatter(data)
+		if err != nil {
+			slog.Warn("Error parsing file", "file", file, "error", err)
+			return nil
+		}
+		return fn(file, fm, body)
+	})
+}
+
+// readFrontmatter splits a post into its frontmatter, in YAML between ---,
+// TOML between +++ or a JSON object, and its body. Keys are lowercased, as
+// Hugo ignores their case.
+func readFrontmatter(data []byte) (map[string]interface{}, string, error) {
+	content := string(bytes.TrimPrefix(data, []byte("\ufeff")))
+	fm := make(map[string]interface{})
+	var body string
+	switch {
+	case strings.HasPrefix(content, "---"):
+		raw, rest, err := splitFrontmatter(content)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := yaml.Unmarshal([]byte(raw), &fm); err != nil {
+			return nil, "", fmt.Errorf("error parsing frontmatter: %v", err)
+		}
+		body = rest
+	case strings.HasPrefix(content, "+++"):
+		raw, rest, ok := strings.Cut(content[3:], "\n+++")
+		if !ok {
+			return nil, "", fmt.Errorf("invalid frontmatter format")
+		}
+		var err error
+		if fm, err = parseTOML(raw); err != nil {
+			return nil, "", fmt.Errorf("error parsing frontmatter: %v", err)
+		}
+		body = rest
+	case strings.HasPrefix(content, "{"):
+		dec := json.NewDecoder(strings.NewReader(content))
+		if err := dec.Decode(&fm); err != nil {
+			return nil, "", fmt.Errorf("error parsing frontmatter: %v", err)
+		}
+		body = content[dec.InputOffset():]
+	default:
+		return nil, "", fmt.Errorf("no frontmatter found")
+	}
+	return flattenKeys("", fm), body, nil
+}
+
+// readSiteConfig reads the first of the given config files in dir that
+// exists, in YAML, TOML or JSON, with the keys of nested tables joined by
+// dots and lowercased
+func readSiteConfig(dir string, names ...string) (map[string]interface{}, error) {
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		config := make(map[string]interface{})
+		switch filepath.Ext(name) {
+		case ".toml":
+			config, err = parseTOML(string(data))
+		case ".json":
+			err = json.Unmarshal(data, &config)
+		default:
+			err = yaml.Unmarshal(data, &config)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", name, err)
+		}
+		return flattenKeys("", config), nil
+	}
+	return map[string]interface{}{}, nil
+}
+
+// flattenKeys lowercases the keys of m and joins those of nested maps to
+// their parent's with a dot, like permalinks.posts
+func flattenKeys(prefix string, m map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+	for key, value := range m {
+		key = prefix + strings.ToLower(key)
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for k, nested := range flattenKeys(key+".", v) {
+				flat[k] = nested
+			}
+		case map[interface{}]interface{}:
+			converted := make(map[string]interface{}, len(v))
+			for k, nested := range v {
+				converted[fmt.Sprint(k)] = nested
+			}
+			for k, nested := range flattenKeys(key+".", converted) {
+				flat[k] = nested
+			}
+		default:
+			flat[key] = value
+		}
+	}
+	return flat
+}
+
+// parseTOML reads the subset of TOML that frontmatter and site configs use:
+// key = value pairs of strings, numbers, booleans, dates and arrays of them,
+// in [tables] that prefix their keys. Arrays of tables are skipped.
+func parseTOML(text string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	prefix := ""
+	skipping := false
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(stripTOMLComment(lines[i]))
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "[["):
+			skipping = true
+			continue
+		case strings.HasPrefix(line, "["):
+			skipping = false
+			prefix = strings.ToLower(strings.Trim(line, "[] ")) + "."
+			continue
+		case skipping:
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", i+1)
+		}
+		value = strings.TrimSpace(value)
+		// Arrays and multi-line strings continue on the following lines
+		for (strings.HasPrefix(value, "[") && strings.Count(value, "[") > strings.Count(value, "]")) ||
+			(strings.HasPrefix(value, `"""`) && (len(value) < 6 || !strings.HasSuffix(value, `"""`))) {
+			i++
+			if i == len(lines) {
+				return nil, fmt.Errorf("unterminated value of %s", strings.TrimSpace(key))
+			}
+			if strings.HasPrefix(value, `"""`) {
+				value += "\n" + lines[i]
+			} else {
+				value += " " + strings.TrimSpace(stripTOMLComment(lines[i]))
+			}
+		}
+		parsed, err := parseTOMLValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		values[prefix+strings.ToLower(strings.Trim(strings.TrimSpace(key), `"'`))] = parsed
+	}
+	return values, nil
+}
+
+// stripTOMLComment removes a # comment that is not inside a string
+func stripTOMLComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseTOMLValue reads a single TOML value. Dates and numbers are kept as
+// strings, which timeValue and the other accessors read.
+func parseTOMLValue(value string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(value, `"""`):
+		return strings.TrimPrefix(strings.TrimSuffix(value[3:], `"""`), "\n"), nil
+	case strings.HasPrefix(value, `"`):
+		return strconv.Unquote(value)
+	case strings.HasPrefix(value, "'"):
+		return strings.Trim(value, "'"), nil
+	case value == "true" || value == "false":
+		return value == "true", nil
+	case strings.HasPrefix(value, "["):
+		var items []interface{}
+		for _, item := range splitTOMLArray(strings.TrimSpace(value[1 : len(value)-1])) {
+			parsed, err := parseTOMLValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, parsed)
+		}
+		return items, nil
+	}
+	return value, nil
+}
+
+// splitTOMLArray splits the items of an array at the commas outside strings
+func splitTOMLArray(s string) []string {
+	var items []string
+	var quote rune
+	start := 0
+	for i, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == ',':
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}
+
+// stringValue returns a frontmatter value as a string, empty for lists and
+// missing values
+func stringValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil, []interface{}:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// listValue returns a frontmatter value that is a list, or a string of items
+// separated by sep
+func listValue(v interface{}, sep string) []string {
+	var items []string
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if s := stringValue(item); s != "" {
+				items = append(items, s)
+			}
+		}
+	case string:
+		if sep == "" {
+			return []string{strings.TrimSpace(v)}
+		}
+		for _, item := range strings.Split(v, sep) {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// firstValue returns a frontmatter string, or the first item of a list
+func firstValue(v interface{}) string {
+	if items := listValue(v, ""); len(items) > 0 {
+		return items[0]
+	}
+	return ""
+}
+
+// firstString returns the first of the keys that is set in fm
+func firstString(fm map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if s := firstValue(fm[key]); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// boolValue returns a frontmatter boolean, and whether it is set
+func boolValue(v interface{}) (bool, bool) {
+	switch v := v.(type) {
+	case bool:
+		return v, true
+	case string:
+		b, err := strconv.ParseBool(v)
+		return b, err == nil
+	}
+	return false, false
+}
+
+// importDateLayouts are the date formats Jekyll and Hugo frontmatter uses
+var importDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 -07:00",
+	"2006-01-02 15:04 -0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// timeValue returns a frontmatter date, in loc when it has no time zone
+func timeValue(v interface{}, loc *time.Location) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range importDateLayouts {
+			if t, err := time.ParseInLocation(layout, strings.TrimSpace(v), loc); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// fileDate returns the modification time of a file, for posts without a date
+func fileDate(file string, loc *time.Location) time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Now().In(loc).Truncate(time.Second)
+	}
+	return info.ModTime().In(loc).Truncate(time.Second)
+}
+
+// weekNumber returns the ISO week of t as two digits
+func weekNumber(t time.Time) string {
+	_, week := t.ISOWeek()
+	return fmt.Sprintf("%02d", week)
+}
+
+// mergeTags joins tags and categories, which this engine has no separate
+// notion of, without duplicates
+func mergeTags(tags, categories []string) []string {
+	var merged []string
+	for _, tag := range append(tags, categories...) {
+		if !slices.ContainsFunc(merged, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			merged = append(merged, tag)
+		}
+	}
+	return merged
+}
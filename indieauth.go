@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// IndieAuthConfig runs an IndieAuth server for the blog's own URL, so
+// Micropub clients are authorized by signing in to the dashboard instead of
+// through a third-party service
+type IndieAuthConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// TokenTTL is how long access tokens work, 90 days by default
+	TokenTTL time.Duration `yaml:"token_ttl"`
+}
+
+// indieAuthCodeTTL is how long an authorization code can be redeemed
+const indieAuthCodeTTL = 10 * time.Minute
+
+// indieAuthScopes are the scopes the consent page offers
+var indieAuthScopes = []string{"create", "update", "delete", "media", "profile"}
+
+// indieAuthGrant is what the signed codes and tokens carry
+type indieAuthGrant struct {
+	Me          string `json:"me"`
+	ClientID    string `json:"client_id"`
+	RedirectURI string `json:"redirect_uri,omitempty"`
+	Scope       string `json:"scope"`
+	Challenge   string `json:"code_challenge,omitempty"`
+}
+
+// redeemedCodes remembers used authorization codes until they expire, so
+// each can only be exchanged once
+var redeemedCodes sync.Map
+
+// redeemCode marks code as used and reports whether it was unused
+func redeemCode(code string) bool {
+	now := time.Now()
+	redeemedCodes.Range(func(k, v any) bool {
+		if now.After(v.(time.Time)) {
+			redeemedCodes.Delete(k)
+		}
+		return true
+	})
+	_, used := redeemedCodes.LoadOrStore(code, now.Add(indieAuthCodeTTL))
+	return !used
+}
+
+// registerIndieAuthRoutes wires up the authorization and token endpoints.
+// The consent page lives in the admin area, see registerAdminRoutes.
+func (s *Server) registerIndieAuthRoutes() {
+	if !s.cfg.IndieAuth.Enabled {
+		return
+	}
+	s.app.Get("/.well-known/oauth-authorization-server", s.handleIndieAuthMetadata)
+	s.app.Get("/indieauth", func(c *fiber.Ctx) error {
+		return c.Redirect("/admin/indieauth?"+string(c.Request().URI().QueryString()), fiber.StatusFound)
+	})
+	s.app.Post("/indieauth", s.handleIndieAuthRedeem(false))
+	s.app.Post("/indieauth/token", s.handleIndieAuthRedeem(true))
+	s.app.Get("/indieauth/token", s.handleIndieAuthVerify)
+}
+
+// indieAuthMe is the URL the server signs people in as
+func (s *Server) indieAuthMe() string {
+	if s.cfg.Micropub.Me != "" {
+		return s.cfg.Micropub.Me
+	}
+	return strings.TrimSuffix(s.cfg.BaseURL, "/") + "/"
+}
+
+// handleIndieAuthMetadata describes the server for clients that discover it
+// through rel="indieauth-metadata"
+func (s *Server) handleIndieAuthMetadata(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"issuer":                                         s.absoluteURL(nil, "/"),
+		"authorization_endpoint":                         s.absoluteURL(nil, "/indieauth"),
+		"token_endpoint":                                 s.absoluteURL(nil, "/indieauth/token"),
+		"scopes_supported":                               indieAuthScopes,
+		"response_types_supported":                       []string{"code"},
+		"grant_types_supported":                          []string{"authorization_code"},
+		"code_challenge_methods_supported":               []string{"S256"},
+		"authorization_response_iss_parameter_supported": true,
+	})
+}
+
+// indieAuthRequest is an authorization request as sent by a client
+type indieAuthRequest struct {
+	ClientID    string
+	RedirectURI string
+	State       string
+	Challenge   string
+	Scopes      []string
+}
+
+// ScopeChecked reports whether the consent page ticks scope, which it does
+// for the scopes the client asked for
+func (r *indieAuthRequest) ScopeChecked(scope string) bool {
+	return slices.Contains(r.Scopes, scope) || (scope != "delete" && slices.Contains(r.Scopes, "post"))
+}
+
+// parseIndieAuthRequest checks an authorization request. The redirect URI
+// must be on the client's own host.
+func parseIndieAuthRequest(get func(string) string) (*indieAuthRequest, error) {
+	req := &indieAuthRequest{
+		ClientID:    get("client_id"),
+		RedirectURI: get("redirect_uri"),
+		State:       get("state"),
+		Challenge:   get("code_challenge"),
+		Scopes:      strings.Fields(get("scope")),
+	}
+	if t := get("response_type"); t != "code" && t != "id" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "response_type must be code")
+	}
+	client, err := url.Parse(req.ClientID)
+	if err != nil || (client.Scheme != "https" && client.Scheme != "http") || client.Host == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "client_id must be an http(s) URL")
+	}
+	redirect, err := url.Parse(req.RedirectURI)
+	if err != nil || redirect.Scheme != client.Scheme || redirect.Host != client.Host {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "redirect_uri must be on the host of client_id")
+	}
+	if req.Challenge == "" || get("code_challenge_method") != "S256" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "a S256 code_challenge is required")
+	}
+	return req, nil
+}
+
+// handleIndieAuthConsent asks the signed-in admin whether to let a client
+// publish as the blog
+func (s *Server) handleIndieAuthConsent(c *fiber.Ctx) error {
+	req, err := parseIndieAuthRequest(func(k string) string { return c.Query(k) })
+	if err != nil {
+		return err
+	}
+	return c.Render("admin_indieauth", fiber.Map{
+		"Title":   "Authorize " + req.ClientID,
+		"Request": req,
+		"Me":      s.indieAuthMe(),
+		"Scopes":  indieAuthScopes,
+	})
+}
+
+// handleIndieAuthApprove issues an authorization code for the scopes the
+// admin ticked and sends the browser back to the client
+func (s *Server) handleIndieAuthApprove(c *fiber.Ctx) error {
+	req, err := parseIndieAuthRequest(func(k string) string { return c.FormValue(k) })
+	if err != nil {
+		return err
+	}
+	var scopes []string
+	for _, scope := range indieAuthScopes {
+		if c.FormValue("scope_"+scope) != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	grant, _ := json.Marshal(indieAuthGrant{
+		Me:          s.indieAuthMe(),
+		ClientID:    req.ClientID,
+		RedirectURI: req.RedirectURI,
+		Scope:       strings.Join(scopes, " "),
+		Challenge:   req.Challenge,
+	})
+	code := s.signer.Sign("indieauth-code|"+string(grant), indieAuthCodeTTL)
+	s.audit(c, "Authorize %s for %s", req.ClientID, strings.Join(scopes, ", "))
+
+	redirect, _ := url.Parse(req.RedirectURI)
+	q := redirect.Query()
+	q.Set("code", code)
+	q.Set("state", req.State)
+	q.Set("iss", s.absoluteURL(nil, "/"))
+	redirect.RawQuery = q.Encode()
+	return c.Redirect(redirect.String(), fiber.StatusFound)
+}
+
+// handleIndieAuthRedeem exchanges an authorization code. The authorization
+// endpoint answers with the profile URL, the token endpoint with an access
+// token as well.
+func (s *Server) handleIndieAuthRedeem(issueToken bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.FormValue("grant_type") != "authorization_code" {
+			return indieAuthError(c, fiber.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code")
+		}
+		code := c.FormValue("code")
+		value, ok := s.signer.Verify(code)
+		payload, isCode := strings.CutPrefix(value, "indieauth-code|")
+		var grant indieAuthGrant
+		if !ok || !isCode || json.Unmarshal([]byte(payload), &grant) != nil {
+			return indieAuthError(c, fiber.StatusBadRequest, "invalid_grant", "the code is invalid or has expired")
+		}
+		if grant.ClientID != c.FormValue("client_id") || grant.RedirectURI != c.FormValue("redirect_uri") {
+			return indieAuthError(c, fiber.StatusBadRequest, "invalid_grant", "client_id or redirect_uri do not match the code")
+		}
+		sum := sha256.Sum256([]byte(c.FormValue("code_verifier")))
+		challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(challenge), []byte(grant.Challenge)) != 1 {
+			return indieAuthError(c, fiber.StatusBadRequest, "invalid_grant", "code_verifier does not match the code_challenge")
+		}
+		if !redeemCode(code) {
+			return indieAuthError(c, fiber.StatusBadRequest, "invalid_grant", "the code has already been used")
+		}
+
+		if !issueToken || grant.Scope == "" {
+			return c.JSON(fiber.Map{"me": grant.Me})
+		}
+		ttl := s.cfg.IndieAuth.TokenTTL
+		grant.RedirectURI, grant.Challenge = "", ""
+		token, _ := json.Marshal(grant)
+		requestLogger(c).Info("Issued IndieAuth token", "client_id", grant.ClientID, "scope", grant.Scope)
+		return c.JSON(fiber.Map{
+			"access_token": s.signer.Sign("indieauth|"+string(token), ttl),
+			"token_type":   "Bearer",
+			"scope":        grant.Scope,
+			"me":           grant.Me,
+			"expires_in":   int(ttl.Seconds()),
+		})
+	}
+}
+
+// verifyIndieAuthToken checks an access token issued by this server
+func (s *Server) verifyIndieAuthToken(token string) (micropubToken, bool) {
+	value, ok := s.signer.Verify(token)
+	payload, isToken := strings.CutPrefix(value, "indieauth|")
+	var grant indieAuthGrant
+	if !ok || !isToken || json.Unmarshal([]byte(payload), &grant) != nil {
+		return micropubToken{}, false
+	}
+	return micropubToken{Me: grant.Me, ClientID: grant.ClientID, Scope: grant.Scope}, true
+}
+
+// handleIndieAuthVerify tells clients what a bearer token is for, like the
+// token endpoints Micropub servers ask
+func (s *Server) handleIndieAuthVerify(c *fiber.Ctx) error {
+	token, _ := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	info, ok := s.verifyIndieAuthToken(token)
+	if !ok {
+		return indieAuthError(c, fiber.StatusUnauthorized, "invalid_token", "the token is invalid or has expired")
+	}
+	return c.JSON(info)
+}
+
+// indieAuthError responds with an OAuth 2.0 error body
+func indieAuthError(c *fiber.Ctx, code int, kind, description string) error {
+	return c.Status(code).JSON(fiber.Map{"error": kind, "error_description": description})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LikesConfig adds an anonymous like button to posts
+type LikesConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Database stores the likes, one per post and visitor
+	Database string `yaml:"database"`
+}
+
+var likeMigrations = []string{
+	`CREATE TABLE likes (
+		post TEXT NOT NULL,
+		visitor TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (post, visitor)
+	);`,
+}
+
+// likeStore counts the likes of posts
+type likeStore struct {
+	db *sql.DB
+}
+
+// newLikeStore opens the likes database of cfg, or returns nil when likes
+// are disabled
+func newLikeStore(cfg *Config) (*likeStore, error) {
+	if !cfg.Likes.Enabled {
+		return nil, nil
+	}
+	db, err := openDatabase(cfg.Likes.Database, likeMigrations)
+	if err != nil {
+		return nil, err
+	}
+	return &likeStore{db: db}, nil
+}
+
+// Like records that visitor likes post and reports whether it is a new like
+func (s *likeStore) Like(post, visitor string) (bool, error) {
+	res, err := s.db.Exec("INSERT INTO likes (post, visitor, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		post, visitor, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Count returns the number of likes of post
+func (s *likeStore) Count(post string) (int, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM likes WHERE post = ?", post).Scan(&n)
+	return n, err
+}
+
+// likeVisitor identifies the client liking a post. Only a hash of the IP is
+// stored, so the database does not list readers' addresses.
+func likeVisitor(c *fiber.Ctx, slug string) string {
+	sum := sha256.Sum256([]byte(slug + "|" + c.IP()))
+	return hex.EncodeToString(sum[:])
+}
+
+// postLikes returns the like count shown on a post page, or nil when likes
+// are disabled
+func (s *Server) postLikes(c *fiber.Ctx, post *BlogPost) *int {
+	if s.likes == nil || !apiVisible(post) {
+		return nil
+	}
+	n, err := s.likes.Count(post.Slug)
+	if err != nil {
+		requestLogger(c).Error("Error counting likes", "slug", post.Slug, "error", err)
+		return nil
+	}
+	return &n
+}
+
+// handleLike likes a published post once per client IP. Scripts get the new
+// count as JSON; the page's form, when scripts are off, is sent back to the
+// post.
+func (s *Server) handleLike(c *fiber.Ctx) error {
+	if s.likes == nil {
+		return apiError(c, fiber.StatusNotFound, "likes are disabled")
+	}
+	post := s.findPost(c.Params("slug"))
+	if post == nil || !apiVisible(post) {
+		return apiError(c, fiber.StatusNotFound, "post %q not found", c.Params("slug"))
+	}
+
+	added, err := s.likes.Like(post.Slug, likeVisitor(c, post.Slug))
+	if err != nil {
+		requestLogger(c).Error("Error saving like", "slug", post.Slug, "error", err)
+		return apiError(c, fiber.StatusInternalServerError, "could not save the like")
+	}
+	count, err := s.likes.Count(post.Slug)
+	if err != nil {
+		requestLogger(c).Error("Error counting likes", "slug", post.Slug, "error", err)
+		return apiError(c, fiber.StatusInternalServerError, "could not count the likes")
+	}
+
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationForm) {
+		return c.Redirect("/blog/"+post.Slug+"#likes", fiber.StatusSeeOther)
+	}
+	status := fiber.StatusOK
+	if added {
+		status = fiber.StatusCreated
+	}
+	return c.Status(status).JSON(fiber.Map{"slug": post.Slug, "likes": count, "new": added})
+}
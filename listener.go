@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sdListenFdsStart is the first file descriptor passed by systemd
+const sdListenFdsStart = 3
+
+// openListener creates the listener described by spec:
+//
+//	""            TCP on the configured port
+//	host:port     TCP on the given address
+//	unix:/path    a Unix domain socket, replacing any stale socket file
+//	fd:N          an already open socket inherited as file descriptor N
+//	systemd       the first socket passed by systemd socket activation
+func openListener(spec, port string) (net.Listener, error) {
+	switch {
+	case spec == "":
+		return net.Listen("tcp", ":"+port)
+
+	case strings.HasPrefix(spec, "unix:"):
+		path := strings.TrimPrefix(spec, "unix:")
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		// Let a reverse proxy in the same group connect
+		if err := os.Chmod(path, 0660); err != nil {
+			ln.Close()
+			return nil, err
+		}
+		return ln, nil
+
+	case strings.HasPrefix(spec, "fd:"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(spec, "fd:"))
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid file descriptor in %q", spec)
+		}
+		return fileListener(fd)
+
+	case spec == "systemd":
+		if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid != os.Getpid() {
+			return nil, fmt.Errorf("no sockets passed by systemd (LISTEN_PID does not match)")
+		}
+		if n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS")); n < 1 {
+			return nil, fmt.Errorf("no sockets passed by systemd (LISTEN_FDS is not set)")
+		}
+		return fileListener(sdListenFdsStart)
+
+	default:
+		return net.Listen("tcp", spec)
+	}
+}
+
+// fileListener wraps an inherited socket file descriptor in a net.Listener
+func fileListener(fd int) (net.Listener, error) {
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+	if f == nil {
+		return nil, fmt.Errorf("file descriptor %d is not open", fd)
+	}
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("file descriptor %d is not a listening socket: %v", fd, err)
+	}
+	return ln, nil
+}
+
+// removeStaleSocket deletes a socket file left behind by a previous run,
+// refusing to touch anything that is not a socket
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
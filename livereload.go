@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// liveReloadPath is the server-sent events endpoint browsers listen on
+const liveReloadPath = "/__livereload"
+
+// liveReloadScript is injected into HTML pages in development
+const liveReloadScript = `<script>(function () {
+  var es = new EventSource("` + liveReloadPath + `");
+  es.addEventListener("reload", function () { location.reload(); });
+})();</script>`
+
+// LiveReloader pushes reload events to every connected browser
+type LiveReloader struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{}
+}
+
+// newLiveReloader creates a reloader with no connected clients
+func newLiveReloader() *LiveReloader {
+	return &LiveReloader{clients: make(map[chan struct{}]struct{})}
+}
+
+// Notify tells every connected browser to reload
+func (lr *LiveReloader) Notify() {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	for ch := range lr.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// A reload is already pending for this client
+		}
+	}
+}
+
+func (lr *LiveReloader) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	lr.mu.Lock()
+	lr.clients[ch] = struct{}{}
+	lr.mu.Unlock()
+	return ch
+}
+
+func (lr *LiveReloader) unsubscribe(ch chan struct{}) {
+	lr.mu.Lock()
+	delete(lr.clients, ch)
+	lr.mu.Unlock()
+}
+
+// handleEvents streams reload events to a browser until it disconnects
+func (lr *LiveReloader) handleEvents(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ch := lr.subscribe()
+		defer lr.unsubscribe(ch)
+
+		ping := time.NewTicker(15 * time.Second)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-ch:
+				fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+			case <-ping.C:
+				fmt.Fprint(w, ": ping\n\n")
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// injectMiddleware adds the live reload client to successful HTML responses
+func (lr *LiveReloader) injectMiddleware(c *fiber.Ctx) error {
+	if err := c.Next(); err != nil {
+		return err
+	}
+
+	if c.Response().StatusCode() != fiber.StatusOK ||
+		!strings.HasPrefix(string(c.Response().Header.ContentType()), fiber.MIMETextHTML) {
+		return nil
+	}
+
+	script := string(withNonce(liveReloadScript, cspNonce(c)))
+	body := c.Response().Body()
+	if i := bytes.LastIndex(body, []byte("</body>")); i >= 0 {
+		out := make([]byte, 0, len(body)+len(script))
+		out = append(out, body[:i]...)
+		out = append(out, script...)
+		out = append(out, body[i:]...)
+		c.Response().SetBodyRaw(out)
+	}
+	return nil
+}
+
+// watchDirs polls dirs for added, removed or modified files and calls
+// onChange after each change until ctx is cancelled
+func watchDirs(ctx context.Context, dirs []string, interval time.Duration, onChange func()) {
+	last := snapshotDirs(dirs)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := snapshotDirs(dirs)
+			if current != last {
+				last = current
+				onChange()
+			}
+		}
+	}
+}
+
+// snapshotDirs fingerprints every file under dirs by name, size and mtime
+func snapshotDirs(dirs []string) string {
+	var b strings.Builder
+	for _, dir := range dirs {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			fmt.Fprintf(&b, "%s|%d|%d\n", path, info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+	}
+	return b.String()
+}
+
+// startLiveReload watches the content, templates and public assets and
+// reloads connected browsers whenever any of them change
+func (s *Server) startLiveReload(ctx context.Context) {
+	dirs := append([]string{s.cfg.ContentDir}, s.cfg.TemplateDirs()...)
+	dirs = append(dirs, s.cfg.PublicDirs()...)
+	if len(s.cfg.Assets.Bundles) > 0 {
+		dirs = append(dirs, s.cfg.Assets.Dir)
+	}
+	go watchDirs(ctx, dirs, 500*time.Millisecond, func() {
+		slog.Debug("Change detected, reloading browsers")
+		s.liveReload.Notify()
+	})
+}
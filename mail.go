@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPConfig is the mail server notifications are sent through
+type SMTPConfig struct {
+	Host string `yaml:"host"`
+	// Port is 587 by default. Port 465 uses TLS from the start, other ports
+	// upgrade with STARTTLS when the server offers it.
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// From is the sender address, such as "Blog <blog@example.com>"
+	From string `yaml:"from"`
+}
+
+// outgoingMail is a message to send. Messages with HTML are sent as
+// multipart/alternative with Text as the plain text version.
+type outgoingMail struct {
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+	// Headers are added to the standard ones, like List-Unsubscribe
+	Headers map[string]string
+}
+
+// sendMessage sends a message through the configured server
+func sendMessage(cfg SMTPConfig, m outgoingMail) error {
+	if cfg.Host == "" || cfg.From == "" {
+		return fmt.Errorf("smtp.host and smtp.from must be set to send mail")
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return fmt.Errorf("invalid smtp.from: %v", err)
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	if cfg.Port == 465 {
+		conn = tls.Client(conn, &tls.Config{ServerName: cfg.Host})
+	}
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, rcpt := range m.To {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(mailMessage(cfg.From, from.Address, m)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// mailMessage formats a UTF-8 message with its headers
+func mailMessage(from, sender string, m outgoingMail) []byte {
+	domain := sender[strings.LastIndex(sender, "@")+1:]
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", randomToken(), domain)
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, m.Headers[name])
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	text := crlf(m.Text)
+	if m.HTML == "" {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+		b.WriteString(text)
+		return []byte(b.String())
+	}
+
+	// HTML lines can be longer than SMTP allows, quoted-printable wraps them
+	var html strings.Builder
+	qp := quotedprintable.NewWriter(&html)
+	qp.Write([]byte(m.HTML))
+	qp.Close()
+
+	boundary := randomToken()
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(text)
+	fmt.Fprintf(&b, "\r\n--%s\r\n", boundary)
+	b.WriteString("Content-Type: text/html; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	b.WriteString(html.String())
+	fmt.Fprintf(&b, "\r\n--%s--\r\n", boundary)
+	return []byte(b.String())
+}
+
+// crlf turns the line endings of a text body into the CRLF mail uses
+func crlf(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+}
+
+// notify sends an email in the background. Notifications are a
+// convenience, so failures are only logged.
+func (s *Server) notify(m outgoingMail) {
+	if len(m.To) == 0 {
+		return
+	}
+	go func() {
+		if err := sendMessage(s.cfg.SMTP, m); err != nil {
+			slog.Error("Error sending email", "to", m.To, "subject", m.Subject, "error", err)
+			return
+		}
+		slog.Info("Sent email", "to", m.To, "subject", m.Subject)
+	}()
+}
@@ -2,16 +2,12 @@ package main
 
 import (
 	"fmt"
-	"html/template"
-	"log"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/template/html/v2"
 	"github.com/russross/blackfriday/v2"
 	"gopkg.in/yaml.v2"
 )
@@ -24,154 +20,131 @@ type BlogPost struct {
 	Description string    `yaml:"description"`
 	Tags        []string  `yaml:"tags"`
 	Slug        string    `yaml:"slug"`
+	Draft       bool      `yaml:"draft"`
+	Series      string    `yaml:"series"`
+	PublishAt   time.Time `yaml:"publish_at"`
+	Comments    *bool     `yaml:"comments"`
+	Dir         string    `yaml:"dir"`
+	Aliases     []string  `yaml:"aliases"`
+	Canonical   string    `yaml:"canonical"`
+	Syndication []string  `yaml:"syndication"`
 	Content     string    `yaml:"-"`
 	HTMLContent string    `yaml:"-"`
+	FilePath    string    `yaml:"-"`
+	// Lang is the language of a translation, empty for posts in the
+	// default language
+	Lang string `yaml:"-"`
+	// Names are the other names wikilinks of an Obsidian vault link to the
+	// post by
+	Names []string `yaml:"-"`
 }
 
-// BlogMetadata represents the frontmatter of a markdown file
-type BlogMetadata struct {
-	Title       string    `yaml:"title"`
-	Date        time.Time `yaml:"date"`
-	Author      string    `yaml:"author"`
-	Description string    `yaml:"description"`
-	Tags        []string  `yaml:"tags"`
-	Slug        string    `yaml:"slug"`
+// Scheduled reports whether the post is dated in the future. Scheduled posts
+// stay hidden outside development until their date has passed.
+func (p *BlogPost) Scheduled() bool {
+	return !p.Draft && p.Date.After(time.Now())
 }
 
-func main() {
-	// Initialize template engine
-	engine := html.New("./internal/templates", ".html")
-	engine.Reload(true) // Optional. Default: false
-
-	// Add custom template function for raw HTML
-	engine.AddFunc("raw", func(s interface{}) template.HTML {
-		switch v := s.(type) {
-		case template.HTML:
-			return v
-		case string:
-			return template.HTML(v)
-		default:
-			return ""
-		}
-	})
-
-	// Create fiber app
-	app := fiber.New(fiber.Config{
-		Views: engine,
-	})
-
-	// Static files
-	app.Static("/", "./public")
-
-	// Routes
-	app.Get("/", func(c *fiber.Ctx) error {
-		posts, err := getAllBlogPosts()
-		if err != nil {
-			return c.Status(500).SendString("Error loading blog posts")
-		}
-		slog.Info("Loaded posts", "count", len(posts))
-		err = c.Render("index", fiber.Map{
-			"Title": "DevDaze Blog",
-			"Posts": posts,
-		})
-		if err != nil {
-			slog.Error("Template render error", "error", err)
-			return c.Status(500).SendString("Template render error")
-		}
-		return nil
-	})
-
-	app.Get("/blog/:slug", func(c *fiber.Ctx) error {
-		slug := c.Params("slug")
-		post, err := getBlogPost(slug)
-		if err != nil {
-			return c.Status(404).SendString("Blog post not found")
-		}
-		return c.Render("post", fiber.Map{
-			"Title": post.Title,
-			"Post":  post,
-		})
-	})
-
-	app.Get("/blog", func(c *fiber.Ctx) error {
-		posts, err := getAllBlogPosts()
-		if err != nil {
-			return c.Status(500).SendString("Error loading blog posts")
-		}
-		return c.Render("blog", fiber.Map{
-			"Title": "All Blog Posts",
-			"Posts": posts,
-		})
-	})
-
-	log.Println("Server starting on :3000")
-	log.Fatal(app.Listen(":3000"))
+// CommentsAllowed reports whether readers can comment on the post, which
+// they can unless its frontmatter says comments: false
+func (p *BlogPost) CommentsAllowed() bool {
+	return p.Comments == nil || *p.Comments
 }
 
-// getBlogPost loads and parses a single blog post by slug
-func getBlogPost(slug string) (*BlogPost, error) {
-	contentDir := "./content"
-	files, err := os.ReadDir(contentDir)
-	if err != nil {
-		return nil, err
+// Language returns the language the post is written in, lang for posts in
+// the default language
+func (p *BlogPost) Language(lang string) string {
+	if p.Lang != "" {
+		return p.Lang
 	}
+	return lang
+}
 
-	for _, file := range files {
-		if !strings.HasSuffix(file.Name(), ".md") {
-			continue
-		}
-
-		filePath := filepath.Join(contentDir, file.Name())
-		content, err := os.ReadFile(filePath)
-		if err != nil {
-			continue
-		}
+// Direction returns the text direction of the post, rtl or ltr, from its dir
+// frontmatter or else its language
+func (p *BlogPost) Direction(lang string) string {
+	if p.Dir != "" {
+		return p.Dir
+	}
+	return textDirection(p.Language(lang))
+}
 
-		post, err := parseMarkdownFile(content)
-		if err != nil {
-			continue
-		}
+// BlogMetadata represents the frontmatter of a markdown file
+type BlogMetadata struct {
+	Title       string    `yaml:"title"`
+	Date        time.Time `yaml:"date"`
+	Author      string    `yaml:"author,omitempty"`
+	Description string    `yaml:"description,omitempty"`
+	Tags        []string  `yaml:"tags,omitempty"`
+	Slug        string    `yaml:"slug"`
+	Draft       bool      `yaml:"draft,omitempty"`
+	// Series groups related posts, which are read in date order
+	Series string `yaml:"series,omitempty"`
+	// PublishAt makes the scheduler publish a draft at the given time
+	PublishAt time.Time `yaml:"publish_at,omitempty"`
+	// Comments set to false closes the post to comments
+	Comments *bool `yaml:"comments,omitempty"`
+	// Dir is the text direction, rtl or ltr, when the language does not
+	// tell it
+	Dir string `yaml:"dir,omitempty"`
+	// Aliases are older paths of the post, like those of an imported site,
+	// that redirect to it
+	Aliases []string `yaml:"aliases,omitempty"`
+	// Canonical is the URL of the original of a post first published
+	// elsewhere, which search engines are pointed to
+	Canonical string `yaml:"canonical,omitempty"`
+	// Syndication are the URLs of copies of the post on other platforms
+	Syndication []string `yaml:"syndication,omitempty"`
+}
 
-		if post.Slug == slug {
-			return post, nil
-		}
+func main() {
+	if err := newRootCmd().Execute(); err != nil {
+		os.Exit(1)
 	}
+}
 
-	return nil, fmt.Errorf("blog post with slug '%s' not found", slug)
+// getAllBlogPosts loads and parses all blog posts, leaving out the
+// translations to the given languages. Dates without a time zone are in loc.
+func getAllBlogPosts(contentDir string, languages []string, loc *time.Location) ([]*BlogPost, error) {
+	return readPostDir(contentDir, loc, func(name string) bool {
+		_, lang := splitLanguage(name, languages)
+		return lang == ""
+	})
 }
 
-// getAllBlogPosts loads and parses all blog posts
-func getAllBlogPosts() ([]*BlogPost, error) {
-	contentDir := "./content"
+// readPostDir loads and parses the markdown files in dir whose names pass
+// keep
+func readPostDir(dir string, loc *time.Location, keep func(name string) bool) ([]*BlogPost, error) {
 	var posts []*BlogPost
 
 	// Check if content directory exists
-	if _, err := os.Stat(contentDir); os.IsNotExist(err) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		return posts, nil // Return empty slice if directory doesn't exist
 	}
 
-	files, err := os.ReadDir(contentDir)
+	files, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, file := range files {
-		if !strings.HasSuffix(file.Name(), ".md") {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".md") || !keep(file.Name()) {
 			continue
 		}
 
-		filePath := filepath.Join(contentDir, file.Name())
+		filePath := filepath.Join(dir, file.Name())
 		content, err := os.ReadFile(filePath)
 		if err != nil {
-			log.Printf("Error reading file %s: %v", filePath, err)
+			slog.Warn("Error reading file", "file", filePath, "error", err)
 			continue
 		}
 
-		post, err := parseMarkdownFile(content)
+		post, err := parseMarkdownFile(content, loc)
 		if err != nil {
-			log.Printf("Error parsing file %s: %v", filePath, err)
+			slog.Warn("Error parsing file", "file", filePath, "error", err)
 			continue
 		}
+		post.FilePath = filePath
 
 		posts = append(posts, post)
 	}
@@ -179,33 +152,57 @@ func getAllBlogPosts() ([]*BlogPost, error) {
 	return posts, nil
 }
 
-// parseMarkdownFile parses a markdown file with YAML frontmatter
-func parseMarkdownFile(content []byte) (*BlogPost, error) {
-	contentStr := string(content)
-
+// splitFrontmatter separates the YAML frontmatter from the markdown body,
+// returning both untrimmed
+func splitFrontmatter(contentStr string) (string, string, error) {
 	// Check for frontmatter
 	if !strings.HasPrefix(contentStr, "---") {
-		return nil, fmt.Errorf("no frontmatter found")
+		return "", "", fmt.Errorf("no frontmatter found")
 	}
 
 	// Split frontmatter and content
 	parts := strings.SplitN(contentStr[3:], "---", 2)
 	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid frontmatter format")
+		return "", "", fmt.Errorf("invalid frontmatter format")
 	}
 
-	frontmatter := strings.TrimSpace(parts[0])
-	markdownContent := strings.TrimSpace(parts[1])
+	return parts[0], parts[1], nil
+}
+
+// parseMarkdownFile parses a markdown file with YAML frontmatter
+func parseMarkdownFile(content []byte, loc *time.Location) (*BlogPost, error) {
+	rawFrontmatter, rawContent, err := splitFrontmatter(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	frontmatter := strings.TrimSpace(rawFrontmatter)
+	markdownContent := strings.TrimSpace(rawContent)
 
 	// Parse YAML frontmatter
 	var metadata BlogMetadata
-	err := yaml.Unmarshal([]byte(frontmatter), &metadata)
+	err = yaml.Unmarshal([]byte(frontmatter), &metadata)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing frontmatter: %v", err)
 	}
+	// Dates written without a time zone are in the site's
+	var raw struct {
+		Date      string `yaml:"date"`
+		PublishAt string `yaml:"publish_at"`
+	}
+	yaml.Unmarshal([]byte(frontmatter), &raw)
+	if floatingTime(raw.Date) {
+		metadata.Date = inLocation(metadata.Date, loc)
+	}
+	if floatingTime(raw.PublishAt) {
+		metadata.PublishAt = inLocation(metadata.PublishAt, loc)
+	}
+	if metadata.Dir != "" && metadata.Dir != "ltr" && metadata.Dir != "rtl" {
+		return nil, fmt.Errorf("error parsing frontmatter: dir is %q instead of rtl or ltr", metadata.Dir)
+	}
 
 	// Convert markdown to HTML
-	htmlContent := blackfriday.Run([]byte(markdownContent))
+	htmlContent := renderMarkdown(markdownContent)
 
 	// Create blog post
 	post := &BlogPost{
@@ -215,9 +212,22 @@ func parseMarkdownFile(content []byte) (*BlogPost, error) {
 		Description: metadata.Description,
 		Tags:        metadata.Tags,
 		Slug:        metadata.Slug,
+		Draft:       metadata.Draft,
+		Series:      metadata.Series,
+		PublishAt:   metadata.PublishAt,
+		Comments:    metadata.Comments,
+		Dir:         metadata.Dir,
+		Aliases:     metadata.Aliases,
+		Canonical:   metadata.Canonical,
+		Syndication: metadata.Syndication,
 		Content:     markdownContent,
-		HTMLContent: string(htmlContent),
+		HTMLContent: htmlContent,
 	}
 
 	return post, nil
 }
+
+// renderMarkdown converts a markdown body to HTML
+func renderMarkdown(markdown string) string {
+	return string(blackfriday.Run([]byte(markdown)))
+}
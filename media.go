@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MediaConfig controls where uploaded files are stored and served from
+type MediaConfig struct {
+	Dir string `yaml:"dir"`
+	// URL is the path prefix the media directory is served under
+	URL string `yaml:"url"`
+	// MaxUploadMB limits the size of a single upload request
+	MaxUploadMB int `yaml:"max_upload_mb"`
+	// Widths lists the resized variants generated for uploaded images
+	Widths []int `yaml:"widths"`
+	// Formats lists extra encodings generated for each width: webp, avif
+	Formats []string `yaml:"formats"`
+	// Quality is the encoder quality for generated variants, 1-100
+	Quality int `yaml:"quality"`
+	// Sizes is the sizes attribute added to responsive images
+	Sizes string `yaml:"sizes"`
+}
+
+// mediaExtensions lists the file types accepted by the uploader
+var mediaExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".avif": true, ".svg": true, ".ico": true, ".pdf": true, ".mp4": true,
+	".webm": true, ".mp3": true, ".zip": true, ".txt": true,
+}
+
+// imageExtensions are media files shown as images in the library
+var imageExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".avif": true, ".svg": true, ".ico": true,
+}
+
+// MediaFile is an uploaded asset listed in the media library
+type MediaFile struct {
+	Name    string
+	URL     string
+	Size    int64
+	ModTime time.Time
+	IsImage bool
+}
+
+// Markdown returns a snippet that references the file from a post
+func (m MediaFile) Markdown() string {
+	if m.IsImage {
+		alt := strings.TrimSuffix(path.Base(m.Name), path.Ext(m.Name))
+		return fmt.Sprintf("![%s](%s)", alt, m.URL)
+	}
+	return fmt.Sprintf("[%s](%s)", path.Base(m.Name), m.URL)
+}
+
+// SizeLabel formats the file size for display
+func (m MediaFile) SizeLabel() string {
+	switch {
+	case m.Size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(m.Size)/(1<<20))
+	case m.Size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(m.Size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", m.Size)
+	}
+}
+
+// mediaInsidePublic reports whether the media directory is already served
+// and exported as part of the public directory
+func mediaInsidePublic(cfg *Config) bool {
+	public, err1 := filepath.Abs(cfg.PublicDir)
+	media, err2 := filepath.Abs(cfg.Media.Dir)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	rel, err := filepath.Rel(public, media)
+	return err == nil && !strings.HasPrefix(rel, "..") && "/"+filepath.ToSlash(rel) == strings.TrimSuffix(cfg.Media.URL, "/")
+}
+
+// listMedia returns the files in the media directory, newest first
+func listMedia(cfg *Config) ([]MediaFile, error) {
+	var files []MediaFile
+	err := filepath.WalkDir(cfg.Media.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == variantsDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(cfg.Media.Dir, p)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		files = append(files, MediaFile{
+			Name:    name,
+			URL:     strings.TrimSuffix(cfg.Media.URL, "/") + "/" + name,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsImage: imageExtensions[strings.ToLower(path.Ext(name))],
+		})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime.After(files[j].ModTime)
+	})
+	return files, err
+}
+
+// uniqueMediaName turns an uploaded filename into a safe one that does not
+// overwrite an existing file
+func uniqueMediaName(dir, filename string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if !mediaExtensions[ext] {
+		return "", fmt.Errorf("files of type %q are not allowed", ext)
+	}
+
+	stem := slugify(strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)))
+	if stem == "" {
+		stem = "file"
+	}
+
+	name := stem + ext
+	for i := 1; fileExists(filepath.Join(dir, name)); i++ {
+		name = fmt.Sprintf("%s-%d%s", stem, i, ext)
+	}
+	return name, nil
+}
+
+// handleMediaLibrary lists uploaded files with markdown snippets
+func (s *Server) handleMediaLibrary(c *fiber.Ctx) error {
+	files, err := listMedia(s.cfg)
+	if err != nil {
+		return s.internalError(c, "Error listing media", err)
+	}
+
+	return c.Render("admin_media", fiber.Map{
+		"Title":  "Media",
+		"Notice": c.Query("notice"),
+		"Files":  files,
+	})
+}
+
+// handleMediaUpload stores one or more uploaded files in the media directory
+func (s *Server) handleMediaUpload(c *fiber.Ctx) error {
+	saved, err := s.saveUploads(c)
+	if err != nil {
+		return err
+	}
+
+	notice := fmt.Sprintf("Uploaded %s", strings.Join(saved, ", "))
+	return c.Redirect("/admin/media?notice="+url.QueryEscape(notice), fiber.StatusSeeOther)
+}
+
+// saveUploads stores the files of a multipart "file" field under unique
+// names and generates their image variants. Errors are left to the app's
+// error handler.
+func (s *Server) saveUploads(c *fiber.Ctx) ([]string, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Expected a multipart upload")
+	}
+
+	uploads := form.File["file"]
+	if len(uploads) == 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "No file selected")
+	}
+
+	if err := os.MkdirAll(s.cfg.Media.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("error saving upload: %v", err)
+	}
+
+	var saved []string
+	for _, upload := range uploads {
+		name, err := uniqueMediaName(s.cfg.Media.Dir, upload.Filename)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		if err := c.SaveFile(upload, filepath.Join(s.cfg.Media.Dir, name)); err != nil {
+			return nil, fmt.Errorf("error saving upload: %v", err)
+		}
+		saved = append(saved, name)
+
+		if _, err := s.images.Process(name); err != nil {
+			requestLogger(c).Warn("Error processing image", "file", name, "error", err)
+		}
+	}
+
+	requestLogger(c).Info("Uploaded media", "files", saved)
+	s.audit(c, "Upload %s", strings.Join(saved, ", "))
+	return saved, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// mediumFileName matches the names of the stories of a Medium export, like
+// posts/2019-03-15_My-Story-1a2b3c4d5e6f.html or posts/draft_My-Story-1a2b3c4d5e6f.html
+var mediumFileName = regexp.MustCompile(`^(?:\d{4}-\d{2}-\d{2}_|draft_)?(.*?)(?:-[0-9a-f]{8,12})?\.html$`)
+
+// mediumImageHosts serve the images of Medium stories
+var mediumImageHosts = map[string]bool{
+	"cdn-images-1.medium.com": true,
+	"cdn-images-2.medium.com": true,
+	"miro.medium.com":         true,
+}
+
+// readMedium reads the stories of a Medium export, the zip file of Settings →
+// Download your information or its extracted directory, and returns the
+// function that downloads their images and points links between them at the
+// imported posts
+func (imp *siteImporter) readMedium() (func(*importedPost) string, error) {
+	var fsys fs.FS
+	if info, err := os.Stat(imp.opts.Path); err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		fsys = os.DirFS(imp.opts.Path)
+	} else {
+		r, err := zip.OpenReader(imp.opts.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading Medium export: %v", err)
+		}
+		defer r.Close()
+		fsys = r
+	}
+
+	files, err := fs.Glob(fsys, "posts/*.html")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		p, err := imp.mediumPost(fsys, file)
+		if err != nil {
+			slog.Warn("Error reading Medium story", "file", file, "error", err)
+			continue
+		}
+		imp.posts = append(imp.posts, p)
+	}
+	if len(imp.posts) == 0 {
+		return nil, fmt.Errorf("no stories found in %s", imp.opts.Path)
+	}
+
+	// Links to other imported stories point to their new URL
+	byURL := make(map[string]string)
+	for _, p := range imp.posts {
+		if p.post.Canonical != "" {
+			byURL[p.post.Canonical] = postPath(p.post)
+		}
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	return func(p *importedPost) string {
+		body := htmlToMarkdown(p.post.Content)
+		var b strings.Builder
+		last := 0
+		for _, m := range assetRef.FindAllStringSubmatchIndex(body, -1) {
+			b.WriteString(body[last:m[2]])
+			b.WriteString(imp.mediumURL(client, byURL, body[m[2]:m[3]]))
+			last = m[3]
+		}
+		b.WriteString(body[last:])
+		return b.String()
+	}, nil
+}
+
+// mediumPost reads a story of the export. Its body is kept as HTML until the
+// story is converted.
+func (imp *siteImporter) mediumPost(fsys fs.FS, file string) (*importedPost, error) {
+	f, err := fsys.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	doc, err := html.Parse(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var title, subtitle, author, canonical, published string
+	var body *html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			classes := strings.Fields(attr(n, "class"))
+			switch {
+			case n.Data == "title" && title == "":
+				title = textContent(n)
+			case n.Data == "h1" && slices.Contains(classes, "p-name"):
+				title = textContent(n)
+			case attr(n, "data-field") == "subtitle":
+				subtitle = textContent(n)
+			case attr(n, "data-field") == "body":
+				body = n
+				return
+			case n.Data == "a" && slices.Contains(classes, "p-author"):
+				author = textContent(n)
+			case n.Data == "a" && slices.Contains(classes, "p-canonical"):
+				canonical = attr(n, "href")
+			case n.Data == "time" && slices.Contains(classes, "dt-published"):
+				published = attr(n, "datetime")
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	if body == nil {
+		return nil, fmt.Errorf("the story has no body")
+	}
+
+	name := path.Base(file)
+	draft := strings.HasPrefix(name, "draft_")
+	date, err := time.Parse(time.RFC3339, published)
+	if err != nil {
+		// Drafts are dated by the export
+		date = time.Now()
+		if info, err := fs.Stat(fsys, file); err == nil && !info.ModTime().IsZero() {
+			date = info.ModTime()
+		}
+	}
+
+	title = strings.TrimSpace(title)
+	slug := slugify(mediumFileName.FindStringSubmatch(name)[1])
+	if slug == "" {
+		slug = slugify(title)
+	}
+	post := &BlogPost{
+		Title:       title,
+		Date:        date.In(imp.cfg.Location()).Truncate(time.Second),
+		Author:      strings.TrimSpace(author),
+		Description: strings.TrimSpace(subtitle),
+		Slug:        slug,
+		Draft:       draft,
+		Content:     mediumBody(body),
+	}
+	if post.Title == "" {
+		post.Title = slug
+	}
+	if u, err := url.Parse(canonical); err == nil && u.Host != "" && !draft {
+		post.Canonical = canonical
+	}
+	return &importedPost{post: post, file: file}, nil
+}
+
+// mediumBody renders the body of a story as HTML, without the title and
+// subtitle Medium repeats in it and the dividers between its sections
+func mediumBody(body *html.Node) string {
+	var remove []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			classes := strings.Fields(attr(n, "class"))
+			switch {
+			case slices.Contains(classes, "graf--title"), slices.Contains(classes, "graf--subtitle"), slices.Contains(classes, "section-divider"):
+				remove = append(remove, n)
+				return
+			case n.Data == "br" && n.Parent != nil && n.Parent.Data == "pre":
+				// Code blocks break their lines with <br>
+				n.Type, n.Data = html.TextNode, "\n"
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(body)
+	for _, n := range remove {
+		n.Parent.RemoveChild(n)
+	}
+
+	var b strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		html.Render(&b, c)
+	}
+	return b.String()
+}
+
+// mediumURL downloads an image on Medium's CDN and returns the URL of the
+// copy, or maps the URL of an imported story to its new one. Other URLs are
+// returned as they are.
+func (imp *siteImporter) mediumURL(client *http.Client, byURL map[string]string, ref string) string {
+	if target, ok := byURL[ref]; ok {
+		return target
+	}
+	u, err := url.Parse(ref)
+	if err != nil || !mediumImageHosts[u.Host] {
+		return ref
+	}
+	if copied, ok := imp.copied[ref]; ok {
+		return copied
+	}
+
+	// Images are named like 1*AbC.png after the last part of their path
+	name := strings.ReplaceAll(path.Base(u.Path), "*", "-")
+	if name == "." || name == "/" {
+		return ref
+	}
+	rel := "medium/" + name
+	if !imp.opts.DryRun {
+		if err := downloadFile(client, ref, filepath.Join(imp.cfg.Media.Dir, filepath.FromSlash(rel))); err != nil {
+			slog.Warn("Error downloading image", "url", ref, "error", err)
+			return ref
+		}
+	}
+	copied := strings.TrimSuffix(imp.cfg.Media.URL, "/") + "/" + rel
+	imp.copied[ref] = copied
+	return copied
+}
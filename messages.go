@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/text/language"
+	"gopkg.in/yaml.v2"
+)
+
+// dateNames are the month and day names time.Format writes, full names
+// first so they are replaced before their abbreviations
+var dateNames = []string{
+	"January", "February", "March", "April", "May", "June", "July",
+	"August", "September", "October", "November", "December",
+	"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday",
+	"Jan", "Feb", "Mar", "Apr", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec",
+	"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun",
+}
+
+// messageCatalogs translate the strings of the templates. Each catalog is a
+// YAML file named after its language, like de.yaml, that maps the English
+// strings to their translation.
+type messageCatalogs struct {
+	defaultLang string
+	// dateFormat is the style or layout of dates when templates name none
+	dateFormat string
+	// loc is the time zone dates are shown in
+	loc      *time.Location
+	catalogs map[string]map[string]string
+	// dates replaces the month and day names of each language, from the
+	// built-in names and the catalogs
+	dates map[string]*strings.Replacer
+	// langs and matcher pick the locale from Accept-Language, langs[0] is
+	// the default language
+	langs   []string
+	matcher language.Matcher
+}
+
+// newMessageCatalogs loads the catalogs in i18n.catalogs. A missing directory
+// leaves every string in the default language. On error the catalogs loaded
+// so far are still returned.
+func newMessageCatalogs(cfg *Config) (*messageCatalogs, error) {
+	m := &messageCatalogs{
+		defaultLang: cfg.I18n.DefaultLanguage,
+		dateFormat:  cfg.I18n.DateFormat,
+		loc:         cfg.Location(),
+		catalogs:    make(map[string]map[string]string),
+		dates:       make(map[string]*strings.Replacer),
+		langs:       []string{cfg.I18n.DefaultLanguage},
+	}
+	defer func() {
+		m.buildDateNames()
+		tags := make([]language.Tag, len(m.langs))
+		for i, lang := range m.langs {
+			tags[i] = language.Make(lang)
+		}
+		m.matcher = language.NewMatcher(tags)
+	}()
+
+	files, err := filepath.Glob(filepath.Join(cfg.I18n.Catalogs, "*.yaml"))
+	if err != nil {
+		return m, err
+	}
+	for _, file := range files {
+		lang := strings.TrimSuffix(filepath.Base(file), ".yaml")
+		if !languageCode.MatchString(lang) {
+			return m, fmt.Errorf("%s: %q is not a lowercase language code", file, lang)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return m, err
+		}
+		var catalog map[string]string
+		if err := yaml.Unmarshal(data, &catalog); err != nil {
+			return m, fmt.Errorf("error parsing %s: %v", file, err)
+		}
+		m.catalogs[lang] = catalog
+		if lang != m.defaultLang {
+			m.langs = append(m.langs, lang)
+		}
+	}
+	return m, nil
+}
+
+// buildDateNames prepares the translation of month and day names for the
+// languages with built-in names or a catalog, where the catalog wins
+func (m *messageCatalogs) buildDateNames() {
+	langs := make(map[string]bool)
+	for lang := range builtinDateNames {
+		langs[lang] = true
+	}
+	for lang := range m.catalogs {
+		langs[lang] = true
+	}
+	for lang := range langs {
+		builtin := builtinDateNames[lang]
+		if builtin == nil {
+			builtin = builtinDateNames[baseLanguage(lang)]
+		}
+		var pairs []string
+		for i, name := range dateNames {
+			if translated, ok := m.catalogs[lang][name]; ok {
+				pairs = append(pairs, name, translated)
+			} else if builtin != nil {
+				pairs = append(pairs, name, builtin[i])
+			}
+		}
+		m.dates[lang] = strings.NewReplacer(pairs...)
+	}
+}
+
+// Translate returns msg in the given locale, formatted with args like
+// fmt.Sprintf when there are any. Strings missing from the catalog stay in
+// English.
+func (m *messageCatalogs) Translate(locale, msg string, args ...interface{}) string {
+	if translated, ok := m.catalogs[locale][msg]; ok && translated != "" {
+		msg = translated
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// FormatDate formats t in the site's time zone with a time.Format layout,
+// with the month and day names in the given locale. The catalog can also
+// translate the layout, to put the day first for instance.
+func (m *messageCatalogs) FormatDate(locale string, t time.Time, layout string) string {
+	formatted := t.In(m.loc).Format(m.Translate(locale, layout))
+	for _, lang := range []string{locale, baseLanguage(locale)} {
+		if r, ok := m.dates[lang]; ok {
+			return r.Replace(formatted)
+		}
+	}
+	return formatted
+}
+
+// negotiate picks the locale an Accept-Language header prefers among the
+// catalogs, falling back to the default language
+func (m *messageCatalogs) negotiate(header string) string {
+	if header == "" || len(m.langs) == 1 {
+		return m.defaultLang
+	}
+	prefs, _, err := language.ParseAcceptLanguage(header)
+	if err != nil || len(prefs) == 0 {
+		return m.defaultLang
+	}
+	_, i, confidence := m.matcher.Match(prefs...)
+	if confidence == language.No {
+		return m.defaultLang
+	}
+	return m.langs[i]
+}
+
+// registerLocale passes the locale of every page to the templates as Locale:
+// the language of a /de/... path, or the one Accept-Language prefers
+func (s *Server) registerLocale() {
+	s.app.Use(func(c *fiber.Ctx) error {
+		locale := s.pathLanguage(c)
+		if locale == "" {
+			locale = s.messages.negotiate(c.Get(fiber.HeaderAcceptLanguage))
+			if len(s.messages.langs) > 1 {
+				c.Vary(fiber.HeaderAcceptLanguage)
+			}
+		}
+		c.Locals("Locale", locale)
+		return c.Next()
+	})
+}
+
+// t translates msg to the locale of the request
+func (s *Server) t(c *fiber.Ctx, msg string, args ...interface{}) string {
+	locale, _ := c.Locals("Locale").(string)
+	return s.messages.Translate(locale, msg, args...)
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/oauth2"
+)
+
+// MicropubConfig enables the Micropub endpoint for IndieWeb clients
+type MicropubConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Me is the URL tokens must be issued for, defaulting to base_url
+	Me string `yaml:"me"`
+	// AuthorizationEndpoint and TokenEndpoint are the IndieAuth server
+	// advertised on every page; tokens are verified against the latter
+	AuthorizationEndpoint string `yaml:"authorization_endpoint"`
+	TokenEndpoint         string `yaml:"token_endpoint"`
+}
+
+// IndieWebLinks are the endpoints pages advertise for discovery, empty
+// for the ones that are disabled
+type IndieWebLinks struct {
+	Micropub              string
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	IndieAuthMetadata     string
+	Webmention            string
+}
+
+// micropubToken is what the token endpoint reports about a token
+type micropubToken struct {
+	Me       string `json:"me"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+}
+
+// Allows reports whether the token grants scope. The legacy "post" scope
+// covers creating, updating and uploading.
+func (t micropubToken) Allows(scope string) bool {
+	scopes := strings.Fields(t.Scope)
+	if slices.Contains(scopes, scope) {
+		return true
+	}
+	return slices.Contains(scopes, "post") && scope != "delete"
+}
+
+// registerIndieWebLinks advertises the enabled IndieWeb endpoints in the
+// page head, and the Webmention endpoint in a Link header as well
+func (s *Server) registerIndieWebLinks() {
+	var links IndieWebLinks
+	if s.cfg.Micropub.Enabled {
+		links.Micropub = s.absoluteURL(nil, "/micropub")
+		links.AuthorizationEndpoint = s.cfg.Micropub.AuthorizationEndpoint
+		links.TokenEndpoint = s.cfg.Micropub.TokenEndpoint
+	}
+	if s.cfg.IndieAuth.Enabled {
+		links.AuthorizationEndpoint = s.absoluteURL(nil, "/indieauth")
+		links.TokenEndpoint = s.absoluteURL(nil, "/indieauth/token")
+		links.IndieAuthMetadata = s.absoluteURL(nil, "/.well-known/oauth-authorization-server")
+	}
+	if s.cfg.Webmention.Enabled {
+		links.Webmention = s.absoluteURL(nil, "/webmention")
+	}
+	if links == (IndieWebLinks{}) {
+		return
+	}
+
+	s.app.Use(func(c *fiber.Ctx) error {
+		c.Locals("IndieWeb", links)
+		if links.Webmention != "" {
+			c.Append(fiber.HeaderLink, "<"+links.Webmention+`>; rel="webmention"`)
+		}
+		return c.Next()
+	})
+}
+
+// registerMicropubRoutes wires up /micropub and its media endpoint
+func (s *Server) registerMicropubRoutes() {
+	if !s.cfg.Micropub.Enabled {
+		return
+	}
+
+	s.app.Get("/micropub", s.requireMicropubToken, s.handleMicropubQuery)
+	s.app.Post("/micropub", s.requireMicropubToken, s.handleMicropub)
+	s.app.Post("/micropub/media", s.requireMicropubToken, s.handleMicropubMedia)
+}
+
+// absoluteURL turns a site path into a URL, using base_url or else the
+// request's own scheme and host
+func (s *Server) absoluteURL(c *fiber.Ctx, path string) string {
+	if s.cfg.BaseURL != "" || c == nil {
+		return strings.TrimSuffix(s.cfg.BaseURL, "/") + path
+	}
+	return c.BaseURL() + path
+}
+
+// micropubError responds with a Micropub error body
+func micropubError(c *fiber.Ctx, code int, kind, format string, args ...interface{}) error {
+	return c.Status(code).JSON(fiber.Map{
+		"error":             kind,
+		"error_description": fmt.Sprintf(format, args...),
+	})
+}
+
+// requireMicropubToken verifies the request's IndieAuth token with the token
+// endpoint and checks it was issued for this site
+func (s *Server) requireMicropubToken(c *fiber.Ctx) error {
+	token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !ok {
+		token = c.FormValue("access_token")
+	}
+	if token == "" {
+		return micropubError(c, fiber.StatusUnauthorized, "unauthorized", "an access token is required")
+	}
+	if s.cfg.IndieAuth.Enabled {
+		info, ok := s.verifyIndieAuthToken(token)
+		if !ok {
+			return micropubError(c, fiber.StatusForbidden, "forbidden", "the token is invalid or has expired")
+		}
+		c.Locals("micropubToken", info)
+		return c.Next()
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+	client := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	var info micropubToken
+	if err := getJSON(ctx, client, s.cfg.Micropub.TokenEndpoint, &info); err != nil {
+		requestLogger(c).Warn("Micropub token verification failed", "error", err)
+		return micropubError(c, fiber.StatusForbidden, "forbidden", "the token could not be verified")
+	}
+
+	me := s.cfg.Micropub.Me
+	if me == "" {
+		me = s.cfg.BaseURL
+	}
+	if normalizeMe(info.Me) != normalizeMe(me) {
+		return micropubError(c, fiber.StatusForbidden, "forbidden", "the token was issued for %s", info.Me)
+	}
+
+	c.Locals("micropubToken", info)
+	return c.Next()
+}
+
+// normalizeMe compares profile URLs regardless of case and trailing slash
+func normalizeMe(me string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(me)), "/")
+}
+
+// micropubAllows reports whether the request's token grants scope
+func micropubAllows(c *fiber.Ctx, scope string) bool {
+	token, _ := c.Locals("micropubToken").(micropubToken)
+	return token.Allows(scope)
+}
+
+// insufficientScope responds that the token lacks scope
+func insufficientScope(c *fiber.Ctx, scope string) error {
+	return micropubError(c, fiber.StatusForbidden, "insufficient_scope", "the token lacks the %s scope", scope)
+}
+
+// handleMicropubQuery answers the config, source, syndicate-to and category
+// queries clients use to set themselves up
+func (s *Server) handleMicropubQuery(c *fiber.Ctx) error {
+	switch c.Query("q") {
+	case "config":
+		return c.JSON(fiber.Map{
+			"media-endpoint": s.absoluteURL(c, "/micropub/media"),
+			"syndicate-to":   []string{},
+			"post-types": []fiber.Map{
+				{"type": "note", "name": "Note"},
+				{"type": "article", "name": "Article"},
+				{"type": "photo", "name": "Photo"},
+			},
+		})
+	case "syndicate-to":
+		return c.JSON(fiber.Map{"syndicate-to": []string{}})
+	case "category":
+		posts, err := s.content.AllPosts()
+		if err != nil {
+			return s.internalError(c, "Error loading blog posts", err)
+		}
+		tags := []string{}
+		for _, post := range posts {
+			for _, tag := range post.Tags {
+				if !slices.Contains(tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+		}
+		slices.Sort(tags)
+		return c.JSON(fiber.Map{"categories": tags})
+	case "source":
+		post := s.findPost(slugFromURL(c.Query("url")))
+		if post == nil {
+			return micropubError(c, fiber.StatusBadRequest, "invalid_request", "no post at %s", c.Query("url"))
+		}
+		props := micropubProperties(post)
+		if want := c.Request().URI().QueryArgs().PeekMulti("properties[]"); len(want) > 0 {
+			filtered := map[string][]interface{}{}
+			for _, name := range want {
+				if v, ok := props[string(name)]; ok {
+					filtered[string(name)] = v
+				}
+			}
+			return c.JSON(fiber.Map{"properties": filtered})
+		}
+		return c.JSON(fiber.Map{"type": []string{"h-entry"}, "properties": props})
+	default:
+		return micropubError(c, fiber.StatusBadRequest, "invalid_request", "unsupported query %q", c.Query("q"))
+	}
+}
+
+// micropubProperties describes a post as microformats2 properties
+func micropubProperties(post *BlogPost) map[string][]interface{} {
+	props := map[string][]interface{}{
+		"name":      {post.Title},
+		"content":   {post.Content},
+		"published": {post.Date.Format(time.RFC3339)},
+		"mp-slug":   {post.Slug},
+	}
+	if post.Description != "" {
+		props["summary"] = []interface{}{post.Description}
+	}
+	for _, tag := range post.Tags {
+		props["category"] = append(props["category"], tag)
+	}
+	for _, link := range post.Syndication {
+		props["syndication"] = append(props["syndication"], link)
+	}
+	if post.Draft {
+		props["post-status"] = []interface{}{"draft"}
+	}
+	return props
+}
+
+// slugFromURL returns the slug of a post URL
+func slugFromURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimSuffix(u.Path, "/"), "/blog/")
+}
+
+// micropubRequest is a create or action request in either encoding
+type micropubRequest struct {
+	Type       []string                 `json:"type"`
+	Properties map[string][]interface{} `json:"properties"`
+	Action     string                   `json:"action"`
+	URL        string                   `json:"url"`
+	Replace    map[string][]interface{} `json:"replace"`
+	Add        map[string][]interface{} `json:"add"`
+	// Delete is either a list of property names or a map of values
+	Delete json.RawMessage `json:"delete"`
+}
+
+// propertyValue returns the first value of a property as text. Rich content
+// ({"html": ...}) and photos with alt text ({"value": ...}) are flattened.
+func propertyValue(props map[string][]interface{}, name string) string {
+	values := props[name]
+	if len(values) == 0 {
+		return ""
+	}
+	return propertyText(values[0])
+}
+
+// propertyText flattens a single property value to text
+func propertyText(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		for _, key := range []string{"markdown", "html", "value"} {
+			if s, ok := v[key].(string); ok {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// propertyValues returns every value of a property as text
+func propertyValues(props map[string][]interface{}, name string) []string {
+	var values []string
+	for _, v := range props[name] {
+		if s := propertyText(v); s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// parseMicropubRequest reads a JSON body or a form-encoded request, where
+// array properties are sent as name[]
+func parseMicropubRequest(c *fiber.Ctx) (*micropubRequest, error) {
+	var req micropubRequest
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		return &req, nil
+	}
+
+	req.Properties = map[string][]interface{}{}
+	add := func(key, value string) {
+		switch key {
+		case "h":
+			req.Type = []string{"h-" + value}
+		case "action":
+			req.Action = value
+		case "url":
+			req.URL = value
+		case "access_token":
+		default:
+			name := strings.TrimSuffix(key, "[]")
+			req.Properties[name] = append(req.Properties[name], value)
+		}
+	}
+
+	if form, err := c.MultipartForm(); err == nil {
+		for key, values := range form.Value {
+			for _, v := range values {
+				add(key, v)
+			}
+		}
+	} else {
+		c.Request().PostArgs().VisitAll(func(key, value []byte) {
+			add(string(key), string(value))
+		})
+	}
+	return &req, nil
+}
+
+// handleMicropub creates, updates or deletes a post
+func (s *Server) handleMicropub(c *fiber.Ctx) error {
+	req, err := parseMicropubRequest(c)
+	if err != nil {
+		return micropubError(c, fiber.StatusBadRequest, "invalid_request", "%v", err)
+	}
+
+	switch req.Action {
+	case "":
+		return s.micropubCreate(c, req)
+	case "update":
+		return s.micropubUpdate(c, req)
+	case "delete":
+		return s.micropubDelete(c, req)
+	case "undelete":
+		return s.micropubUndelete(c, req)
+	default:
+		return micropubError(c, fiber.StatusBadRequest, "invalid_request", "unsupported action %q", req.Action)
+	}
+}
+
+// micropubCreate writes a new markdown file for an h-entry. Notes have no
+// name, so their title is the start of the content.
+func (s *Server) micropubCreate(c *fiber.Ctx, req *micropubRequest) error {
+	if !micropubAllows(c, "create") {
+		return insufficientScope(c, "create")
+	}
+	if len(req.Type) > 0 && req.Type[0] != "h-entry" {
+		return micropubError(c, fiber.StatusBadRequest, "invalid_request", "only h-entry posts are supported")
+	}
+
+	props := req.Properties
+	post := &BlogPost{
+		Title:       propertyValue(props, "name"),
+		Date:        time.Now().Truncate(time.Second),
+		Author:      s.cfg.Author,
+		Description: propertyValue(props, "summary"),
+		Tags:        propertyValues(props, "category"),
+		Slug:        slugify(propertyValue(props, "mp-slug")),
+		Draft:       propertyValue(props, "post-status") == "draft",
+		Content:     micropubContent(props),
+	}
+	if published := propertyValue(props, "published"); published != "" {
+		date, err := time.Parse(time.RFC3339, published)
+		if err != nil {
+			return micropubError(c, fiber.StatusBadRequest, "invalid_request", "published is not an RFC 3339 date")
+		}
+		post.Date = date
+	}
+	if post.Title == "" {
+		post.Title = noteTitle(propertyValue(props, "content"), post.Date)
+	}
+	if post.Slug == "" {
+		post.Slug = slugify(post.Title)
+	}
+	if strings.TrimSpace(post.Content) == "" && propertyValue(props, "name") == "" {
+		return micropubError(c, fiber.StatusBadRequest, "invalid_request", "a post needs content or a name")
+	}
+
+	path, slug := uniquePostPath(s.cfg.ContentDir, post.Slug)
+	post.Slug = slug
+	if err := s.writePost(path, post); err != nil {
+		return s.internalError(c, "Error saving post", err)
+	}
+
+	requestLogger(c).Info("Created post via Micropub", "slug", post.Slug, "client", editor(c))
+	s.recordEdit(c, "Create %s\n\nPublished with Micropub.", post.Slug)
+	s.postChanged(nil, s.findPost(post.Slug))
+	c.Location(s.absoluteURL(c, "/blog/"+post.Slug))
+	if post.Draft {
+		return c.SendStatus(fiber.StatusAccepted)
+	}
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+// micropubContent returns the markdown body of a new post, with photos
+// appended as images
+func micropubContent(props map[string][]interface{}) string {
+	parts := []string{propertyValue(props, "content")}
+	for _, v := range props["photo"] {
+		alt := ""
+		if m, ok := v.(map[string]interface{}); ok {
+			alt, _ = m["alt"].(string)
+		}
+		if src := propertyText(v); src != "" {
+			parts = append(parts, "!["+alt+"]("+src+")")
+		}
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n\n"))
+}
+
+// noteTitle takes the first words of a note as its title, or its date when
+// it has no text
+func noteTitle(content string, date time.Time) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	if line == "" {
+		return "Note " + date.Format("2006-01-02 15:04")
+	}
+	if utf8.RuneCountInString(line) <= 60 {
+		return line
+	}
+	cut := string([]rune(line)[:60])
+	if i := strings.LastIndex(cut, " "); i > 20 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
+
+// uniquePostPath returns a content path and slug for slug, numbering it when
+// a post already uses it
+func uniquePostPath(dir, slug string) (string, string) {
+	candidate := slug
+	for i := 2; ; i++ {
+		path := filepath.Join(dir, candidate+".md")
+		if !fileExists(path) {
+			return path, candidate
+		}
+		candidate = slug + "-" + strconv.Itoa(i)
+	}
+}
+
+// micropubUpdate applies replace, add and delete to an existing post
+func (s *Server) micropubUpdate(c *fiber.Ctx, req *micropubRequest) error {
+	if !micropubAllows(c, "update") {
+		return insufficientScope(c, "update")
+	}
+	existing := s.findPost(slugFromURL(req.URL))
+	if existing == nil {
+		return micropubError(c, fiber.StatusBadRequest, "invalid_request", "no post at %s", req.URL)
+	}
+	post := *existing
+
+	for name, values := range req.Replace {
+		setMicropubProperty(&post, name, values)
+	}
+	for name, values := range req.Add {
+		if name == "category" {
+			post.Tags = append(post.Tags, propertyValues(map[string][]interface{}{name: values}, name)...)
+		} else {
+			setMicropubProperty(&post, name, values)
+		}
+	}
+
+	if len(req.Delete) > 0 {
+		var names []string
+		var values map[string][]interface{}
+		switch {
+		case json.Unmarshal(req.Delete, &names) == nil:
+			for _, name := range names {
+				setMicropubProperty(&post, name, nil)
+			}
+		case json.Unmarshal(req.Delete, &values) == nil:
+			// Only categories hold several values worth removing one by one
+			drop := propertyValues(values, "category")
+			post.Tags = slices.DeleteFunc(slices.Clone(post.Tags), func(tag string) bool {
+				return slices.Contains(drop, tag)
+			})
+		default:
+			return micropubError(c, fiber.StatusBadRequest, "invalid_request", "delete must be a list of properties or a map of values")
+		}
+	}
+
+	if strings.TrimSpace(post.Title) == "" {
+		return micropubError(c, fiber.StatusBadRequest, "invalid_request", "a post needs a name")
+	}
+	if err := s.writePost(existing.FilePath, &post); err != nil {
+		return s.internalError(c, "Error saving post", err)
+	}
+
+	requestLogger(c).Info("Updated post via Micropub", "slug", post.Slug)
+	s.recordEdit(c, "Update %s\n\nUpdated with Micropub.", post.Slug)
+	s.postChanged(existing, s.findPost(post.Slug))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// setMicropubProperty sets a post field from property values; nil values
+// clear it
+func setMicropubProperty(post *BlogPost, name string, values []interface{}) {
+	props := map[string][]interface{}{name: values}
+	switch name {
+	case "name":
+		post.Title = propertyValue(props, name)
+	case "content":
+		post.Content = propertyValue(props, name)
+	case "summary":
+		post.Description = propertyValue(props, name)
+	case "category":
+		post.Tags = propertyValues(props, name)
+	case "post-status":
+		post.Draft = propertyValue(props, name) == "draft"
+	case "published":
+		if date, err := time.Parse(time.RFC3339, propertyValue(props, name)); err == nil {
+			post.Date = date
+		}
+	}
+}
+
+// micropubDelete moves the markdown file of a post to the trash
+func (s *Server) micropubDelete(c *fiber.Ctx, req *micropubRequest) error {
+	if !micropubAllows(c, "delete") {
+		return insufficientScope(c, "delete")
+	}
+	post := s.findPost(slugFromURL(req.URL))
+	if post == nil {
+		return micropubError(c, fiber.StatusBadRequest, "invalid_request", "no post at %s", req.URL)
+	}
+
+	if err := s.trashPost(post.FilePath); err != nil {
+		return s.internalError(c, "Error deleting post", err)
+	}
+
+	requestLogger(c).Info("Deleted post via Micropub", "slug", post.Slug)
+	s.recordEdit(c, "Delete %s\n\nDeleted with Micropub.", post.Slug)
+	s.postChanged(post, nil)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// micropubUndelete restores the most recently deleted post with the slug
+// of the request's URL from the trash
+func (s *Server) micropubUndelete(c *fiber.Ctx, req *micropubRequest) error {
+	if !micropubAllows(c, "delete") {
+		return insufficientScope(c, "delete")
+	}
+	entries, err := listTrash(s.cfg.ContentDir)
+	if err != nil {
+		return s.internalError(c, "Error listing trash", err)
+	}
+
+	slug := slugFromURL(req.URL)
+	for _, entry := range entries {
+		if entry.Slug != slug {
+			continue
+		}
+		if _, err := restoreFromTrash(s.cfg.ContentDir, entry.ID); err != nil {
+			return micropubError(c, fiber.StatusBadRequest, "invalid_request", "%v", err)
+		}
+		if err := s.content.Load(); err != nil {
+			return s.internalError(c, "Restored, but re-indexing failed", err)
+		}
+		requestLogger(c).Info("Restored post via Micropub", "slug", slug)
+		s.recordEdit(c, "Restore %s\n\nRestored with Micropub.", slug)
+		s.postChanged(nil, s.findPost(slug))
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	return micropubError(c, fiber.StatusBadRequest, "invalid_request", "no deleted post at %s", req.URL)
+}
+
+// handleMicropubMedia stores an uploaded file in the media library and
+// responds with its URL
+func (s *Server) handleMicropubMedia(c *fiber.Ctx) error {
+	if !micropubAllows(c, "media") && !micropubAllows(c, "create") {
+		return insufficientScope(c, "media")
+	}
+
+	saved, err := s.saveUploads(c)
+	if err != nil {
+		return err
+	}
+
+	c.Location(s.absoluteURL(c, strings.TrimSuffix(s.cfg.Media.URL, "/")+"/"+saved[0]))
+	return c.SendStatus(fiber.StatusCreated)
+}
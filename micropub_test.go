@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestMicropubTokenAllows(t *testing.T) {
+	tests := []struct {
+		scope string
+		asked string
+		want  bool
+	}{
+		{"create", "create", true},
+		{"create update", "update", true},
+		{"create", "update", false},
+		{"create", "delete", false},
+		{"delete", "delete", true},
+		{"post", "create", true},
+		{"post", "update", true},
+		{"post", "media", true},
+		{"post", "delete", false},
+		{"", "create", false},
+		{"created", "create", false},
+	}
+	for _, tt := range tests {
+		token := micropubToken{Scope: tt.scope}
+		if got := token.Allows(tt.asked); got != tt.want {
+			t.Errorf("scope %q: Allows(%q) = %v, want %v", tt.scope, tt.asked, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// requestIDHeader carries the request ID in both directions
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits incoming IDs to something safe to log and echo back
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// requestIDMiddleware assigns every request an ID, reusing a well-formed one
+// from an upstream proxy, and returns it in the response headers
+func requestIDMiddleware(c *fiber.Ctx) error {
+	id := c.Get(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = utils.UUIDv4()
+	}
+
+	c.Locals("requestID", id)
+	c.Set(requestIDHeader, id)
+	return c.Next()
+}
+
+// requestID returns the ID assigned to the current request
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals("requestID").(string)
+	return id
+}
+
+// requestLogger returns a logger that tags every line with the request ID
+func requestLogger(c *fiber.Ctx) *slog.Logger {
+	return slog.Default().With("request_id", requestID(c))
+}
+
+// accessLogMiddleware logs one line per request once the response is
+// complete, including errors handled by the app's error handler
+func accessLogMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		if err := c.Next(); err != nil {
+			if herr := c.App().ErrorHandler(c, err); herr != nil {
+				c.Status(fiber.StatusInternalServerError)
+			}
+		}
+
+		// Reading the body of a streamed response would drain the stream
+		size := c.Response().Header.ContentLength()
+		if !c.Response().IsBodyStream() {
+			size = len(c.Response().Body())
+		}
+
+		slog.Info("request",
+			"request_id", requestID(c),
+			"method", c.Method(),
+			"path", c.Path(),
+			"status", c.Response().StatusCode(),
+			"latency_ms", float64(time.Since(start).Microseconds())/1000,
+			"bytes", size,
+			"ip", c.IP(),
+			"user_agent", c.Get(fiber.HeaderUserAgent),
+		)
+		return nil
+	}
+}
+
+// errorResponse sends a plain text error page that includes the request ID,
+// so a user reporting a problem can quote it
+func errorResponse(c *fiber.Ctx, code int, message string) error {
+	return c.Status(code).SendString(fmt.Sprintf("%s (request ID: %s)", message, requestID(c)))
+}
+
+// errorPage responds with the themed error.html page, the plain text
+// errorResponse for headless sites and clients that don't take HTML, or when
+// the page itself fails to render. message tells the visitor what went
+// wrong, "" for the page's own words for the status, and detail is shown
+// when set.
+func (s *Server) errorPage(c *fiber.Ctx, code int, message, detail string) error {
+	if s.cfg.Headless || c.Accepts(fiber.MIMETextHTML) == "" {
+		return errorResponse(c, code, errorText(code, message, detail))
+	}
+	title := s.t(c, "Something went wrong")
+	if code < fiber.StatusInternalServerError {
+		title = s.t(c, utils.StatusMessage(code))
+	}
+	view := ErrorView{
+		PageView:  s.pageView(c, title),
+		Code:      code,
+		Message:   message,
+		Detail:    detail,
+		RequestID: requestID(c),
+	}
+	if err := renderView(c.Status(code), "error", view); err != nil {
+		requestLogger(c).Error("Error rendering error page", "error", err)
+		return errorResponse(c, code, errorText(code, message, detail))
+	}
+	return nil
+}
+
+// errorText is the plain text version of an error page
+func errorText(code int, message, detail string) string {
+	if message == "" {
+		message = utils.StatusMessage(code)
+	}
+	if detail != "" {
+		message += ": " + detail
+	}
+	return message
+}
+
+// handleError is the app-wide fiber error handler. Unexpected errors are
+// logged and forwarded to the error reporter.
+func (s *Server) handleError(c *fiber.Ctx, err error) error {
+	if e, ok := err.(*fiber.Error); ok {
+		// The page has its own words for the status, and for the paths
+		// no route serves, which fiber names "Cannot GET /path"
+		message := e.Message
+		if message == utils.StatusMessage(e.Code) || strings.HasPrefix(message, "Cannot "+c.Method()+" ") {
+			message = ""
+		}
+		return s.errorPage(c, e.Code, message, "")
+	}
+
+	requestLogger(c).Error("Request failed", "path", c.Path(), "error", err)
+	s.reporter.Report(c, err)
+	detail := ""
+	if s.cfg.Development() {
+		if sent, err := s.sendTemplateError(c, err); sent {
+			return err
+		}
+		detail = err.Error()
+	}
+	return s.errorPage(c, fiber.StatusInternalServerError, "", detail)
+}
+
+// internalError logs and reports err, then responds with a 500 page. The
+// underlying error is only shown to the visitor in development.
+func (s *Server) internalError(c *fiber.Ctx, message string, err error) error {
+	requestLogger(c).Error(message, "error", err)
+	s.reporter.Report(c, err)
+	detail := ""
+	if s.cfg.Development() {
+		if sent, err := s.sendTemplateError(c, err); sent {
+			return err
+		}
+		detail = message + ": " + err.Error()
+	}
+	return s.errorPage(c, fiber.StatusInternalServerError, "", detail)
+}
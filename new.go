@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// defaultPostTemplate is used when no post template is configured
+const defaultPostTemplate = `---
+title: {{ printf "%q" .Title }}
+date: {{ .Date.Format "2006-01-02T15:04:05Z07:00" }}
+author: {{ printf "%q" .Author }}
+description: {{ printf "%q" .Description }}
+tags: [{{ range $i, $tag := .Tags }}{{ if $i }}, {{ end }}{{ printf "%q" $tag }}{{ end }}]
+slug: {{ printf "%q" .Slug }}
+{{- if .Draft }}
+draft: true
+{{- end }}
+---
+
+# {{ .Title }}
+`
+
+// NewPostOptions holds the values used to fill in a new post template
+type NewPostOptions struct {
+	Title       string
+	Slug        string
+	Date        time.Time
+	Author      string
+	Description string
+	Tags        []string
+	Draft       bool
+	Template    string
+}
+
+// slugify turns a title into a URL and filename friendly slug
+func slugify(s string) string {
+	return strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// parseDateFlag accepts either a plain date, midnight in loc, or a full
+// RFC 3339 timestamp
+func parseDateFlag(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// newPost writes a markdown file with pre-filled frontmatter for a new post
+func newPost(out io.Writer, cfg *Config, opts NewPostOptions) error {
+	// The slug names the file, so it must not reach outside the content dir
+	if want := slugify(opts.Slug); opts.Slug != "" && opts.Slug != want {
+		return fmt.Errorf("slug %q is not URL safe, try %q", opts.Slug, want)
+	}
+	content, err := renderPostTemplate(cfg, &opts)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(cfg.ContentDir, 0755); err != nil {
+		return err
+	}
+
+	filePath := filepath.Join(cfg.ContentDir, opts.Slug+".md")
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists", filePath)
+		}
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(content); err != nil {
+		os.Remove(filePath)
+		return err
+	}
+
+	fmt.Fprintf(out, "Created %s\n", filePath)
+	return nil
+}
+
+// renderPostTemplate fills in the defaults for opts and renders the post
+// template with them
+func renderPostTemplate(cfg *Config, opts *NewPostOptions) ([]byte, error) {
+	if opts.Slug == "" {
+		opts.Slug = slugify(opts.Title)
+	}
+	if opts.Slug == "" {
+		return nil, fmt.Errorf("cannot derive a slug from title %q", opts.Title)
+	}
+	if opts.Title == "" {
+		opts.Title = opts.Slug
+	}
+	if opts.Date.IsZero() {
+		opts.Date = time.Now().In(cfg.Location()).Truncate(time.Second)
+	}
+	if opts.Author == "" {
+		opts.Author = cfg.Author
+	}
+	if opts.Template == "" {
+		opts.Template = cfg.PostTemplate
+	}
+
+	text := defaultPostTemplate
+	if opts.Template != "" {
+		data, err := os.ReadFile(opts.Template)
+		if err != nil {
+			return nil, fmt.Errorf("error reading post template: %v", err)
+		}
+		text = string(data)
+	}
+
+	tmpl, err := template.New("post").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing post template: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, opts); err != nil {
+		return nil, fmt.Errorf("error rendering post template: %v", err)
+	}
+	return buf.Bytes(), nil
+}
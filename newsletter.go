@@ -0,0 +1,614 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/net/html"
+)
+
+// NewsletterConfig emails new posts to readers who subscribed with their
+// address and confirmed it
+type NewsletterConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Database stores the subscribers and the log of sent emails
+	Database string `yaml:"database"`
+	// Retries is how many more times a failed email is tried, waiting
+	// longer each time
+	Retries int `yaml:"retries"`
+}
+
+// Statuses of newsletter subscribers
+const (
+	SubscriberPending      = "pending"
+	SubscriberConfirmed    = "confirmed"
+	SubscriberUnsubscribed = "unsubscribed"
+)
+
+// Statuses of newsletter sends
+const (
+	SendQueued = "queued"
+	SendSent   = "sent"
+	SendFailed = "failed"
+)
+
+const (
+	// newsletterInterval is how often the sender looks for due emails when
+	// it is not woken up by a new post
+	newsletterInterval = 30 * time.Second
+	// newsletterBatch is how many emails the sender takes at a time
+	newsletterBatch = 50
+	// newsletterFormTTL is how long a rendered signup form can be submitted
+	newsletterFormTTL = 24 * time.Hour
+	// newsletterMinSubmitTime rejects signups sent back faster than a
+	// person could type their address
+	newsletterMinSubmitTime = 2 * time.Second
+)
+
+var newsletterMigrations = []string{
+	`CREATE TABLE subscribers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		status TEXT NOT NULL,
+		token TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMP NOT NULL,
+		confirmed_at TIMESTAMP
+	);
+	CREATE TABLE newsletter_sends (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post TEXT NOT NULL,
+		subscriber INTEGER NOT NULL REFERENCES subscribers (id) ON DELETE CASCADE,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		next_attempt_at TIMESTAMP NOT NULL,
+		sent_at TIMESTAMP,
+		UNIQUE (post, subscriber)
+	);
+	CREATE INDEX newsletter_sends_due ON newsletter_sends (status, next_attempt_at);`,
+}
+
+// Subscriber is a reader signed up for the newsletter. Token is the secret
+// in their confirmation and unsubscribe links.
+type Subscriber struct {
+	ID        int64
+	Email     string
+	Status    string
+	Token     string
+	CreatedAt time.Time
+}
+
+// NewsletterSend is the delivery of a post to one subscriber
+type NewsletterSend struct {
+	ID       int64
+	Post     string
+	Email    string
+	Token    string
+	Status   string
+	Attempts int
+	Error    string
+	// At is when the email was sent, or when it is tried next
+	At time.Time
+}
+
+// newsletterStore keeps the subscribers and the send queue. wake starts
+// the sender early when a post is queued.
+type newsletterStore struct {
+	db   *sql.DB
+	wake chan struct{}
+}
+
+// newNewsletterStore opens the newsletter database of cfg, or returns nil
+// when the newsletter is disabled
+func newNewsletterStore(cfg *Config) (*newsletterStore, error) {
+	if !cfg.Newsletter.Enabled {
+		return nil, nil
+	}
+	db, err := openDatabase(cfg.Newsletter.Database, newsletterMigrations)
+	if err != nil {
+		return nil, err
+	}
+	return &newsletterStore{db: db, wake: make(chan struct{}, 1)}, nil
+}
+
+// Subscribe signs up email and returns the subscriber. Readers who left are
+// signed up again and need to confirm once more.
+func (s *newsletterStore) Subscribe(email string) (*Subscriber, error) {
+	_, err := s.db.Exec(`INSERT INTO subscribers (email, status, token, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (email) DO UPDATE SET status = ?, token = ?, created_at = excluded.created_at WHERE status = ?`,
+		email, SubscriberPending, randomToken(), time.Now().UTC(),
+		SubscriberPending, randomToken(), SubscriberUnsubscribed)
+	if err != nil {
+		return nil, err
+	}
+	return s.subscriber("email = ?", email)
+}
+
+// ByToken returns the subscriber a confirmation or unsubscribe link is for
+func (s *newsletterStore) ByToken(token string) (*Subscriber, error) {
+	return s.subscriber("token = ?", token)
+}
+
+func (s *newsletterStore) subscriber(where string, arg any) (*Subscriber, error) {
+	var sub Subscriber
+	err := s.db.QueryRow("SELECT id, email, status, token, created_at FROM subscribers WHERE "+where, arg).
+		Scan(&sub.ID, &sub.Email, &sub.Status, &sub.Token, &sub.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// SetStatus confirms or unsubscribes a subscriber. Unsubscribing drops the
+// emails still queued for them.
+func (s *newsletterStore) SetStatus(id int64, status string) error {
+	res, err := s.db.Exec("UPDATE subscribers SET status = ?, confirmed_at = CASE WHEN ? = ? THEN ? ELSE confirmed_at END WHERE id = ?",
+		status, status, SubscriberConfirmed, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if err := expectOneRow(res); err != nil {
+		return err
+	}
+	if status == SubscriberUnsubscribed {
+		_, err = s.db.Exec("DELETE FROM newsletter_sends WHERE subscriber = ? AND status = ?", id, SendQueued)
+	}
+	return err
+}
+
+// Delete removes a subscriber and their send log
+func (s *newsletterStore) Delete(id int64) error {
+	if _, err := s.db.Exec("DELETE FROM newsletter_sends WHERE subscriber = ?", id); err != nil {
+		return err
+	}
+	res, err := s.db.Exec("DELETE FROM subscribers WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(res)
+}
+
+// Subscribers returns every subscriber, newest first
+func (s *newsletterStore) Subscribers() ([]Subscriber, error) {
+	rows, err := s.db.Query("SELECT id, email, status, token, created_at FROM subscribers ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []Subscriber
+	for rows.Next() {
+		var sub Subscriber
+		if err := rows.Scan(&sub.ID, &sub.Email, &sub.Status, &sub.Token, &sub.CreatedAt); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// Queue adds an email about post for every confirmed subscriber and
+// returns how many were queued. A post is only ever sent once to each
+// subscriber, even when it is published again.
+func (s *newsletterStore) Queue(post string) (int64, error) {
+	res, err := s.db.Exec(`INSERT INTO newsletter_sends (post, subscriber, status, next_attempt_at)
+		SELECT ?, id, ?, ? FROM subscribers WHERE status = ? ON CONFLICT DO NOTHING`,
+		post, SendQueued, time.Now().UTC(), SubscriberConfirmed)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if n > 0 {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	return n, err
+}
+
+// Due returns up to n queued emails whose time has come
+func (s *newsletterStore) Due(n int) ([]NewsletterSend, error) {
+	return s.sends(`WHERE n.status = ? AND n.next_attempt_at <= ? ORDER BY n.id LIMIT ?`, SendQueued, time.Now().UTC(), n)
+}
+
+// Recent returns the last n sends, tried or not, newest first
+func (s *newsletterStore) Recent(n int) ([]NewsletterSend, error) {
+	return s.sends("ORDER BY n.id DESC LIMIT ?", n)
+}
+
+func (s *newsletterStore) sends(query string, args ...any) ([]NewsletterSend, error) {
+	rows, err := s.db.Query(`SELECT n.id, n.post, s.email, s.token, n.status, n.attempts, n.error, COALESCE(n.sent_at, n.next_attempt_at)
+		FROM newsletter_sends n JOIN subscribers s ON s.id = n.subscriber `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sends []NewsletterSend
+	for rows.Next() {
+		var send NewsletterSend
+		var at string
+		if err := rows.Scan(&send.ID, &send.Post, &send.Email, &send.Token, &send.Status, &send.Attempts, &send.Error, &at); err != nil {
+			return nil, err
+		}
+		// COALESCE loses the column type, so the time comes back as text
+		send.At, _ = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", at)
+		sends = append(sends, send)
+	}
+	return sends, rows.Err()
+}
+
+// Sent marks an email as delivered
+func (s *newsletterStore) Sent(id int64) error {
+	_, err := s.db.Exec("UPDATE newsletter_sends SET status = ?, attempts = attempts + 1, error = '', sent_at = ? WHERE id = ?",
+		SendSent, time.Now().UTC(), id)
+	return err
+}
+
+// Failed records a failed attempt. The email is tried again after a growing
+// delay until retries are used up.
+func (s *newsletterStore) Failed(send NewsletterSend, retries int, sendErr error) error {
+	attempts := send.Attempts + 1
+	status := SendQueued
+	if attempts > retries {
+		status = SendFailed
+	}
+	next := time.Now().Add(time.Duration(attempts*attempts) * time.Minute)
+	_, err := s.db.Exec("UPDATE newsletter_sends SET status = ?, attempts = ?, error = ?, next_attempt_at = ? WHERE id = ?",
+		status, attempts, sendErr.Error(), next.UTC(), send.ID)
+	return err
+}
+
+// startNewsletter runs the newsletter sender of every site until ctx is done
+func (r *siteRouter) startNewsletter(ctx context.Context) {
+	for _, s := range append([]*Server{r.main}, r.sites...) {
+		if s.newsletter != nil {
+			go s.runNewsletter(ctx)
+		}
+	}
+}
+
+// runNewsletter sends due emails every newsletterInterval, or right away
+// when a post is queued
+func (s *Server) runNewsletter(ctx context.Context) {
+	ticker := time.NewTicker(newsletterInterval)
+	defer ticker.Stop()
+	for {
+		s.sendNewsletters(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.newsletter.wake:
+		}
+	}
+}
+
+// sendNewsletters sends the due emails, one at a time
+func (s *Server) sendNewsletters(ctx context.Context) {
+	for ctx.Err() == nil {
+		due, err := s.newsletter.Due(newsletterBatch)
+		if err != nil {
+			slog.Error("Error loading newsletter queue", "error", err)
+			return
+		}
+		if len(due) == 0 {
+			return
+		}
+		for _, send := range due {
+			if ctx.Err() != nil {
+				return
+			}
+			s.sendNewsletter(send)
+		}
+	}
+}
+
+// sendNewsletter emails a post to one subscriber and logs the outcome
+func (s *Server) sendNewsletter(send NewsletterSend) {
+	log := slog.With("post", send.Post, "to", send.Email, "attempt", send.Attempts+1)
+	post := s.findPost(send.Post)
+	if post == nil || !apiVisible(post) {
+		// The post was unpublished or deleted since, it is not tried again
+		err := s.newsletter.Failed(send, 0, fmt.Errorf("the post is no longer published"))
+		log.Warn("Dropped newsletter for unpublished post", "error", err)
+		return
+	}
+
+	m, err := s.newsletterMail(post, send)
+	if err == nil {
+		err = sendMessage(s.cfg.SMTP, m)
+	}
+	if err != nil {
+		log.Warn("Error sending newsletter", "error", err)
+		if err := s.newsletter.Failed(send, s.cfg.Newsletter.Retries, err); err != nil {
+			log.Error("Error saving newsletter status", "error", err)
+		}
+		return
+	}
+	log.Info("Sent newsletter")
+	if err := s.newsletter.Sent(send.ID); err != nil {
+		log.Error("Error saving newsletter status", "error", err)
+	}
+}
+
+// newsletterMail renders the email about post for a subscriber: the HTML
+// from the emails/newsletter template and a plain text version
+func (s *Server) newsletterMail(post *BlogPost, send NewsletterSend) (outgoingMail, error) {
+	link := s.absoluteURL(nil, "/blog/"+post.Slug)
+	unsubscribe := s.absoluteURL(nil, "/newsletter/unsubscribe/"+send.Token)
+	base, err := url.Parse(link)
+	if err != nil {
+		return outgoingMail{}, err
+	}
+
+	view := *post
+	view.HTMLContent = emailHTML(s.images.Rewrite(post.HTMLContent), base)
+	body, err := s.renderEmail("newsletter", post.Title, fiber.Map{
+		"Post":        &view,
+		"URL":         link,
+		"Unsubscribe": unsubscribe,
+	})
+	if err != nil {
+		return outgoingMail{}, err
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s\n\n", post.Title)
+	if post.Description != "" {
+		fmt.Fprintf(&text, "%s\n\n", post.Description)
+	}
+	fmt.Fprintf(&text, "Read it on %s: %s\n\n", s.cfg.Title, link)
+	fmt.Fprintf(&text, "You get this email because you subscribed to %s. Unsubscribe: %s\n", s.cfg.Title, unsubscribe)
+
+	return outgoingMail{
+		To:      []string{send.Email},
+		Subject: post.Title,
+		Text:    text.String(),
+		HTML:    body,
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribe + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}, nil
+}
+
+// absolutizeHTML resolves the relative links and image sources of a post
+// against its URL, so they keep working outside the site
+func absolutizeHTML(content string, base *url.URL) string {
+	z := html.NewTokenizer(strings.NewReader(content))
+	var b strings.Builder
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return b.String()
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			b.Write(z.Raw())
+			continue
+		}
+		tok := z.Token()
+		for i, a := range tok.Attr {
+			switch a.Key {
+			case "href", "src", "poster":
+				if ref, err := base.Parse(a.Val); err == nil {
+					tok.Attr[i].Val = ref.String()
+				}
+			case "srcset":
+				candidates := strings.Split(a.Val, ",")
+				for j, c := range candidates {
+					src, size, _ := strings.Cut(strings.TrimSpace(c), " ")
+					if ref, err := base.Parse(src); err == nil {
+						candidates[j] = strings.TrimSpace(ref.String() + " " + size)
+					}
+				}
+				tok.Attr[i].Val = strings.Join(candidates, ", ")
+			}
+		}
+		b.WriteString(tok.String())
+	}
+}
+
+// queueNewsletter queues the email about a newly published post
+func (s *Server) queueNewsletter(post *BlogPost) {
+	if s.newsletter == nil {
+		return
+	}
+	n, err := s.newsletter.Queue(post.Slug)
+	if err != nil {
+		slog.Error("Error queueing newsletter", "slug", post.Slug, "error", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("Queued newsletter", "slug", post.Slug, "subscribers", n)
+	}
+}
+
+// newsletterForm is what the signup form in the footer needs. Its tokens
+// are only made when a page renders the form, not for every request.
+type newsletterForm struct {
+	s *Server
+	c *fiber.Ctx
+}
+
+// Token signs the time the form was rendered
+func (f newsletterForm) Token() string {
+	return f.s.signer.Sign("newsletter|"+strconv.FormatInt(time.Now().Unix(), 10), newsletterFormTTL)
+}
+
+// CSRFToken is the token of the visitor's session, which the form sends back
+func (f newsletterForm) CSRFToken() string {
+	return f.s.session(f.c).CSRF
+}
+
+// registerNewsletterRoutes wires up the signup form and the confirmation and
+// unsubscribe links
+func (s *Server) registerNewsletterRoutes() {
+	if s.newsletter == nil {
+		return
+	}
+	s.app.Use(func(c *fiber.Ctx) error {
+		c.Locals("Newsletter", newsletterForm{s: s, c: c})
+		return c.Next()
+	})
+	s.app.Post("/newsletter/subscribe", s.sessionMiddleware, s.csrfProtect, s.handleNewsletterSubscribe)
+	s.app.Get("/newsletter/confirm/:token", s.handleNewsletterLink)
+	s.app.Post("/newsletter/confirm/:token", s.handleNewsletterConfirm)
+	s.app.Get("/newsletter/unsubscribe/:token", s.handleNewsletterLink)
+	s.app.Post("/newsletter/unsubscribe/:token", s.handleNewsletterUnsubscribe)
+}
+
+// renderNewsletterPage shows the outcome of a signup or a link
+func renderNewsletterPage(c *fiber.Ctx, status int, title, message string, data fiber.Map) error {
+	if data == nil {
+		data = fiber.Map{}
+	}
+	data["Title"] = title
+	data["Message"] = message
+	return c.Status(status).Render("newsletter", data)
+}
+
+// handleNewsletterSubscribe signs a reader up and emails them a link to
+// confirm their address. The page says the same whether or not the address
+// was already subscribed, so it does not tell who reads the blog.
+func (s *Server) handleNewsletterSubscribe(c *fiber.Ctx) error {
+	log := requestLogger(c)
+	value, ok := s.signer.Verify(c.FormValue("token"))
+	prefix, stamp, _ := strings.Cut(value, "|")
+	rendered, err := strconv.ParseInt(stamp, 10, 64)
+	if c.FormValue(commentHoneypot) != "" || !ok || err != nil || prefix != "newsletter" ||
+		time.Since(time.Unix(rendered, 0)) < newsletterMinSubmitTime {
+		log.Info("Rejected newsletter signup", "reason", "honeypot, form token or submitted too quickly")
+		return renderNewsletterPage(c, fiber.StatusOK, "Check your inbox", "We sent you a link to confirm your subscription.", nil)
+	}
+	addr, err := mail.ParseAddress(strings.TrimSpace(c.FormValue("email")))
+	if err != nil || addr.Name != "" || !strings.Contains(addr.Address, ".") {
+		return renderNewsletterPage(c, fiber.StatusUnprocessableEntity, "Invalid address", "Enter an email address like you@example.com.", nil)
+	}
+	if !s.newsletterLimit.Allow(c.IP()) {
+		return renderNewsletterPage(c, fiber.StatusTooManyRequests, "Too many signups", "Please try again later.", nil)
+	}
+
+	sub, err := s.newsletter.Subscribe(strings.ToLower(addr.Address))
+	if err != nil {
+		return s.internalError(c, "Error saving subscriber", err)
+	}
+	if sub.Status == SubscriberPending {
+		s.notify(s.confirmMail(c, sub))
+	}
+	log.Info("Newsletter signup", "status", sub.Status)
+	return renderNewsletterPage(c, fiber.StatusOK, "Check your inbox", "We sent you a link to confirm your subscription.", nil)
+}
+
+// confirmMail is the email with the link that confirms a subscription
+func (s *Server) confirmMail(c *fiber.Ctx, sub *Subscriber) outgoingMail {
+	link := s.absoluteURL(c, "/newsletter/confirm/"+sub.Token)
+	m := outgoingMail{
+		To:      []string{sub.Email},
+		Subject: fmt.Sprintf("[%s] Confirm your subscription", s.cfg.Title),
+		Text: fmt.Sprintf("Please confirm that you want new posts of %s by email:\n\n%s\n\nIf you did not sign up, ignore this email and you will not hear from us again.\n",
+			s.cfg.Title, link),
+	}
+	return s.withHTML(m, "confirm", fiber.Map{"Link": link})
+}
+
+// handleNewsletterLink shows the button behind a confirmation or unsubscribe
+// link. Mail scanners open links in emails, so only the POST acts.
+func (s *Server) handleNewsletterLink(c *fiber.Ctx) error {
+	sub, err := s.newsletter.ByToken(c.Params("token"))
+	if err == sql.ErrNoRows {
+		return renderNewsletterPage(c, fiber.StatusNotFound, "Link not found", "This link is invalid or the subscription was removed.", nil)
+	}
+	if err != nil {
+		return s.internalError(c, "Error loading subscriber", err)
+	}
+	if strings.HasPrefix(c.Path(), "/newsletter/confirm/") {
+		return renderNewsletterPage(c, fiber.StatusOK, "Confirm your subscription", "Get new posts of "+s.cfg.Title+" at "+sub.Email+".",
+			fiber.Map{"Action": "Confirm"})
+	}
+	return renderNewsletterPage(c, fiber.StatusOK, "Unsubscribe", "Stop emails about new posts to "+sub.Email+".",
+		fiber.Map{"Action": "Unsubscribe"})
+}
+
+// handleNewsletterConfirm confirms a subscriber's address
+func (s *Server) handleNewsletterConfirm(c *fiber.Ctx) error {
+	return s.setSubscriberStatus(c, SubscriberConfirmed, "You are subscribed", "New posts will arrive in your inbox.")
+}
+
+// handleNewsletterUnsubscribe unsubscribes a reader, either from the page or
+// with a one-click List-Unsubscribe POST from their mail client
+func (s *Server) handleNewsletterUnsubscribe(c *fiber.Ctx) error {
+	return s.setSubscriberStatus(c, SubscriberUnsubscribed, "You are unsubscribed", "You will not get emails about new posts anymore.")
+}
+
+func (s *Server) setSubscriberStatus(c *fiber.Ctx, status, title, message string) error {
+	sub, err := s.newsletter.ByToken(c.Params("token"))
+	if err == sql.ErrNoRows {
+		return renderNewsletterPage(c, fiber.StatusNotFound, "Link not found", "This link is invalid or the subscription was removed.", nil)
+	}
+	if err != nil {
+		return s.internalError(c, "Error loading subscriber", err)
+	}
+	if err := s.newsletter.SetStatus(sub.ID, status); err != nil {
+		return s.internalError(c, "Error saving subscriber", err)
+	}
+	requestLogger(c).Info("Newsletter subscriber "+status, "id", sub.ID)
+	return renderNewsletterPage(c, fiber.StatusOK, title, message, nil)
+}
+
+// handleNewsletterAdmin lists the subscribers and the recent sends
+func (s *Server) handleNewsletterAdmin(c *fiber.Ctx) error {
+	if s.newsletter == nil {
+		return c.Render("admin_newsletter", fiber.Map{"Title": "Newsletter", "Enabled": false})
+	}
+	subs, err := s.newsletter.Subscribers()
+	if err != nil {
+		return s.internalError(c, "Error loading subscribers", err)
+	}
+	sends, err := s.newsletter.Recent(100)
+	if err != nil {
+		return s.internalError(c, "Error loading newsletter log", err)
+	}
+	counts := map[string]int{}
+	for _, sub := range subs {
+		counts[sub.Status]++
+	}
+	return c.Render("admin_newsletter", fiber.Map{
+		"Title":       "Newsletter",
+		"Enabled":     true,
+		"Notice":      c.Query("notice"),
+		"Subscribers": subs,
+		"Counts":      counts,
+		"Sends":       sends,
+	})
+}
+
+// handleSubscriberDelete removes a subscriber
+func (s *Server) handleSubscriberDelete(c *fiber.Ctx) error {
+	if s.newsletter == nil {
+		return fiber.ErrNotFound
+	}
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return fiber.ErrNotFound
+	}
+	err = s.newsletter.Delete(id)
+	if err == sql.ErrNoRows {
+		return fiber.ErrNotFound
+	}
+	if err != nil {
+		return s.internalError(c, "Error deleting subscriber", err)
+	}
+	requestLogger(c).Info("Deleted subscriber", "id", id)
+	s.audit(c, "Delete newsletter subscriber %d", id)
+	return c.Redirect("/admin/newsletter?notice="+url.QueryEscape("Subscriber removed"), fiber.StatusSeeOther)
+}
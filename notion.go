@@ -0,0 +1,710 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NotionConfig syncs the pages of a Notion database into the content
+// directory as posts
+type NotionConfig struct {
+	// Token is the secret of the Notion integration the database is shared
+	// with
+	Token string `yaml:"token"`
+	// Database is the ID of the database, the 32 characters in its URL
+	Database string `yaml:"database"`
+	// Interval is how often serve syncs the database. Zero leaves syncing to
+	// `devdaze notion sync`.
+	Interval time.Duration `yaml:"interval"`
+	// Properties names the database properties frontmatter is read from
+	Properties NotionProperties `yaml:"properties"`
+}
+
+// NotionProperties names the property of the database each frontmatter
+// field comes from. The title is always the title property.
+type NotionProperties struct {
+	Slug        string `yaml:"slug"`
+	Date        string `yaml:"date"`
+	Tags        string `yaml:"tags"`
+	Description string `yaml:"description"`
+	Author      string `yaml:"author"`
+	Series      string `yaml:"series"`
+	// Status is a checkbox, or a select or status property that is
+	// Published for posts that are out. Pages without it are published.
+	Status string `yaml:"status"`
+}
+
+// validate checks that syncing on a schedule has a database to sync
+func (c *NotionConfig) validate() error {
+	if c.Interval > 0 && (c.Token == "" || c.Database == "") {
+		return fmt.Errorf("notion.interval needs notion.token and notion.database")
+	}
+	return nil
+}
+
+// notionAPI is the base URL of the Notion API
+const notionAPI = "https://api.notion.com/v1"
+
+// notionVersion is the version of the Notion API the sync is written against
+const notionVersion = "2022-06-28"
+
+// notionStateFile remembers which file each page was written to and when the
+// page was last edited, in the content directory
+const notionStateFile = ".notion-sync.json"
+
+// notionClient calls the Notion API with an integration token
+type notionClient struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// do sends a request and decodes the JSON response into out, waiting out
+// rate limits
+func (c *notionClient) do(ctx context.Context, method, endpoint string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.base+endpoint, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		req.Header.Set("Notion-Version", notionVersion)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < 3 {
+			wait, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(max(wait, 1)) * time.Second):
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			var apiErr struct {
+				Message string `json:"message"`
+			}
+			json.Unmarshal(data, &apiErr)
+			return fmt.Errorf("notion API %s: %d %s", endpoint, resp.StatusCode, apiErr.Message)
+		}
+		return json.Unmarshal(data, out)
+	}
+}
+
+// notionPage is a page of the database
+type notionPage struct {
+	ID             string                    `json:"id"`
+	CreatedTime    string                    `json:"created_time"`
+	LastEditedTime string                    `json:"last_edited_time"`
+	Properties     map[string]notionProperty `json:"properties"`
+}
+
+// notionName is a select option or a person
+type notionName struct {
+	Name string `json:"name"`
+}
+
+// notionProperty is a property value of a page. Only the field of its type
+// is set.
+type notionProperty struct {
+	Type        string           `json:"type"`
+	Title       []notionRichText `json:"title"`
+	RichText    []notionRichText `json:"rich_text"`
+	Select      *notionName      `json:"select"`
+	Status      *notionName      `json:"status"`
+	MultiSelect []notionName     `json:"multi_select"`
+	People      []notionName     `json:"people"`
+	Checkbox    bool             `json:"checkbox"`
+	URL         string           `json:"url"`
+	Date        *struct {
+		Start string `json:"start"`
+	} `json:"date"`
+}
+
+// text returns a property as plain text
+func (p notionProperty) text() string {
+	switch p.Type {
+	case "title":
+		return plainText(p.Title)
+	case "rich_text":
+		return plainText(p.RichText)
+	case "select":
+		if p.Select != nil {
+			return p.Select.Name
+		}
+	case "status":
+		if p.Status != nil {
+			return p.Status.Name
+		}
+	case "people", "multi_select":
+		return strings.Join(p.list(), ", ")
+	case "date":
+		if p.Date != nil {
+			return p.Date.Start
+		}
+	case "url":
+		return p.URL
+	}
+	return ""
+}
+
+// list returns the names of a multi select or people property, or a text
+// property split at commas
+func (p notionProperty) list() []string {
+	var names []notionName
+	switch p.Type {
+	case "multi_select":
+		names = p.MultiSelect
+	case "people":
+		names = p.People
+	default:
+		return listValue(p.text(), ",")
+	}
+	var list []string
+	for _, n := range names {
+		list = append(list, n.Name)
+	}
+	return list
+}
+
+// notionRichText is a run of formatted text
+type notionRichText struct {
+	Type        string `json:"type"`
+	PlainText   string `json:"plain_text"`
+	Href        string `json:"href"`
+	Annotations struct {
+		Bold          bool `json:"bold"`
+		Italic        bool `json:"italic"`
+		Strikethrough bool `json:"strikethrough"`
+		Code          bool `json:"code"`
+	} `json:"annotations"`
+}
+
+// plainText joins rich text without its formatting
+func plainText(rt []notionRichText) string {
+	var b strings.Builder
+	for _, t := range rt {
+		b.WriteString(t.PlainText)
+	}
+	return b.String()
+}
+
+// notionMarkdown converts rich text to inline markdown
+func notionMarkdown(rt []notionRichText) string {
+	var b strings.Builder
+	for _, t := range rt {
+		text := markdownEscaper.Replace(t.PlainText)
+		switch {
+		case t.Type == "equation":
+			text = "$" + t.PlainText + "$"
+		case t.Annotations.Code:
+			text = "`" + t.PlainText + "`"
+		}
+		if t.Annotations.Bold {
+			text = wrapInline(text, "**")
+		}
+		if t.Annotations.Italic {
+			text = wrapInline(text, "*")
+		}
+		if t.Annotations.Strikethrough {
+			text = wrapInline(text, "~~")
+		}
+		if t.Href != "" && strings.TrimSpace(text) != "" {
+			text = "[" + text + "](" + t.Href + ")"
+		}
+		b.WriteString(text)
+	}
+	return b.String()
+}
+
+// notionBlock is a block of a page's content, with the fields of its type
+// in Content
+type notionBlock struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	HasChildren bool   `json:"has_children"`
+	Content     notionBlockContent
+	Children    []notionBlock
+}
+
+// notionFile is an uploaded file, whose URL expires after an hour, or an
+// external one
+type notionFile struct {
+	URL string `json:"url"`
+}
+
+// notionBlockContent holds the fields of every block type the sync converts
+type notionBlockContent struct {
+	RichText        []notionRichText   `json:"rich_text"`
+	Caption         []notionRichText   `json:"caption"`
+	Checked         bool               `json:"checked"`
+	Language        string             `json:"language"`
+	URL             string             `json:"url"`
+	Expression      string             `json:"expression"`
+	Type            string             `json:"type"`
+	File            notionFile         `json:"file"`
+	External        notionFile         `json:"external"`
+	Cells           [][]notionRichText `json:"cells"`
+	HasColumnHeader bool               `json:"has_column_header"`
+}
+
+// UnmarshalJSON reads the content of a block from the field named after its
+// type
+func (b *notionBlock) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	json.Unmarshal(fields["id"], &b.ID)
+	json.Unmarshal(fields["type"], &b.Type)
+	json.Unmarshal(fields["has_children"], &b.HasChildren)
+	if content, ok := fields[b.Type]; ok {
+		return json.Unmarshal(content, &b.Content)
+	}
+	return nil
+}
+
+// notionList is a page of results of the Notion API
+type notionList struct {
+	Results    json.RawMessage `json:"results"`
+	HasMore    bool            `json:"has_more"`
+	NextCursor string          `json:"next_cursor"`
+}
+
+// queryPages returns every page of the database
+func (c *notionClient) queryPages(ctx context.Context, database string) ([]notionPage, error) {
+	var pages []notionPage
+	body := map[string]interface{}{"page_size": 100}
+	for {
+		var list notionList
+		var results []notionPage
+		if err := c.do(ctx, http.MethodPost, "/databases/"+url.PathEscape(database)+"/query", body, &list); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(list.Results, &results); err != nil {
+			return nil, err
+		}
+		pages = append(pages, results...)
+		if !list.HasMore {
+			return pages, nil
+		}
+		body["start_cursor"] = list.NextCursor
+	}
+}
+
+// blocks returns the blocks of a page or block, with their children
+func (c *notionClient) blocks(ctx context.Context, id string) ([]notionBlock, error) {
+	var blocks []notionBlock
+	cursor := ""
+	for {
+		endpoint := "/blocks/" + url.PathEscape(id) + "/children?page_size=100"
+		if cursor != "" {
+			endpoint += "&start_cursor=" + url.QueryEscape(cursor)
+		}
+		var list notionList
+		var results []notionBlock
+		if err := c.do(ctx, http.MethodGet, endpoint, nil, &list); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(list.Results, &results); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, results...)
+		if !list.HasMore {
+			break
+		}
+		cursor = list.NextCursor
+	}
+
+	for i := range blocks {
+		// Subpages are pages of their own
+		if blocks[i].HasChildren && blocks[i].Type != "child_page" && blocks[i].Type != "child_database" {
+			children, err := c.blocks(ctx, blocks[i].ID)
+			if err != nil {
+				return nil, err
+			}
+			blocks[i].Children = children
+		}
+	}
+	return blocks, nil
+}
+
+// notionSynced is where a page was written and the edit it was written at
+type notionSynced struct {
+	File   string `json:"file"`
+	Edited string `json:"edited"`
+}
+
+// NotionSyncResult counts what a sync did
+type NotionSyncResult struct {
+	Updated   int
+	Unchanged int
+	Removed   int
+	Skipped   int
+}
+
+// Changed reports whether the sync wrote or removed any post
+func (r NotionSyncResult) Changed() bool {
+	return r.Updated > 0 || r.Removed > 0
+}
+
+// notionSyncer converts the pages of a database into posts
+type notionSyncer struct {
+	cfg    *Config
+	client *notionClient
+	// files downloads the uploaded files pages show, whose URLs expire
+	files *http.Client
+}
+
+// syncNotion writes the pages of the Notion database into the content
+// directory. Pages are written again once edited, and the posts of pages
+// removed from the database are moved to the trash. Files uploaded to
+// Notion are downloaded to the media directory.
+func syncNotion(ctx context.Context, cfg *Config) (NotionSyncResult, error) {
+	var result NotionSyncResult
+	if cfg.Notion.Token == "" || cfg.Notion.Database == "" {
+		return result, fmt.Errorf("syncing Notion needs notion.token and notion.database")
+	}
+	ns := &notionSyncer{
+		cfg:    cfg,
+		client: &notionClient{base: notionAPI, token: cfg.Notion.Token, http: &http.Client{Timeout: 30 * time.Second}},
+		files:  &http.Client{Timeout: 30 * time.Second},
+	}
+
+	statePath := filepath.Join(cfg.ContentDir, notionStateFile)
+	state := make(map[string]notionSynced)
+	if data, err := os.ReadFile(statePath); err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return result, fmt.Errorf("error reading %s: %v", statePath, err)
+		}
+	}
+
+	pages, err := ns.client.queryPages(ctx, cfg.Notion.Database)
+	if err != nil {
+		return result, err
+	}
+	seen := make(map[string]bool, len(pages))
+	for _, page := range pages {
+		seen[page.ID] = true
+		synced, ok := state[page.ID]
+		if ok && synced.Edited == page.LastEditedTime && fileExists(filepath.Join(cfg.ContentDir, synced.File)) {
+			result.Unchanged++
+			continue
+		}
+
+		post, err := ns.post(ctx, page)
+		if err != nil {
+			return result, fmt.Errorf("error syncing Notion page %s: %v", page.ID, err)
+		}
+		file := post.Slug + ".md"
+		if file != synced.File && fileExists(filepath.Join(cfg.ContentDir, file)) {
+			slog.Warn("Skipping Notion page whose slug is taken by another post", "page", page.ID, "slug", post.Slug)
+			result.Skipped++
+			continue
+		}
+		data, err := formatPostFile(post)
+		if err != nil {
+			return result, err
+		}
+		if err := writeOutputFile(cfg.ContentDir, file, data); err != nil {
+			return result, err
+		}
+		// The slug changed
+		if synced.File != "" && synced.File != file {
+			os.Remove(filepath.Join(cfg.ContentDir, synced.File))
+		}
+		state[page.ID] = notionSynced{File: file, Edited: page.LastEditedTime}
+		result.Updated++
+	}
+
+	for id, synced := range state {
+		if seen[id] {
+			continue
+		}
+		if err := moveToTrash(cfg.ContentDir, filepath.Join(cfg.ContentDir, synced.File)); err != nil && !os.IsNotExist(err) {
+			return result, err
+		}
+		delete(state, id)
+		result.Removed++
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return result, err
+	}
+	return result, os.WriteFile(statePath, data, 0644)
+}
+
+// post converts a page to a post, with frontmatter from its properties
+func (ns *notionSyncer) post(ctx context.Context, page notionPage) (*BlogPost, error) {
+	props := ns.cfg.Notion.Properties
+	prop := func(name string) notionProperty {
+		return page.Properties[name]
+	}
+
+	var title string
+	for _, p := range page.Properties {
+		if p.Type == "title" {
+			title = strings.TrimSpace(p.text())
+		}
+	}
+	post := &BlogPost{
+		Title:       title,
+		Author:      strings.Join(prop(props.Author).list(), ", "),
+		Description: strings.TrimSpace(prop(props.Description).text()),
+		Tags:        prop(props.Tags).list(),
+		Series:      strings.TrimSpace(prop(props.Series).text()),
+		Slug:        slugify(prop(props.Slug).text()),
+	}
+	if post.Slug == "" {
+		post.Slug = slugify(title)
+	}
+	if post.Slug == "" {
+		post.Slug = strings.ReplaceAll(page.ID, "-", "")
+	}
+	if post.Title == "" {
+		post.Title = post.Slug
+	}
+
+	date, ok := timeValue(prop(props.Date).text(), ns.cfg.Location())
+	if !ok {
+		date, _ = timeValue(page.CreatedTime, ns.cfg.Location())
+	}
+	post.Date = date.In(ns.cfg.Location())
+
+	if status, ok := page.Properties[props.Status]; ok {
+		if status.Type == "checkbox" {
+			post.Draft = !status.Checkbox
+		} else {
+			post.Draft = !strings.EqualFold(status.text(), "published")
+		}
+	}
+
+	blocks, err := ns.client.blocks(ctx, page.ID)
+	if err != nil {
+		return nil, err
+	}
+	post.Content = ns.markdown(blocks)
+	return post, nil
+}
+
+// markdown converts blocks to markdown. List items follow each other without
+// a blank line.
+func (ns *notionSyncer) markdown(blocks []notionBlock) string {
+	var b strings.Builder
+	number := 0
+	prev := ""
+	for _, block := range blocks {
+		if block.Type == "numbered_list_item" {
+			number++
+		} else {
+			number = 0
+		}
+		text := ns.block(block, number)
+		if text == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			if notionListItem(block.Type) && block.Type == prev {
+				b.WriteString("\n")
+			} else {
+				b.WriteString("\n\n")
+			}
+		}
+		b.WriteString(text)
+		prev = block.Type
+	}
+	return b.String()
+}
+
+// notionListItem reports whether a block type is an item of a list
+func notionListItem(kind string) bool {
+	return kind == "bulleted_list_item" || kind == "numbered_list_item" || kind == "to_do"
+}
+
+// block converts a block and its children to markdown. number is the
+// position of a numbered list item in its list.
+func (ns *notionSyncer) block(block notionBlock, number int) string {
+	c := block.Content
+	text := notionMarkdown(c.RichText)
+	children := ns.markdown(block.Children)
+	indent := func(marker string) string {
+		s := marker + text
+		if children != "" {
+			s += "\n" + children
+		}
+		return strings.ReplaceAll(s, "\n", "\n"+strings.Repeat(" ", len(marker)))
+	}
+	quote := func(s string) string {
+		lines := strings.Split(s, "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return strings.Join(lines, "\n")
+	}
+	joined := func(parts ...string) string {
+		var kept []string
+		for _, p := range parts {
+			if p != "" {
+				kept = append(kept, p)
+			}
+		}
+		return strings.Join(kept, "\n\n")
+	}
+
+	switch block.Type {
+	case "paragraph":
+		return joined(text, children)
+	case "heading_1", "heading_2", "heading_3":
+		level, _ := strconv.Atoi(block.Type[len("heading_"):])
+		return joined(strings.Repeat("#", level)+" "+text, children)
+	case "bulleted_list_item":
+		return indent("- ")
+	case "numbered_list_item":
+		return indent(strconv.Itoa(number) + ". ")
+	case "to_do":
+		if c.Checked {
+			return indent("- [x] ")
+		}
+		return indent("- [ ] ")
+	case "quote", "callout":
+		return quote(joined(text, children))
+	case "toggle":
+		return "<details>\n<summary>" + text + "</summary>\n\n" + children + "\n\n</details>"
+	case "code":
+		language := c.Language
+		if language == "plain text" {
+			language = ""
+		}
+		return "```" + language + "\n" + plainText(c.RichText) + "\n```"
+	case "equation":
+		return "$$\n" + c.Expression + "\n$$"
+	case "divider":
+		return "---"
+	case "image":
+		image := "![" + markdownEscaper.Replace(plainText(c.Caption)) + "](" + ns.fileURL(block) + ")"
+		if caption := notionMarkdown(c.Caption); caption != "" {
+			image += "\n\n" + wrapInline(caption, "*")
+		}
+		return image
+	case "video", "file", "pdf", "audio":
+		label := notionMarkdown(c.Caption)
+		link := ns.fileURL(block)
+		if label == "" {
+			label = link
+		}
+		return "[" + label + "](" + link + ")"
+	case "bookmark", "embed", "link_preview":
+		label := notionMarkdown(c.Caption)
+		if label == "" {
+			label = c.URL
+		}
+		return "[" + label + "](" + c.URL + ")"
+	case "table":
+		return notionTable(block.Children)
+	case "column_list", "column", "synced_block":
+		return children
+	}
+	slog.Debug("Skipping Notion block without a markdown equivalent", "type", block.Type, "block", block.ID)
+	return ""
+}
+
+// notionTable converts the rows of a table to a markdown table with the
+// first row as its header
+func notionTable(rows []notionBlock) string {
+	var lines []string
+	for i, row := range rows {
+		var cells []string
+		for _, cell := range row.Content.Cells {
+			cells = append(cells, strings.ReplaceAll(notionMarkdown(cell), "|", `\|`))
+		}
+		lines = append(lines, "| "+strings.Join(cells, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", len(cells)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// fileURL returns the URL of the file a media block shows. Files uploaded
+// to Notion are downloaded to the media directory, as their URLs expire.
+func (ns *notionSyncer) fileURL(block notionBlock) string {
+	c := block.Content
+	if c.Type != "file" {
+		return c.External.URL
+	}
+	u, err := url.Parse(c.File.URL)
+	if err != nil {
+		return c.File.URL
+	}
+	rel := "notion/" + strings.ReplaceAll(block.ID, "-", "") + strings.ToLower(path.Ext(u.Path))
+	target := filepath.Join(ns.cfg.Media.Dir, filepath.FromSlash(rel))
+	if !fileExists(target) {
+		if err := downloadFile(ns.files, c.File.URL, target); err != nil {
+			slog.Warn("Error downloading Notion file", "block", block.ID, "error", err)
+			return c.File.URL
+		}
+	}
+	return strings.TrimSuffix(ns.cfg.Media.URL, "/") + "/" + rel
+}
+
+// startNotionSync runs the Notion sync of every site that has an interval
+// until ctx is done
+func (r *siteRouter) startNotionSync(ctx context.Context) {
+	for _, s := range append([]*Server{r.main}, r.sites...) {
+		if s.cfg.Notion.Interval > 0 {
+			go s.runNotionSync(ctx)
+		}
+	}
+}
+
+// runNotionSync syncs the Notion database every interval and re-indexes the
+// content when posts changed
+func (s *Server) runNotionSync(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Notion.Interval)
+	defer ticker.Stop()
+	for {
+		result, err := syncNotion(ctx, s.cfg)
+		if err != nil {
+			slog.Error("Error syncing Notion", "error", err)
+		} else if result.Changed() {
+			slog.Info("Synced Notion", "updated", result.Updated, "removed", result.Removed)
+			if err := s.content.Load(); err != nil {
+				slog.Error("Error reloading content", "error", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+// oauthStateCookie ties an OAuth callback to the browser that started it
+const oauthStateCookie = "devdaze_oauth_state"
+
+// OAuthConfig enables signing in to the admin area with GitHub or Google
+type OAuthConfig struct {
+	GitHub OAuthProviderConfig `yaml:"github"`
+	Google OAuthProviderConfig `yaml:"google"`
+	// Allow lists the account emails and GitHub usernames permitted to sign in
+	Allow []string `yaml:"allow"`
+}
+
+// OAuthProviderConfig holds the app credentials registered with a provider
+type OAuthProviderConfig struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+}
+
+// oauthProvider describes how to sign in with one identity provider
+type oauthProvider struct {
+	Name     string
+	Label    string
+	config   OAuthProviderConfig
+	endpoint oauth2.Endpoint
+	scopes   []string
+	// identities returns the account names checked against the allowlist
+	identities func(ctx context.Context, client *http.Client) ([]string, error)
+}
+
+// oauthProviders returns the providers with credentials configured
+func (s *Server) oauthProviders() []oauthProvider {
+	all := []oauthProvider{
+		{
+			Name: "github", Label: "GitHub",
+			config:     s.cfg.Admin.OAuth.GitHub,
+			endpoint:   endpoints.GitHub,
+			scopes:     []string{"read:user", "user:email"},
+			identities: githubIdentities,
+		},
+		{
+			Name: "google", Label: "Google",
+			config:     s.cfg.Admin.OAuth.Google,
+			endpoint:   endpoints.Google,
+			scopes:     []string{"openid", "email"},
+			identities: googleIdentities,
+		},
+	}
+
+	var enabled []oauthProvider
+	for _, p := range all {
+		if p.config.ClientID != "" && p.config.ClientSecret != "" {
+			enabled = append(enabled, p)
+		}
+	}
+	return enabled
+}
+
+// oauthProvider looks up an enabled provider by name
+func (s *Server) oauthProvider(name string) (oauthProvider, bool) {
+	for _, p := range s.oauthProviders() {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return oauthProvider{}, false
+}
+
+// oauthEnabled reports whether any OAuth provider is configured
+func (s *Server) oauthEnabled() bool {
+	return len(s.oauthProviders()) > 0
+}
+
+// oauth2Config builds the client config for a provider, with the callback
+// URL derived from base_url or the current request
+func (s *Server) oauth2Config(c *fiber.Ctx, p oauthProvider) *oauth2.Config {
+	base := strings.TrimSuffix(s.cfg.BaseURL, "/")
+	if base == "" {
+		base = c.BaseURL()
+	}
+	return &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		Endpoint:     p.endpoint,
+		Scopes:       p.scopes,
+		RedirectURL:  base + "/admin/oauth/" + p.Name + "/callback",
+	}
+}
+
+// adminSession returns the account of a signed-in OAuth admin, if any
+func (s *Server) adminSession(c *fiber.Ctx) (string, bool) {
+	account := s.session(c).Account
+	return account, account != ""
+}
+
+// handleAdminLogin shows the sign-in buttons for the configured providers
+func (s *Server) handleAdminLogin(c *fiber.Ctx) error {
+	if !s.oauthEnabled() {
+		return fiber.ErrNotFound
+	}
+	return c.Render("admin_login", fiber.Map{
+		"Title":     "Sign in",
+		"Providers": s.oauthProviders(),
+		"Next":      safeRedirect(c.Query("next")),
+		"Error":     c.Query("error"),
+	})
+}
+
+// handleOAuthStart redirects to the provider's consent screen
+func (s *Server) handleOAuthStart(c *fiber.Ctx) error {
+	p, ok := s.oauthProvider(c.Params("provider"))
+	if !ok {
+		return fiber.ErrNotFound
+	}
+
+	// The state cookie remembers where to return to after signing in
+	state := randomToken()
+	c.Cookie(&fiber.Cookie{
+		Name:     oauthStateCookie,
+		Value:    s.signer.Sign(state+"|"+safeRedirect(c.Query("next")), 10*time.Minute),
+		Path:     "/admin/oauth",
+		HTTPOnly: true,
+		Secure:   !s.cfg.Development(),
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return c.Redirect(s.oauth2Config(c, p).AuthCodeURL(state), fiber.StatusFound)
+}
+
+// handleOAuthCallback exchanges the authorization code, checks the account
+// against the allowlist and starts an admin session
+func (s *Server) handleOAuthCallback(c *fiber.Ctx) error {
+	p, ok := s.oauthProvider(c.Params("provider"))
+	if !ok {
+		return fiber.ErrNotFound
+	}
+
+	saved, ok := s.signer.Verify(c.Cookies(oauthStateCookie))
+	state, next, _ := strings.Cut(saved, "|")
+	c.ClearCookie(oauthStateCookie)
+	if !ok || c.Query("state") != state {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid or expired login attempt, please try again")
+	}
+	if msg := c.Query("error"); msg != "" {
+		return c.Redirect("/admin/login?error="+url.QueryEscape(msg), fiber.StatusSeeOther)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	conf := s.oauth2Config(c, p)
+	token, err := conf.Exchange(ctx, c.Query("code"))
+	if err != nil {
+		return s.internalError(c, "Sign-in failed", fmt.Errorf("error exchanging %s code: %v", p.Name, err))
+	}
+	identities, err := p.identities(ctx, conf.Client(ctx, token))
+	if err != nil {
+		return s.internalError(c, "Sign-in failed", fmt.Errorf("error fetching %s account: %v", p.Name, err))
+	}
+
+	user, ok := s.oauthUser(p.Name, identities)
+	if !ok {
+		requestLogger(c).Warn("Admin sign-in refused", "provider", p.Name, "accounts", identities)
+		return c.Redirect("/admin/login?error="+url.QueryEscape("That account is not allowed to sign in"), fiber.StatusSeeOther)
+	}
+
+	account := user.Account
+	s.startSession(c, account)
+	requestLogger(c).Info("Admin signed in", "provider", p.Name, "account", account)
+	s.auditAs(c, account, "Sign in with %s", p.Name)
+	return c.Redirect(next, fiber.StatusSeeOther)
+}
+
+// handleAdminLogout ends an OAuth admin session
+func (s *Server) handleAdminLogout(c *fiber.Ctx) error {
+	if account, ok := s.adminSession(c); ok {
+		s.auditAs(c, account, "Sign out")
+	}
+	s.startSession(c, "")
+	return c.Redirect("/admin/login", fiber.StatusSeeOther)
+}
+
+// safeRedirect only allows returning to local admin pages after signing in
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/admin") || strings.HasPrefix(next, "//") {
+		return "/admin"
+	}
+	return next
+}
+
+// githubIdentities returns the username and verified emails of a GitHub account
+func githubIdentities(ctx context.Context, client *http.Client) ([]string, error) {
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user", &user); err != nil {
+		return nil, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	ids := []string{user.Login}
+	for _, e := range emails {
+		if e.Verified {
+			ids = append(ids, e.Email)
+		}
+	}
+	return ids, nil
+}
+
+// googleIdentities returns the verified email of a Google account
+func googleIdentities(ctx context.Context, client *http.Client) ([]string, error) {
+	var info struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := getJSON(ctx, client, "https://openidconnect.googleapis.com/v1/userinfo", &info); err != nil {
+		return nil, err
+	}
+	if !info.EmailVerified {
+		return nil, nil
+	}
+	return []string{info.Email}, nil
+}
+
+// getJSON fetches url with client and decodes the JSON response into v
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
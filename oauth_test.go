@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestSafeRedirect(t *testing.T) {
+	tests := []struct {
+		next string
+		want string
+	}{
+		{"/admin", "/admin"},
+		{"/admin/drafts?status=scheduled", "/admin/drafts?status=scheduled"},
+		{"", "/admin"},
+		{"/blog/hello", "/admin"},
+		{"https://evil.example/admin", "/admin"},
+		{"//evil.example/admin", "/admin"},
+		{"admin", "/admin"},
+	}
+	for _, tt := range tests {
+		if got := safeRedirect(tt.next); got != tt.want {
+			t.Errorf("safeRedirect(%q) = %q, want %q", tt.next, got, tt.want)
+		}
+	}
+}
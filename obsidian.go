@@ -0,0 +1,365 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ObsidianConfig reads the content directory as an Obsidian vault
+type ObsidianConfig struct {
+	// Enabled reads notes in every folder, with or without frontmatter, and
+	// resolves their wikilinks and ![[embeds]]
+	Enabled bool `yaml:"enabled"`
+	// URL is the path prefix the vault's attachments are served under
+	URL string `yaml:"url"`
+}
+
+// readPosts loads the posts of the content directory, leaving out
+// translations, reading it as a vault in Obsidian mode
+func readPosts(cfg *Config) ([]*BlogPost, error) {
+	if cfg.Obsidian.Enabled {
+		return readVault(cfg.ContentDir, cfg.I18n.Languages, cfg.Location())
+	}
+	return getAllBlogPosts(cfg.ContentDir, cfg.I18n.Languages, cfg.Location())
+}
+
+// vaultFolder reports whether a folder of the vault holds notes, which all
+// but hidden ones like .obsidian and .trash do
+func vaultFolder(name string) bool {
+	return !strings.HasPrefix(name, ".") && !internalDir(name)
+}
+
+// readVault loads the notes of a vault in every folder but hidden ones and
+// the translation folders at its top
+func readVault(dir string, languages []string, loc *time.Location) ([]*BlogPost, error) {
+	var posts []*BlogPost
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return posts, nil
+	}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && (!vaultFolder(d.Name()) || (filepath.Dir(p) == dir && slices.Contains(languages, d.Name()))) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), ".md") {
+			return nil
+		}
+		if _, lang := splitLanguage(d.Name(), languages); lang != "" {
+			return nil
+		}
+		post, err := readVaultNote(p, loc)
+		if err != nil {
+			slog.Warn("Error parsing file", "file", p, "error", err)
+			return nil
+		}
+		posts = append(posts, post)
+		return nil
+	})
+	return posts, err
+}
+
+// readVaultNote loads a note. Notes without frontmatter, or without a title,
+// slug or date in it, are named after their file and dated by it. Aliases
+// that aren't paths are the other names Obsidian links to the note by.
+func readVaultNote(p string, loc *time.Location) (*BlogPost, error) {
+	content, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var post *BlogPost
+	if strings.HasPrefix(string(content), "---") {
+		if post, err = parseMarkdownFile(content, loc); err != nil {
+			return nil, err
+		}
+	} else {
+		body := strings.TrimSpace(string(content))
+		post = &BlogPost{Content: body, HTMLContent: renderMarkdown(body)}
+	}
+
+	name := strings.TrimSuffix(filepath.Base(p), ".md")
+	if post.Title == "" {
+		post.Title = name
+	}
+	if post.Slug == "" {
+		post.Slug = slugify(name)
+	}
+	if post.Date.IsZero() {
+		post.Date = info.ModTime().In(loc).Truncate(time.Second)
+	}
+	var paths []string
+	for _, alias := range post.Aliases {
+		if strings.HasPrefix(alias, "/") {
+			paths = append(paths, alias)
+		} else {
+			post.Names = append(post.Names, alias)
+		}
+	}
+	post.Aliases = paths
+	post.FilePath = p
+	return post, nil
+}
+
+// vaultIndex resolves the wikilinks and embeds of a vault's notes
+type vaultIndex struct {
+	url string
+	// notes maps the lowercased names and paths of notes, without .md, to
+	// their post
+	notes map[string]*BlogPost
+	// files maps the lowercased names and paths of attachments to their path
+	// in the vault
+	files map[string]string
+}
+
+// newVaultIndex indexes the attachments of the vault in dir and the notes
+// links can point to
+func newVaultIndex(dir, urlPrefix string, notes []*BlogPost) *vaultIndex {
+	v := &vaultIndex{
+		url:   strings.TrimSuffix(urlPrefix, "/"),
+		notes: make(map[string]*BlogPost),
+		files: make(map[string]string),
+	}
+	for _, note := range notes {
+		rel, err := filepath.Rel(dir, note.FilePath)
+		if err != nil {
+			continue
+		}
+		rel = strings.ToLower(strings.TrimSuffix(filepath.ToSlash(rel), ".md"))
+		v.notes[rel] = note
+		for _, name := range append([]string{path.Base(rel)}, note.Names...) {
+			if _, ok := v.notes[strings.ToLower(name)]; !ok {
+				v.notes[strings.ToLower(name)] = note
+			}
+		}
+	}
+
+	// Obsidian finds an attachment by its name anywhere in the vault,
+	// preferring the one closest to the top
+	depth := make(map[string]int)
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p != dir && !vaultFolder(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !mediaExtensions[strings.ToLower(filepath.Ext(d.Name()))] {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		v.files[strings.ToLower(rel)] = rel
+		name := strings.ToLower(d.Name())
+		if shallowest, ok := depth[name]; !ok || strings.Count(rel, "/") < shallowest {
+			v.files[name] = rel
+			depth[name] = strings.Count(rel, "/")
+		}
+		return nil
+	})
+	return v
+}
+
+// File returns the path in the vault of an attachment, given the path
+// after the attachments URL
+func (v *vaultIndex) File(rel string) (string, bool) {
+	file, ok := v.files[strings.ToLower(rel)]
+	return file, ok && file == rel
+}
+
+// render converts the Obsidian syntax of posts to markdown and renders their
+// HTML again. Their Content stays as written.
+func (v *vaultIndex) render(posts []*BlogPost) {
+	for _, post := range posts {
+		post.HTMLContent = renderMarkdown(v.markdown(post))
+	}
+}
+
+// wikilink matches [[Note]], [[Note#Heading|text]] and ![[image.png|300]]
+var wikilink = regexp.MustCompile(`(!?)\[\[([^\]|#^]*)(?:[#^]([^\]|]*))?(?:\|([^\]]*))?\]\]`)
+
+// obsidianComment matches %%comments%%, which Obsidian doesn't render
+var obsidianComment = regexp.MustCompile(`(?s)%%.*?%%`)
+
+// obsidianHighlight matches ==highlighted== text
+var obsidianHighlight = regexp.MustCompile(`==([^=\n]+)==`)
+
+// obsidianCallout matches the first line of a callout, like > [!note] Title
+var obsidianCallout = regexp.MustCompile(`(?m)^(>\s*)\[!(\w+)\][+-]?[ \t]*(.*)$`)
+
+// fencedCode matches fenced code blocks, which are left as they are
+var fencedCode = regexp.MustCompile("(?ms)^(```|~~~).*?^(```|~~~)[ \t]*$")
+
+// markdown converts a note to plain markdown: wikilinks become links to
+// posts, embeds become images or links to attachments, and callouts, comments
+// and highlights become their markdown or HTML equivalent
+func (v *vaultIndex) markdown(post *BlogPost) string {
+	convert := func(text string) string {
+		text = obsidianComment.ReplaceAllString(text, "")
+		text = obsidianCallout.ReplaceAllStringFunc(text, func(line string) string {
+			m := obsidianCallout.FindStringSubmatch(line)
+			title := strings.TrimSpace(m[3])
+			if title == "" {
+				title = strings.ToUpper(m[2][:1]) + strings.ToLower(m[2][1:])
+			}
+			return m[1] + "**" + title + "**"
+		})
+		// Inline code is left alone too
+		parts := strings.Split(text, "`")
+		for i := 0; i < len(parts); i += 2 {
+			parts[i] = obsidianHighlight.ReplaceAllString(parts[i], "<mark>$1</mark>")
+			parts[i] = wikilink.ReplaceAllStringFunc(parts[i], func(link string) string {
+				return v.link(post, wikilink.FindStringSubmatch(link))
+			})
+		}
+		return strings.Join(parts, "`")
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range fencedCode.FindAllStringIndex(post.Content, -1) {
+		b.WriteString(convert(post.Content[last:m[0]]))
+		b.WriteString(post.Content[m[0]:m[1]])
+		last = m[1]
+	}
+	b.WriteString(convert(post.Content[last:]))
+	return b.String()
+}
+
+// link converts a wikilink or embed. Links to notes that aren't published
+// are left as their text, as in Obsidian.
+func (v *vaultIndex) link(post *BlogPost, m []string) string {
+	embed, target, heading, text := m[1] == "!", strings.TrimSpace(m[2]), strings.TrimSpace(m[3]), strings.TrimSpace(m[4])
+
+	if embed {
+		if file, ok := v.files[strings.ToLower(target)]; ok {
+			src := v.fileURL(file)
+			switch {
+			case !imageExtensions[strings.ToLower(path.Ext(file))]:
+				return "[" + markdownEscaper.Replace(path.Base(file)) + "](" + src + ")"
+			case isWidth(text):
+				// ![[image.png|300]] and ![[image.png|300x200]] size the image
+				width, height, _ := strings.Cut(text, "x")
+				size := `width="` + width + `"`
+				if height != "" {
+					size += ` height="` + height + `"`
+				}
+				return `<img src="` + src + `" alt="" ` + size + `>`
+			}
+			return "![" + markdownEscaper.Replace(text) + "](" + src + ")"
+		}
+	}
+
+	label := text
+	if label == "" {
+		label = target
+		if heading != "" {
+			label = strings.TrimSpace(label + " > " + heading)
+			if target == "" {
+				label = heading
+			}
+		}
+	}
+	if target == "" {
+		return markdownEscaper.Replace(label)
+	}
+	note, ok := v.notes[strings.ToLower(strings.TrimSuffix(target, ".md"))]
+	if !ok {
+		return markdownEscaper.Replace(label)
+	}
+	return "[" + markdownEscaper.Replace(label) + "](" + languagePrefix(post.Lang) + "/blog/" + note.Slug + ")"
+}
+
+// isWidth reports whether the text of an embed is a size like 300 or
+// 300x200
+func isWidth(text string) bool {
+	width, height, found := strings.Cut(text, "x")
+	if _, err := strconv.Atoi(width); err != nil {
+		return false
+	}
+	_, err := strconv.Atoi(height)
+	return !found || err == nil
+}
+
+// fileURL returns the URL an attachment is served at
+func (v *vaultIndex) fileURL(file string) string {
+	parts := strings.Split(file, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return v.url + "/" + strings.Join(parts, "/")
+}
+
+// handleVaultFile serves an attachment of the vault. Only media files are
+// served, never notes or the files of hidden folders.
+func (s *Server) handleVaultFile(c *fiber.Ctx) error {
+	rel, err := url.PathUnescape(c.Params("*"))
+	if err != nil {
+		return errorResponse(c, 404, "Not found")
+	}
+	file, ok := s.content.VaultFile(rel)
+	if !ok {
+		return errorResponse(c, 404, "Not found")
+	}
+	if !s.cfg.Development() {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=3600")
+	}
+	return c.SendFile(filepath.Join(s.cfg.ContentDir, filepath.FromSlash(file)))
+}
+
+// copyVaultFiles copies the attachments of the vault into the output
+// directory at their URL, skipping those whose hash matches the previous
+// build
+func copyVaultFiles(cfg *Config, dst string, previous, current *buildManifest) (int, error) {
+	copied := 0
+	prefix := strings.Trim(cfg.Obsidian.URL, "/")
+	for key, file := range newVaultIndex(cfg.ContentDir, cfg.Obsidian.URL, nil).files {
+		if key != strings.ToLower(file) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(cfg.ContentDir, filepath.FromSlash(file)))
+		if err != nil {
+			return copied, fmt.Errorf("error copying %s: %v", file, err)
+		}
+		rel := filepath.Join(prefix, filepath.FromSlash(file))
+		sum := sha256.Sum256(data)
+		h := hex.EncodeToString(sum[:])
+		current.Assets[rel] = h
+		if previous.Assets[rel] == h && fileExists(filepath.Join(dst, rel)) {
+			continue
+		}
+		if err := writeOutputFile(dst, rel, data); err != nil {
+			return copied, err
+		}
+		copied++
+	}
+	return copied, nil
+}
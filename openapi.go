@@ -0,0 +1,338 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// openAPIObject is a node of the OpenAPI document
+type openAPIObject = map[string]interface{}
+
+// schemaRef points at a schema under components
+func schemaRef(name string) openAPIObject {
+	return openAPIObject{"$ref": "#/components/schemas/" + name}
+}
+
+// jsonResponse describes a JSON response with the given schema
+func jsonResponse(description string, schema openAPIObject) openAPIObject {
+	return openAPIObject{
+		"description": description,
+		"content":     openAPIObject{"application/json": openAPIObject{"schema": schema}},
+	}
+}
+
+// queryParam describes an optional query string parameter
+func queryParam(name, typ, description string) openAPIObject {
+	return openAPIObject{"name": name, "in": "query", "description": description, "schema": openAPIObject{"type": typ}}
+}
+
+// openAPIDocument describes the content API as an OpenAPI 3 document
+func (s *Server) openAPIDocument(serverURL string) openAPIObject {
+	slugParam := openAPIObject{"name": "slug", "in": "path", "required": true, "schema": openAPIObject{"type": "string"}}
+	errorResponse := jsonResponse("Error", schemaRef("Error"))
+	bearer := func(scope string) []openAPIObject {
+		return []openAPIObject{{"bearerAuth": []string{scope}}}
+	}
+	postList := openAPIObject{
+		"type": "object",
+		"properties": openAPIObject{
+			"posts":  openAPIObject{"type": "array", "items": schemaRef("Post")},
+			"total":  openAPIObject{"type": "integer"},
+			"limit":  openAPIObject{"type": "integer"},
+			"offset": openAPIObject{"type": "integer"},
+		},
+	}
+	postBody := openAPIObject{
+		"required": true,
+		"content":  openAPIObject{"application/json": openAPIObject{"schema": schemaRef("PostInput")}},
+	}
+
+	return openAPIObject{
+		"openapi": "3.0.3",
+		"info": openAPIObject{
+			"title":       s.cfg.Title + " API",
+			"version":     currentBuildInfo().Version,
+			"description": "Read and write the posts and media of a DevDaze blog.",
+		},
+		"servers": []openAPIObject{{"url": serverURL}},
+		"paths": openAPIObject{
+			"/api/posts": openAPIObject{
+				"get": openAPIObject{
+					"operationId": "listPosts",
+					"summary":     "List posts",
+					"description": "Lists published posts. Other statuses need a token with the read scope.",
+					"parameters": []openAPIObject{
+						queryParam("status", "string", "published (default), draft, scheduled or all"),
+						queryParam("tag", "string", "Only posts with this tag"),
+						queryParam("author", "string", "Only posts by this author"),
+						queryParam("q", "string", "Only posts whose title contains this text"),
+						queryParam("limit", "integer", "Page size, 1 to 100 (default 20)"),
+						queryParam("offset", "integer", "Number of posts to skip"),
+					},
+					"responses": openAPIObject{"200": jsonResponse("A page of posts", postList), "400": errorResponse, "401": errorResponse},
+				},
+				"post": openAPIObject{
+					"operationId": "createPost",
+					"summary":     "Create a post",
+					"description": "Creating a published post also needs the publish scope.",
+					"security":    bearer(ScopeWrite),
+					"requestBody": postBody,
+					"responses": openAPIObject{
+						"201": jsonResponse("The created post", schemaRef("Post")),
+						"403": errorResponse, "409": errorResponse, "422": errorResponse,
+					},
+				},
+			},
+			"/api/posts/{slug}": openAPIObject{
+				"parameters": []openAPIObject{slugParam},
+				"get": openAPIObject{
+					"operationId": "getPost",
+					"summary":     "Get a post with its markdown and HTML",
+					"responses":   openAPIObject{"200": jsonResponse("The post", schemaRef("Post")), "404": errorResponse},
+				},
+				"put": openAPIObject{
+					"operationId": "updatePost",
+					"summary":     "Update the fields present in the body",
+					"description": "Changing a published post, or publishing a draft, also needs the publish scope.",
+					"security":    bearer(ScopeWrite),
+					"requestBody": postBody,
+					"responses": openAPIObject{
+						"200": jsonResponse("The updated post", schemaRef("Post")),
+						"403": errorResponse, "404": errorResponse, "422": errorResponse,
+					},
+				},
+				"delete": openAPIObject{
+					"operationId": "deletePost",
+					"summary":     "Move a post to the trash",
+					"description": "Deleting a published post also needs the publish scope.",
+					"security":    bearer(ScopeWrite),
+					"responses":   openAPIObject{"204": openAPIObject{"description": "Deleted"}, "403": errorResponse, "404": errorResponse},
+				},
+			},
+			"/api/posts/{slug}/like": openAPIObject{
+				"parameters": []openAPIObject{slugParam},
+				"post": openAPIObject{
+					"operationId": "likePost",
+					"summary":     "Like a published post",
+					"description": "Anonymous; each client IP counts once per post. Needs likes.enabled.",
+					"responses": openAPIObject{
+						"200": jsonResponse("Already liked from this IP", schemaRef("Likes")),
+						"201": jsonResponse("Liked", schemaRef("Likes")),
+						"404": errorResponse,
+					},
+				},
+			},
+			"/api/popular": openAPIObject{
+				"get": openAPIObject{
+					"operationId": "popularPosts",
+					"summary":     "List the most viewed published posts",
+					"description": "Needs analytics.enabled. Rankings are cached for a minute.",
+					"parameters": []openAPIObject{
+						queryParam("window", "string", "Count views within this window: a duration like 24h, days like 7d, or all (default 30d)"),
+						queryParam("limit", "integer", "Number of posts, 1 to 100 (default 10)"),
+					},
+					"responses": openAPIObject{
+						"200": jsonResponse("Posts, most viewed first", openAPIObject{
+							"type": "object",
+							"properties": openAPIObject{
+								"window": openAPIObject{"type": "string"},
+								"posts":  openAPIObject{"type": "array", "items": schemaRef("PopularPost")},
+							},
+						}),
+						"400": errorResponse, "404": errorResponse,
+					},
+				},
+			},
+			"/api/campaign.rss": openAPIObject{
+				"get": openAPIObject{
+					"operationId": "campaignFeed",
+					"summary":     "RSS feed of the latest posts for email campaign services",
+					"description": "Full content with absolute links and email-friendly images. The token may also be passed as ?token=.",
+					"security":    bearer(ScopeRead),
+					"parameters":  []openAPIObject{queryParam("token", "string", "A token with the read scope, for services that only take a URL")},
+					"responses": openAPIObject{
+						"200": openAPIObject{
+							"description": "RSS 2.0 feed",
+							"content":     openAPIObject{"application/rss+xml": openAPIObject{"schema": openAPIObject{"type": "string"}}},
+						},
+						"401": errorResponse,
+					},
+				},
+			},
+			"/api/search": openAPIObject{
+				"get": openAPIObject{
+					"operationId": "searchPosts",
+					"summary":     "Search published posts",
+					"parameters": []openAPIObject{
+						{"name": "q", "in": "query", "required": true, "description": "Words that must all appear", "schema": openAPIObject{"type": "string"}},
+					},
+					"responses": openAPIObject{
+						"200": jsonResponse("Matching posts, title matches first", openAPIObject{
+							"type": "object",
+							"properties": openAPIObject{
+								"query": openAPIObject{"type": "string"},
+								"posts": openAPIObject{"type": "array", "items": schemaRef("Post")},
+								"total": openAPIObject{"type": "integer"},
+							},
+						}),
+						"400": errorResponse,
+					},
+				},
+			},
+			"/api/tags": openAPIObject{
+				"get": openAPIObject{
+					"operationId": "listTags",
+					"summary":     "List the tags of published posts, most used first",
+					"responses": openAPIObject{
+						"200": jsonResponse("Tags", openAPIObject{
+							"type":       "object",
+							"properties": openAPIObject{"tags": openAPIObject{"type": "array", "items": schemaRef("Tag")}},
+						}),
+					},
+				},
+			},
+			"/api/media": openAPIObject{
+				"get": openAPIObject{
+					"operationId": "listMedia",
+					"summary":     "List uploaded media, newest first",
+					"security":    bearer(ScopeRead),
+					"responses":   openAPIObject{"200": jsonResponse("Media files", mediaList())},
+				},
+				"post": openAPIObject{
+					"operationId": "uploadMedia",
+					"summary":     "Upload one or more files",
+					"security":    bearer(ScopeWrite),
+					"requestBody": openAPIObject{
+						"required": true,
+						"content": openAPIObject{"multipart/form-data": openAPIObject{"schema": openAPIObject{
+							"type": "object",
+							"properties": openAPIObject{"file": openAPIObject{
+								"type": "array", "items": openAPIObject{"type": "string", "format": "binary"},
+							}},
+						}}},
+					},
+					"responses": openAPIObject{"201": jsonResponse("The stored files", mediaList()), "400": errorResponse},
+				},
+			},
+			"/api/whoami": openAPIObject{
+				"get": openAPIObject{
+					"operationId": "whoami",
+					"summary":     "Describe the token used for the request",
+					"security":    bearer(ScopeRead),
+					"responses": openAPIObject{"200": jsonResponse("The token", openAPIObject{
+						"type": "object",
+						"properties": openAPIObject{
+							"name":   openAPIObject{"type": "string"},
+							"scopes": openAPIObject{"type": "array", "items": openAPIObject{"type": "string"}},
+						},
+					})},
+				},
+			},
+		},
+		"components": openAPIObject{
+			"securitySchemes": openAPIObject{
+				"bearerAuth": openAPIObject{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "A token from api.tokens. Scopes: " + strings.Join(apiScopes, ", ") + ".",
+				},
+			},
+			"schemas": openAPIObject{
+				"Post": openAPIObject{
+					"type": "object",
+					"properties": openAPIObject{
+						"title":       openAPIObject{"type": "string"},
+						"slug":        openAPIObject{"type": "string"},
+						"date":        openAPIObject{"type": "string", "format": "date-time"},
+						"author":      openAPIObject{"type": "string"},
+						"description": openAPIObject{"type": "string"},
+						"tags":        openAPIObject{"type": "array", "items": openAPIObject{"type": "string"}},
+						"draft":       openAPIObject{"type": "boolean"},
+						"series":      openAPIObject{"type": "string"},
+						"comments":    openAPIObject{"type": "boolean", "description": "False when the post is closed to comments"},
+						"scheduled":   openAPIObject{"type": "boolean", "description": "Dated in the future and not visible yet"},
+						"url":         openAPIObject{"type": "string"},
+						"content":     openAPIObject{"type": "string", "description": "Markdown body, left out of listings"},
+						"html":        openAPIObject{"type": "string", "description": "Rendered body, left out of listings"},
+					},
+				},
+				"PostInput": openAPIObject{
+					"type":     "object",
+					"required": []string{"title"},
+					"properties": openAPIObject{
+						"title":       openAPIObject{"type": "string"},
+						"slug":        openAPIObject{"type": "string", "description": "Derived from the title when empty; cannot be changed"},
+						"date":        openAPIObject{"type": "string", "format": "date-time", "description": "Defaults to now"},
+						"author":      openAPIObject{"type": "string"},
+						"description": openAPIObject{"type": "string"},
+						"tags":        openAPIObject{"type": "array", "items": openAPIObject{"type": "string"}},
+						"draft":       openAPIObject{"type": "boolean"},
+						"series":      openAPIObject{"type": "string"},
+						"comments":    openAPIObject{"type": "boolean", "description": "Set to false to close the post to comments"},
+						"content":     openAPIObject{"type": "string", "description": "Markdown body"},
+					},
+				},
+				"Tag": openAPIObject{
+					"type": "object",
+					"properties": openAPIObject{
+						"name":  openAPIObject{"type": "string"},
+						"count": openAPIObject{"type": "integer"},
+					},
+				},
+				"PopularPost": openAPIObject{
+					"allOf": []openAPIObject{schemaRef("Post"), {
+						"type": "object",
+						"properties": openAPIObject{
+							"views":    openAPIObject{"type": "integer"},
+							"visitors": openAPIObject{"type": "integer", "description": "Visitors are counted once per day"},
+						},
+					}},
+				},
+				"Likes": openAPIObject{
+					"type": "object",
+					"properties": openAPIObject{
+						"slug":  openAPIObject{"type": "string"},
+						"likes": openAPIObject{"type": "integer"},
+						"new":   openAPIObject{"type": "boolean", "description": "Whether this request added a like"},
+					},
+				},
+				"Media": openAPIObject{
+					"type": "object",
+					"properties": openAPIObject{
+						"name":     openAPIObject{"type": "string"},
+						"url":      openAPIObject{"type": "string"},
+						"size":     openAPIObject{"type": "integer"},
+						"modified": openAPIObject{"type": "string", "format": "date-time"},
+						"image":    openAPIObject{"type": "boolean"},
+						"markdown": openAPIObject{"type": "string", "description": "Snippet that embeds the file in a post"},
+					},
+				},
+				"Error": openAPIObject{
+					"type": "object",
+					"properties": openAPIObject{
+						"error":      openAPIObject{"type": "string"},
+						"request_id": openAPIObject{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// mediaList is the schema of a list of media files
+func mediaList() openAPIObject {
+	return openAPIObject{
+		"type":       "object",
+		"properties": openAPIObject{"media": openAPIObject{"type": "array", "items": schemaRef("Media")}},
+	}
+}
+
+// handleOpenAPI serves the OpenAPI document of the content API
+func (s *Server) handleOpenAPI(c *fiber.Ctx) error {
+	serverURL := s.cfg.BaseURL
+	if serverURL == "" {
+		serverURL = c.BaseURL()
+	}
+	return c.JSON(s.openAPIDocument(serverURL))
+}
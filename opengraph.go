@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/net/html"
+)
+
+// openGraph is the Open Graph data of a post, which link previews on social
+// media are made from
+type openGraph struct {
+	Title       string
+	Description string
+	URL         string
+	// Image is the first image of the post
+	Image string
+	// ImageFile is the image's file when it is in the media directory
+	ImageFile string
+}
+
+// openGraph returns the Open Graph data of post, with absolute URLs
+func (s *Server) openGraph(c *fiber.Ctx, post *BlogPost) openGraph {
+	og := openGraph{
+		Title:       post.Title,
+		Description: postExcerpt(post, 200),
+		URL:         s.absoluteURL(c, postPath(post)),
+	}
+	src := firstImage(post.HTMLContent)
+	if src == "" {
+		return og
+	}
+	og.Image = src
+	if strings.HasPrefix(src, "/") && !strings.HasPrefix(src, "//") {
+		og.Image = s.absoluteURL(c, src)
+		prefix := strings.TrimSuffix(s.cfg.Media.URL, "/") + "/"
+		if rel, ok := strings.CutPrefix(src, prefix); ok {
+			if rel = path.Clean(rel); !strings.HasPrefix(rel, "..") {
+				og.ImageFile = filepath.Join(s.cfg.Media.Dir, filepath.FromSlash(rel))
+			}
+		}
+	}
+	return og
+}
+
+// firstImage returns the src of the first image in a post's HTML
+func firstImage(content string) string {
+	z := html.NewTokenizer(strings.NewReader(content))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "img" || !hasAttr {
+				continue
+			}
+			for {
+				key, val, more := z.TagAttr()
+				if string(key) == "src" {
+					return string(val)
+				}
+				if !more {
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// paginationWindow is how many pages on each side of the current one
+// Paginator.Window links to
+const paginationWindow = 2
+
+// Paginator splits a listing into pages of posts_per_page posts, numbered
+// from 1. Listing templates get it as .Pagination, so that links to the
+// other pages need no arithmetic.
+type Paginator struct {
+	// Page is the current page and Pages how many there are, at least one
+	Page, Pages int
+	// PerPage is how many posts a page lists, and Total across all pages
+	PerPage, Total int
+	// Prev and Next are the URLs of the neighbouring pages, empty on the
+	// first and the last page
+	Prev, Next string
+	// First and Last are the URLs of the first and the last page
+	First, Last string
+	// Window is the pages around the current one, along with the first and
+	// the last, with a gap where pages are left out
+	Window []PageLink
+
+	base string
+}
+
+// PageLink is one page of Paginator.Window
+type PageLink struct {
+	Number  int
+	URL     string
+	Current bool
+	// Gap stands for the pages left out before the next link
+	Gap bool
+}
+
+// newPaginator pages total posts for the listing at base, like /blog. A
+// perPage of 0 or less puts every post on one page.
+func newPaginator(base string, total, perPage, page int) *Paginator {
+	if perPage <= 0 {
+		perPage = max(total, 1)
+	}
+	pages := max((total+perPage-1)/perPage, 1)
+	p := &Paginator{Page: page, Pages: pages, PerPage: perPage, Total: total, base: base}
+	p.First, p.Last = p.URL(1), p.URL(pages)
+	if page > 1 {
+		p.Prev = p.URL(page - 1)
+	}
+	if page < pages {
+		p.Next = p.URL(page + 1)
+	}
+	for n := 1; n <= pages; n++ {
+		if n != 1 && n != pages && (n < page-paginationWindow || n > page+paginationWindow) {
+			if len(p.Window) > 0 && !p.Window[len(p.Window)-1].Gap {
+				p.Window = append(p.Window, PageLink{Gap: true})
+			}
+			continue
+		}
+		p.Window = append(p.Window, PageLink{Number: n, URL: p.URL(n), Current: n == page})
+	}
+	return p
+}
+
+// URL returns the URL of page n of the listing
+func (p *Paginator) URL(n int) string {
+	return pageURL(p.base, n)
+}
+
+// HasPrev reports whether there is a page before the current one
+func (p *Paginator) HasPrev() bool { return p.Page > 1 }
+
+// HasNext reports whether there is a page after the current one
+func (p *Paginator) HasNext() bool { return p.Page < p.Pages }
+
+// Posts returns the posts of the current page out of the whole listing
+func (p *Paginator) Posts(posts []*BlogPost) []*BlogPost {
+	start := min((p.Page-1)*p.PerPage, len(posts))
+	return posts[start:min(start+p.PerPage, len(posts))]
+}
+
+// pageURL returns the URL of page n of the listing at base: base itself for
+// the first page, and base/page/n after it. This is the pageURL template
+// function.
+func pageURL(base string, n int) string {
+	if n <= 1 {
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + "/page/" + strconv.Itoa(n)
+}
+
+// paginationRoutes registers handler for a listing and its later pages
+func (s *Server) paginationRoutes(route string, handler fiber.Handler) {
+	s.app.Get(route, handler)
+	s.app.Get(strings.TrimSuffix(route, "/")+"/page/:page", handler)
+}
+
+// paginate picks the page a listing request asks for out of posts. It
+// returns fiber.ErrNotFound for a page past the last one.
+func (s *Server) paginate(c *fiber.Ctx, base string, posts []*BlogPost) (*Paginator, []*BlogPost, error) {
+	page := 1
+	if param := c.Params("page"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n < 1 {
+			return nil, nil, fiber.ErrNotFound
+		}
+		page = n
+	}
+	p := newPaginator(base, len(posts), s.cfg.PostsPerPage, page)
+	if page > p.Pages {
+		return nil, nil, fiber.ErrNotFound
+	}
+	return p, p.Posts(posts), nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// popularCacheTTL is how long a ranking of popular posts is reused, so
+// pages listing them do not query the view log on every request
+const popularCacheTTL = time.Minute
+
+// popularPost is a post with its views within a window
+type popularPost struct {
+	Post     *BlogPost
+	Views    int
+	Visitors int
+}
+
+// APIPopularPost is a post in the popular posts API
+type APIPopularPost struct {
+	APIPost
+	Views    int `json:"views"`
+	Visitors int `json:"visitors"`
+}
+
+// popularCache keeps recent rankings by window and size
+type popularCache struct {
+	mu      sync.Mutex
+	entries map[string]popularCacheEntry
+}
+
+type popularCacheEntry struct {
+	posts   []popularPost
+	expires time.Time
+}
+
+// viewTotals returns the views and visitors of every post viewed since,
+// all time when t is zero
+func (s *viewStore) viewTotals(since time.Time) (map[string][2]int, error) {
+	rows, err := s.db.Query("SELECT post, COUNT(*), COUNT(DISTINCT visitor) FROM views WHERE post != '' AND viewed_at >= ? GROUP BY post", since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := map[string][2]int{}
+	for rows.Next() {
+		var post string
+		var views, visitors int
+		if err := rows.Scan(&post, &views, &visitors); err != nil {
+			return nil, err
+		}
+		totals[post] = [2]int{views, visitors}
+	}
+	return totals, rows.Err()
+}
+
+// parseViewWindow reads a window like 24h, 7d or all. Zero means all time.
+func parseViewWindow(window string) (time.Duration, error) {
+	if window == "all" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(window, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid window %q", window)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q (want a duration like 24h, a number of days like 7d, or all)", window)
+	}
+	return d, nil
+}
+
+// popularPosts returns the n published posts with the most views within
+// window, most viewed first. Rankings are cached for a minute.
+func (s *Server) popularPosts(window time.Duration, n int) ([]popularPost, error) {
+	if s.views == nil {
+		return nil, nil
+	}
+	key := fmt.Sprintf("%d|%d", window, n)
+	s.popular.mu.Lock()
+	if e, ok := s.popular.entries[key]; ok && time.Now().Before(e.expires) {
+		s.popular.mu.Unlock()
+		return e.posts, nil
+	}
+	s.popular.mu.Unlock()
+
+	var since time.Time
+	if window > 0 {
+		since = time.Now().Add(-window)
+	}
+	totals, err := s.views.viewTotals(since)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := s.content.Posts()
+	if err != nil {
+		return nil, err
+	}
+
+	var ranked []popularPost
+	for _, post := range posts {
+		if t, ok := totals[post.Slug]; ok && apiVisible(post) {
+			ranked = append(ranked, popularPost{Post: post, Views: t[0], Visitors: t[1]})
+		}
+	}
+	// Ties go to the newer post
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Views != ranked[j].Views {
+			return ranked[i].Views > ranked[j].Views
+		}
+		return ranked[i].Post.Date.After(ranked[j].Post.Date)
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+
+	s.popular.mu.Lock()
+	if s.popular.entries == nil {
+		s.popular.entries = map[string]popularCacheEntry{}
+	}
+	s.popular.entries[key] = popularCacheEntry{posts: ranked, expires: time.Now().Add(popularCacheTTL)}
+	s.popular.mu.Unlock()
+	return ranked, nil
+}
+
+// templatePopularPosts is the popularPosts template function: it takes a
+// window like "7d" and the number of posts, and lists nothing when analytics
+// are disabled
+func (s *Server) templatePopularPosts(window string, n int) ([]popularPost, error) {
+	d, err := parseViewWindow(window)
+	if err != nil {
+		return nil, err
+	}
+	return s.popularPosts(d, n)
+}
+
+// handleAPIPopular lists the most viewed published posts within a window
+func (s *Server) handleAPIPopular(c *fiber.Ctx) error {
+	if s.views == nil {
+		return apiError(c, fiber.StatusNotFound, "analytics are disabled")
+	}
+	window := c.Query("window", "30d")
+	d, err := parseViewWindow(window)
+	if err != nil {
+		return apiError(c, fiber.StatusBadRequest, "%v", err)
+	}
+	limit := c.QueryInt("limit", 10)
+	if limit < 1 || limit > 100 {
+		return apiError(c, fiber.StatusBadRequest, "limit must be between 1 and 100")
+	}
+
+	ranked, err := s.popularPosts(d, limit)
+	if err != nil {
+		return s.internalError(c, "Error loading page views", err)
+	}
+	posts := make([]APIPopularPost, 0, len(ranked))
+	for _, p := range ranked {
+		posts = append(posts, APIPopularPost{APIPost: apiPost(p.Post, false), Views: p.Views, Visitors: p.Visitors})
+	}
+	return c.JSON(fiber.Map{"window": window, "posts": posts})
+}
@@ -0,0 +1,61 @@
+package main
+
+import "strings"
+
+// referrerSpamDomains are well known referrer spam senders, which fake visits
+// so their domain shows up in analytics reports
+var referrerSpamDomains = []string{
+	"7makemoneyonline.com",
+	"best-seo-offer.com",
+	"best-seo-solution.com",
+	"blackhatworth.com",
+	"buttons-for-website.com",
+	"buttons-for-your-website.com",
+	"darodar.com",
+	"econom.co",
+	"free-share-buttons.com",
+	"get-free-traffic-now.com",
+	"hulfingtonpost.com",
+	"ilovevitaly.com",
+	"priceg.com",
+	"semalt.com",
+	"simple-share-buttons.com",
+	"social-buttons.com",
+	"trafficmonetize.com",
+	"webmonetizer.net",
+}
+
+// isReferrerSpam reports whether a referrer host is a known spam domain or
+// one in analytics.ignore_referrers, including their subdomains
+func (s *Server) isReferrerSpam(host string) bool {
+	if host == "" {
+		return false
+	}
+	for _, lists := range [][]string{referrerSpamDomains, s.cfg.Analytics.IgnoreReferrers} {
+		for _, domain := range lists {
+			domain = strings.TrimPrefix(strings.ToLower(domain), "www.")
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// referrerHostOf returns the host of a logged referrer
+func referrerHostOf(referrer string) string {
+	host, _, _ := strings.Cut(referrer, "/")
+	return host
+}
+
+// withoutReferrerSpam drops spam hosts from a referrer ranking, which may
+// have been logged before they were ignored, and keeps the first n
+func (s *Server) withoutReferrerSpam(referrers []referrerCount, n int) []referrerCount {
+	kept := referrers[:0]
+	for _, r := range referrers {
+		if !s.isReferrerSpam(r.Host) && len(kept) < n {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// siteSet holds the sites currently serving requests. Reloading builds a
+// complete new set from a fresh config and swaps it in atomically, so the
+// listener and open connections are untouched while new requests see the
+// new config and content.
+type siteSet struct {
+	load     func() (*Config, error)
+	reporter ErrorReporter
+
+	mu      sync.Mutex // serializes reloads
+	current atomic.Pointer[siteRouter]
+	cancel  context.CancelFunc
+}
+
+// newSiteSet builds the initial set of sites from cfg
+func newSiteSet(cfg *Config, load func() (*Config, error), reporter ErrorReporter) (*siteSet, error) {
+	set := &siteSet{load: load, reporter: reporter}
+
+	router, err := newSiteRouter(newServer(cfg, reporter), reporter)
+	if err != nil {
+		return nil, err
+	}
+	set.activate(router)
+	return set, nil
+}
+
+// activate swaps in router and moves the file watchers and schedulers
+// over to it
+func (set *siteSet) activate(router *siteRouter) {
+	ctx, cancel := context.WithCancel(context.Background())
+	router.startLiveReload(ctx)
+	router.startScheduler(ctx)
+	router.startAnalytics(ctx)
+	router.startNewsletter(ctx)
+	router.startNotionSync(ctx)
+	router.startSourceSync(ctx)
+
+	set.current.Store(router)
+	if set.cancel != nil {
+		set.cancel()
+	}
+	set.cancel = cancel
+}
+
+// Close stops the background watchers and schedulers
+func (set *siteSet) Close() {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if set.cancel != nil {
+		set.cancel()
+	}
+}
+
+// Config returns the main site's current config
+func (set *siteSet) Config() *Config {
+	return set.current.Load().main.cfg
+}
+
+// Reload re-reads the config file and re-indexes every site's content. If
+// the new config or any site fails to load, the running sites are kept.
+func (set *siteSet) Reload() error {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	cfg, err := set.load()
+	if err != nil {
+		return fmt.Errorf("error loading config: %v", err)
+	}
+
+	old := set.current.Load().main.cfg
+	if cfg.Listen != old.Listen || cfg.Port != old.Port ||
+		!reflect.DeepEqual(cfg.TLS, old.TLS) || cfg.ErrorReporting != old.ErrorReporting {
+		slog.Warn("Listener, TLS and error reporting changes take effect after a restart")
+	}
+
+	router, err := newSiteRouter(newServer(cfg, set.reporter), set.reporter)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range append([]*Server{router.main}, router.sites...) {
+		if loaded, _, err := s.content.Status(); !loaded {
+			return fmt.Errorf("error loading content from %s: %v", s.cfg.ContentDir, err)
+		}
+		if s.templatesErr != nil {
+			return fmt.Errorf("error compiling templates in %s: %v", s.cfg.TemplateDir, s.templatesErr)
+		}
+	}
+
+	set.activate(router)
+	slog.Info("Reloaded config and content", "sites", 1+len(router.sites))
+	return nil
+}
+
+// app returns the front app that hands each request to the current sites
+func (set *siteSet) app() *fiber.App {
+	front := fiber.New()
+	front.Use(func(c *fiber.Ctx) error {
+		set.current.Load().serverFor(c.Hostname()).app.Handler()(c.Context())
+		return nil
+	})
+	return front
+}
+
+// reloadOnSignal reloads the sites every time the process receives SIGHUP
+func (set *siteSet) reloadOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	go func() {
+		for range ch {
+			slog.Info("Received SIGHUP, reloading")
+			if err := set.Reload(); err != nil {
+				slog.Error("Reload failed, keeping the running config", "error", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+// ErrorReportingConfig enables forwarding errors to an external service
+type ErrorReportingConfig struct {
+	// SentryDSN turns on Sentry reporting when set
+	SentryDSN   string  `yaml:"sentry_dsn"`
+	Environment string  `yaml:"environment"`
+	SampleRate  float64 `yaml:"sample_rate"`
+}
+
+// ErrorReporter receives panics and handler errors along with the request
+// they happened in. Implementations must be safe for concurrent use.
+type ErrorReporter interface {
+	Report(c *fiber.Ctx, err error)
+	Flush(timeout time.Duration)
+}
+
+// nopReporter is used when error reporting is not configured
+type nopReporter struct{}
+
+func (nopReporter) Report(c *fiber.Ctx, err error) {}
+func (nopReporter) Flush(timeout time.Duration)    {}
+
+// newErrorReporter returns the reporter selected by the config
+func newErrorReporter(cfg *Config) (ErrorReporter, error) {
+	rc := cfg.ErrorReporting
+	if rc.SentryDSN == "" {
+		return nopReporter{}, nil
+	}
+
+	env := rc.Environment
+	if env == "" {
+		env = cfg.Env
+	}
+
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         rc.SentryDSN,
+		Environment: env,
+		SampleRate:  rc.SampleRate,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error initializing Sentry: %v", err)
+	}
+
+	slog.Info("Error reporting enabled", "provider", "sentry", "environment", env)
+	return sentryReporter{}, nil
+}
+
+// sentryReporter sends errors to Sentry
+type sentryReporter struct{}
+
+func (sentryReporter) Report(c *fiber.Ctx, err error) {
+	hub := sentry.CurrentHub().Clone()
+	hub.ConfigureScope(func(scope *sentry.Scope) {
+		var req http.Request
+		if convErr := fasthttpadaptor.ConvertRequest(c.Context(), &req, true); convErr == nil {
+			scope.SetRequest(&req)
+		}
+		scope.SetTag("request_id", requestID(c))
+		scope.SetTag("route", c.Route().Path)
+	})
+	hub.CaptureException(err)
+}
+
+func (sentryReporter) Flush(timeout time.Duration) {
+	sentry.Flush(timeout)
+}
+
+// panicError wraps a recovered panic value so it can be reported as an error
+type panicError struct {
+	value interface{}
+}
+
+func (e panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// recoverMiddleware turns panics in later handlers into reported 500 errors
+// instead of letting them take down the connection. The panic is logged with
+// its stack trace, and the visitor gets the themed error page.
+func (s *Server) recoverMiddleware(c *fiber.Ctx) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			perr := panicError{value: r}
+			requestLogger(c).Error("Recovered from panic", "error", perr, "stack", string(debug.Stack()))
+			s.reporter.Report(c, perr)
+			c.Response().ResetBody()
+			detail := ""
+			if s.cfg.Development() {
+				detail = perr.Error()
+			}
+			err = s.errorPage(c, fiber.StatusInternalServerError, "", detail)
+		}
+	}()
+	return c.Next()
+}
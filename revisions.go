@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// revisionsDir holds earlier versions of posts inside the content
+// directory, one directory per file named after its relative path
+const revisionsDir = ".revisions"
+
+// RevisionsConfig controls the snapshots kept of every saved post
+type RevisionsConfig struct {
+	// Keep is how many revisions are kept per file; 0 keeps them all
+	Keep int `yaml:"keep"`
+}
+
+// Revision is a saved version of a post
+type Revision struct {
+	// ID identifies the revision in URLs, the time it was saved
+	ID      string
+	SavedAt time.Time
+	Size    int
+}
+
+// DiffLine is one line of a diff: Op is "+" for an added line, "-" for a
+// removed one and " " for an unchanged one
+type DiffLine struct {
+	Op   string
+	Text string
+}
+
+// revisionPath returns the directory holding the revisions of the file at
+// path
+func revisionPath(contentDir, path string) (string, error) {
+	rel, err := filepath.Rel(contentDir, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(contentDir, revisionsDir, rel), nil
+}
+
+// listRevisions returns the revisions of the file at path, newest first
+func listRevisions(contentDir, path string) ([]Revision, error) {
+	dir, err := revisionPath(contentDir, path)
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []Revision
+	for _, f := range files {
+		id := strings.TrimSuffix(f.Name(), ".md")
+		savedAt, err := time.Parse(trashStamp, id)
+		if f.IsDir() || err != nil {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		revisions = append(revisions, Revision{ID: id, SavedAt: savedAt, Size: int(info.Size())})
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].SavedAt.After(revisions[j].SavedAt) })
+	return revisions, nil
+}
+
+// readRevision returns the content of a revision of the file at path
+func readRevision(contentDir, path, id string) ([]byte, error) {
+	if _, err := time.Parse(trashStamp, id); err != nil {
+		return nil, fmt.Errorf("%q is not a revision", id)
+	}
+	dir, err := revisionPath(contentDir, path)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(dir, id+".md"))
+}
+
+// snapshotRevision stores the current content of the file at path as a
+// revision dated at its modification time, unless it matches the latest
+// revision. Only the newest keep revisions are kept.
+func snapshotRevision(contentDir, path string, keep int) error {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := listRevisions(contentDir, path)
+	if err != nil {
+		return err
+	}
+	if len(revisions) > 0 {
+		if latest, err := readRevision(contentDir, path, revisions[0].ID); err == nil && bytes.Equal(latest, content) {
+			return nil
+		}
+	}
+
+	dir, err := revisionPath(contentDir, path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Keep revisions out of git history, which has its own
+	root := filepath.Join(contentDir, revisionsDir)
+	if err := os.WriteFile(filepath.Join(root, ".gitignore"), []byte("*\n"), 0644); err != nil {
+		return err
+	}
+	id := info.ModTime().UTC().Format(trashStamp)
+	if err := os.WriteFile(filepath.Join(dir, id+".md"), content, 0644); err != nil {
+		return err
+	}
+
+	if keep <= 0 {
+		return nil
+	}
+	revisions, err = listRevisions(contentDir, path)
+	if err != nil {
+		return err
+	}
+	for _, old := range revisions[min(keep, len(revisions)):] {
+		if err := os.Remove(filepath.Join(dir, old.ID+".md")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revise runs write, which changes the file at path, between two snapshots:
+// the first keeps a version edited outside DevDaze, the second the new one.
+// Snapshot failures are only logged, they never hold up the edit.
+func (s *Server) revise(path string, write func() error) error {
+	s.snapshot(path)
+	if err := write(); err != nil {
+		return err
+	}
+	s.snapshot(path)
+	return nil
+}
+
+// snapshot runs snapshotRevision with the configured limit, logging failures
+func (s *Server) snapshot(path string) {
+	if err := snapshotRevision(s.cfg.ContentDir, path, s.cfg.Revisions.Keep); err != nil {
+		slog.Error("Error saving revision", "path", path, "error", err)
+	}
+}
+
+// diffLines compares two texts line by line, following the longest common
+// subsequence of their lines
+func diffLines(a, b string) []DiffLine {
+	x := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	y := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff []DiffLine
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			diff = append(diff, DiffLine{Op: " ", Text: x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{Op: "-", Text: x[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Op: "+", Text: y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		diff = append(diff, DiffLine{Op: "-", Text: x[i]})
+	}
+	for ; j < len(y); j++ {
+		diff = append(diff, DiffLine{Op: "+", Text: y[j]})
+	}
+	return diff
+}
+
+// handleRevisions lists the revisions of a file, or with ?rev= shows what
+// restoring that revision would change
+func (s *Server) handleRevisions(c *fiber.Ctx) error {
+	rel := c.Params("*")
+	path, err := resolveContentPath(s.cfg.ContentDir, rel)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if err := s.checkEditable(c, path); err != nil {
+		return err
+	}
+
+	revisions, err := listRevisions(s.cfg.ContentDir, path)
+	if err != nil {
+		return s.internalError(c, "Error listing revisions", err)
+	}
+
+	data := fiber.Map{
+		"Title":     "History of " + rel,
+		"Path":      rel,
+		"Exists":    fileExists(path),
+		"Revisions": revisions,
+	}
+	if id := c.Query("rev"); id != "" {
+		old, err := readRevision(s.cfg.ContentDir, path, id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "No revision "+id)
+		}
+		current, _ := os.ReadFile(path)
+		savedAt, _ := time.Parse(trashStamp, id)
+		data["Revision"] = Revision{ID: id, SavedAt: savedAt, Size: len(old)}
+		data["Diff"] = diffLines(string(current), string(old))
+	}
+	return c.Render("admin_revisions", data)
+}
+
+// handleRevisionRestore writes a revision back over the file. The file's
+// current content stays in the history, so a restore can be undone.
+func (s *Server) handleRevisionRestore(c *fiber.Ctx) error {
+	rel := c.Params("*")
+	path, err := resolveContentPath(s.cfg.ContentDir, rel)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if err := s.checkEditable(c, path); err != nil {
+		return err
+	}
+
+	id := c.FormValue("rev")
+	content, err := readRevision(s.cfg.ContentDir, path, id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "No revision "+id)
+	}
+	post, err := parseMarkdownFile(content, s.cfg.Location())
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "Not restored: "+err.Error())
+	}
+	if user := currentUser(c); !user.canEdit(post) {
+		return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("Not restored: authors can only save drafts with author %q", user.Author))
+	}
+
+	before := s.findPostFile(path)
+	err = s.revise(path, func() error {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(path, content, 0644)
+	})
+	if err != nil {
+		return s.internalError(c, "Error restoring revision", err)
+	}
+	if err := s.content.Load(); err != nil {
+		return s.internalError(c, "Restored, but re-indexing failed", err)
+	}
+
+	savedAt, _ := time.Parse(trashStamp, id)
+	requestLogger(c).Info("Restored revision", "path", rel, "revision", id)
+	s.recordEdit(c, "Restore %s\n\nRestored the revision saved at %s.", rel, savedAt.Format(time.RFC3339))
+	s.postChanged(before, s.findPostFile(path))
+	notice := "Restored the revision of " + savedAt.In(s.cfg.Location()).Format("Jan 2, 2006 15:04:05")
+	return c.Redirect("/admin/editor/"+rel+"?notice="+url.QueryEscape(notice), fiber.StatusSeeOther)
+}
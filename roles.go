@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Admin area roles
+const (
+	// RoleAdmin can do everything, including publishing and purging caches
+	RoleAdmin = "admin"
+	// RoleAuthor can only create and edit their own drafts
+	RoleAuthor = "author"
+)
+
+// AdminUser grants an account access to the admin area
+type AdminUser struct {
+	// Account is the basic auth username, or for accounts signing in with
+	// OAuth the provider and the username or email, like github:octocat or
+	// google:jane@example.com
+	Account string `yaml:"account"`
+	// Password lets the account sign in with basic auth; OAuth accounts
+	// leave it empty
+	Password string `yaml:"password"`
+	// Role is admin or author, defaulting to author
+	Role string `yaml:"role"`
+	// Author is the frontmatter author of the user's posts, defaulting to
+	// the account
+	Author string `yaml:"author"`
+}
+
+// IsAdmin reports whether the user has the admin role
+func (u AdminUser) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// basicUser looks up the role of an account signed in with basic auth. The
+// admin login is an admin; other accounts need an entry in admin.users with
+// a password.
+func (s *Server) basicUser(account string) (AdminUser, bool) {
+	if account == "" {
+		return AdminUser{}, false
+	}
+	if account == s.cfg.Admin.Username {
+		return AdminUser{Account: account, Role: RoleAdmin, Author: account}, true
+	}
+	for _, u := range s.cfg.Admin.Users {
+		if u.Password != "" && u.Account == account {
+			return u.withDefaults(account), true
+		}
+	}
+	return AdminUser{}, false
+}
+
+// oauthUser looks up the first of the identities a provider returned for an
+// account that may sign in. admin.users entries without a password match by
+// provider and identity, like github:octocat, and the oauth.allow entries
+// that aren't already users are admins. The account is qualified with the
+// provider, so it can't be mistaken for a basic auth user.
+func (s *Server) oauthUser(provider string, identities []string) (AdminUser, bool) {
+	for _, id := range identities {
+		if id == "" {
+			continue
+		}
+		account := provider + ":" + id
+		for _, u := range s.cfg.Admin.Users {
+			if u.Password == "" && strings.EqualFold(u.Account, account) {
+				return u.withDefaults(id), true
+			}
+		}
+		for _, allowed := range s.cfg.Admin.OAuth.Allow {
+			allowed = strings.TrimSpace(allowed)
+			if strings.EqualFold(allowed, id) || strings.EqualFold(allowed, account) {
+				return AdminUser{Account: account, Role: RoleAdmin, Author: id}, true
+			}
+		}
+	}
+	return AdminUser{}, false
+}
+
+// sessionUser looks up the user of an OAuth session account, as stored by
+// oauthUser
+func (s *Server) sessionUser(account string) (AdminUser, bool) {
+	provider, id, ok := strings.Cut(account, ":")
+	if !ok {
+		return AdminUser{}, false
+	}
+	return s.oauthUser(provider, []string{id})
+}
+
+// withDefaults fills in the role and the author a user entry leaves out.
+// author is the account without its provider.
+func (u AdminUser) withDefaults(author string) AdminUser {
+	if u.Role == "" {
+		u.Role = RoleAuthor
+	}
+	if u.Author == "" {
+		u.Author = author
+	}
+	return u
+}
+
+// basicUsers returns the accounts that can sign in with basic auth
+func (s *Server) basicUsers() map[string]string {
+	users := map[string]string{s.cfg.Admin.Username: s.cfg.Admin.Password}
+	for _, u := range s.cfg.Admin.Users {
+		if u.Password != "" {
+			users[u.Account] = u.Password
+		}
+	}
+	return users
+}
+
+// currentUser returns the signed-in admin user of a request
+func currentUser(c *fiber.Ctx) AdminUser {
+	user, _ := c.Locals("adminUser").(AdminUser)
+	return user
+}
+
+// requireRole only lets users with the given role through
+func requireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if currentUser(c).Role != role {
+			return fiber.NewError(fiber.StatusForbidden, "This action needs the "+role+" role")
+		}
+		return c.Next()
+	}
+}
+
+// canEdit reports whether the user may change a post. Authors may only edit
+// their own drafts; a nil post is a file that does not exist yet.
+func (u AdminUser) canEdit(post *BlogPost) bool {
+	if u.IsAdmin() || post == nil {
+		return true
+	}
+	return post.Draft && strings.EqualFold(post.Author, u.Author)
+}
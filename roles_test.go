@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestAdminUserCanEdit(t *testing.T) {
+	admin := AdminUser{Account: "admin", Role: RoleAdmin, Author: "Admin"}
+	author := AdminUser{Account: "github:jane", Role: RoleAuthor, Author: "Jane"}
+	tests := []struct {
+		name string
+		user AdminUser
+		post *BlogPost
+		want bool
+	}{
+		{"admin, published post", admin, &BlogPost{Author: "Jane"}, true},
+		{"admin, someone's draft", admin, &BlogPost{Author: "Jane", Draft: true}, true},
+		{"new file", author, nil, true},
+		{"own draft", author, &BlogPost{Author: "Jane", Draft: true}, true},
+		{"own draft, other case", author, &BlogPost{Author: "jane", Draft: true}, true},
+		{"own published post", author, &BlogPost{Author: "Jane"}, false},
+		{"someone else's draft", author, &BlogPost{Author: "Joe", Draft: true}, false},
+		{"draft without an author", author, &BlogPost{Draft: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.user.canEdit(tt.post); got != tt.want {
+				t.Errorf("canEdit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// SchedulerConfig controls the background worker that publishes scheduled
+// posts
+type SchedulerConfig struct {
+	// Interval between checks for due posts, a minute by default
+	Interval time.Duration `yaml:"interval"`
+	// Hooks are notified of every post that goes live, changes or is
+	// deleted
+	Hooks []PublishHook `yaml:"hooks"`
+}
+
+// Events publish hooks can subscribe to
+const (
+	EventPublish = "publish"
+	EventUpdate  = "update"
+	EventDelete  = "delete"
+)
+
+// PublishHook receives a JSON POST for each event of a published post, for
+// example to announce it on social media or purge a CDN
+type PublishHook struct {
+	URL string `yaml:"url"`
+	// Secret signs the body in the X-DevDaze-Signature header as
+	// sha256=<hex HMAC>, like GitHub webhooks
+	Secret string `yaml:"secret"`
+	// Events are the events the hook is sent, publish only by default
+	Events []string `yaml:"events"`
+}
+
+// Wants reports whether the hook subscribed to event
+func (h PublishHook) Wants(event string) bool {
+	if len(h.Events) == 0 {
+		return event == EventPublish
+	}
+	return slices.Contains(h.Events, event)
+}
+
+func (h PublishHook) validate() error {
+	if !absoluteHTTPURL(h.URL) {
+		return fmt.Errorf("scheduler.hooks: url %q is not an absolute http or https URL", h.URL)
+	}
+	for _, event := range h.Events {
+		if event != EventPublish && event != EventUpdate && event != EventDelete {
+			return fmt.Errorf("scheduler.hooks: unknown event %q for %s, expected publish, update or delete", event, h.URL)
+		}
+	}
+	return nil
+}
+
+// PublishEvent is the body sent to publish hooks
+type PublishEvent struct {
+	Event string    `json:"event"`
+	Site  string    `json:"site"`
+	URL   string    `json:"url"`
+	Time  time.Time `json:"time"`
+	Post  APIPost   `json:"post"`
+}
+
+// startScheduler runs the publish worker of every site until ctx is done
+func (r *siteRouter) startScheduler(ctx context.Context) {
+	for _, s := range append([]*Server{r.main}, r.sites...) {
+		go s.runScheduler(ctx)
+	}
+}
+
+// runScheduler checks for due posts every interval. Posts dated in the
+// future go live on their own once the index notices, drafts with a
+// publish_at time are rewritten as published; either way the publish hooks
+// fire once the post is visible.
+func (s *Server) runScheduler(ctx context.Context) {
+	interval := s.cfg.Scheduler.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	pending := s.pendingPosts()
+	s.purgeExpiredTrash()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pending = s.publishDue(pending)
+			s.purgeExpiredTrash()
+		}
+	}
+}
+
+// pendingPosts returns the slugs of posts waiting for their publish time
+func (s *Server) pendingPosts() map[string]bool {
+	posts, err := s.content.AllPosts()
+	if err != nil {
+		return nil
+	}
+	pending := map[string]bool{}
+	for _, post := range posts {
+		if post.Scheduled() || (post.Draft && !post.PublishAt.IsZero()) {
+			pending[post.Slug] = true
+		}
+	}
+	return pending
+}
+
+// publishDue publishes the drafts whose publish_at has passed, reports
+// every previously pending post that is now live and returns the posts
+// still pending
+func (s *Server) publishDue(pending map[string]bool) map[string]bool {
+	posts, err := s.content.AllPosts()
+	if err != nil {
+		slog.Error("Scheduler could not load posts", "dir", s.cfg.ContentDir, "error", err)
+		return pending
+	}
+
+	now := time.Now()
+	rewritten := false
+	for _, post := range posts {
+		if !post.Draft || post.PublishAt.IsZero() || post.PublishAt.After(now) {
+			continue
+		}
+		if err := s.revise(post.FilePath, func() error { return publishAt(post) }); err != nil {
+			slog.Error("Error publishing scheduled post", "slug", post.Slug, "error", err)
+			continue
+		}
+		rewritten = true
+		if s.repo != nil {
+			if err := s.repo.Commit("scheduler", fmt.Sprintf("Publish %s\n\nPublished on schedule.", post.Slug)); err != nil {
+				slog.Error("Error committing content change", "slug", post.Slug, "error", err)
+			}
+		}
+	}
+	if rewritten {
+		if err := s.content.Load(); err != nil {
+			slog.Error("Error re-indexing content", "dir", s.cfg.ContentDir, "error", err)
+			return pending
+		}
+	}
+
+	for slug := range pending {
+		if post := s.findPost(slug); post != nil && apiVisible(post) {
+			slog.Info("Scheduled post is live", "slug", slug)
+			s.firePublishHooks(post)
+		}
+	}
+	return s.pendingPosts()
+}
+
+// publishAt turns a draft with a publish_at time into a post dated at that
+// time
+func publishAt(post *BlogPost) error {
+	if err := setFrontmatterField(post.FilePath, "date", post.PublishAt.Format(time.RFC3339)); err != nil {
+		return err
+	}
+	if err := setFrontmatterField(post.FilePath, "draft", "false"); err != nil {
+		return err
+	}
+	return deleteFrontmatterField(post.FilePath, "publish_at")
+}
+
+// firePublishHooks notifies every publish hook, the fediverse followers and
+// the newsletter subscribers of post, and cross-posts it, in the background
+func (s *Server) firePublishHooks(post *BlogPost) {
+	s.deliverPost(post)
+	s.queueNewsletter(post)
+	s.syndicate(post)
+	s.fireHooks(EventPublish, post)
+}
+
+// postChanged fires the hooks of an edit that turned before into after,
+// either of which is nil when the post didn't exist: publish when the post
+// went live, update when a live post changed and delete when it was deleted
+// or made a draft again. Posts that were waiting for their publish time are
+// announced by the scheduler once it notices.
+func (s *Server) postChanged(before, after *BlogPost) {
+	wasLive := before != nil && apiVisible(before)
+	isLive := after != nil && apiVisible(after)
+	switch {
+	case isLive && !wasLive:
+		if before != nil && (before.Scheduled() || (before.Draft && !before.PublishAt.IsZero())) {
+			return
+		}
+		s.firePublishHooks(after)
+	case isLive:
+		s.fireHooks(EventUpdate, after)
+	case wasLive:
+		s.fireHooks(EventDelete, before)
+	}
+}
+
+// fireHooks sends event for post to every hook that subscribed to it in the
+// background
+func (s *Server) fireHooks(event string, post *BlogPost) {
+	var hooks []PublishHook
+	for _, hook := range s.cfg.Scheduler.Hooks {
+		if hook.Wants(event) {
+			hooks = append(hooks, hook)
+		}
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(PublishEvent{
+		Event: event,
+		Site:  s.cfg.Title,
+		URL:   s.absoluteURL(nil, "/blog/"+post.Slug),
+		Time:  time.Now().UTC().Truncate(time.Second),
+		Post:  apiPost(post, false),
+	})
+	if err != nil {
+		slog.Error("Error encoding publish event", "event", event, "slug", post.Slug, "error", err)
+		return
+	}
+
+	for _, hook := range hooks {
+		go func(hook PublishHook) {
+			if err := sendPublishHook(hook, event, body); err != nil {
+				slog.Warn("Publish hook failed", "url", hook.URL, "event", event, "slug", post.Slug, "error", err)
+			}
+		}(hook)
+	}
+}
+
+// sendPublishHook posts body to a hook, signing it when a secret is set
+func sendPublishHook(hook PublishHook, event string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "DevDaze/"+currentBuildInfo().Version)
+	req.Header.Set("X-DevDaze-Event", event)
+	if hook.Secret != "" {
+		req.Header.Set("X-DevDaze-Signature", signBody(hook.Secret, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", hook.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// cspNoncePlaceholder stands for the nonce of the response in
+// security_headers.content_security_policy
+const cspNoncePlaceholder = "{nonce}"
+
+// defaultContentSecurityPolicy only lets scripts run that the server marked
+// with the response's nonce, and the scripts those load, as third-party
+// embeds and analytics do. Browsers without 'strict-dynamic' fall back to
+// scripts from the site and over HTTPS.
+const defaultContentSecurityPolicy = "script-src 'nonce-{nonce}' 'strict-dynamic' 'self' https:; object-src 'none'; base-uri 'self'"
+
+// SecurityHeadersConfig sets the security headers of every response. A
+// header set to "" is left out.
+type SecurityHeadersConfig struct {
+	// ContentSecurityPolicy is the Content-Security-Policy header, with
+	// {nonce} replaced by a new nonce for every response
+	ContentSecurityPolicy string `yaml:"content_security_policy"`
+	// HSTSMaxAge is how long browsers only visit the site over HTTPS once
+	// they have over it, 0 to send no Strict-Transport-Security. It is only
+	// sent on HTTPS requests.
+	HSTSMaxAge            time.Duration `yaml:"hsts_max_age"`
+	HSTSIncludeSubdomains bool          `yaml:"hsts_include_subdomains"`
+	HSTSPreload           bool          `yaml:"hsts_preload"`
+	ContentTypeOptions    string        `yaml:"content_type_options"`
+	ReferrerPolicy        string        `yaml:"referrer_policy"`
+	FrameOptions          string        `yaml:"frame_options"`
+}
+
+func (c SecurityHeadersConfig) validate() error {
+	if c.HSTSMaxAge < 0 {
+		return fmt.Errorf("security_headers.hsts_max_age must not be negative")
+	}
+	if c.HSTSPreload && (c.HSTSMaxAge < 365*24*time.Hour || !c.HSTSIncludeSubdomains) {
+		return fmt.Errorf("security_headers.hsts_preload needs hsts_include_subdomains and an hsts_max_age of at least 8760h")
+	}
+	switch strings.ToUpper(c.FrameOptions) {
+	case "", "DENY", "SAMEORIGIN":
+	default:
+		return fmt.Errorf("unknown security_headers.frame_options %q (want DENY or SAMEORIGIN)", c.FrameOptions)
+	}
+	return nil
+}
+
+// strictTransportSecurity returns the Strict-Transport-Security header, or
+// "" when HSTS is off
+func (c SecurityHeadersConfig) strictTransportSecurity() string {
+	if c.HSTSMaxAge <= 0 {
+		return ""
+	}
+	value := "max-age=" + strconv.FormatInt(int64(c.HSTSMaxAge/time.Second), 10)
+	if c.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if c.HSTSPreload {
+		value += "; preload"
+	}
+	return value
+}
+
+// securityHeadersMiddleware sets the configured security headers. When the
+// policy uses a nonce, templates get it as .CSPNonce for their inline
+// scripts. The headers are set once the response is ready, as the static
+// file handlers drop those set earlier when they find no file.
+func (s *Server) securityHeadersMiddleware(c *fiber.Ctx) error {
+	cfg := s.cfg.SecurityHeaders
+	policy := cfg.ContentSecurityPolicy
+	if strings.Contains(policy, cspNoncePlaceholder) {
+		nonce, err := newCSPNonce()
+		if err != nil {
+			return s.internalError(c, "Error generating CSP nonce", err)
+		}
+		c.Locals("CSPNonce", nonce)
+		policy = strings.ReplaceAll(policy, cspNoncePlaceholder, nonce)
+	}
+
+	err := c.Next()
+
+	if policy != "" {
+		c.Set(fiber.HeaderContentSecurityPolicy, policy)
+	}
+	if hsts := cfg.strictTransportSecurity(); hsts != "" && c.Protocol() == "https" {
+		c.Set(fiber.HeaderStrictTransportSecurity, hsts)
+	}
+	if cfg.ContentTypeOptions != "" {
+		c.Set(fiber.HeaderXContentTypeOptions, cfg.ContentTypeOptions)
+	}
+	if cfg.ReferrerPolicy != "" {
+		c.Set(fiber.HeaderReferrerPolicy, cfg.ReferrerPolicy)
+	}
+	if cfg.FrameOptions != "" {
+		c.Set(fiber.HeaderXFrameOptions, cfg.FrameOptions)
+	}
+	return err
+}
+
+// newCSPNonce returns a random nonce for one response
+func newCSPNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// cspNonce returns the nonce of the current response, or "" when the policy
+// uses none
+func cspNonce(c *fiber.Ctx) string {
+	nonce, _ := c.Locals("CSPNonce").(string)
+	return nonce
+}
+
+// scriptTag matches the start of a script element
+var scriptTag = regexp.MustCompile(`(?i)<script\b`)
+
+// withNonce adds the nonce to every script element of html, so that scripts
+// put in pages from the config, like analytics and head.scripts, may run
+func withNonce(html template.HTML, nonce string) template.HTML {
+	if nonce == "" {
+		return html
+	}
+	return template.HTML(scriptTag.ReplaceAllString(string(html), `<script nonce="`+nonce+`"`))
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/template/html/v2"
+)
+
+// Server ties together the config, templates, content index and fiber app
+type Server struct {
+	cfg      *Config
+	engine   *html.Engine
+	content  *ContentIndex
+	app      *fiber.App
+	reporter ErrorReporter
+	// images generates media variants and rewrites post images to use them
+	images *imagePipeline
+	// signer signs session cookies
+	signer *cookieSigner
+	// repo commits content edits when git history is enabled
+	repo *contentRepo
+	// auditLog records admin and API changes, nil when disabled
+	auditLog *auditLog
+	// comments stores readers' comments, nil when disabled
+	comments *commentStore
+	// commentLimit throttles comments per client IP
+	commentLimit *rateLimiter
+	// postCommentLimit throttles comments per post
+	postCommentLimit *rateLimiter
+	// mentions stores received Webmentions, nil when disabled
+	mentions *mentionStore
+	// mentionLimit throttles Webmentions per client IP
+	mentionLimit *rateLimiter
+	// activityPub federates the blog with the fediverse, nil when disabled
+	activityPub *activityPub
+	// likes counts anonymous likes of posts, nil when disabled
+	likes *likeStore
+	// views logs page views, nil when analytics are disabled
+	views *viewStore
+	// popular caches the rankings of popularPosts
+	popular popularCache
+	// newsletter keeps the newsletter subscribers, nil when disabled
+	newsletter *newsletterStore
+	// newsletterLimit throttles newsletter signups per client IP
+	newsletterLimit *rateLimiter
+	// contactLimit throttles contact messages per client IP
+	contactLimit *rateLimiter
+	// remote fetches from other sites, guarded against the server's own
+	// network
+	remote *http.Client
+	// messages translates the strings of the templates
+	messages *messageCatalogs
+	// errors keeps recent errors for the admin dashboard
+	errors    *errorLog
+	startedAt time.Time
+
+	// liveReload is set in development to refresh browsers on file changes
+	liveReload *LiveReloader
+
+	// templatesErr holds the error from compiling the templates at startup
+	templatesErr error
+	// bundles are the asset bundles built at startup, outside development
+	bundles map[string]*assetBundle
+	// assets fingerprints the public files templates link with asset
+	assets *assetManifest
+	// icons are the favicon and home screen icons generated at startup
+	icons []siteIcon
+}
+
+// newTemplateEngine creates the HTML template engine with the custom
+// functions, reading each template from the theme layer that has it
+func newTemplateEngine(cfg *Config) *html.Engine {
+	var engine *html.Engine
+	if dirs := cfg.TemplateDirs(); len(dirs) == 1 {
+		engine = html.New(dirs[0], ".html")
+	} else {
+		engine = html.NewFileSystem(layeredDirs(dirs), ".html")
+	}
+	engine.Reload(cfg.Development())
+
+	// dict passes several values to a sub-template, as pairs of a key and
+	// a value
+	engine.AddFunc("dict", func(pairs ...interface{}) (map[string]interface{}, error) {
+		if len(pairs)%2 != 0 {
+			return nil, fmt.Errorf("dict needs pairs of a key and a value")
+		}
+		m := make(map[string]interface{}, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			key, ok := pairs[i].(string)
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings")
+			}
+			m[key] = pairs[i+1]
+		}
+		return m, nil
+	})
+
+	// Add custom template function for raw HTML
+	engine.AddFunc("raw", func(s interface{}) template.HTML {
+		switch v := s.(type) {
+		case template.HTML:
+			return v
+		case string:
+			return template.HTML(v)
+		default:
+			return ""
+		}
+	})
+
+	addLayoutFuncs(engine)
+	addTemplateFuncs(engine, cfg)
+	return engine
+}
+
+// newServer loads the content and templates and registers all routes.
+// Load failures are logged and reported by /readyz rather than aborting.
+func newServer(cfg *Config, reporter ErrorReporter) *Server {
+	errors := newErrorLog(50)
+	s := &Server{
+		cfg:       cfg,
+		engine:    newTemplateEngine(cfg),
+		content:   newContentIndex(cfg.ContentDir, cfg.I18n.Languages, cfg.Location(), cfg.Development(), cfg.Development()),
+		images:    newImagePipeline(cfg),
+		assets:    newAssetManifest(cfg),
+		signer:    newCookieSigner(cfg.Admin.SessionSecret),
+		remote:    newRemoteClient(cfg.Development()),
+		repo:      newContentRepo(cfg),
+		auditLog:  newAuditLog(cfg),
+		reporter:  multiReporter{reporter, errors},
+		errors:    errors,
+		startedAt: time.Now(),
+	}
+	if cfg.Development() && !cfg.Headless {
+		s.liveReload = newLiveReloader()
+	}
+	if cfg.Obsidian.Enabled {
+		s.content.vaultURL = cfg.Obsidian.URL
+	}
+
+	if _, err := syncContentSource(context.Background(), cfg); err != nil {
+		slog.Error("Error syncing content", "dir", cfg.ContentDir, "error", err)
+	}
+	if err := s.content.Load(); err != nil {
+		slog.Error("Error loading content", "dir", cfg.ContentDir, "error", err)
+	}
+	comments, err := newCommentStore(cfg)
+	if err != nil {
+		slog.Error("Error opening comments database", "file", cfg.Comments.Database, "error", err)
+	}
+	s.comments = comments
+	s.commentLimit = newRateLimiter(cfg.Comments.PerIPPerHour, time.Hour)
+	s.postCommentLimit = newRateLimiter(cfg.Comments.PerPostPerHour, time.Hour)
+	mentions, err := newMentionStore(cfg)
+	if err != nil {
+		slog.Error("Error opening webmentions database", "file", cfg.Webmention.Database, "error", err)
+	}
+	s.mentions = mentions
+	s.mentionLimit = newRateLimiter(60, time.Hour)
+	likes, err := newLikeStore(cfg)
+	if err != nil {
+		slog.Error("Error opening likes database", "file", cfg.Likes.Database, "error", err)
+	}
+	s.likes = likes
+	views, err := newViewStore(cfg)
+	if err != nil {
+		slog.Error("Error opening analytics database", "file", cfg.Analytics.Database, "error", err)
+	}
+	s.views = views
+	s.engine.AddFunc("popularPosts", s.templatePopularPosts)
+	messages, err := newMessageCatalogs(cfg)
+	if err != nil {
+		slog.Error("Error loading message catalogs", "dir", cfg.I18n.Catalogs, "error", err)
+	}
+	s.messages = messages
+	s.engine.AddFunc("t", s.messages.Translate)
+	s.engine.AddFunc("tdate", s.messages.FormatDate)
+	s.engine.AddFunc("date", s.messages.FormatDateStyle)
+	s.engine.AddFunc("textdir", textDirection)
+	s.engine.AddFunc("local", func(t time.Time) time.Time { return t.In(cfg.Location()) })
+	s.engine.AddFunc("bundle", s.bundleURL)
+	s.engine.AddFunc("asset", s.assetURL)
+	s.engine.AddFunc("pageURL", pageURL)
+	newsletter, err := newNewsletterStore(cfg)
+	if err != nil {
+		slog.Error("Error opening newsletter database", "file", cfg.Newsletter.Database, "error", err)
+	}
+	s.newsletter = newsletter
+	s.newsletterLimit = newRateLimiter(5, time.Hour)
+	s.contactLimit = newRateLimiter(cfg.Contact.PerIPPerHour, time.Hour)
+	activityPub, err := newActivityPub(cfg)
+	if err != nil {
+		slog.Error("Error setting up ActivityPub", "file", cfg.ActivityPub.Database, "error", err)
+	}
+	s.activityPub = activityPub
+	// Nothing is rendered headless, so the templates may not even exist
+	if !cfg.Headless {
+		if s.templatesErr = s.engine.Load(); s.templatesErr != nil {
+			slog.Error("Error compiling templates", "dir", cfg.TemplateDir, "error", s.templatesErr)
+		}
+		if !cfg.Development() {
+			if s.bundles, err = buildBundles(cfg); err != nil {
+				slog.Error("Error building asset bundles", "dir", cfg.Assets.Dir, "error", err)
+			}
+		}
+		if s.icons, err = buildIcons(cfg); err != nil {
+			slog.Error("Error generating icons", "source", cfg.Icons.Source, "error", err)
+		}
+	}
+
+	// Create fiber app
+	// Locals set by middleware, like the build info, are visible in every template
+	s.app = fiber.New(fiber.Config{
+		Views:             pageViews{s.engine},
+		PassLocalsToViews: true,
+		ErrorHandler:      s.handleError,
+		BodyLimit:         cfg.Media.MaxUploadMB << 20,
+	})
+
+	s.registerRoutes()
+	return s
+}
+
+// registerRoutes wires up the health checks, static files and pages
+func (s *Server) registerRoutes() {
+	app := s.app
+
+	// Middleware
+	app.Use(requestIDMiddleware)
+	app.Use(accessLogMiddleware())
+	app.Use(s.securityHeadersMiddleware)
+	app.Use(s.recoverMiddleware)
+	app.Use(buildInfoMiddleware)
+	if s.cfg.Headless {
+		s.registerHeadlessRoutes()
+		return
+	}
+	if s.views != nil {
+		app.Use(s.countViews)
+	}
+	app.Use(colorSchemeMiddleware)
+	s.registerIndieWebLinks()
+	s.registerAnalyticsScripts()
+	s.registerHeadTags()
+	s.registerIcons()
+	if s.cfg.MinifyHTML {
+		app.Use(minifyHTMLMiddleware)
+	}
+	if s.liveReload != nil {
+		app.Get(liveReloadPath, s.liveReload.handleEvents)
+		app.Use(s.liveReload.injectMiddleware)
+	}
+
+	// The locale and language of the page, before any route renders one
+	s.registerLocale()
+	s.registerLanguageRoutes()
+
+	s.registerHealthRoutes()
+
+	// Admin
+	s.registerAdminRoutes()
+	s.registerAPIRoutes()
+	s.registerMicropubRoutes()
+	s.registerIndieAuthRoutes()
+	s.registerHookRoutes()
+	s.registerCommentRoutes()
+	s.registerWebmentionRoutes()
+	s.registerActivityPubRoutes()
+	s.registerNewsletterRoutes()
+	s.registerContactRoutes()
+	s.registerFeedRoutes()
+
+	// Diagnostics
+	s.registerDebugRoutes()
+
+	if len(s.cfg.Assets.Bundles) > 0 {
+		app.Get(strings.TrimSuffix(s.cfg.Assets.URL, "/")+"/:file", s.handleBundle)
+	}
+
+	if !s.cfg.Development() {
+		app.Use(s.serveFingerprinted)
+	}
+
+	// Static files, cached by browsers outside development. A file missing
+	// from one layer of the theme falls through to the next.
+	for _, dir := range s.cfg.PublicDirs() {
+		app.Static("/", dir, s.staticConfig())
+	}
+	if !mediaInsidePublic(s.cfg) {
+		app.Static(s.cfg.Media.URL, s.cfg.Media.Dir, s.staticConfig())
+	}
+	if s.cfg.Obsidian.Enabled {
+		app.Get(strings.TrimSuffix(s.cfg.Obsidian.URL, "/")+"/*", s.handleVaultFile)
+	}
+
+	// Routes
+	s.paginationRoutes("/", s.handleIndex)
+	app.Get("/blog/:slug", s.handlePost)
+	s.paginationRoutes("/blog", s.handleBlog)
+	s.paginationRoutes("/tags/:tag", s.handleTag)
+	s.registerArchiveRoutes()
+	app.Post("/color-scheme", s.handleColorScheme)
+	app.Use(s.handleAlias)
+}
+
+// registerHealthRoutes serves the health checks and version information
+func (s *Server) registerHealthRoutes() {
+	s.app.Get("/healthz", s.handleHealth)
+	s.app.Get("/readyz", s.handleReady)
+	s.app.Get("/version", s.handleVersion)
+}
+
+// staticConfig caches static files in browsers outside development
+func (s *Server) staticConfig() fiber.Static {
+	static := fiber.Static{}
+	if !s.cfg.Development() {
+		static.MaxAge = 3600
+	}
+	return static
+}
+
+func (s *Server) handleIndex(c *fiber.Ctx) error {
+	posts, err := s.content.Posts()
+	if err != nil {
+		return s.internalError(c, "Error loading blog posts", err)
+	}
+	requestLogger(c).Debug("Loaded posts", "count", len(posts))
+	list, err := s.listView(c, s.cfg.Title, "/", posts)
+	if err != nil {
+		return err
+	}
+	if err := renderView(c, "index", IndexView{list}); err != nil {
+		return s.internalError(c, "Template render error", err)
+	}
+	return nil
+}
+
+func (s *Server) handlePost(c *fiber.Ctx) error {
+	slug := c.Params("slug")
+	post, ok := s.content.Post(slug)
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Blog post not found")
+	}
+	c.Locals(viewedPostLocal, post.Slug)
+	return s.renderPost(c, post)
+}
+
+// renderPost renders a single post page
+func (s *Server) renderPost(c *fiber.Ctx, post *BlogPost) error {
+	shown := *post
+	shown.HTMLContent = s.images.Rewrite(post.HTMLContent)
+	og := s.openGraph(c, post)
+	view := PostView{
+		PageView:    s.pageView(c, post.Title),
+		Post:        &shown,
+		Comments:    s.postComments(c, post),
+		Mentions:    s.postMentions(c, post),
+		Likes:       s.postLikes(c, post),
+		Views:       s.postViews(c, post),
+		Languages:   s.languageLinks(c, post),
+		Syndication: syndicationLinks(post.Syndication),
+	}
+	view.SEO = SEOView{Description: og.Description, Canonical: post.Canonical, OpenGraph: &og}
+	if post.Lang != "" {
+		view.Lang = post.Lang
+	}
+	s.translationFallback(c, &view)
+	// Drafts shown in previews cannot be commented on
+	if apiVisible(post) && post.CommentsAllowed() {
+		if s.comments != nil {
+			// The comment forms send back the session's CSRF token
+			s.session(c)
+			view.CommentsOpen = true
+			view.CommentFeed = "/blog/" + post.Slug + "/comments.rss"
+			view.CommentToken = s.commentFormToken(post)
+			view.CommentAwaiting = c.Query("comment") == "pending"
+			view.ReplyTo = s.commentReplyTo(c, post)
+			view.NotifyReplies = s.cfg.Comments.NotifyReplies
+			view.CommenterSignIn = s.cfg.Comments.IndieAuth
+			view.Commenter = s.commenter(c)
+		}
+		view.CommentEmbed = s.commentEmbed(s.absoluteURL(c, "/blog/"+post.Slug), post, cspNonce(c))
+	}
+	return renderView(c, "post", view)
+}
+
+func (s *Server) handleBlog(c *fiber.Ctx) error {
+	posts, err := s.content.Posts()
+	if err != nil {
+		return s.internalError(c, "Error loading blog posts", err)
+	}
+	list, err := s.listView(c, s.t(c, "All Blog Posts"), "/blog", posts)
+	if err != nil {
+		return err
+	}
+	return renderView(c, "blog", list)
+}
+
+// serve starts the HTTP server and blocks until it stops. load is called
+// again to pick up config changes when the process receives SIGHUP.
+func serve(load func() (*Config, error)) error {
+	cfg, err := load()
+	if err != nil {
+		return err
+	}
+
+	reporter, err := newErrorReporter(cfg)
+	if err != nil {
+		return err
+	}
+	defer reporter.Flush(2 * time.Second)
+
+	set, err := newSiteSet(cfg, load, reporter)
+	if err != nil {
+		return err
+	}
+	defer set.Close()
+	set.reloadOnSignal()
+
+	app := set.app()
+	router := set.current.Load()
+	go router.processMedia()
+
+	if cfg.Gemini.Enabled {
+		ln, err := listenGemini(cfg, router.Hosts())
+		if err != nil {
+			return fmt.Errorf("error opening Gemini listener: %v", err)
+		}
+		defer ln.Close()
+		slog.Info("Gemini server starting", "addr", ln.Addr().String())
+		go set.serveGemini(ln)
+	}
+
+	if cfg.TLS.Autocert {
+		return listenAutocert(cfg.TLS, app, router.Hosts())
+	}
+
+	ln, err := openListener(cfg.Listen, cfg.Port)
+	if err != nil {
+		return fmt.Errorf("error opening listener: %v", err)
+	}
+
+	slog.Info("Server starting", "addr", ln.Addr().String(), "sites", 1+len(router.sites))
+	return app.Listener(ln)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// cookieSigner signs and verifies cookie values so clients cannot forge them
+type cookieSigner struct {
+	key []byte
+}
+
+// newCookieSigner creates a signer from the configured secret. Without one a
+// random key is generated, which signs everyone out when the process restarts.
+func newCookieSigner(secret string) *cookieSigner {
+	if secret != "" {
+		return &cookieSigner{key: []byte(secret)}
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	slog.Debug("No session secret configured, using a random key")
+	return &cookieSigner{key: key}
+}
+
+// Sign encodes value with an expiry time and a signature
+func (s *cookieSigner) Sign(value string, ttl time.Duration) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(value)) + "." +
+		strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return payload + "." + s.mac(payload)
+}
+
+// Verify returns the value of a signed cookie if the signature matches and
+// it has not expired
+func (s *cookieSigner) Verify(cookie string) (string, bool) {
+	i := strings.LastIndex(cookie, ".")
+	if i < 0 {
+		return "", false
+	}
+	payload, sig := cookie[:i], cookie[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(s.mac(payload))) {
+		return "", false
+	}
+
+	encoded, expires, ok := strings.Cut(payload, ".")
+	if !ok {
+		return "", false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return "", false
+	}
+	value, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	return string(value), true
+}
+
+// mac returns the hex HMAC-SHA256 of payload
+func (s *cookieSigner) mac(payload string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(payload))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// randomToken returns a random URL-safe token
+func randomToken() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+const (
+	// sessionCookie holds the signed session of an admin or form visitor
+	sessionCookie = "devdaze_session"
+	// sessionTTL is how long a session lasts without signing in again
+	sessionTTL = 12 * time.Hour
+	// csrfField and csrfHeader carry the CSRF token on unsafe requests
+	csrfField  = "_csrf"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// Session is the state kept in the signed session cookie
+type Session struct {
+	// Account is the signed-in admin, empty for anonymous visitors
+	Account string `json:"account,omitempty"`
+	// CSRF is the token forms and scripts must send back on unsafe requests
+	CSRF string `json:"csrf"`
+}
+
+// session returns the session of the current request, starting a new one
+// when the cookie is missing, expired or tampered with
+func (s *Server) session(c *fiber.Ctx) *Session {
+	if sess, ok := c.Locals("session").(*Session); ok {
+		return sess
+	}
+
+	sess := &Session{}
+	if value, ok := s.signer.Verify(c.Cookies(sessionCookie)); ok {
+		if err := json.Unmarshal([]byte(value), sess); err != nil {
+			sess = &Session{}
+		}
+	}
+	if sess.CSRF == "" {
+		sess.CSRF = randomToken()
+		s.saveSession(c, sess)
+	}
+
+	c.Locals("session", sess)
+	c.Locals("CSRFToken", sess.CSRF)
+	return sess
+}
+
+// saveSession writes the session cookie
+func (s *Server) saveSession(c *fiber.Ctx, sess *Session) {
+	value, _ := json.Marshal(sess)
+	c.Cookie(&fiber.Cookie{
+		Name:     sessionCookie,
+		Value:    s.signer.Sign(string(value), sessionTTL),
+		Path:     "/",
+		HTTPOnly: true,
+		Secure:   !s.cfg.Development(),
+		SameSite: fiber.CookieSameSiteLaxMode,
+		Expires:  time.Now().Add(sessionTTL),
+	})
+	c.Locals("session", sess)
+	c.Locals("CSRFToken", sess.CSRF)
+}
+
+// startSession replaces the session after signing in or out, so a token
+// obtained before signing in cannot be reused afterwards
+func (s *Server) startSession(c *fiber.Ctx, account string) {
+	s.saveSession(c, &Session{Account: account, CSRF: randomToken()})
+}
+
+// sessionMiddleware loads the session so templates can render .CSRFToken
+func (s *Server) sessionMiddleware(c *fiber.Ctx) error {
+	s.session(c)
+	return c.Next()
+}
+
+// csrfProtect rejects unsafe requests that do not echo the session's CSRF
+// token in the _csrf form field or the X-CSRF-Token header
+func (s *Server) csrfProtect(c *fiber.Ctx) error {
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return c.Next()
+	}
+
+	token := c.Get(csrfHeader)
+	if token == "" {
+		token = c.FormValue(csrfField)
+	}
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.session(c).CSRF)) != 1 {
+		requestLogger(c).Warn("CSRF token mismatch", "path", c.Path())
+		return fiber.NewError(fiber.StatusForbidden, "Invalid or missing CSRF token, reload the page and try again")
+	}
+	return c.Next()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieSignerVerify(t *testing.T) {
+	signer := newCookieSigner("secret")
+	valid := signer.Sign("admin", time.Hour)
+	payload, sig, _ := strings.Cut(valid, ".")
+
+	tests := []struct {
+		name   string
+		cookie string
+		want   string
+		ok     bool
+	}{
+		{"valid", valid, "admin", true},
+		{"value with dots", signer.Sign(`{"csrf":"a.b.c"}`, time.Hour), `{"csrf":"a.b.c"}`, true},
+		{"empty", "", "", false},
+		{"no signature", "YWRtaW4", "", false},
+		{"tampered value", "cm9vdA" + valid[len(payload):], "", false},
+		{"tampered signature", valid[:len(valid)-1] + "0", "", false},
+		{"expired", signer.Sign("admin", -time.Minute), "", false},
+		{"other key", newCookieSigner("other").Sign("admin", time.Hour), "", false},
+		{"signature only", sig, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := signer.Verify(tt.cookie)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("Verify(%q) = %q, %v, want %q, %v", tt.cookie, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
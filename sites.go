@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// SiteConfig serves a separate blog for one or more hostnames
+type SiteConfig struct {
+	Hosts []string `yaml:"hosts"`
+	// Config is a config file whose settings override the main config for
+	// this site, typically content_dir, template_dir, public_dir and title
+	Config string `yaml:"config"`
+}
+
+// loadSiteConfig applies a site's config file on top of the main config, so
+// process-wide settings like logging and error reporting are inherited
+func loadSiteConfig(base *Config, path string) (*Config, error) {
+	cfg := *base
+	cfg.Sites = nil
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading site config %s: %v", path, err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing site config %s: %v", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("site config %s: %v", path, err)
+	}
+	return &cfg, nil
+}
+
+// siteRouter dispatches requests to a site's app by Host header, falling
+// back to the main site for unknown hosts
+type siteRouter struct {
+	main  *Server
+	hosts map[string]*Server
+	sites []*Server
+}
+
+// newSiteRouter creates a server for each configured site
+func newSiteRouter(main *Server, reporter ErrorReporter) (*siteRouter, error) {
+	r := &siteRouter{main: main, hosts: make(map[string]*Server)}
+
+	for _, site := range main.cfg.Sites {
+		if len(site.Hosts) == 0 {
+			return nil, fmt.Errorf("site %s has no hosts", site.Config)
+		}
+
+		cfg, err := loadSiteConfig(main.cfg, site.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		s := newServer(cfg, reporter)
+		r.sites = append(r.sites, s)
+		for _, host := range site.Hosts {
+			host = strings.ToLower(host)
+			if _, dup := r.hosts[host]; dup {
+				return nil, fmt.Errorf("host %s is configured for more than one site", host)
+			}
+			r.hosts[host] = s
+		}
+	}
+
+	return r, nil
+}
+
+// Hosts returns every hostname served by an additional site
+func (r *siteRouter) Hosts() []string {
+	hosts := make([]string, 0, len(r.hosts))
+	for host := range r.hosts {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// serverFor returns the site serving host
+func (r *siteRouter) serverFor(host string) *Server {
+	if s, ok := r.hosts[hostWithoutPort(host)]; ok {
+		return s
+	}
+	return r.main
+}
+
+// startLiveReload starts watching the files of every site
+func (r *siteRouter) startLiveReload(ctx context.Context) {
+	for _, s := range append([]*Server{r.main}, r.sites...) {
+		if s.liveReload != nil {
+			s.startLiveReload(ctx)
+		}
+	}
+}
+
+// processMedia generates missing image variants for every site, so images
+// added outside the uploader are resized too
+func (r *siteRouter) processMedia() {
+	for _, s := range append([]*Server{r.main}, r.sites...) {
+		n, err := s.images.ProcessAll()
+		if err != nil {
+			slog.Warn("Error processing media", "dir", s.cfg.Media.Dir, "error", err)
+		}
+		if n > 0 {
+			slog.Info("Generated image variants", "dir", s.cfg.Media.Dir, "count", n)
+		}
+	}
+}
+
+// hostWithoutPort normalizes a Host header value for lookups
+func hostWithoutPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
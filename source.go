@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ContentSourceConfig selects where the files of the content directory come
+// from. Every source but local fills content_dir with a copy of them, which
+// the rest of DevDaze reads and edits as usual.
+type ContentSourceConfig struct {
+	// Type is local, the default, embed, git, s3 or gcs
+	Type string `yaml:"type"`
+	// Interval is how often serve syncs the source. Zero syncs it only when
+	// the server starts and reloads.
+	Interval time.Duration `yaml:"interval"`
+	// URL is the repository a git source clones
+	URL string `yaml:"url"`
+	// Branch is the branch a git source checks out, the repository's
+	// default branch when empty
+	Branch string `yaml:"branch"`
+	// Bucket and Prefix are where an s3 or gcs source reads the files from
+	Bucket string `yaml:"bucket"`
+	Prefix string `yaml:"prefix"`
+	// Region is the bucket's AWS region, from the environment when empty
+	Region string `yaml:"region"`
+	// Endpoint is the URL of an S3-compatible API other than AWS, like MinIO
+	// or Cloudflare R2. gcs uses https://storage.googleapis.com.
+	Endpoint string `yaml:"endpoint"`
+}
+
+func (c ContentSourceConfig) validate() error {
+	switch c.Type {
+	case "", "local", "embed":
+	case "git":
+		if c.URL == "" {
+			return fmt.Errorf("source.url is required for a git source")
+		}
+	case "s3", "gcs":
+		if c.Bucket == "" {
+			return fmt.Errorf("source.bucket is required for an %s source", c.Type)
+		}
+		if c.Endpoint != "" && !absoluteHTTPURL(c.Endpoint) {
+			return fmt.Errorf("source.endpoint %q is not an absolute http or https URL", c.Endpoint)
+		}
+	default:
+		return fmt.Errorf("unknown source.type %q (want local, embed, git, s3 or gcs)", c.Type)
+	}
+	return nil
+}
+
+// gcsEndpoint is the S3-compatible XML API of Google Cloud Storage
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// sourceStateFile remembers the version of each file a source wrote
+const sourceStateFile = ".devdaze-source.json"
+
+// embeddedContent is the content directory built into the binary with
+// -tags embedcontent, nil otherwise
+var embeddedContent fs.FS
+
+// ContentSource fills the content directory from where the content lives
+type ContentSource interface {
+	// Sync brings the files in dir up to date with the source
+	Sync(ctx context.Context, dir string) (SourceSyncResult, error)
+}
+
+// SourceSyncResult counts what a sync did
+type SourceSyncResult struct {
+	Updated   int
+	Unchanged int
+	Removed   int
+}
+
+// Changed reports whether the sync wrote or removed any file
+func (r SourceSyncResult) Changed() bool {
+	return r.Updated > 0 || r.Removed > 0
+}
+
+// newContentSource returns the source cfg selects
+func newContentSource(ctx context.Context, cfg ContentSourceConfig) (ContentSource, error) {
+	switch cfg.Type {
+	case "", "local":
+		return localSource{}, nil
+	case "embed":
+		if embeddedContent == nil {
+			return nil, fmt.Errorf("this binary has no embedded content, build it with -tags embedcontent")
+		}
+		return fsSource{fsys: embeddedContent}, nil
+	case "git":
+		return gitSource{url: cfg.URL, branch: cfg.Branch}, nil
+	case "s3", "gcs":
+		return newBucketSource(ctx, cfg)
+	}
+	return nil, fmt.Errorf("unknown source.type %q", cfg.Type)
+}
+
+// syncContentSource syncs the content directory from the configured source
+func syncContentSource(ctx context.Context, cfg *Config) (SourceSyncResult, error) {
+	source, err := newContentSource(ctx, cfg.Source)
+	if err != nil {
+		return SourceSyncResult{}, err
+	}
+	result, err := source.Sync(ctx, cfg.ContentDir)
+	if err != nil {
+		return result, fmt.Errorf("error syncing %s source: %v", cfg.Source.Type, err)
+	}
+	if result.Changed() {
+		slog.Info("Synced content source", "type", cfg.Source.Type, "updated", result.Updated, "removed", result.Removed)
+	}
+	return result, nil
+}
+
+// startSourceSync runs the source sync of every site that has an interval
+// until ctx is done
+func (r *siteRouter) startSourceSync(ctx context.Context) {
+	for _, s := range append([]*Server{r.main}, r.sites...) {
+		if s.cfg.Source.Interval > 0 {
+			go s.runSourceSync(ctx)
+		}
+	}
+}
+
+// runSourceSync syncs the content source every interval and re-indexes the
+// content when files changed. The first sync happened when the server was
+// created.
+func (s *Server) runSourceSync(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Source.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		result, err := syncContentSource(ctx, s.cfg)
+		if err != nil {
+			slog.Error("Error syncing content source", "error", err)
+		} else if result.Changed() {
+			if err := s.content.Load(); err != nil {
+				slog.Error("Error reloading content", "error", err)
+			}
+		}
+	}
+}
+
+// localSource is content that already is in the content directory
+type localSource struct{}
+
+func (localSource) Sync(context.Context, string) (SourceSyncResult, error) {
+	return SourceSyncResult{}, nil
+}
+
+// mirrorFiles writes the files of a source into dir. versions maps the
+// slash-separated name of each file to a version that changes with its
+// contents, and only files whose version changed are fetched again. Files
+// the source no longer has are moved to the trash, while files that never
+// came from it are left alone.
+func mirrorFiles(dir string, versions map[string]string, fetch func(name string) ([]byte, error)) (SourceSyncResult, error) {
+	var result SourceSyncResult
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return result, err
+	}
+	statePath := filepath.Join(dir, sourceStateFile)
+	state := make(map[string]string)
+	if data, err := os.ReadFile(statePath); err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return result, fmt.Errorf("error reading %s: %v", statePath, err)
+		}
+	}
+
+	for name, version := range versions {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if state[name] == version && fileExists(file) {
+			result.Unchanged++
+			continue
+		}
+		data, err := fetch(name)
+		if err != nil {
+			return result, fmt.Errorf("error fetching %s: %v", name, err)
+		}
+		if err := writeOutputFile(dir, filepath.FromSlash(name), data); err != nil {
+			return result, err
+		}
+		state[name] = version
+		result.Updated++
+	}
+
+	for name := range state {
+		if _, ok := versions[name]; ok {
+			continue
+		}
+		if err := moveToTrash(dir, filepath.Join(dir, filepath.FromSlash(name))); err != nil && !os.IsNotExist(err) {
+			return result, err
+		}
+		delete(state, name)
+		result.Removed++
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return result, err
+	}
+	return result, os.WriteFile(statePath, data, 0644)
+}
+
+// sourceFileName checks a file name of a source, returning false for names
+// outside the content directory, hidden files and DevDaze's own folders
+func sourceFileName(name string) bool {
+	if name != path.Clean(name) || name == "." || path.IsAbs(name) || strings.HasPrefix(name, "..") {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || internalDir(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// fsSource copies the files of a file system, like the content embedded in
+// the binary
+type fsSource struct {
+	fsys fs.FS
+}
+
+func (src fsSource) Sync(ctx context.Context, dir string) (SourceSyncResult, error) {
+	versions := make(map[string]string)
+	contents := make(map[string][]byte)
+	err := fs.WalkDir(src.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !sourceFileName(name) {
+			return err
+		}
+		data, err := fs.ReadFile(src.fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		versions[name] = hex.EncodeToString(sum[:])
+		contents[name] = data
+		return nil
+	})
+	if err != nil {
+		return SourceSyncResult{}, err
+	}
+	return mirrorFiles(dir, versions, func(name string) ([]byte, error) {
+		return contents[name], nil
+	})
+}
+
+// gitSource clones a repository into the content directory and pulls it on
+// every sync. Commits made with git.enabled are pushed back when git.remote
+// is set.
+type gitSource struct {
+	url    string
+	branch string
+}
+
+func (src gitSource) Sync(ctx context.Context, dir string) (SourceSyncResult, error) {
+	repo := &contentRepo{dir: dir}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	before, err := repo.git(ctx, nil, "rev-parse", "HEAD")
+	if err != nil {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return SourceSyncResult{}, err
+		}
+		if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+			return SourceSyncResult{}, err
+		}
+		args := []string{"clone", "-q"}
+		if src.branch != "" {
+			args = append(args, "--branch", src.branch)
+		}
+		parent := &contentRepo{dir: filepath.Dir(abs)}
+		if _, err := parent.git(ctx, nil, append(args, "--", src.url, abs)...); err != nil {
+			return SourceSyncResult{}, err
+		}
+		files, err := repo.git(ctx, nil, "ls-files")
+		if err != nil {
+			return SourceSyncResult{}, err
+		}
+		return SourceSyncResult{Updated: len(strings.Fields(files))}, nil
+	}
+
+	if err := repo.Pull(ctx); err != nil {
+		return SourceSyncResult{}, err
+	}
+	after, err := repo.git(ctx, nil, "rev-parse", "HEAD")
+	if err != nil || after == before {
+		return SourceSyncResult{}, err
+	}
+	changes, err := repo.git(ctx, nil, "diff", "--name-status", before, after)
+	if err != nil {
+		return SourceSyncResult{}, err
+	}
+	var result SourceSyncResult
+	for _, line := range strings.Split(changes, "\n") {
+		if strings.HasPrefix(line, "D") {
+			result.Removed++
+		} else if line != "" {
+			result.Updated++
+		}
+	}
+	return result, nil
+}
+
+// bucketSource copies the objects under a prefix of an S3 or GCS bucket
+type bucketSource struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// newBucketSource connects to the bucket with the usual AWS credentials.
+// GCS reads HMAC keys from the same variables.
+func newBucketSource(ctx context.Context, cfg ContentSourceConfig) (*bucketSource, error) {
+	endpoint, region := cfg.Endpoint, cfg.Region
+	if cfg.Type == "gcs" {
+		if endpoint == "" {
+			endpoint = gcsEndpoint
+		}
+		if region == "" {
+			region = "auto"
+		}
+	}
+
+	var loadOpts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		loadOpts = append(loadOpts, awsconfig.WithRegion(region))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("error loading AWS credentials: %v", err)
+	}
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &bucketSource{client: client, bucket: cfg.Bucket, prefix: prefix}, nil
+}
+
+func (src *bucketSource) Sync(ctx context.Context, dir string) (SourceSyncResult, error) {
+	versions := make(map[string]string)
+	paginator := s3.NewListObjectsV2Paginator(src.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(src.bucket),
+		Prefix: aws.String(src.prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return SourceSyncResult{}, fmt.Errorf("error listing s3://%s/%s: %v", src.bucket, src.prefix, err)
+		}
+		for _, obj := range page.Contents {
+			name := strings.TrimPrefix(aws.ToString(obj.Key), src.prefix)
+			if strings.HasSuffix(name, "/") || !sourceFileName(name) {
+				continue
+			}
+			versions[name] = aws.ToString(obj.ETag)
+		}
+	}
+
+	return mirrorFiles(dir, versions, func(name string) ([]byte, error) {
+		obj, err := src.client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(src.bucket),
+			Key:    aws.String(src.prefix + name),
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer obj.Body.Close()
+		return io.ReadAll(obj.Body)
+	})
+}
@@ -0,0 +1,17 @@
+//go:build embedcontent
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// embeddedFiles is the content directory at build time
+//
+//go:embed content
+var embeddedFiles embed.FS
+
+func init() {
+	embeddedContent, _ = fs.Sub(embeddedFiles, "content")
+}
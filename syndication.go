@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// SyndicationConfig controls cross-posting published posts to other blogging
+// platforms, each enabled by setting its token
+type SyndicationConfig struct {
+	DevTo    DevToConfig    `yaml:"devto"`
+	Hashnode HashnodeConfig `yaml:"hashnode"`
+	Mastodon MastodonConfig `yaml:"mastodon"`
+	Bluesky  BlueskyConfig  `yaml:"bluesky"`
+}
+
+// DevToConfig cross-posts to dev.to
+type DevToConfig struct {
+	// Token is an API key from dev.to/settings/extensions
+	Token string `yaml:"token"`
+}
+
+// HashnodeConfig cross-posts to a Hashnode publication
+type HashnodeConfig struct {
+	// Token is a personal access token from hashnode.com/settings/developer
+	Token string `yaml:"token"`
+	// Publication is the host of the publication posts go to, like
+	// example.hashnode.dev
+	Publication string `yaml:"publication"`
+}
+
+// MastodonConfig announces published posts on a Mastodon account
+type MastodonConfig struct {
+	// Server is the URL of the account's instance, like https://mastodon.social
+	Server string `yaml:"server"`
+	// Token is the access token of an application with the write:statuses
+	// scope, from Preferences → Development
+	Token string `yaml:"token"`
+	// Message is the text/template of the status, with the post's .Title,
+	// .Excerpt, .URL and .Hashtags
+	Message string `yaml:"message"`
+	// Visibility is public, unlisted or private
+	Visibility string `yaml:"visibility"`
+}
+
+// Enabled reports whether any platform is configured
+func (c SyndicationConfig) Enabled() bool {
+	return c.DevTo.Token != "" || c.Hashnode.Token != "" || c.Mastodon.Token != "" || c.Bluesky.Password != ""
+}
+
+func (c SyndicationConfig) validate() error {
+	if c.Hashnode.Token != "" && c.Hashnode.Publication == "" {
+		return fmt.Errorf("syndication.hashnode needs publication, the host of the blog to post to")
+	}
+	if err := c.Bluesky.validate(); err != nil {
+		return err
+	}
+	if c.Mastodon.Token == "" {
+		return nil
+	}
+	if !absoluteHTTPURL(c.Mastodon.Server) {
+		return fmt.Errorf("syndication.mastodon.server %q is not an absolute http or https URL", c.Mastodon.Server)
+	}
+	if _, err := template.New("mastodon").Parse(c.Mastodon.Message); err != nil {
+		return fmt.Errorf("syndication.mastodon.message: %v", err)
+	}
+	switch c.Mastodon.Visibility {
+	case "public", "unlisted", "private":
+	default:
+		return fmt.Errorf("unknown syndication.mastodon.visibility %q, expected public, unlisted or private", c.Mastodon.Visibility)
+	}
+	return nil
+}
+
+const (
+	devToAPI    = "https://dev.to/api/articles"
+	hashnodeAPI = "https://gql.hashnode.com"
+)
+
+// syndicatedArticle is a post as it is sent to another platform
+type syndicatedArticle struct {
+	Title       string
+	Description string
+	Tags        []string
+	Series      string
+	// URL is the post's page on the site
+	URL string
+	// Excerpt is the description, or else the start of the text
+	Excerpt string
+	// Image and ImageFile are the post's Open Graph image
+	Image     string
+	ImageFile string
+	// Markdown has its links to the site made absolute
+	Markdown string
+	// Canonical is the URL of the original, which the copy points search
+	// engines to
+	Canonical string
+}
+
+// syndicationTarget is a platform posts are cross-posted to
+type syndicationTarget struct {
+	name string
+	// host is the host of the URLs of posts on the platform, so posts
+	// already cross-posted there aren't posted again
+	host    string
+	publish func(ctx context.Context, article syndicatedArticle) (string, error)
+}
+
+// syndicationTargets returns the platforms configured for the site
+func (s *Server) syndicationTargets() []syndicationTarget {
+	var targets []syndicationTarget
+	if c := s.cfg.Syndication.DevTo; c.Token != "" {
+		targets = append(targets, syndicationTarget{name: "dev.to", host: "dev.to", publish: c.publish})
+	}
+	if c := s.cfg.Syndication.Hashnode; c.Token != "" {
+		targets = append(targets, syndicationTarget{name: "Hashnode", host: c.Publication, publish: c.publish})
+	}
+	if c := s.cfg.Syndication.Mastodon; c.Token != "" {
+		u, _ := url.Parse(c.Server)
+		targets = append(targets, syndicationTarget{name: "Mastodon", host: u.Host, publish: c.publish})
+	}
+	if c := s.cfg.Syndication.Bluesky; c.Password != "" {
+		targets = append(targets, syndicationTarget{name: "Bluesky", host: "bsky.app", publish: c.publish})
+	}
+	return targets
+}
+
+// syndicate cross-posts a newly published post to the configured platforms
+// in the background and records the URLs of the copies in its syndication
+// frontmatter
+func (s *Server) syndicate(post *BlogPost) {
+	targets := s.syndicationTargets()
+	if len(targets) == 0 || post.Lang != "" {
+		return
+	}
+
+	posted := make(map[string]bool)
+	for _, link := range post.Syndication {
+		if u, err := url.Parse(link); err == nil {
+			posted[strings.ToLower(u.Host)] = true
+		}
+	}
+	article := s.syndicatedArticle(post)
+	go func() {
+		var urls []string
+		for _, target := range targets {
+			if posted[strings.ToLower(target.host)] {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			link, err := target.publish(ctx, article)
+			cancel()
+			if err != nil {
+				slog.Warn("Error cross-posting", "to", target.name, "slug", post.Slug, "error", err)
+				continue
+			}
+			slog.Info("Cross-posted post", "to", target.name, "slug", post.Slug, "url", link)
+			urls = append(urls, link)
+		}
+		if len(urls) > 0 {
+			s.recordSyndication(post.Slug, urls)
+		}
+	}()
+}
+
+// siteLink matches the link targets of markdown that are paths on the site
+var siteLink = regexp.MustCompile(`(\]\(\s*<?|\b(?:src|href)\s*=\s*["'])(/[^/][^)"'\s>]*)`)
+
+// syndicatedArticle prepares post for another platform, where links to
+// pages and media of the site need its host
+func (s *Server) syndicatedArticle(post *BlogPost) syndicatedArticle {
+	og := s.openGraph(nil, post)
+	canonical := post.Canonical
+	if canonical == "" {
+		canonical = og.URL
+	}
+	markdown := siteLink.ReplaceAllStringFunc(post.Content, func(link string) string {
+		m := siteLink.FindStringSubmatch(link)
+		return m[1] + s.absoluteURL(nil, m[2])
+	})
+	return syndicatedArticle{
+		Title:       post.Title,
+		Description: post.Description,
+		Tags:        post.Tags,
+		Series:      post.Series,
+		URL:         og.URL,
+		Excerpt:     og.Description,
+		Image:       og.Image,
+		ImageFile:   og.ImageFile,
+		Markdown:    markdown,
+		Canonical:   canonical,
+	}
+}
+
+// recordSyndication adds urls to the syndication frontmatter of a post and
+// re-indexes the content
+func (s *Server) recordSyndication(slug string, urls []string) {
+	post := s.findPost(slug)
+	if post == nil {
+		return
+	}
+	links, err := json.Marshal(append(append([]string{}, post.Syndication...), urls...))
+	if err != nil {
+		return
+	}
+	// A JSON list is a YAML flow sequence
+	if err := s.revise(post.FilePath, func() error { return setFrontmatterField(post.FilePath, "syndication", string(links)) }); err != nil {
+		slog.Error("Error recording syndication", "slug", slug, "error", err)
+		return
+	}
+	if s.repo != nil {
+		if err := s.repo.Commit("syndication", fmt.Sprintf("Syndicate %s\n\nCross-posted to %s.", slug, strings.Join(urls, ", "))); err != nil {
+			slog.Error("Error committing content change", "slug", slug, "error", err)
+		}
+	}
+	if err := s.content.Load(); err != nil {
+		slog.Error("Error re-indexing content", "dir", s.cfg.ContentDir, "error", err)
+	}
+}
+
+// postExcerpt returns the description of post, or else the first words of
+// its text up to n characters
+func postExcerpt(post *BlogPost, n int) string {
+	if post.Description != "" {
+		return post.Description
+	}
+	doc, err := html.Parse(strings.NewReader(post.HTMLContent))
+	if err != nil {
+		return ""
+	}
+	return truncateWords(textContent(doc), n)
+}
+
+// truncateWords shortens text to at most n characters, ending with an
+// ellipsis after the last whole word
+func truncateWords(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	if n < 1 {
+		return ""
+	}
+	cut := string([]rune(text)[:n-1])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, ",.;: ") + "…"
+}
+
+// syndicationLink is a link to a copy of a post on another platform
+type syndicationLink struct {
+	URL  string
+	Name string
+}
+
+// syndicationLinks names the copies of a post by their host
+func syndicationLinks(urls []string) []syndicationLink {
+	var links []syndicationLink
+	for _, link := range urls {
+		u, err := url.Parse(link)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		links = append(links, syndicationLink{URL: link, Name: strings.TrimPrefix(u.Host, "www.")})
+	}
+	return links
+}
+
+// devToTag matches the characters dev.to tags may not contain
+var devToTag = regexp.MustCompile(`[^a-z0-9]`)
+
+// publish creates a published article on dev.to and returns its URL
+func (c DevToConfig) publish(ctx context.Context, article syndicatedArticle) (string, error) {
+	// dev.to takes up to four tags of only letters and digits
+	var tags []string
+	for _, tag := range article.Tags {
+		if tag = devToTag.ReplaceAllString(strings.ToLower(tag), ""); tag != "" && len(tags) < 4 {
+			tags = append(tags, tag)
+		}
+	}
+	fields := map[string]interface{}{
+		"title":         article.Title,
+		"body_markdown": article.Markdown,
+		"published":     true,
+		"canonical_url": article.Canonical,
+		"tags":          tags,
+	}
+	if article.Description != "" {
+		fields["description"] = article.Description
+	}
+	if article.Series != "" {
+		fields["series"] = article.Series
+	}
+
+	var created struct {
+		URL string `json:"url"`
+	}
+	err := postJSON(ctx, devToAPI, map[string]string{"api-key": c.Token}, map[string]interface{}{"article": fields}, &created)
+	if err != nil {
+		return "", err
+	}
+	if created.URL == "" {
+		return "", fmt.Errorf("dev.to returned no URL")
+	}
+	return created.URL, nil
+}
+
+// publish publishes a post on the Hashnode publication and returns its URL
+func (c HashnodeConfig) publish(ctx context.Context, article syndicatedArticle) (string, error) {
+	var found struct {
+		Publication *struct {
+			ID string `json:"id"`
+		} `json:"publication"`
+	}
+	if err := c.query(ctx, `query Publication($host: String) { publication(host: $host) { id } }`,
+		map[string]interface{}{"host": c.Publication}, &found); err != nil {
+		return "", err
+	}
+	if found.Publication == nil {
+		return "", fmt.Errorf("no Hashnode publication at %s", c.Publication)
+	}
+
+	tags := []map[string]string{}
+	for _, tag := range article.Tags {
+		if slug := slugify(tag); slug != "" {
+			tags = append(tags, map[string]string{"slug": slug, "name": tag})
+		}
+	}
+	input := map[string]interface{}{
+		"title":              article.Title,
+		"contentMarkdown":    article.Markdown,
+		"publicationId":      found.Publication.ID,
+		"originalArticleURL": article.Canonical,
+		"tags":               tags,
+	}
+	if article.Description != "" {
+		input["subtitle"] = article.Description
+	}
+
+	var published struct {
+		PublishPost struct {
+			Post struct {
+				URL string `json:"url"`
+			} `json:"post"`
+		} `json:"publishPost"`
+	}
+	if err := c.query(ctx, `mutation PublishPost($input: PublishPostInput!) { publishPost(input: $input) { post { url } } }`,
+		map[string]interface{}{"input": input}, &published); err != nil {
+		return "", err
+	}
+	if published.PublishPost.Post.URL == "" {
+		return "", fmt.Errorf("hashnode returned no URL")
+	}
+	return published.PublishPost.Post.URL, nil
+}
+
+// query runs a query of the Hashnode GraphQL API and decodes its data into
+// result
+func (c HashnodeConfig) query(ctx context.Context, query string, variables map[string]interface{}, result interface{}) error {
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	body := map[string]interface{}{"query": query, "variables": variables}
+	if err := postJSON(ctx, hashnodeAPI, map[string]string{"Authorization": c.Token}, body, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("hashnode: %s", resp.Errors[0].Message)
+	}
+	return json.Unmarshal(resp.Data, result)
+}
+
+// postJSON posts body as JSON with the given headers and decodes the JSON
+// response into result
+func postJSON(ctx context.Context, endpoint string, headers map[string]string, body, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "DevDaze/"+currentBuildInfo().Version)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+// hashtagChars matches the characters Mastodon hashtags may not contain
+var hashtagChars = regexp.MustCompile(`[^\p{L}\p{N}_]`)
+
+// statusMessage is what the templates of Mastodon and Bluesky posts are
+// executed with
+type statusMessage struct {
+	Title    string
+	Excerpt  string
+	URL      string
+	Hashtags string
+}
+
+// renderStatus executes the template of a status for article
+func renderStatus(message string, article syndicatedArticle) (string, error) {
+	var hashtags []string
+	for _, tag := range article.Tags {
+		if tag = hashtagChars.ReplaceAllString(tag, ""); tag != "" {
+			hashtags = append(hashtags, "#"+tag)
+		}
+	}
+	tmpl, err := template.New("status").Parse(message)
+	if err != nil {
+		return "", err
+	}
+	var status strings.Builder
+	err = tmpl.Execute(&status, statusMessage{
+		Title:    article.Title,
+		Excerpt:  article.Excerpt,
+		URL:      article.URL,
+		Hashtags: strings.Join(hashtags, " "),
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(status.String()), nil
+}
+
+// publish posts a status announcing the post and returns its URL. The
+// Idempotency-Key makes Mastodon return the first status when a post is
+// announced again.
+func (c MastodonConfig) publish(ctx context.Context, article syndicatedArticle) (string, error) {
+	status, err := renderStatus(c.Message, article)
+	if err != nil {
+		return "", err
+	}
+
+	var created struct {
+		URL string `json:"url"`
+	}
+	headers := map[string]string{
+		"Authorization":   "Bearer " + c.Token,
+		"Idempotency-Key": shortHash(article.URL),
+	}
+	body := map[string]string{"status": status, "visibility": c.Visibility}
+	if err := postJSON(ctx, strings.TrimSuffix(c.Server, "/")+"/api/v1/statuses", headers, body, &created); err != nil {
+		return "", err
+	}
+	if created.URL == "" {
+		return "", fmt.Errorf("mastodon returned no URL")
+	}
+	return created.URL, nil
+}
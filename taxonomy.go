@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// handleTag lists the posts with a tag, found by its slug: /tags/small-web
+// for posts tagged "Small Web"
+func (s *Server) handleTag(c *fiber.Ctx) error {
+	posts, err := s.content.Posts()
+	if err != nil {
+		return s.internalError(c, "Error loading blog posts", err)
+	}
+	name, tagged := postsTagged(posts, c.Params("tag"))
+	if len(tagged) == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "Tag not found")
+	}
+	list, err := s.listView(c, s.t(c, "Posts tagged %s", name), "/tags/"+slugify(name), tagged)
+	if err != nil {
+		return err
+	}
+	list.Tag = name
+	return renderView(c, s.termTemplate("tags", slugify(name), "blog"), list)
+}
+
+// postsTagged returns the posts with the tag whose slug is slug, and the tag
+// as the first of them writes it
+func postsTagged(posts []*BlogPost, slug string) (string, []*BlogPost) {
+	var name string
+	var tagged []*BlogPost
+	for _, post := range posts {
+		for _, tag := range post.Tags {
+			if slugify(tag) == slug {
+				if name == "" {
+					name = tag
+				}
+				tagged = append(tagged, post)
+				break
+			}
+		}
+	}
+	return name, tagged
+}
+
+// tagSlugs returns the slugs of the tags posts use, each once
+func tagSlugs(posts []*BlogPost) []string {
+	seen := make(map[string]bool)
+	var slugs []string
+	for _, post := range posts {
+		for _, tag := range post.Tags {
+			if slug := slugify(tag); slug != "" && !seen[slug] {
+				seen[slug] = true
+				slugs = append(slugs, slug)
+			}
+		}
+	}
+	return slugs
+}
+
+// termTemplate picks the template a taxonomy term is listed with: its own,
+// like tags/go.html, when the templates have one, and fallback otherwise
+func (s *Server) termTemplate(taxonomy, term, fallback string) string {
+	name := taxonomy + "/" + term
+	s.engine.Mutex.RLock()
+	defer s.engine.Mutex.RUnlock()
+	if s.engine.Templates != nil && s.engine.Templates.Lookup(name) != nil {
+		return name
+	}
+	return fallback
+}
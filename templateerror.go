@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// templateError is a template that failed to compile or render
+type templateError struct {
+	err error
+}
+
+func (e *templateError) Error() string { return e.err.Error() }
+
+func (e *templateError) Unwrap() error { return e.err }
+
+// templateErrorLocation finds the template name and line in the errors of
+// html/template, like `template: post:12: function "foo" not defined`
+var templateErrorLocation = regexp.MustCompile(`(?:html/)?template: ?(\S+?):(\d+):(?:\d+:)?`)
+
+// templateErrorPage shows the developer what broke, in place of the page
+var templateErrorPage = template.Must(template.New("template_error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Template error</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; max-width: 900px; margin: 40px auto; padding: 0 20px; color: #333; }
+        h1 { color: #c0392b; }
+        pre { background: #f4f4f4; padding: 15px; border-radius: 5px; overflow-x: auto; }
+        .error { white-space: pre-wrap; }
+        .current { background: #fdecea; color: #c0392b; font-weight: bold; }
+        .meta { color: #7f8c8d; }
+    </style>
+</head>
+<body>
+    <h1>Template error</h1>
+    {{- if .Name }}
+    <p><strong>{{ .Name }}</strong>{{ with .File }} in <code>{{ . }}</code>{{ end }}{{ if .Line }}, line {{ .Line }}{{ end }}</p>
+    {{- end }}
+    <pre class="error">{{ .Message }}</pre>
+    {{- with .Source }}
+    <pre>{{ range . }}<span{{ if .Current }} class="current"{{ end }}>{{ printf "%4d" .Number }}  {{ .Text }}</span>
+{{ end }}</pre>
+    {{- end }}
+    <p class="meta">{{ if .LiveReload }}Fix the template and save it, and this page reloads on its own. {{ end }}Production never shows this page. Request ID: {{ .RequestID }}</p>
+    {{- with .LiveReload }}{{ . }}{{ end }}
+</body>
+</html>
+`))
+
+// sourceLine is a line of the template shown around the error
+type sourceLine struct {
+	Number  int
+	Text    string
+	Current bool
+}
+
+// sendTemplateError responds with a page showing where a template broke,
+// when err comes from one. It is only used in development.
+func (s *Server) sendTemplateError(c *fiber.Ctx, err error) (bool, error) {
+	var te *templateError
+	if !errors.As(err, &te) {
+		return false, nil
+	}
+	data := struct {
+		Name, File, Message, RequestID string
+		Line                           int
+		Source                         []sourceLine
+		LiveReload                     template.HTML
+	}{Message: te.Error(), RequestID: requestID(c)}
+	if s.liveReload != nil {
+		data.LiveReload = liveReloadScript
+	}
+
+	// The last location is the innermost, like a partial inside a page
+	if found := templateErrorLocation.FindAllStringSubmatch(te.Error(), -1); len(found) > 0 {
+		loc := found[len(found)-1]
+		data.Name = loc[1]
+		data.Line, _ = strconv.Atoi(loc[2])
+		data.File, data.Source = s.templateSource(data.Name, data.Line)
+	}
+
+	var buf bytes.Buffer
+	if err := templateErrorPage.Execute(&buf, data); err != nil {
+		return false, nil
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return true, c.Status(fiber.StatusInternalServerError).Send(buf.Bytes())
+}
+
+// templateSource returns the file of the template called name, from the
+// theme layer that has it, and its lines around line. Templates defined
+// inside another file, like layout:title, have no file of their own.
+func (s *Server) templateSource(name string, line int) (string, []sourceLine) {
+	for _, dir := range s.cfg.TemplateDirs() {
+		file := filepath.Join(dir, filepath.FromSlash(name)+".html")
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		lines := strings.Split(string(content), "\n")
+		var source []sourceLine
+		for n := max(line-5, 1); n <= min(line+5, len(lines)); n++ {
+			source = append(source, sourceLine{Number: n, Text: lines[n-1], Current: n == line})
+		}
+		return file, source
+	}
+	return "", nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/template/html/v2"
+)
+
+// pageViews renders pages inside their layout, telling the layout which page
+// it wraps so that the page can fill in the layout's blocks
+type pageViews struct {
+	*html.Engine
+}
+
+func (v pageViews) Render(out io.Writer, name string, binding interface{}, layout ...string) error {
+	if data, ok := binding.(fiber.Map); ok {
+		data["Template"] = name
+	}
+	if err := v.Engine.Render(out, name, binding, layout...); err != nil {
+		return &templateError{err: err}
+	}
+	return nil
+}
+
+// addLayoutFuncs adds the functions pages and the layout are put together
+// with. {{ partial "header" . }} renders partials/header.html, and
+// {{ slot "head" . }} renders a block of the layout: the page's own
+// "<page>:head" if it defines one, or else the layout's "layout:head".
+func addLayoutFuncs(engine *html.Engine) {
+	engine.AddFunc("partial", func(name string, data interface{}) (template.HTML, error) {
+		tmpl := engine.Templates.Lookup("partials/" + name)
+		if tmpl == nil {
+			return "", fmt.Errorf("partial %q not found in the partials folder", name)
+		}
+		return executeTemplate(tmpl, data)
+	})
+
+	engine.AddFunc("slot", func(name string, data interface{}) (template.HTML, error) {
+		var page string
+		if m, ok := data.(fiber.Map); ok {
+			page, _ = m["Template"].(string)
+		}
+		for _, owner := range []string{page, "layout"} {
+			if owner == "" {
+				continue
+			}
+			if tmpl := engine.Templates.Lookup(owner + ":" + name); tmpl != nil {
+				return executeTemplate(tmpl, data)
+			}
+		}
+		return "", nil
+	})
+}
+
+// executeTemplate renders tmpl on its own, for templates included from
+// another one while it is being rendered
+func executeTemplate(tmpl *template.Template, data interface{}) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// addTemplateFuncs adds the general purpose functions templates format values
+// with
+func addTemplateFuncs(engine *html.Engine, cfg *Config) {
+	// dateFormat formats a time with a Go layout in the site's time zone,
+	// where date formats it for a locale
+	engine.AddFunc("dateFormat", func(layout string, t time.Time) string {
+		return t.In(cfg.Location()).Format(layout)
+	})
+
+	// truncate shortens text to at most n characters at a word boundary
+	engine.AddFunc("truncate", func(n int, text string) string {
+		return truncateWords(text, n)
+	})
+
+	// markdownify renders markdown, without the paragraph around a single
+	// line so that it can be used inline
+	engine.AddFunc("markdownify", func(markdown string) template.HTML {
+		out := strings.TrimSpace(renderMarkdown(markdown))
+		if inner, ok := strings.CutPrefix(out, "<p>"); ok && strings.HasSuffix(inner, "</p>") && !strings.Contains(inner, "<p>") {
+			out = strings.TrimSuffix(inner, "</p>")
+		}
+		return template.HTML(out)
+	})
+
+	engine.AddFunc("slugify", slugify)
+
+	// absURL makes a path absolute with base_url, leaving full URLs alone
+	engine.AddFunc("absURL", func(path string) string {
+		if strings.Contains(path, "://") || strings.HasPrefix(path, "//") {
+			return path
+		}
+		return strings.TrimSuffix(cfg.BaseURL, "/") + "/" + strings.TrimPrefix(path, "/")
+	})
+
+	// pluralize picks the singular or the plural for a count. The plural
+	// defaults to the singular with an s.
+	engine.AddFunc("pluralize", func(count interface{}, singular string, plural ...string) (string, error) {
+		n, err := templateInt(count)
+		if err != nil {
+			return "", err
+		}
+		if n == 1 || n == -1 {
+			return singular, nil
+		}
+		if len(plural) > 0 {
+			return plural[0], nil
+		}
+		return singular + "s", nil
+	})
+
+	// jsonify encodes a value as JSON, for data handed to scripts
+	engine.AddFunc("jsonify", func(v interface{}) (template.JS, error) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("jsonify: %v", err)
+		}
+		return template.JS(b), nil
+	})
+}
+
+// templateInt converts the numbers templates pass around, which come in
+// whatever integer type the data holds them in
+func templateInt(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case int32:
+		return int64(n), nil
+	case uint:
+		return int64(n), nil
+	case uint64:
+		return int64(n), nil
+	case float64:
+		return int64(n), nil
+	case *int:
+		if n != nil {
+			return int64(*n), nil
+		}
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("%v is not a number", v)
+	}
+}
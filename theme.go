@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ThemeConfig selects a theme, a folder of templates and assets that
+// replaces the built-in design file by file
+type ThemeConfig struct {
+	// Name is the theme's folder in Dir, empty for the built-in design
+	Name string `yaml:"name"`
+	// Dir holds the installed themes, each with a templates and a public
+	// folder
+	Dir string `yaml:"dir"`
+	// Override is the site's own templates and public folders, whose files
+	// take precedence over the theme's
+	Override string `yaml:"override"`
+}
+
+func (c ThemeConfig) validate() error {
+	if c.Name == "" {
+		return nil
+	}
+	if filepath.Base(c.Name) != c.Name {
+		return fmt.Errorf("theme.name %q must be the name of a folder in %s", c.Name, c.Dir)
+	}
+	if info, err := os.Stat(filepath.Join(c.Dir, c.Name)); err != nil || !info.IsDir() {
+		return fmt.Errorf("theme %q not found in %s", c.Name, c.Dir)
+	}
+	return nil
+}
+
+// themeLayers returns the folders the files named sub come from, the one
+// that takes precedence first: the override, the theme and then base.
+// Folders that don't exist are left out, but base is always there.
+func (c *Config) themeLayers(sub, base string) []string {
+	var dirs []string
+	if c.Theme.Override != "" {
+		dirs = append(dirs, filepath.Join(c.Theme.Override, sub))
+	}
+	if c.Theme.Name != "" {
+		dirs = append(dirs, filepath.Join(c.Theme.Dir, c.Theme.Name, sub))
+	}
+	layers := dirs[:0]
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			layers = append(layers, dir)
+		}
+	}
+	return append(layers, base)
+}
+
+// TemplateDirs returns the folders templates are read from, in order of
+// precedence
+func (c *Config) TemplateDirs() []string {
+	return c.themeLayers("templates", c.TemplateDir)
+}
+
+// PublicDirs returns the folders static assets are served from, in order of
+// precedence
+func (c *Config) PublicDirs() []string {
+	return c.themeLayers("public", c.PublicDir)
+}
+
+// layeredDirs is a file system of folders stacked on top of each other. A
+// file is opened from the first folder that has it, and listing a folder
+// merges it across all of them.
+type layeredDirs []string
+
+func (l layeredDirs) Open(name string) (http.File, error) {
+	var err error = os.ErrNotExist
+	for _, dir := range l {
+		var f http.File
+		f, err = http.Dir(dir).Open(name)
+		if err != nil {
+			continue
+		}
+		if info, statErr := f.Stat(); statErr == nil && info.IsDir() {
+			return &layeredDir{File: f, layers: l, name: name}, nil
+		}
+		return f, nil
+	}
+	return nil, err
+}
+
+// layeredDir is a folder of layeredDirs, listing the files of every layer
+type layeredDir struct {
+	http.File
+	layers layeredDirs
+	name   string
+	// listed holds the merged listing once read, and next the part of it
+	// Readdir returns next
+	listed []os.FileInfo
+	next   int
+}
+
+func (d *layeredDir) Readdir(count int) ([]os.FileInfo, error) {
+	if d.listed == nil {
+		seen := make(map[string]bool)
+		d.listed = []os.FileInfo{}
+		for _, dir := range d.layers {
+			f, err := http.Dir(dir).Open(d.name)
+			if err != nil {
+				continue
+			}
+			entries, err := f.Readdir(-1)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			for _, info := range entries {
+				if !seen[info.Name()] {
+					seen[info.Name()] = true
+					d.listed = append(d.listed, info)
+				}
+			}
+		}
+		sort.Slice(d.listed, func(i, j int) bool { return d.listed[i].Name() < d.listed[j].Name() })
+	}
+
+	rest := d.listed[d.next:]
+	if count <= 0 {
+		d.next = len(d.listed)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	rest = rest[:min(count, len(rest))]
+	d.next += len(rest)
+	return rest, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// themeInstallTimeout limits how long cloning a theme may take
+const themeInstallTimeout = 5 * time.Minute
+
+// themeReadme explains a new theme to its author
+const themeReadme = `# %s
+
+A DevDaze theme. Select it in devdaze.yaml:
+
+    theme:
+      name: %s
+
+templates/ holds the templates, laid out like the built-in ones, and public/
+the stylesheets, images and scripts served from the site's root. Only the
+files that are here replace the built-in ones, so delete those you don't
+change. The layout and partials to start from are copied in.
+`
+
+// scaffoldTheme creates the theme name in theme.dir, with a copy of the
+// built-in layout, partials and stylesheet to start from
+func scaffoldTheme(cfg *Config, name string) (string, error) {
+	if name == "" || filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("theme name %q must be a folder name", name)
+	}
+	dir := filepath.Join(cfg.Theme.Dir, name)
+	if _, err := os.Stat(dir); err == nil {
+		return "", fmt.Errorf("%s already exists", dir)
+	}
+
+	type themeFile struct{ src, dst string }
+	files := []themeFile{
+		{filepath.Join(cfg.TemplateDir, "layout.html"), filepath.Join(dir, "templates", "layout.html")},
+		{filepath.Join(cfg.PublicDir, "style.css"), filepath.Join(dir, "public", "style.css")},
+	}
+	partials, err := filepath.Glob(filepath.Join(cfg.TemplateDir, "partials", "*.html"))
+	if err != nil {
+		return "", err
+	}
+	for _, src := range partials {
+		files = append(files, themeFile{src, filepath.Join(dir, "templates", "partials", filepath.Base(src))})
+	}
+
+	for _, sub := range []string{"templates", "public"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return "", err
+		}
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(f.src)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(filepath.Dir(f.dst), 0755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(f.dst, data, 0644); err != nil {
+			return "", err
+		}
+	}
+	readme := fmt.Sprintf(themeReadme, name, name)
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte(readme), 0644); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// themeNameFromURL names a theme after its repository, like dark for
+// https://github.com/someone/dark.git
+func themeNameFromURL(repo string) string {
+	repo = strings.TrimRight(strings.TrimSuffix(strings.TrimRight(repo, "/"), ".git"), "/")
+	if i := strings.LastIndexAny(repo, ":/"); i >= 0 {
+		repo = repo[i+1:]
+	}
+	return repo
+}
+
+// installTheme clones the git repository of a theme into theme.dir, at ref
+// when it is set. The clone keeps its history, so the theme can be updated
+// with git pull.
+func installTheme(ctx context.Context, out io.Writer, cfg *Config, repo, name, ref string) (string, error) {
+	if name == "" {
+		name = themeNameFromURL(repo)
+	}
+	if name == "" || filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("can't name a theme after %q, pass --name", repo)
+	}
+	dir := filepath.Join(cfg.Theme.Dir, name)
+	if _, err := os.Stat(dir); err == nil {
+		return "", fmt.Errorf("%s already exists", dir)
+	}
+	if err := os.MkdirAll(cfg.Theme.Dir, 0755); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, themeInstallTimeout)
+	defer cancel()
+	args := []string{"clone", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	cmd := exec.CommandContext(ctx, "git", append(args, "--", repo, dir)...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("git clone failed: %v", err)
+	}
+
+	// Anything else is not a theme, and would silently change nothing
+	if !dirExists(filepath.Join(dir, "templates")) && !dirExists(filepath.Join(dir, "public")) {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("%s has no templates or public folder, so it isn't a DevDaze theme", repo)
+	}
+	return dir, nil
+}
+
+// dirExists reports whether path is a directory
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func newThemeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "theme",
+		Short: "Create and install themes",
+	}
+	cmd.AddCommand(newThemeNewCmd(), newThemeInstallCmd())
+	return cmd
+}
+
+func newThemeNewCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "new <name>",
+		Short: "Create a theme in the themes directory from the built-in design",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCommandConfig(cmd)
+			if err != nil {
+				return err
+			}
+			dir, err := scaffoldTheme(cfg, args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Created %s; select it with theme.name: %s\n", dir, args[0])
+			return nil
+		},
+	}
+}
+
+func newThemeInstallCmd() *cobra.Command {
+	var name, ref string
+
+	cmd := &cobra.Command{
+		Use:   "install <git-url>",
+		Short: "Clone a theme into the themes directory",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCommandConfig(cmd)
+			if err != nil {
+				return err
+			}
+			dir, err := installTheme(cmd.Context(), cmd.ErrOrStderr(), cfg, args[0], name, ref)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Installed %s; select it with theme.name: %s\n", dir, filepath.Base(dir))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "", "folder to install the theme as (default the repository name)")
+	cmd.Flags().StringVar(&ref, "ref", "", "branch or tag to install (default the default branch)")
+	return cmd
+}
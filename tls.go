@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig enables automatic HTTPS with certificates from Let's Encrypt
+type TLSConfig struct {
+	Autocert bool     `yaml:"autocert"`
+	Domains  []string `yaml:"domains"`
+	CacheDir string   `yaml:"cache_dir"`
+	Email    string   `yaml:"email"`
+	// HTTPAddr serves ACME challenges and redirects everything else to HTTPS
+	HTTPAddr  string `yaml:"http_addr"`
+	HTTPSAddr string `yaml:"https_addr"`
+}
+
+// listenAutocert serves the app over HTTPS with certificates obtained and
+// renewed automatically, plus a plain HTTP listener that answers ACME
+// challenges and redirects all other requests to HTTPS. Certificates are
+// requested for the configured domains and any extra site hosts.
+func listenAutocert(tc TLSConfig, app *fiber.App, extraHosts []string) error {
+	domains := append(append([]string{}, tc.Domains...), extraHosts...)
+	if len(domains) == 0 {
+		return fmt.Errorf("tls.autocert needs at least one domain in tls.domains")
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(tc.CacheDir),
+		Email:      tc.Email,
+	}
+
+	httpServer := &http.Server{
+		Addr:              tc.HTTPAddr,
+		Handler:           manager.HTTPHandler(nil),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		slog.Info("HTTP redirect server starting", "addr", tc.HTTPAddr)
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTP redirect server failed", "error", err)
+		}
+	}()
+	defer httpServer.Close()
+
+	ln, err := net.Listen("tcp", tc.HTTPSAddr)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := manager.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+
+	slog.Info("Server starting", "addr", tc.HTTPSAddr, "domains", domains)
+	return app.Listener(tls.NewListener(ln, tlsConfig))
+}
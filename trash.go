@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// trashDir holds deleted posts inside the content directory. Each deletion
+// gets a directory named after its time, keeping the file's relative path.
+const trashDir = ".trash"
+
+// trashStamp names the directory of a deletion
+const trashStamp = "20060102T150405.000000000Z"
+
+// TrashConfig controls how long deleted posts are kept
+type TrashConfig struct {
+	// RetentionDays is how long deleted posts stay restorable; 0 keeps
+	// them until they are deleted forever from the trash page
+	RetentionDays int `yaml:"retention_days"`
+}
+
+// TrashEntry is a deleted post that can be restored
+type TrashEntry struct {
+	// ID identifies the entry in URLs as <deletion>/<path>
+	ID        string
+	Path      string
+	Title     string
+	Slug      string
+	DeletedAt time.Time
+}
+
+// moveToTrash moves the file at path into the trash of contentDir
+func moveToTrash(contentDir, path string) error {
+	rel, err := filepath.Rel(contentDir, path)
+	if err != nil {
+		return err
+	}
+
+	trash := filepath.Join(contentDir, trashDir)
+	dest := filepath.Join(trash, time.Now().UTC().Format(trashStamp), rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	// Keep deleted posts out of git history of the content directory
+	if err := os.WriteFile(filepath.Join(trash, ".gitignore"), []byte("*\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path, dest)
+}
+
+// listTrash returns the deleted posts, most recently deleted first
+func listTrash(contentDir string) ([]TrashEntry, error) {
+	trash := filepath.Join(contentDir, trashDir)
+	dirs, err := os.ReadDir(trash)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []TrashEntry
+	for _, d := range dirs {
+		deletedAt, err := time.Parse(trashStamp, d.Name())
+		if !d.IsDir() || err != nil {
+			continue
+		}
+		root := filepath.Join(trash, d.Name())
+		err = filepath.WalkDir(root, func(path string, f fs.DirEntry, err error) error {
+			if err != nil || f.IsDir() || !strings.HasSuffix(f.Name(), ".md") {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			entry := TrashEntry{
+				ID:        d.Name() + "/" + filepath.ToSlash(rel),
+				Path:      filepath.ToSlash(rel),
+				Title:     filepath.ToSlash(rel),
+				DeletedAt: deletedAt,
+			}
+			if content, err := os.ReadFile(path); err == nil {
+				if post, err := parseMarkdownFile(content, time.UTC); err == nil && post.Title != "" {
+					entry.Title = post.Title
+					entry.Slug = post.Slug
+				}
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].DeletedAt.After(entries[j].DeletedAt) })
+	return entries, nil
+}
+
+// trashEntryPath resolves a TrashEntry ID to the deleted file and the path
+// it is restored to
+func trashEntryPath(contentDir, id string) (string, string, error) {
+	stamp, rel, ok := strings.Cut(id, "/")
+	if _, err := time.Parse(trashStamp, stamp); !ok || err != nil {
+		return "", "", fmt.Errorf("%q is not in the trash", id)
+	}
+	original, err := resolveContentPath(contentDir, rel)
+	if err != nil {
+		return "", "", err
+	}
+	rel, _ = filepath.Rel(contentDir, original)
+	return filepath.Join(contentDir, trashDir, stamp, rel), original, nil
+}
+
+// restoreFromTrash moves a deleted post back to where it was. It fails when
+// another file has taken its place in the meantime.
+func restoreFromTrash(contentDir, id string) (string, error) {
+	deleted, original, err := trashEntryPath(contentDir, id)
+	if err != nil {
+		return "", err
+	}
+	if !fileExists(deleted) {
+		return "", fmt.Errorf("%q is not in the trash", id)
+	}
+	if fileExists(original) {
+		return "", fmt.Errorf("%s already exists", original)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(original), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(deleted, original); err != nil {
+		return "", err
+	}
+	removeEmptyDirs(filepath.Join(contentDir, trashDir), filepath.Dir(deleted))
+	return original, nil
+}
+
+// deleteFromTrash removes a deleted post for good
+func deleteFromTrash(contentDir, id string) error {
+	deleted, _, err := trashEntryPath(contentDir, id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(deleted); err != nil {
+		return err
+	}
+	removeEmptyDirs(filepath.Join(contentDir, trashDir), filepath.Dir(deleted))
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents up to, but not including, root
+// while they are empty
+func removeEmptyDirs(root, dir string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// purgeTrash removes deletions older than the retention period and returns
+// how many were removed
+func purgeTrash(contentDir string, retentionDays int) (int, error) {
+	if retentionDays <= 0 {
+		return 0, nil
+	}
+	trash := filepath.Join(contentDir, trashDir)
+	dirs, err := os.ReadDir(trash)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	purged := 0
+	for _, d := range dirs {
+		deletedAt, err := time.Parse(trashStamp, d.Name())
+		if !d.IsDir() || err != nil || deletedAt.After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(trash, d.Name())); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// trashPost moves a post's file to the trash and re-indexes the content
+func (s *Server) trashPost(path string) error {
+	if err := moveToTrash(s.cfg.ContentDir, path); err != nil {
+		return err
+	}
+	return s.content.Load()
+}
+
+// purgeExpiredTrash runs purgeTrash for the scheduler, logging the outcome
+func (s *Server) purgeExpiredTrash() {
+	n, err := purgeTrash(s.cfg.ContentDir, s.cfg.Trash.RetentionDays)
+	if err != nil {
+		slog.Error("Error purging trash", "dir", s.cfg.ContentDir, "error", err)
+	}
+	if n > 0 {
+		slog.Info("Purged expired trash", "dir", s.cfg.ContentDir, "deletions", n)
+	}
+}
+
+// handleEditorDelete moves a file from the editor to the trash
+func (s *Server) handleEditorDelete(c *fiber.Ctx) error {
+	rel := c.Params("*")
+	path, err := resolveContentPath(s.cfg.ContentDir, rel)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if !fileExists(path) {
+		return fiber.ErrNotFound
+	}
+	if err := s.checkEditable(c, path); err != nil {
+		return err
+	}
+
+	before := s.findPostFile(path)
+	if err := s.trashPost(path); err != nil {
+		return s.internalError(c, "Error deleting file", err)
+	}
+
+	requestLogger(c).Info("Moved content file to trash", "path", rel)
+	s.recordEdit(c, "Delete %s\n\nMoved to the trash in the admin editor.", rel)
+	s.postChanged(before, nil)
+	return c.Redirect("/admin/editor?notice="+url.QueryEscape("Moved "+rel+" to the trash"), fiber.StatusSeeOther)
+}
+
+// handleTrashList shows the deleted posts
+func (s *Server) handleTrashList(c *fiber.Ctx) error {
+	entries, err := listTrash(s.cfg.ContentDir)
+	if err != nil {
+		return s.internalError(c, "Error listing trash", err)
+	}
+	return c.Render("admin_trash", fiber.Map{
+		"Title":         "Trash",
+		"Notice":        c.Query("notice"),
+		"Entries":       entries,
+		"RetentionDays": s.cfg.Trash.RetentionDays,
+	})
+}
+
+// handleTrashRestore moves a deleted post back into the content directory
+func (s *Server) handleTrashRestore(c *fiber.Ctx) error {
+	id := c.Params("*")
+	path, err := restoreFromTrash(s.cfg.ContentDir, id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusConflict, "Not restored: "+err.Error())
+	}
+	if err := s.content.Load(); err != nil {
+		return s.internalError(c, "Restored, but re-indexing failed", err)
+	}
+
+	rel, _ := filepath.Rel(s.cfg.ContentDir, path)
+	requestLogger(c).Info("Restored content file", "path", rel)
+	s.recordEdit(c, "Restore %s\n\nRestored from the trash.", filepath.ToSlash(rel))
+	s.postChanged(nil, s.findPostFile(path))
+	return c.Redirect("/admin/trash?notice="+url.QueryEscape("Restored "+filepath.ToSlash(rel)), fiber.StatusSeeOther)
+}
+
+// handleTrashDelete removes a deleted post for good
+func (s *Server) handleTrashDelete(c *fiber.Ctx) error {
+	id := c.Params("*")
+	if err := deleteFromTrash(s.cfg.ContentDir, id); err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "Not deleted: "+err.Error())
+	}
+
+	requestLogger(c).Info("Deleted content file from trash", "entry", id)
+	s.audit(c, "Delete %s forever", id)
+	return c.Redirect("/admin/trash?notice="+url.QueryEscape("Deleted forever"), fiber.StatusSeeOther)
+}
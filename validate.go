@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// yamlLineRe extracts the line number from yaml.v2 error messages
+var yamlLineRe = regexp.MustCompile(`line (\d+)`)
+
+// requiredFields lists the frontmatter keys every post must set
+var requiredFields = []string{"title", "slug", "date"}
+
+// translationRequiredFields are the keys a translation must set, as it takes
+// the slug and the date of its original
+var translationRequiredFields = []string{"title"}
+
+// ValidationIssue is a single problem found in a content file
+type ValidationIssue struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (i ValidationIssue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", i.File, i.Line, i.Message)
+	}
+	return fmt.Sprintf("%s: %s", i.File, i.Message)
+}
+
+// translatedFile is a translation found while validating, checked against
+// its original once every file is read
+type translatedFile struct {
+	path, key string
+	slug      string
+	slugLine  int
+}
+
+// validateContent lints every post in the content tree and reports frontmatter
+// errors, missing fields, bad dates and duplicate slugs. Translations are
+// checked against their original instead. It returns an error if any problems
+// were found so it can gate commits and CI runs.
+func validateContent(out io.Writer, cfg *Config) error {
+	var issues []ValidationIssue
+	slugs := make(map[string]string)
+	// originals are the slugs of the posts translations can belong to, by
+	// the name they share with them
+	originals := make(map[string]string)
+	var translations []translatedFile
+	checked := 0
+
+	err := filepath.WalkDir(cfg.ContentDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			issues = append(issues, ValidationIssue{File: path, Message: err.Error()})
+			return nil
+		}
+		if d.IsDir() && (internalDir(d.Name()) || (cfg.Obsidian.Enabled && path != cfg.ContentDir && !vaultFolder(d.Name()))) {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".md") {
+			return nil
+		}
+
+		checked++
+		key, translation := translationFileKey(cfg, path)
+		fileIssues, slug, slugLine := validateFile(path, cfg.Obsidian.Enabled, translation)
+		issues = append(issues, fileIssues...)
+
+		if translation {
+			translations = append(translations, translatedFile{path, key, slug, slugLine})
+			return nil
+		}
+		if _, ok := originals[key]; !ok {
+			originals[key] = slug
+		}
+		if slug != "" {
+			if first, ok := slugs[slug]; ok {
+				issues = append(issues, ValidationIssue{
+					File:    path,
+					Line:    slugLine,
+					Message: fmt.Sprintf("duplicate slug %q, already used by %s", slug, first),
+				})
+			} else {
+				slugs[slug] = fmt.Sprintf("%s:%d", path, slugLine)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range translations {
+		original, ok := originals[t.key]
+		switch {
+		case !ok:
+			issues = append(issues, ValidationIssue{
+				File:    t.path,
+				Message: fmt.Sprintf("translation without an original post %s.md, so it is skipped", t.key),
+			})
+		case t.slug != "" && original != "" && t.slug != original:
+			issues = append(issues, ValidationIssue{
+				File:    t.path,
+				Line:    t.slugLine,
+				Message: fmt.Sprintf("slug %q differs from the original's %q, which translations always take", t.slug, original),
+			})
+		}
+	}
+
+	for _, issue := range issues {
+		fmt.Fprintln(out, issue)
+	}
+
+	if len(issues) > 0 {
+		return fmt.Errorf("%d problem(s) found in %d post(s)", len(issues), checked)
+	}
+
+	fmt.Fprintf(out, "All %d posts are valid\n", checked)
+	return nil
+}
+
+// translationFileKey reports whether path is a translation, like
+// my-post.de.md or de/my-post.md, and returns the name it shares with its
+// original
+func translationFileKey(cfg *Config, path string) (string, bool) {
+	languages := cfg.I18n.Languages
+	name, lang := splitLanguage(filepath.Base(path), languages)
+	rel, err := filepath.Rel(cfg.ContentDir, path)
+	if err != nil {
+		return name, false
+	}
+	switch dir := filepath.Dir(rel); {
+	case dir == "." && lang != "":
+		return name, true
+	case slices.Contains(languages, dir):
+		return strings.TrimSuffix(filepath.Base(path), ".md"), true
+	}
+	return name, false
+}
+
+// validateFile checks a single post, returning its issues along with the slug
+// and the line it was declared on so duplicates can be reported. Notes of an
+// Obsidian vault need no frontmatter, and get their slug from their name.
+// Translations only need a title.
+func validateFile(path string, vault, translation bool) ([]ValidationIssue, string, int) {
+	issue := func(line int, format string, args ...interface{}) ValidationIssue {
+		return ValidationIssue{File: path, Line: line, Message: fmt.Sprintf(format, args...)}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return []ValidationIssue{issue(0, "unreadable file: %v", err)}, "", 0
+	}
+
+	noteSlug := ""
+	if vault {
+		noteSlug = slugify(strings.TrimSuffix(filepath.Base(path), ".md"))
+		if !strings.HasPrefix(string(content), "---") {
+			return nil, noteSlug, 1
+		}
+	}
+	rawFrontmatter, _, err := splitFrontmatter(string(content))
+	if err != nil {
+		return []ValidationIssue{issue(1, "%v", err)}, "", 0
+	}
+
+	// Frontmatter begins after the opening --- and any blank lines
+	trimmed := strings.TrimLeft(rawFrontmatter, " \t\r\n")
+	startLine := 1 + strings.Count(rawFrontmatter[:len(rawFrontmatter)-len(trimmed)], "\n")
+	frontmatter := strings.TrimSpace(trimmed)
+
+	keyLine := func(key string) int {
+		for i, line := range strings.Split(frontmatter, "\n") {
+			if strings.HasPrefix(line, key+":") {
+				return startLine + i
+			}
+		}
+		return 1
+	}
+
+	var fields map[string]interface{}
+	if err := yaml.Unmarshal([]byte(frontmatter), &fields); err != nil {
+		line := 1
+		if m := yamlLineRe.FindStringSubmatch(err.Error()); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			line = startLine + n - 1
+		}
+		return []ValidationIssue{issue(line, "invalid frontmatter: %v", err)}, "", 0
+	}
+
+	required := requiredFields
+	if translation {
+		required = translationRequiredFields
+	}
+	var issues []ValidationIssue
+	for _, key := range required {
+		if v, ok := fields[key]; (!ok || v == nil || v == "") && !vault {
+			issues = append(issues, issue(1, "missing required field %q", key))
+		}
+	}
+
+	// Check the date on its own so a bad value gets pointed at its line
+	var dated struct {
+		Date time.Time `yaml:"date"`
+	}
+	if err := yaml.Unmarshal([]byte(frontmatter), &dated); err != nil {
+		issues = append(issues, issue(keyLine("date"), "unparseable date %q", fmt.Sprint(fields["date"])))
+	} else {
+		var metadata BlogMetadata
+		if err := yaml.Unmarshal([]byte(frontmatter), &metadata); err != nil {
+			issues = append(issues, issue(1, "invalid frontmatter: %v", err))
+		}
+	}
+
+	slug, _ := fields["slug"].(string)
+	if slug != "" && slug != slugify(slug) {
+		issues = append(issues, issue(keyLine("slug"), "slug %q contains characters that are not URL safe", slug))
+	}
+
+	// Aliases of vault notes that aren't paths are names wikilinks use
+	aliases, _ := fields["aliases"].([]interface{})
+	for _, alias := range aliases {
+		if s, _ := alias.(string); !strings.HasPrefix(s, "/") && !vault {
+			issues = append(issues, issue(keyLine("aliases"), "alias %q is not a path starting with /", fmt.Sprint(alias)))
+		}
+	}
+
+	if canonical, ok := fields["canonical"]; ok {
+		if s, _ := canonical.(string); !absoluteHTTPURL(s) {
+			issues = append(issues, issue(keyLine("canonical"), "canonical %q is not an absolute http or https URL", fmt.Sprint(canonical)))
+		}
+	}
+
+	syndication, _ := fields["syndication"].([]interface{})
+	for _, link := range syndication {
+		if s, _ := link.(string); !absoluteHTTPURL(s) {
+			issues = append(issues, issue(keyLine("syndication"), "syndication %q is not an absolute http or https URL", fmt.Sprint(link)))
+		}
+	}
+
+	if slug == "" {
+		return issues, noteSlug, 1
+	}
+	return issues, slug, keyLine("slug")
+}
+
+// absoluteHTTPURL reports whether s is an absolute http or https URL
+func absoluteHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Set at build time, for example:
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildTime = ""
+)
+
+// BuildInfo identifies the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// ShortCommit returns the first 7 characters of the commit hash
+func (b BuildInfo) ShortCommit() string {
+	if len(b.Commit) > 7 {
+		return b.Commit[:7]
+	}
+	return b.Commit
+}
+
+// currentBuildInfo returns the values set through ldflags, falling back to
+// the VCS information the Go toolchain embeds in module builds
+func currentBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = s.Value
+			}
+		}
+	}
+
+	return info
+}
+
+// handleVersion reports the version, commit and build time of the binary
+func (s *Server) handleVersion(c *fiber.Ctx) error {
+	return c.JSON(currentBuildInfo())
+}
+
+// buildInfoMiddleware exposes the build info to templates as .Build
+func buildInfoMiddleware(c *fiber.Ctx) error {
+	c.Locals("Build", currentBuildInfo())
+	return c.Next()
+}
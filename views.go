@@ -0,0 +1,176 @@
+package main
+
+import (
+	"html/template"
+	"reflect"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PageView is the data every public page has, embedded in the view of each
+// kind of page
+type PageView struct {
+	// Title is the page's own title, which the layout adds the site's to
+	Title string
+	Site  SiteView
+	SEO   SEOView
+	// Lang is the language of the page
+	Lang string
+}
+
+// SiteView is the site-wide config pages show, as .Site
+type SiteView struct {
+	Title           string
+	BaseURL         string
+	Author          string
+	DefaultLanguage string
+}
+
+// SEOView is what search engines and link previews learn about a page, as
+// .SEO
+type SEOView struct {
+	Description string
+	// Canonical is the URL search engines should index the page under, when
+	// it is not the page's own
+	Canonical string
+	// OpenGraph is set on the pages of posts
+	OpenGraph *openGraph
+}
+
+// ListView is the data of a listing of posts, like /blog or a tag
+type ListView struct {
+	PageView
+	// Posts are the posts of the current page
+	Posts      []*BlogPost
+	Pagination *Paginator
+	ViewCounts map[string]int
+	ShowViews  bool
+	// Tag is the tag a tag listing is for
+	Tag string
+	// LanguagePrefix and DefaultLanguageName are set on the listing of a
+	// language other than the default, which links to its translations
+	LanguagePrefix      string
+	DefaultLanguageName string
+}
+
+// IndexView is the data of the home page, a listing of the newest posts
+type IndexView struct {
+	ListView
+}
+
+// ArchiveView is the data of archive.html, the years and months with posts
+type ArchiveView struct {
+	PageView
+	Years []archiveYear
+}
+
+// PostView is the data of the page of a post
+type PostView struct {
+	PageView
+	Post        *BlogPost
+	Languages   []languageLink
+	Syndication []syndicationLink
+	Views       *int
+	Likes       *int
+	Mentions    PostMentions
+	Comments    []*Comment
+	// MissingLanguage is the language the post was asked for in while it is
+	// not translated to it, and DefaultLanguageName the one it is shown in
+	MissingLanguage     string
+	DefaultLanguageName string
+
+	// The comment form, when the post takes comments
+	CommentsOpen    bool
+	CommentFeed     string
+	CommentToken    string
+	CommentAwaiting bool
+	ReplyTo         *Comment
+	NotifyReplies   bool
+	CommenterSignIn bool
+	Commenter       string
+	CommentEmbed    template.HTML
+}
+
+// ErrorView is the data of error.html, the page shown when a request fails
+type ErrorView struct {
+	PageView
+	// Code is the HTTP status of the response
+	Code int
+	// Message tells the visitor what went wrong, when the handler said
+	Message string
+	// Detail is what went wrong, only shown in development
+	Detail    string
+	RequestID string
+}
+
+// pageView returns the data every page starts with
+func (s *Server) pageView(c *fiber.Ctx, title string) PageView {
+	lang, _ := c.Locals("Lang").(string)
+	return PageView{
+		Title: title,
+		Site: SiteView{
+			Title:           s.cfg.Title,
+			BaseURL:         s.cfg.BaseURL,
+			Author:          s.cfg.Author,
+			DefaultLanguage: s.cfg.I18n.DefaultLanguage,
+		},
+		Lang: lang,
+	}
+}
+
+// listView returns the page of a listing of posts at base the request asks
+// for. It returns fiber.ErrNotFound for a page past the last one.
+func (s *Server) listView(c *fiber.Ctx, title, base string, posts []*BlogPost) (ListView, error) {
+	pagination, posts, err := s.paginate(c, base, posts)
+	if err != nil {
+		return ListView{}, err
+	}
+	return ListView{
+		PageView:   s.pageView(c, title),
+		Posts:      posts,
+		Pagination: pagination,
+		ViewCounts: s.listingViews(c, posts),
+		ShowViews:  s.cfg.Analytics.PublicCounts,
+	}, nil
+}
+
+// renderView renders a page inside the layout with its view
+func renderView(c *fiber.Ctx, name string, view interface{}) error {
+	return c.Render(name, viewMap(view), "layout")
+}
+
+// viewMap turns a view into the map templates are rendered with, keyed by
+// the names of its fields and those of the views it embeds, like .Posts and
+// .Title. The views are typed for the handlers filling them in, but pages
+// are rendered from a map so that the locals set by middleware, like
+// .Locale, and the layout's blocks still reach them.
+func viewMap(view interface{}) fiber.Map {
+	m := fiber.Map{}
+	addViewFields(m, reflect.Indirect(reflect.ValueOf(view)))
+	return m
+}
+
+// addViewFields adds the fields of v to m. As in Go, a field of v hides one
+// of the same name in a struct it embeds.
+func addViewFields(m fiber.Map, v reflect.Value) {
+	t := v.Type()
+	var embedded []reflect.Value
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		switch {
+		case f.Anonymous && f.Type.Kind() == reflect.Struct:
+			embedded = append(embedded, v.Field(i))
+		case f.IsExported():
+			m[f.Name] = v.Field(i).Interface()
+		}
+	}
+	for _, e := range embedded {
+		inner := fiber.Map{}
+		addViewFields(inner, e)
+		for name, value := range inner {
+			if _, ok := m[name]; !ok {
+				m[name] = value
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestViewMap(t *testing.T) {
+	type shadowing struct {
+		PageView
+		Title string
+	}
+	type unexported struct {
+		PageView
+		hidden string
+	}
+	tests := []struct {
+		name string
+		view interface{}
+		want fiber.Map
+	}{
+		{
+			name: "embedded view",
+			view: ErrorView{PageView: PageView{Title: "Oops", Lang: "de"}, Code: 500, RequestID: "abc"},
+			want: fiber.Map{"Title": "Oops", "Lang": "de", "Site": SiteView{}, "SEO": SEOView{},
+				"Code": 500, "Message": "", "Detail": "", "RequestID": "abc"},
+		},
+		{
+			name: "pointer",
+			view: &ArchiveView{PageView: PageView{Title: "Archive"}},
+			want: fiber.Map{"Title": "Archive", "Lang": "", "Site": SiteView{}, "SEO": SEOView{}, "Years": []archiveYear(nil)},
+		},
+		{
+			name: "own field hides the embedded one",
+			view: shadowing{PageView: PageView{Title: "inner"}, Title: "outer"},
+			want: fiber.Map{"Title": "outer", "Lang": "", "Site": SiteView{}, "SEO": SEOView{}},
+		},
+		{
+			name: "unexported fields are left out",
+			view: unexported{hidden: "x"},
+			want: fiber.Map{"Title": "", "Lang": "", "Site": SiteView{}, "SEO": SEOView{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := viewMap(tt.view); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("viewMap() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	// Views embedding views embedding PageView reach all the way down
+	index := viewMap(IndexView{ListView: ListView{PageView: PageView{Title: "Home"}, Tag: "go"}})
+	if index["Title"] != "Home" || index["Tag"] != "go" {
+		t.Errorf("viewMap(IndexView) = %#v, want .Title and .Tag", index)
+	}
+}
+
+func TestPageView(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Title = "Test Blog"
+	cfg.BaseURL = "https://blog.example.com"
+	cfg.Author = "Jane"
+	cfg.I18n.DefaultLanguage = "en"
+	s := &Server{cfg: cfg}
+
+	tests := []struct {
+		name string
+		lang interface{}
+		want string
+	}{
+		{"language of the page", "de", "de"},
+		{"no language", nil, ""},
+		{"not a string", 42, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got PageView
+			app := fiber.New()
+			app.Get("/", func(c *fiber.Ctx) error {
+				if tt.lang != nil {
+					c.Locals("Lang", tt.lang)
+				}
+				got = s.pageView(c, "Hello")
+				return nil
+			})
+			if _, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil)); err != nil {
+				t.Fatal(err)
+			}
+			want := PageView{
+				Title: "Hello",
+				Site:  SiteView{Title: "Test Blog", BaseURL: "https://blog.example.com", Author: "Jane", DefaultLanguage: "en"},
+				Lang:  tt.want,
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("pageView() = %#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestListView(t *testing.T) {
+	var posts []*BlogPost
+	for i := 1; i <= 5; i++ {
+		posts = append(posts, &BlogPost{Slug: fmt.Sprintf("post-%d", i)})
+	}
+	tests := []struct {
+		name      string
+		perPage   int
+		path      string
+		wantSlugs []string
+		wantPrev  string
+		wantNext  string
+		wantErr   error
+	}{
+		{"one page", 0, "/blog", []string{"post-1", "post-2", "post-3", "post-4", "post-5"}, "", "", nil},
+		{"first page", 2, "/blog", []string{"post-1", "post-2"}, "", "/blog/page/2", nil},
+		{"middle page", 2, "/blog/page/2", []string{"post-3", "post-4"}, "/blog", "/blog/page/3", nil},
+		{"last page", 2, "/blog/page/3", []string{"post-5"}, "/blog/page/2", "", nil},
+		{"past the last page", 2, "/blog/page/4", nil, "", "", fiber.ErrNotFound},
+		{"page 0", 2, "/blog/page/0", nil, "", "", fiber.ErrNotFound},
+		{"not a number", 2, "/blog/page/two", nil, "", "", fiber.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.PostsPerPage = tt.perPage
+			s := &Server{cfg: cfg}
+
+			var got ListView
+			var gotErr error
+			app := fiber.New()
+			handler := func(c *fiber.Ctx) error {
+				got, gotErr = s.listView(c, "All Blog Posts", "/blog", posts)
+				return nil
+			}
+			app.Get("/blog", handler)
+			app.Get("/blog/page/:page", handler)
+			if _, err := app.Test(httptest.NewRequest(fiber.MethodGet, tt.path, nil)); err != nil {
+				t.Fatal(err)
+			}
+
+			if gotErr != tt.wantErr {
+				t.Fatalf("listView() error = %v, want %v", gotErr, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			var slugs []string
+			for _, post := range got.Posts {
+				slugs = append(slugs, post.Slug)
+			}
+			if !reflect.DeepEqual(slugs, tt.wantSlugs) {
+				t.Errorf("posts = %v, want %v", slugs, tt.wantSlugs)
+			}
+			if got.Title != "All Blog Posts" || got.Pagination.Total != len(posts) {
+				t.Errorf("title %q and total %d, want All Blog Posts and %d", got.Title, got.Pagination.Total, len(posts))
+			}
+			if got.Pagination.Prev != tt.wantPrev || got.Pagination.Next != tt.wantNext {
+				t.Errorf("prev %q and next %q, want %q and %q", got.Pagination.Prev, got.Pagination.Next, tt.wantPrev, tt.wantNext)
+			}
+		})
+	}
+}
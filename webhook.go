@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// registerHookRoutes wires up webhooks from content hosts. The GitHub hook
+// does not exist while no secret is configured.
+func (s *Server) registerHookRoutes() {
+	if s.cfg.Git.WebhookSecret == "" {
+		return
+	}
+	s.app.Post("/hooks/github", s.handleGitHubHook)
+}
+
+// signBody returns the webhook signature of body, sha256=<hex HMAC-SHA256>
+func signBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validGitHubSignature checks the X-Hub-Signature-256 header against the
+// webhook secret
+func validGitHubSignature(secret string, body []byte, header string) bool {
+	return hmac.Equal([]byte(header), []byte(signBody(secret, body)))
+}
+
+// handleGitHubHook pulls the content repository and re-indexes it when
+// GitHub reports a push. Pushes to other branches than git.branch are
+// ignored when one is configured.
+func (s *Server) handleGitHubHook(c *fiber.Ctx) error {
+	if !validGitHubSignature(s.cfg.Git.WebhookSecret, c.Body(), c.Get("X-Hub-Signature-256")) {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid signature")
+	}
+
+	switch event := c.Get("X-GitHub-Event"); event {
+	case "ping":
+		return c.JSON(fiber.Map{"status": "pong"})
+	case "push":
+	default:
+		return c.JSON(fiber.Map{"status": "ignored", "event": event})
+	}
+
+	var push struct {
+		Ref   string `json:"ref"`
+		After string `json:"after"`
+	}
+	if err := json.Unmarshal(c.Body(), &push); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid push payload")
+	}
+	if branch := s.cfg.Git.Branch; branch != "" && push.Ref != "refs/heads/"+branch {
+		return c.JSON(fiber.Map{"status": "ignored", "ref": push.Ref})
+	}
+
+	repo := s.repo
+	if repo == nil {
+		repo = &contentRepo{dir: s.cfg.ContentDir, remote: s.cfg.Git.Remote, branch: s.cfg.Git.Branch}
+	}
+	if err := repo.Pull(c.UserContext()); err != nil {
+		return s.internalError(c, "Pull failed", err)
+	}
+	if err := s.reloadContent(); err != nil {
+		return s.internalError(c, "Pulled, but re-indexing failed", err)
+	}
+
+	_, count, _ := s.content.Status()
+	requestLogger(c).Info("Pulled content from GitHub", "ref", push.Ref, "commit", push.After, "posts", count)
+	s.auditAs(c, "github", "Pull %s at %s", push.Ref, push.After)
+	return c.JSON(fiber.Map{"status": "pulled", "posts": count})
+}
+
+// Pull fast-forwards the content repository from its remote: git.remote and
+// git.branch when set, otherwise the current branch's upstream
+func (r *contentRepo) Pull(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	args := []string{"pull", "-q", "--ff-only"}
+	if r.remote != "" {
+		args = append(args, r.remote)
+		if r.branch != "" {
+			args = append(args, r.branch)
+		}
+	}
+	_, err := r.git(ctx, nil, args...)
+	return err
+}
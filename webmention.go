@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/net/html"
+	"willnorris.com/go/microformats"
+)
+
+// WebmentionConfig enables receiving Webmentions, which other sites send
+// when they link to a post
+type WebmentionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Database is the SQLite file mentions are stored in
+	Database string `yaml:"database"`
+}
+
+// Webmention types, from the microformats properties of the source
+const (
+	MentionMention  = "mention"
+	MentionReply    = "reply"
+	MentionLike     = "like"
+	MentionRepost   = "repost"
+	MentionBookmark = "bookmark"
+)
+
+// maxMentionSource is the most of a source page that is read
+const maxMentionSource = 1 << 20
+
+// maxMentionContent is how much of a mention's text is kept, in characters
+const maxMentionContent = 500
+
+// Mention is a verified Webmention of a post
+type Mention struct {
+	ID          int64
+	Post        string
+	Source      string
+	Target      string
+	Type        string
+	AuthorName  string
+	AuthorURL   string
+	AuthorPhoto string
+	Content     string
+	// URL is the mentioning post, which may differ from the source
+	URL       string
+	Published time.Time
+}
+
+// PostMentions groups the mentions shown under a post
+type PostMentions struct {
+	Likes     []*Mention
+	Reposts   []*Mention
+	Bookmarks []*Mention
+	// Replies holds replies and plain mentions, which both have content
+	Replies []*Mention
+}
+
+// Count returns the number of mentions in every group
+func (m PostMentions) Count() int {
+	return len(m.Likes) + len(m.Reposts) + len(m.Bookmarks) + len(m.Replies)
+}
+
+// webmentionMigrations create and upgrade the mentions database
+var webmentionMigrations = []string{
+	`CREATE TABLE webmentions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post TEXT NOT NULL,
+		source TEXT NOT NULL,
+		target TEXT NOT NULL,
+		type TEXT NOT NULL,
+		author_name TEXT NOT NULL DEFAULT '',
+		author_url TEXT NOT NULL DEFAULT '',
+		author_photo TEXT NOT NULL DEFAULT '',
+		content TEXT NOT NULL DEFAULT '',
+		url TEXT NOT NULL,
+		published TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		UNIQUE (source, target)
+	);
+	CREATE INDEX webmentions_post ON webmentions (post);`,
+}
+
+// mentionStore keeps verified mentions in SQLite
+type mentionStore struct {
+	db *sql.DB
+}
+
+// newMentionStore returns the mention store of cfg, or nil when Webmention
+// is disabled
+func newMentionStore(cfg *Config) (*mentionStore, error) {
+	if !cfg.Webmention.Enabled {
+		return nil, nil
+	}
+	db, err := openDatabase(cfg.Webmention.Database, webmentionMigrations)
+	if err != nil {
+		return nil, err
+	}
+	return &mentionStore{db: db}, nil
+}
+
+// Save stores a mention, replacing an earlier one with the same source and
+// target
+func (s *mentionStore) Save(m *Mention) error {
+	_, err := s.db.Exec(`INSERT INTO webmentions (post, source, target, type, author_name, author_url, author_photo, content, url, published, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (source, target) DO UPDATE SET post = excluded.post, type = excluded.type,
+			author_name = excluded.author_name, author_url = excluded.author_url, author_photo = excluded.author_photo,
+			content = excluded.content, url = excluded.url, published = excluded.published, updated_at = excluded.updated_at`,
+		m.Post, m.Source, m.Target, m.Type, m.AuthorName, m.AuthorURL, m.AuthorPhoto, m.Content, m.URL, m.Published.UTC(), time.Now().UTC())
+	return err
+}
+
+// Delete removes the mention of target by source, if there is one
+func (s *mentionStore) Delete(source, target string) error {
+	_, err := s.db.Exec("DELETE FROM webmentions WHERE source = ? AND target = ?", source, target)
+	return err
+}
+
+// ForPost returns the mentions of a post grouped by type, oldest first
+func (s *mentionStore) ForPost(post string) (PostMentions, error) {
+	var grouped PostMentions
+	rows, err := s.db.Query(`SELECT id, post, source, target, type, author_name, author_url, author_photo, content, url, published
+		FROM webmentions WHERE post = ? ORDER BY published, id`, post)
+	if err != nil {
+		return grouped, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m Mention
+		err := rows.Scan(&m.ID, &m.Post, &m.Source, &m.Target, &m.Type, &m.AuthorName, &m.AuthorURL, &m.AuthorPhoto, &m.Content, &m.URL, &m.Published)
+		if err != nil {
+			return grouped, err
+		}
+		switch m.Type {
+		case MentionLike:
+			grouped.Likes = append(grouped.Likes, &m)
+		case MentionRepost:
+			grouped.Reposts = append(grouped.Reposts, &m)
+		case MentionBookmark:
+			grouped.Bookmarks = append(grouped.Bookmarks, &m)
+		default:
+			grouped.Replies = append(grouped.Replies, &m)
+		}
+	}
+	return grouped, rows.Err()
+}
+
+// registerWebmentionRoutes wires up the receiving endpoint
+func (s *Server) registerWebmentionRoutes() {
+	if s.mentions == nil {
+		return
+	}
+	s.app.Post("/webmention", s.handleWebmention)
+}
+
+// postMentions returns the mentions shown under a post
+func (s *Server) postMentions(c *fiber.Ctx, post *BlogPost) PostMentions {
+	if s.mentions == nil {
+		return PostMentions{}
+	}
+	mentions, err := s.mentions.ForPost(post.Slug)
+	if err != nil {
+		requestLogger(c).Error("Error loading webmentions", "slug", post.Slug, "error", err)
+	}
+	return mentions
+}
+
+// handleWebmention accepts a Webmention after checking that its target is
+// a post here. The source is fetched and verified in the background, as the
+// spec recommends, so the sender gets 202 Accepted right away.
+func (s *Server) handleWebmention(c *fiber.Ctx) error {
+	source, err := url.Parse(c.FormValue("source"))
+	if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Host == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "source must be an http(s) URL")
+	}
+	target, err := url.Parse(c.FormValue("target"))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return fiber.NewError(fiber.StatusBadRequest, "target must be an http(s) URL")
+	}
+	if source.String() == target.String() {
+		return fiber.NewError(fiber.StatusBadRequest, "source and target are the same")
+	}
+
+	post := s.mentionedPost(c, target)
+	if post == nil {
+		return fiber.NewError(fiber.StatusBadRequest, "target is not a post on this site")
+	}
+	if !s.mentionLimit.Allow(c.IP()) {
+		return fiber.NewError(fiber.StatusTooManyRequests, "Too many webmentions, please try again later")
+	}
+
+	log := requestLogger(c).With("source", source.String(), "target", target.String())
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.verifyWebmention(ctx, source, target, post.Slug); err != nil {
+			log.Warn("Webmention not verified", "error", err)
+		}
+	}()
+	return c.Status(fiber.StatusAccepted).SendString("Accepted, the source will be verified shortly")
+}
+
+// mentionedPost returns the published post a target URL points at, which
+// has to be on this site's host
+func (s *Server) mentionedPost(c *fiber.Ctx, target *url.URL) *BlogPost {
+	host := c.Hostname()
+	if base, err := url.Parse(s.cfg.BaseURL); err == nil && base.Host != "" {
+		host = base.Host
+	}
+	if !strings.EqualFold(target.Host, host) {
+		return nil
+	}
+	slug, ok := strings.CutPrefix(strings.TrimSuffix(target.Path, "/"), "/blog/")
+	if !ok || slug == "" || strings.Contains(slug, "/") {
+		return nil
+	}
+	post, ok := s.content.Post(slug)
+	if !ok {
+		return nil
+	}
+	return post
+}
+
+// verifyWebmention fetches the source and stores the mention if it links to
+// target. A source that is gone or no longer links removes the mention.
+func (s *Server) verifyWebmention(ctx context.Context, source, target *url.URL, slug string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/html, */*;q=0.5")
+	req.Header.Set("User-Agent", "DevDaze/"+currentBuildInfo().Version+" (webmention)")
+
+	resp, err := s.remote.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound {
+		return s.mentions.Delete(source.String(), target.String())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("source returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMentionSource))
+	if err != nil {
+		return err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get(fiber.HeaderContentType))
+	isHTML := mediaType == "text/html" || mediaType == "application/xhtml+xml"
+	var linked bool
+	if isHTML {
+		linked = linksTo(body, resp.Request.URL, target.String())
+	} else {
+		linked = bytes.Contains(body, []byte(target.String()))
+	}
+	if !linked {
+		if err := s.mentions.Delete(source.String(), target.String()); err != nil {
+			return err
+		}
+		return fmt.Errorf("source does not link to target")
+	}
+
+	mention := &Mention{
+		Post:      slug,
+		Source:    source.String(),
+		Target:    target.String(),
+		Type:      MentionMention,
+		URL:       source.String(),
+		Published: time.Now(),
+	}
+	if isHTML {
+		parseMention(mention, microformats.Parse(bytes.NewReader(body), resp.Request.URL))
+	}
+	if err := s.mentions.Save(mention); err != nil {
+		return err
+	}
+	slog.Info("Received webmention", "slug", slug, "source", mention.Source, "type", mention.Type)
+	return nil
+}
+
+// newRemoteClient creates the client that fetches pages and documents named
+// by other sites. Outside development it refuses to connect to loopback and
+// private addresses, so mentions and fediverse requests cannot be used to
+// probe the server's own network. The server keeps one, so its connections
+// are reused.
+func newRemoteClient(development bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !development {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+				return fmt.Errorf("refusing to fetch from %s", host)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:   20 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+}
+
+// linksTo reports whether an HTML page has a link, image or other embed
+// pointing at target
+func linksTo(page []byte, base *url.URL, target string) bool {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return false
+	}
+
+	var found bool
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if found {
+			return
+		}
+		if n.Type == html.ElementNode {
+			for _, attr := range n.Attr {
+				if attr.Key != "href" && attr.Key != "src" {
+					continue
+				}
+				if ref, err := base.Parse(strings.TrimSpace(attr.Val)); err == nil && sameURL(ref.String(), target) {
+					found = true
+					return
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return found
+}
+
+// sameURL compares URLs ignoring a trailing slash
+func sameURL(a, b string) bool {
+	return strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
+
+// parseMention fills in a mention from the first h-entry of the source
+func parseMention(m *Mention, data *microformats.Data) {
+	entry := findEntry(data.Items)
+	if entry == nil {
+		return
+	}
+
+	for _, kind := range []struct{ property, kind string }{
+		{"like-of", MentionLike},
+		{"repost-of", MentionRepost},
+		{"bookmark-of", MentionBookmark},
+		{"in-reply-to", MentionReply},
+	} {
+		for _, v := range entry.Properties[kind.property] {
+			if sameURL(mfURL(v), m.Target) {
+				m.Type = kind.kind
+			}
+		}
+		if m.Type != MentionMention {
+			break
+		}
+	}
+
+	if u := mfString(entry, "url"); u != "" {
+		m.URL = u
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05-0700", "2006-01-02 15:04:05-07:00", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, mfString(entry, "published")); err == nil {
+			m.Published = t
+			break
+		}
+	}
+
+	content := mfString(entry, "content")
+	if content == "" {
+		content = mfString(entry, "summary")
+	}
+	if runes := []rune(strings.TrimSpace(content)); len(runes) > maxMentionContent {
+		content = string(runes[:maxMentionContent]) + "…"
+	}
+	m.Content = strings.TrimSpace(content)
+
+	if authors := entry.Properties["author"]; len(authors) > 0 {
+		switch author := authors[0].(type) {
+		case *microformats.Microformat:
+			m.AuthorName = mfString(author, "name")
+			if m.AuthorName == "" {
+				m.AuthorName = author.Value
+			}
+			m.AuthorURL = mfString(author, "url")
+			m.AuthorPhoto = mfString(author, "photo")
+		case string:
+			if strings.HasPrefix(author, "http") {
+				m.AuthorURL = author
+			} else {
+				m.AuthorName = author
+			}
+		}
+	}
+	if m.AuthorName == "" {
+		m.AuthorName = m.AuthorURL
+	}
+}
+
+// findEntry returns the first h-entry among items and their children
+func findEntry(items []*microformats.Microformat) *microformats.Microformat {
+	for _, item := range items {
+		for _, t := range item.Type {
+			if t == "h-entry" {
+				return item
+			}
+		}
+		if entry := findEntry(item.Children); entry != nil {
+			return entry
+		}
+	}
+	return nil
+}
+
+// mfString returns the text of an item's first value for a property
+func mfString(item *microformats.Microformat, property string) string {
+	values := item.Properties[property]
+	if len(values) == 0 {
+		return ""
+	}
+	switch v := values[0].(type) {
+	case string:
+		return v
+	case map[string]string:
+		return v["value"]
+	case *microformats.Microformat:
+		return v.Value
+	}
+	return ""
+}
+
+// mfURL returns the URL a property value refers to, which is either the
+// value itself or the url of a nested item
+func mfURL(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case *microformats.Microformat:
+		if u := mfString(v, "url"); u != "" {
+			return u
+		}
+		return v.Value
+	}
+	return ""
+}
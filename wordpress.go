@@ -0,0 +1,495 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// wxrExport is the XML file WordPress exports a site as, under Tools, Export.
+// Fields are matched by their local name, as the wp namespace changes with
+// the version of the format.
+type wxrExport struct {
+	Channel struct {
+		Link        string      `xml:"link"`
+		BaseSiteURL string      `xml:"base_site_url"`
+		Authors     []wxrAuthor `xml:"author"`
+		Items       []wxrItem   `xml:"item"`
+	} `xml:"channel"`
+}
+
+// wxrAuthor is a user of the site that wrote posts
+type wxrAuthor struct {
+	Login       string `xml:"author_login"`
+	DisplayName string `xml:"author_display_name"`
+}
+
+// wxrItem is a post, page or attachment of the export
+type wxrItem struct {
+	Title    string `xml:"title"`
+	Link     string `xml:"link"`
+	Creator  string `xml:"creator"`
+	ID       string `xml:"post_id"`
+	Date     string `xml:"post_date"`
+	DateGMT  string `xml:"post_date_gmt"`
+	Name     string `xml:"post_name"`
+	Status   string `xml:"status"`
+	Type     string `xml:"post_type"`
+	Password string `xml:"post_password"`
+	// Encoded holds both the content and the excerpt, told apart by their
+	// namespace
+	Encoded    []wxrText     `xml:"encoded"`
+	Categories []wxrCategory `xml:"category"`
+	Meta       []wxrMeta     `xml:"postmeta"`
+}
+
+// wxrText is an element whose namespace matters
+type wxrText struct {
+	XMLName xml.Name
+	Text    string `xml:",chardata"`
+}
+
+// wxrCategory is a category or tag of a post
+type wxrCategory struct {
+	Domain   string `xml:"domain,attr"`
+	Nicename string `xml:"nicename,attr"`
+	Name     string `xml:",chardata"`
+}
+
+// wxrMeta is a custom field of a post
+type wxrMeta struct {
+	Key   string `xml:"meta_key"`
+	Value string `xml:"meta_value"`
+}
+
+// wxrContent returns the body and the excerpt of an item
+func (item wxrItem) wxrContent() (string, string) {
+	var content, excerpt string
+	for _, e := range item.Encoded {
+		if strings.Contains(e.XMLName.Space, "excerpt") {
+			excerpt = e.Text
+		} else {
+			content = e.Text
+		}
+	}
+	return content, excerpt
+}
+
+// wordpressCaption matches the caption shortcode the classic editor wraps
+// images in
+var wordpressCaption = regexp.MustCompile(`(?s)\[caption[^\]]*\](.*?)\[/caption\]`)
+
+// wordpressShortcode matches the core shortcodes that have no markdown
+// equivalent
+var wordpressShortcode = regexp.MustCompile(`\[(?:gallery|embed|audio|video|playlist)\b`)
+
+// readWordPress reads the published, scheduled and draft posts of a WordPress
+// export, and returns the function that downloads the media of the site they
+// show and points their links to other posts at the imported ones
+func (imp *siteImporter) readWordPress() (func(*importedPost) string, error) {
+	f, err := os.Open(imp.opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var export wxrExport
+	if err := xml.NewDecoder(f).Decode(&export); err != nil {
+		return nil, fmt.Errorf("error reading WordPress export: %v", err)
+	}
+	site := export.Channel.BaseSiteURL
+	if site == "" {
+		site = export.Channel.Link
+	}
+	base, err := url.Parse(strings.TrimSpace(site))
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("the WordPress export has no site URL")
+	}
+
+	authors := make(map[string]string)
+	for _, a := range export.Channel.Authors {
+		authors[a.Login] = a.DisplayName
+	}
+
+	skipped := 0
+	for _, item := range export.Channel.Items {
+		if item.Type != "post" {
+			continue
+		}
+		p := imp.wordpressPost(item, authors)
+		if p == nil {
+			skipped++
+			continue
+		}
+		imp.posts = append(imp.posts, p)
+	}
+	if skipped > 0 {
+		slog.Info("Skipped posts that are trashed or were never saved", "count", skipped)
+	}
+	if len(imp.posts) == 0 {
+		return nil, fmt.Errorf("no posts found in %s", imp.opts.Path)
+	}
+
+	// Links to old URLs of imported posts point to their new URL
+	byAlias := make(map[string]string)
+	for _, p := range imp.posts {
+		for _, alias := range p.post.Aliases {
+			byAlias[alias] = postPath(p.post)
+		}
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	return func(p *importedPost) string {
+		body := htmlToMarkdown(wordpressCaption.ReplaceAllString(p.post.Content, "$1"))
+		var b strings.Builder
+		last := 0
+		for _, m := range assetRef.FindAllStringSubmatchIndex(body, -1) {
+			b.WriteString(body[last:m[2]])
+			b.WriteString(imp.wordpressURL(client, base, byAlias, body[m[2]:m[3]]))
+			last = m[3]
+		}
+		b.WriteString(body[last:])
+
+		if wordpressShortcode.MatchString(b.String()) {
+			slog.Warn("Post keeps shortcodes that have to be converted by hand", "file", p.file)
+		}
+		return b.String()
+	}, nil
+}
+
+// wordpressPost converts a post of the export, or returns nil for one that
+// was trashed or never saved
+func (imp *siteImporter) wordpressPost(item wxrItem, authors map[string]string) *importedPost {
+	var draft bool
+	switch item.Status {
+	case "publish", "future":
+	case "draft", "pending", "private":
+		draft = true
+	default:
+		return nil
+	}
+
+	date, err := time.Parse("2006-01-02 15:04:05", item.DateGMT)
+	if err != nil || date.Year() < 1900 {
+		date, err = time.ParseInLocation("2006-01-02 15:04:05", item.Date, imp.cfg.Location())
+		if err != nil {
+			date = time.Now()
+		}
+	}
+	date = date.In(imp.cfg.Location())
+
+	title := strings.TrimSpace(html.UnescapeString(item.Title))
+	name, _ := url.PathUnescape(item.Name)
+	slug := slugify(name)
+	if slug == "" {
+		slug = slugify(title)
+	}
+	if slug == "" {
+		slug = "post-" + item.ID
+	}
+
+	var tags []string
+	for _, c := range item.Categories {
+		if (c.Domain == "category" && c.Nicename != "uncategorized") || c.Domain == "post_tag" {
+			tags = mergeTags(tags, []string{strings.TrimSpace(c.Name)})
+		}
+	}
+	author := authors[item.Creator]
+	if author == "" {
+		author = item.Creator
+	}
+	content, excerpt := item.wxrContent()
+
+	post := &BlogPost{
+		Title:       title,
+		Date:        date,
+		Author:      author,
+		Description: strings.TrimSpace(disqusText(excerpt)),
+		Tags:        tags,
+		Slug:        slug,
+		Draft:       draft || item.Password != "",
+		Content:     content,
+	}
+	if post.Title == "" {
+		post.Title = slug
+	}
+
+	// The old URL, and those of the slugs the post had before
+	var old []string
+	if u, err := url.Parse(strings.TrimSpace(item.Link)); err == nil && u.RawQuery == "" && u.Path != "" && !draft {
+		old = append(old, u.Path)
+		for _, meta := range item.Meta {
+			if meta.Key == "_wp_old_slug" && meta.Value != "" && item.Name != "" {
+				old = append(old, strings.Replace(u.Path, "/"+item.Name, "/"+meta.Value, 1))
+			}
+		}
+	}
+	if len(old) > 0 {
+		post.Aliases = importAliases(post, old[0], old[1:], "/")
+	}
+
+	return &importedPost{post: post, file: imp.opts.Path + "#" + item.ID}
+}
+
+// wordpressURL downloads the media file of the site a URL points to and
+// returns the URL of the copy, or maps an old URL of an imported post to its
+// new one. Other URLs are returned as they are.
+func (imp *siteImporter) wordpressURL(client *http.Client, base *url.URL, byAlias map[string]string, ref string) string {
+	u, err := base.Parse(ref)
+	if err != nil || strings.TrimPrefix(u.Host, "www.") != strings.TrimPrefix(base.Host, "www.") {
+		return ref
+	}
+	if target, ok := byAlias[aliasPath(u.Path)]; ok {
+		if u.Fragment != "" {
+			return target + "#" + u.Fragment
+		}
+		return target
+	}
+	if !mediaExtensions[strings.ToLower(path.Ext(u.Path))] {
+		return ref
+	}
+
+	src := u.Scheme + "://" + u.Host + u.Path
+	if copied, ok := imp.copied[src]; ok {
+		return copied
+	}
+	rel := strings.TrimPrefix(u.Path, "/")
+	if _, uploads, ok := strings.Cut(u.Path, "/wp-content/uploads/"); ok {
+		rel = uploads
+	}
+	rel = path.Clean(rel)
+	if strings.HasPrefix(rel, "..") {
+		return ref
+	}
+
+	if !imp.opts.DryRun {
+		if err := downloadFile(client, src, filepath.Join(imp.cfg.Media.Dir, filepath.FromSlash(rel))); err != nil {
+			slog.Warn("Error downloading media", "url", src, "error", err)
+			return ref
+		}
+	}
+	copied := strings.TrimSuffix(imp.cfg.Media.URL, "/") + "/" + rel
+	imp.copied[src] = copied
+	return copied
+}
+
+// downloadFile saves the file at src to target, creating its directory
+func downloadFile(client *http.Client, src, target string) error {
+	resp, err := client.Get(src)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return writeOutputFile(filepath.Dir(target), filepath.Base(target), data)
+}
+
+// rawHTMLElements are kept as HTML by htmlToMarkdown, as markdown has no
+// syntax for them
+var rawHTMLElements = map[string]bool{
+	"table": true, "iframe": true, "video": true, "audio": true, "object": true,
+	"embed": true, "svg": true, "form": true, "details": true, "dl": true,
+}
+
+// htmlToMarkdown converts the HTML of a post to markdown. Blank lines in text
+// separate paragraphs, like WordPress renders them, and elements without a
+// markdown equivalent stay HTML.
+func htmlToMarkdown(content string) string {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(content), context)
+	if err != nil {
+		return content
+	}
+	var b strings.Builder
+	for _, n := range nodes {
+		b.WriteString(markdownNode(n))
+	}
+	text := strings.ReplaceAll(b.String(), "\u00a0", " ")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if !strings.HasSuffix(line, "  ") || strings.TrimSpace(line) == "" {
+			lines[i] = strings.TrimRight(line, " \t")
+		}
+	}
+	return strings.TrimSpace(collapseBlankLines(strings.Join(lines, "\n")))
+}
+
+// markdownEscaper escapes the characters of text that markdown would read as
+// formatting
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`")
+
+// paragraphBreak matches the blank lines between paragraphs of text
+var paragraphBreak = regexp.MustCompile(`\s*\n\s*\n\s*`)
+
+// nestedList matches the start of a list inside a list item along with the
+// space before it
+var nestedList = regexp.MustCompile(`\s*\x00`)
+
+// spaceRun matches the whitespace HTML collapses into a space
+var spaceRun = regexp.MustCompile(`\s+`)
+
+// collapseSpace turns a run of whitespace into the line break or space
+// markdown reads it as
+func collapseSpace(run string) string {
+	if strings.Contains(run, "\n") {
+		return "\n"
+	}
+	return " "
+}
+
+// markdownNode converts a node and its children to markdown
+func markdownNode(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		paragraphs := paragraphBreak.Split(n.Data, -1)
+		for i, p := range paragraphs {
+			paragraphs[i] = markdownEscaper.Replace(spaceRun.ReplaceAllStringFunc(p, collapseSpace))
+		}
+		return strings.Join(paragraphs, "\n\n")
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	if rawHTMLElements[n.Data] {
+		var b strings.Builder
+		html.Render(&b, n)
+		return "\n\n" + b.String() + "\n\n"
+	}
+
+	inner := func() string {
+		var b strings.Builder
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			b.WriteString(markdownNode(c))
+		}
+		return b.String()
+	}
+	switch n.Data {
+	case "script", "style", "noscript":
+		return ""
+	case "p", "div", "section", "article", "figure", "header", "footer", "main", "aside":
+		return "\n\n" + strings.TrimSpace(inner()) + "\n\n"
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level, _ := strconv.Atoi(n.Data[1:])
+		return "\n\n" + strings.Repeat("#", level) + " " + strings.TrimSpace(spaceRun.ReplaceAllString(inner(), " ")) + "\n\n"
+	case "br":
+		return "  \n"
+	case "hr":
+		return "\n\n---\n\n"
+	case "strong", "b":
+		return wrapInline(inner(), "**")
+	case "em", "i":
+		return wrapInline(inner(), "*")
+	case "del", "s":
+		return wrapInline(inner(), "~~")
+	case "code":
+		return "`" + rawText(n) + "`"
+	case "pre":
+		return "\n\n```\n" + strings.Trim(rawText(n), "\n") + "\n```\n\n"
+	case "figcaption":
+		return "\n\n" + wrapInline(strings.TrimSpace(inner()), "*") + "\n\n"
+	case "a":
+		href := attr(n, "href")
+		text := strings.TrimSpace(inner())
+		if href == "" || text == "" {
+			return text
+		}
+		if title := attr(n, "title"); title != "" {
+			return "[" + text + "](" + href + " " + strconv.Quote(title) + ")"
+		}
+		return "[" + text + "](" + href + ")"
+	case "img":
+		src := attr(n, "src")
+		if src == "" {
+			return ""
+		}
+		return "![" + markdownEscaper.Replace(attr(n, "alt")) + "](" + src + ")"
+	case "blockquote":
+		lines := strings.Split(collapseBlankLines(strings.TrimSpace(inner())), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return "\n\n" + strings.Join(lines, "\n") + "\n\n"
+	case "ul", "ol":
+		var b strings.Builder
+		number := 1
+		if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+			number = start
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || c.Data != "li" {
+				continue
+			}
+			marker := "- "
+			if n.Data == "ol" {
+				marker = strconv.Itoa(number) + ". "
+				number++
+			}
+			item := nestedList.ReplaceAllString(collapseBlankLines(strings.TrimSpace(markdownNode(c))), "\n")
+			indent := "\n" + strings.Repeat(" ", len(marker))
+			b.WriteString(marker + strings.ReplaceAll(item, "\n", indent) + "\n")
+		}
+		// Nested lists follow their item without a blank line, see
+		// nestedList
+		if n.Parent != nil && n.Parent.Data == "li" {
+			return "\x00" + b.String()
+		}
+		return "\n\n" + b.String() + "\n"
+	}
+	return inner()
+}
+
+// collapseBlankLines leaves at most one blank line between paragraphs
+func collapseBlankLines(text string) string {
+	for strings.Contains(text, "\n\n\n") {
+		text = strings.ReplaceAll(text, "\n\n\n", "\n\n")
+	}
+	return text
+}
+
+// wrapInline wraps text in a markdown marker like **, keeping the spaces
+// around it outside so the marker still applies
+func wrapInline(text, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	start := strings.Index(text, trimmed)
+	return text[:start] + marker + trimmed + marker + text[start+len(trimmed):]
+}
+
+// attr returns the value of an attribute, empty when it is missing
+func attr(n *html.Node, key string) string {
+	value, _ := attribute(n, key)
+	return value
+}
+
+// rawText returns the text of a node and its children as it is, for code
+func rawText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(rawText(c))
+	}
+	return b.String()
+}